@@ -28,16 +28,19 @@ type AllowedSize struct {
 
 // Options is a struct to add options to the application
 type Options struct {
-	AllowedIPAddresses  []string      `mapstructure:"allowed_ip_addresses"`
-	AllowedSizes        []AllowedSize `mapstructure:"allowed_sizes"`
-	DefaultUserAgent    string        `mapstructure:"default_user_agent"`
-	EnableCascadeDelete bool          `mapstructure:"enable_cascade_delete"`
-	EnableDelete        bool          `mapstructure:"enable_delete"`
-	EnableHealth        bool          `mapstructure:"enable_health"`
-	EnablePprof         bool          `mapstructure:"enable_pprof"`
-	EnableStats         bool          `mapstructure:"enable_stats"`
-	EnableUpload        bool          `mapstructure:"enable_upload"`
-	MimetypeDetector    string        `mapstructure:"mimetype_detector"`
+	AllowedIPAddresses      []string      `mapstructure:"allowed_ip_addresses"`
+	AllowedSizes            []AllowedSize `mapstructure:"allowed_sizes"`
+	CacheMaxAge             int           `mapstructure:"cache_max_age"`
+	DefaultUserAgent        string        `mapstructure:"default_user_agent"`
+	EnableCascadeDelete     bool          `mapstructure:"enable_cascade_delete"`
+	EnableDelete            bool          `mapstructure:"enable_delete"`
+	EnableFormatNegotiation bool          `mapstructure:"enable_format_negotiation"`
+	EnableHealth            bool          `mapstructure:"enable_health"`
+	EnablePprof             bool          `mapstructure:"enable_pprof"`
+	EnableStats             bool          `mapstructure:"enable_stats"`
+	EnableStreaming         bool          `mapstructure:"enable_streaming"`
+	EnableUpload            bool          `mapstructure:"enable_upload"`
+	MimetypeDetector        string        `mapstructure:"mimetype_detector"`
 }
 
 // Sentry is a struct to configure sentry using a dsn
@@ -2,6 +2,7 @@ package constants
 
 const (
 	ForceParamName     = "force"
+	FormatParamName    = "fmt"
 	SigParamName       = "sig"
 	OperationParamName = "op"
 )
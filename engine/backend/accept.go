@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// acceptFormats are the output formats indexed by their extension
+// and their mime type
+var acceptFormats = map[string]imaging.Format{
+	"avif":       AVIF,
+	"bmp":        imaging.BMP,
+	"gif":        imaging.GIF,
+	"jpeg":       imaging.JPEG,
+	"jpg":        imaging.JPEG,
+	"png":        imaging.PNG,
+	"webp":       WebP,
+	"image/avif": AVIF,
+	"image/bmp":  imaging.BMP,
+	"image/gif":  imaging.GIF,
+	"image/jpeg": imaging.JPEG,
+	"image/png":  imaging.PNG,
+	"image/webp": WebP,
+}
+
+// ResolveFormat returns the first output format of accept, ordered by preference,
+// formats are extensions or mime types, parameters such as quality values
+// and wildcards are ignored, AVIF is skipped when it's not supported by the build.
+func ResolveFormat(accept []string) (imaging.Format, bool) {
+	for _, value := range accept {
+		if i := strings.Index(value, ";"); i >= 0 {
+			value = value[:i]
+		}
+
+		format, ok := acceptFormats[strings.ToLower(strings.TrimSpace(value))]
+		if ok && (format != AVIF || avifSupported) {
+			return format, true
+		}
+	}
+
+	return 0, false
+}
+
+// mimeType returns the mime type of the output format.
+func mimeType(format imaging.Format) string {
+	for name, f := range acceptFormats {
+		if f == format && strings.HasPrefix(name, "image/") {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// formatFromName returns the format of an image decoded by the image package.
+func formatFromName(name string) (imaging.Format, error) {
+	switch name {
+	case "avif":
+		return AVIF, nil
+	case "webp":
+		return WebP, nil
+	}
+
+	return imaging.FormatFromExtension(name)
+}
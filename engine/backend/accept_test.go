@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveFormat(t *testing.T) {
+	tests := []struct {
+		accept []string
+		format imaging.Format
+		ok     bool
+		// avif is true when the format is AVIF in the builds supporting it
+		avif bool
+	}{
+		{[]string{"image/avif", "image/webp", "image/jpeg"}, WebP, true, true},
+		{[]string{"image/avif", "image/jpeg", "image/webp"}, imaging.JPEG, true, true},
+		{[]string{"image/avif", "image/png", "image/jpeg"}, imaging.PNG, true, true},
+		{[]string{"avif", "gif", "png"}, imaging.GIF, true, true},
+		{[]string{"image/avif;q=0.9", " Image/PNG ;q=0.8"}, imaging.PNG, true, true},
+		{[]string{"image/png", "image/avif"}, imaging.PNG, true, false},
+		{[]string{"*/*", "image/*", "tiff", "bmp"}, imaging.BMP, true, false},
+		{[]string{"image/avif", "image/heic"}, 0, false, true},
+		{nil, 0, false, false},
+	}
+
+	for _, tt := range tests {
+		expected, expectedOK := tt.format, tt.ok
+		if tt.avif && avifSupported {
+			expected, expectedOK = AVIF, true
+		}
+
+		format, ok := ResolveFormat(tt.accept)
+		assert.Equal(t, expectedOK, ok, "%v", tt.accept)
+		assert.Equal(t, expected, format, "%v", tt.accept)
+	}
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// alphaBleed returns a copy of img where the color of fully transparent
+// pixels is extended from the nearest visible pixels, layer by layer, so
+// consumers sampling the image without its alpha don't reveal their
+// arbitrary color around the edges. The alpha channel is unchanged.
+func alphaBleed(img image.Image) *image.NRGBA {
+	dst := imaging.Clone(img)
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+
+	known := make([]bool, w*h)
+	queued := make([]bool, w*h)
+
+	var layer []int
+	for i := range known {
+		known[i] = dst.Pix[i*4+3] > 0
+	}
+
+	neighbors := func(i int, fn func(j int)) {
+		x, y := i%w, i/w
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if (dx != 0 || dy != 0) && nx >= 0 && nx < w && ny >= 0 && ny < h {
+					fn(ny*w + nx)
+				}
+			}
+		}
+	}
+
+	for i := range known {
+		if !known[i] {
+			continue
+		}
+		neighbors(i, func(j int) {
+			if !known[j] && !queued[j] {
+				queued[j] = true
+				layer = append(layer, j)
+			}
+		})
+	}
+
+	colors := make([]uint8, 0, 3*len(layer))
+	for len(layer) > 0 {
+		colors = colors[:0]
+
+		// the colors of a layer only depend on the previous layers
+		for _, i := range layer {
+			var r, g, b, total float64
+			neighbors(i, func(j int) {
+				if !known[j] {
+					return
+				}
+				// visible pixels are weighted by their alpha
+				weight := float64(dst.Pix[j*4+3])
+				if weight == 0 {
+					weight = 1
+				}
+				r += float64(dst.Pix[j*4]) * weight
+				g += float64(dst.Pix[j*4+1]) * weight
+				b += float64(dst.Pix[j*4+2]) * weight
+				total += weight
+			})
+			colors = append(colors, clampUint8(r/total), clampUint8(g/total), clampUint8(b/total))
+		}
+
+		var next []int
+		for k, i := range layer {
+			copy(dst.Pix[i*4:i*4+3], colors[k*3:k*3+3])
+			known[i] = true
+		}
+		for _, i := range layer {
+			neighbors(i, func(j int) {
+				if !known[j] && !queued[j] {
+					queued[j] = true
+					next = append(next, j)
+				}
+			})
+		}
+
+		layer = next
+	}
+
+	return dst
+}
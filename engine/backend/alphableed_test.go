@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestFixAlphaBleed(t *testing.T) {
+	e := &GoImage{}
+
+	// transparent pixels are black around a red square with a translucent edge
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for x := 10; x < 30; x++ {
+		for y := 10; y < 30; y++ {
+			c := colorRed
+			if x == 10 || x == 29 || y == 10 || y == 29 {
+				c.A = 64
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+
+	for _, filter := range []string{"", "linear", "nearest"} {
+		content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+			Format:        imaging.PNG,
+			Width:         13,
+			Height:        13,
+			Filter:        filter,
+			FixAlphaBleed: true,
+		})
+		assert.Nil(t, err)
+
+		img, err := png.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		out := imaging.Clone(img)
+
+		// no dark halo shows when the transparent pixels are sampled
+		// without their alpha such as by a browser scaling the image
+		for i := 0; i < len(out.Pix); i += 4 {
+			assert.True(t, out.Pix[i] > 240, "%s red %d", filter, out.Pix[i])
+			assert.True(t, out.Pix[i+1] < 15 && out.Pix[i+2] < 15, filter)
+		}
+	}
+
+	// transparent pixels take the color of the square
+	bled := alphaBleed(src)
+	assert.Equal(t, uint8(0), bled.NRGBAAt(0, 0).A)
+	assert.Equal(t, colorRed.R, bled.NRGBAAt(0, 0).R)
+	assert.Equal(t, colorRed.R, bled.NRGBAAt(39, 20).R)
+	assert.Equal(t, colorRed, bled.NRGBAAt(20, 20))
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	phashSize    = 32
+	phashLowSize = 8
+)
+
+// Analyzer is implemented by backends able to analyze an image
+type Analyzer interface {
+	Analyze(img *imagefile.ImageFile) (*Analysis, error)
+}
+
+// Analysis summarizes an image, it's meant to be serialized as a JSON sidecar
+type Analysis struct {
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	Format        string  `json:"format"`
+	DominantColor string  `json:"dominant_color"`
+	Luminance     float64 `json:"luminance"`
+	HasAlpha      bool    `json:"has_alpha"`
+	Entropy       float64 `json:"entropy"`
+	PHash         string  `json:"phash"`
+}
+
+// Analyze returns an Analysis of the image, the luminance is in range [0, 1]
+// and the entropy of the luminance histogram is expressed in bits.
+func (e *GoImage) Analyze(img *imagefile.ImageFile) (*Analysis, error) {
+	info, err := e.Info(img)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := e.source(img, &Options{AutoOrient: true})
+	if err != nil {
+		return nil, err
+	}
+
+	src := imaging.Clone(decoded)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	analysis := &Analysis{
+		Width:         w,
+		Height:        h,
+		Format:        info.Format,
+		DominantColor: FindDominantColor(src),
+		PHash:         phash(src),
+	}
+
+	var (
+		histogram [256]int
+		total     float64
+		pixels    = w * h
+	)
+
+	for i := 0; i < len(src.Pix); i += 4 {
+		luminance := 0.2126*float64(src.Pix[i]) + 0.7152*float64(src.Pix[i+1]) + 0.0722*float64(src.Pix[i+2])
+		histogram[clampUint8(luminance)]++
+		total += luminance
+
+		if src.Pix[i+3] != 255 {
+			analysis.HasAlpha = true
+		}
+	}
+
+	if pixels == 0 {
+		return analysis, nil
+	}
+
+	analysis.Luminance = total / float64(pixels) / 255
+
+	for _, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(pixels)
+		analysis.Entropy -= p * math.Log2(p)
+	}
+
+	return analysis, nil
+}
+
+// phash returns the perceptual hash of img: the low frequencies of the DCT
+// of its downscaled luminance compared to their median.
+func phash(img image.Image) string {
+	gray := imaging.Grayscale(imaging.Resize(img, phashSize, phashSize, imaging.Lanczos))
+
+	var pixels [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			pixels[y][x] = float64(gray.Pix[y*gray.Stride+x*4])
+		}
+	}
+
+	low := make([]float64, 0, phashLowSize*phashLowSize)
+
+	for v := 0; v < phashLowSize; v++ {
+		for u := 0; u < phashLowSize; u++ {
+			var sum float64
+			for y := 0; y < phashSize; y++ {
+				for x := 0; x < phashSize; x++ {
+					sum += pixels[y][x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*phashSize)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*phashSize))
+				}
+			}
+			low = append(low, sum)
+		}
+	}
+
+	// the DC coefficient is excluded from the median
+	coefficients := append([]float64{}, low[1:]...)
+	sort.Float64s(coefficients)
+	median := (coefficients[len(coefficients)/2-1] + coefficients[len(coefficients)/2]) / 2
+
+	var hash uint64
+	for i, c := range low {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash)
+}
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestAnalyze(t *testing.T) {
+	e := &GoImage{}
+
+	analysis, err := e.Analyze(&imagefile.ImageFile{Source: newImage(t, 40, 20, imaging.PNG)})
+	assert.Nil(t, err)
+	assert.Equal(t, 40, analysis.Width)
+	assert.Equal(t, 20, analysis.Height)
+	assert.Equal(t, "png", analysis.Format)
+	assert.Equal(t, "#C86432", analysis.DominantColor)
+	assert.InDelta(t, (0.2126*200+0.7152*100+0.0722*50)/255, analysis.Luminance, 0.01)
+	assert.False(t, analysis.HasAlpha)
+	assert.Equal(t, float64(0), analysis.Entropy)
+	assert.Len(t, analysis.PHash, 16)
+
+	// half black, half white and translucent
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			if x < 20 {
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 128})
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err = imaging.Encode(buf, img, imaging.PNG)
+	assert.Nil(t, err)
+
+	other, err := e.Analyze(&imagefile.ImageFile{Source: buf.Bytes()})
+	assert.Nil(t, err)
+	assert.True(t, other.HasAlpha)
+	assert.InDelta(t, 1, other.Entropy, 1e-9)
+	assert.NotEqual(t, analysis.PHash, other.PHash)
+
+	content, err := json.Marshal(other)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), `"has_alpha":true`)
+}
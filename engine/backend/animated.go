@@ -0,0 +1,302 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"time"
+
+	"golang.org/x/image/webp"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	AnimatedToStillFirst   = "first"
+	AnimatedToStillLast    = "last"
+	AnimatedToStillMiddle  = "middle"
+	AnimatedToStillLongest = "longest"
+)
+
+// AnimatedToStillModes are the frames which can represent an animation as a still image
+var AnimatedToStillModes = []string{
+	AnimatedToStillFirst,
+	AnimatedToStillLast,
+	AnimatedToStillMiddle,
+	AnimatedToStillLongest,
+}
+
+// ErrInvalidAnimation is an error returned if the frames of an animation cannot be read
+var ErrInvalidAnimation = errors.New("Invalid animation")
+
+var (
+	webpHeader = []byte("WEBP")
+	riffHeader = []byte("RIFF")
+)
+
+// AnimInfo describes the animation of an image, still images have a single frame
+type AnimInfo struct {
+	Frames   int           `json:"frames"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AnimationInfo returns the number of frames and the total duration of an
+// animated GIF or WebP, only the headers of the frames are read.
+func (e *GoImage) AnimationInfo(img *imagefile.ImageFile) (*AnimInfo, error) {
+	switch {
+	case bytes.HasPrefix(img.Source, gifHeader):
+		end, frames, delay := gifScan(img.Source)
+		if end < 0 || frames == 0 {
+			return nil, ErrInvalidAnimation
+		}
+
+		// delays are expressed in 100ths of a second
+		return &AnimInfo{Frames: frames, Duration: time.Duration(delay) * 10 * time.Millisecond}, nil
+	case isAnimatedWebP(img.Source):
+		_, frames, err := webpFrames(img.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		info := &AnimInfo{Frames: len(frames)}
+		for i := range frames {
+			info.Duration += time.Duration(frames[i].duration) * time.Millisecond
+		}
+
+		return info, nil
+	}
+
+	if _, _, err := image.DecodeConfig(bytes.NewReader(img.Source)); err != nil {
+		return nil, err
+	}
+
+	return &AnimInfo{Frames: 1}, nil
+}
+
+// webpFrame is a frame of an animated WebP
+type webpFrame struct {
+	bounds   image.Rectangle
+	duration int
+	blend    bool
+	dispose  bool
+	// data contains the ALPH, VP8 and VP8L chunks of the frame
+	data  []byte
+	alpha bool
+}
+
+// webpChunks calls fn with the id and the data of each chunk of data.
+func webpChunks(data []byte, fn func(id string, chunk []byte) error) error {
+	for len(data) >= 8 {
+		size := int64(binary.LittleEndian.Uint32(data[4:8]))
+		if size > int64(len(data)-8) {
+			return ErrInvalidAnimation
+		}
+
+		if err := fn(string(data[:4]), data[8:8+size]); err != nil {
+			return err
+		}
+
+		// chunks are padded to an even size
+		next := 8 + size + size&1
+		if next > int64(len(data)) {
+			next = int64(len(data))
+		}
+		data = data[next:]
+	}
+
+	return nil
+}
+
+func isWebP(source []byte) bool {
+	return len(source) >= 12 && bytes.HasPrefix(source, riffHeader) && bytes.Equal(source[8:12], webpHeader)
+}
+
+// isAnimatedWebP returns true if source is a WebP with the animation flag set
+func isAnimatedWebP(source []byte) bool {
+	if !isWebP(source) {
+		return false
+	}
+
+	var animated bool
+	webpChunks(source[12:], func(id string, chunk []byte) error {
+		if id == "VP8X" && len(chunk) > 0 {
+			animated = chunk[0]&0x02 != 0
+		}
+		return nil
+	})
+
+	return animated
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// webpFrames returns the canvas and the frames of an animated WebP.
+func webpFrames(source []byte) (image.Rectangle, []webpFrame, error) {
+	var (
+		canvas image.Rectangle
+		frames []webpFrame
+	)
+
+	if !isWebP(source) {
+		return canvas, nil, ErrInvalidAnimation
+	}
+
+	err := webpChunks(source[12:], func(id string, chunk []byte) error {
+		switch id {
+		case "VP8X":
+			if len(chunk) < 10 {
+				return ErrInvalidAnimation
+			}
+			canvas = image.Rect(0, 0, uint24(chunk[4:])+1, uint24(chunk[7:])+1)
+		case "ANMF":
+			if len(chunk) < 16 {
+				return ErrInvalidAnimation
+			}
+
+			x, y := uint24(chunk[0:])*2, uint24(chunk[3:])*2
+			frame := webpFrame{
+				bounds:   image.Rect(x, y, x+uint24(chunk[6:])+1, y+uint24(chunk[9:])+1),
+				duration: uint24(chunk[12:]),
+				blend:    chunk[15]&0x02 == 0,
+				dispose:  chunk[15]&0x01 != 0,
+				data:     chunk[16:],
+			}
+
+			webpChunks(frame.data, func(id string, chunk []byte) error {
+				frame.alpha = frame.alpha || id == "ALPH"
+				return nil
+			})
+
+			frames = append(frames, frame)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return canvas, nil, err
+	}
+
+	if len(frames) == 0 || canvas.Empty() {
+		return canvas, nil, ErrInvalidAnimation
+	}
+
+	return canvas, frames, nil
+}
+
+// decode decodes the frame by wrapping its chunks in a standalone WebP.
+func (f webpFrame) decode() (image.Image, error) {
+	body := &bytes.Buffer{}
+	body.Write(webpHeader)
+
+	if f.alpha {
+		// an alpha chunk requires the extended format
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		w, h := f.bounds.Dx()-1, f.bounds.Dy()-1
+		vp8x[4], vp8x[5], vp8x[6] = byte(w), byte(w>>8), byte(w>>16)
+		vp8x[7], vp8x[8], vp8x[9] = byte(h), byte(h>>8), byte(h>>16)
+
+		body.WriteString("VP8X")
+		binary.Write(body, binary.LittleEndian, uint32(len(vp8x)))
+		body.Write(vp8x)
+	}
+
+	body.Write(f.data)
+
+	buf := &bytes.Buffer{}
+	buf.Write(riffHeader)
+	binary.Write(buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+
+	return webp.Decode(bytes.NewReader(buf.Bytes()))
+}
+
+// stillFrameIndex returns the index of the frame representing the animation.
+func stillFrameIndex(mode string, durations []int) (int, error) {
+	n := len(durations)
+
+	switch mode {
+	case "", AnimatedToStillFirst:
+		return 0, nil
+	case AnimatedToStillLast:
+		return n - 1, nil
+	case AnimatedToStillMiddle:
+		return n / 2, nil
+	case AnimatedToStillLongest:
+		index := 0
+		for i := range durations {
+			if durations[i] > durations[index] {
+				index = i
+			}
+		}
+		return index, nil
+	}
+
+	return 0, fmt.Errorf("Invalid animated to still mode %s, available values are: %v", mode, AnimatedToStillModes)
+}
+
+// animatedStill returns the frame of an animated GIF or WebP selected
+// by mode, composed with the previous frames.
+func animatedStill(source []byte, mode string) (image.Image, error) {
+	if bytes.HasPrefix(source, gifHeader) {
+		g, err := gif.DecodeAll(bytes.NewReader(source))
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := stillFrameIndex(mode, g.Delay)
+		if err != nil {
+			return nil, err
+		}
+
+		canvas := newGIFCanvas(g)
+		for i := 0; i < index; i++ {
+			canvas.draw(i)
+			canvas.dispose(i)
+		}
+		canvas.draw(index)
+
+		return canvas.RGBA, nil
+	}
+
+	bounds, frames, err := webpFrames(source)
+	if err != nil {
+		return nil, err
+	}
+
+	durations := make([]int, len(frames))
+	for i := range frames {
+		durations[i] = frames[i].duration
+	}
+
+	index, err := stillFrameIndex(mode, durations)
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewNRGBA(bounds)
+	for i := 0; i <= index; i++ {
+		frame, err := frames[i].decode()
+		if err != nil {
+			return nil, err
+		}
+
+		op := draw.Src
+		if frames[i].blend {
+			op = draw.Over
+		}
+
+		draw.Draw(canvas, frames[i].bounds, frame, frame.Bounds().Min, op)
+		if i < index && frames[i].dispose {
+			draw.Draw(canvas, frames[i].bounds, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return canvas, nil
+}
@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+var animatedColors = []color.NRGBA{
+	{255, 0, 0, 255},
+	{0, 255, 0, 255},
+	{0, 0, 255, 255},
+	{255, 255, 255, 255},
+}
+
+// newVP8L returns a lossless WebP bitstream of a solid color, each prefix code
+// contains a single symbol so pixels are encoded with zero bits.
+func newVP8L(width int, height int, c color.NRGBA) []byte {
+	var (
+		out  = []byte{0x2f}
+		acc  uint64
+		bits uint
+	)
+
+	write := func(v uint64, n uint) {
+		acc |= v << bits
+		bits += n
+		for bits >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			bits -= 8
+		}
+	}
+
+	write(uint64(width-1), 14)
+	write(uint64(height-1), 14)
+	write(1, 1) // alpha is used
+	write(0, 3) // version
+	write(0, 1) // no transform
+	write(0, 1) // no color cache
+	write(0, 1) // no meta prefix codes
+
+	for _, symbol := range []uint8{c.G, c.R, c.B, c.A} {
+		write(1, 1) // simple code
+		write(0, 1) // one symbol
+		write(1, 1) // symbol on 8 bits
+		write(uint64(symbol), 8)
+	}
+
+	// distance code
+	write(1, 1)
+	write(0, 1)
+	write(0, 1)
+	write(0, 1)
+
+	if bits > 0 {
+		out = append(out, byte(acc))
+	}
+
+	return out
+}
+
+func webpChunk(id string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func put24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func newAnimatedWebP(width int, height int, durations []int) []byte {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10
+	put24(vp8x[4:], width-1)
+	put24(vp8x[7:], height-1)
+
+	body := &bytes.Buffer{}
+	body.WriteString("WEBP")
+	body.Write(webpChunk("VP8X", vp8x))
+	body.Write(webpChunk("ANIM", make([]byte, 6)))
+
+	for i, duration := range durations {
+		header := make([]byte, 16)
+		put24(header[6:], width-1)
+		put24(header[9:], height-1)
+		put24(header[12:], duration)
+
+		frame := append(header, webpChunk("VP8L", newVP8L(width, height, animatedColors[i]))...)
+		body.Write(webpChunk("ANMF", frame))
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+
+	return buf.Bytes()
+}
+
+func newColoredGIF(t *testing.T, width int, height int, delays []int) []byte {
+	g := &gif.GIF{}
+	for i, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(animatedColors[i]), image.Point{}, draw.Src)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, delay)
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, g)
+	assert.Nil(t, err)
+
+	return buf.Bytes()
+}
+
+func TestAnimatedToStill(t *testing.T) {
+	e := &GoImage{}
+
+	durations := []int{10, 50, 20, 10}
+	expected := map[string]color.NRGBA{
+		"":                     animatedColors[0],
+		AnimatedToStillFirst:   animatedColors[0],
+		AnimatedToStillLast:    animatedColors[3],
+		AnimatedToStillMiddle:  animatedColors[2],
+		AnimatedToStillLongest: animatedColors[1],
+	}
+
+	sources := map[string][]byte{
+		"gif":  newColoredGIF(t, 10, 10, durations),
+		"webp": newAnimatedWebP(10, 10, durations),
+	}
+
+	for name, source := range sources {
+		for mode, c := range expected {
+			content, err := e.Resize(&imagefile.ImageFile{Source: source}, &Options{
+				Format:          imaging.PNG,
+				Width:           5,
+				Height:          5,
+				AnimatedToStill: mode,
+			})
+			assert.Nil(t, err, name)
+
+			out, err := imaging.Decode(bytes.NewReader(content))
+			assert.Nil(t, err, name)
+			assert.Equal(t, c, color.NRGBAModel.Convert(out.At(2, 2)), "%s %s", name, mode)
+		}
+	}
+
+	_, err := e.Resize(&imagefile.ImageFile{Source: sources["gif"]}, &Options{
+		Format:          imaging.PNG,
+		AnimatedToStill: "random",
+	})
+	assert.NotNil(t, err)
+}
+
+func TestAnimationInfo(t *testing.T) {
+	e := &GoImage{}
+
+	info, err := e.AnimationInfo(&imagefile.ImageFile{Source: newColoredGIF(t, 8, 8, []int{10, 20, 50})})
+	assert.Nil(t, err)
+	assert.Equal(t, &AnimInfo{Frames: 3, Duration: 800 * time.Millisecond}, info)
+
+	info, err = e.AnimationInfo(&imagefile.ImageFile{Source: newAnimatedWebP(8, 8, []int{100, 300})})
+	assert.Nil(t, err)
+	assert.Equal(t, &AnimInfo{Frames: 2, Duration: 400 * time.Millisecond}, info)
+
+	info, err = e.AnimationInfo(&imagefile.ImageFile{Source: newColoredGIF(t, 8, 8, []int{0})})
+	assert.Nil(t, err)
+	assert.Equal(t, &AnimInfo{Frames: 1}, info)
+
+	info, err = e.AnimationInfo(&imagefile.ImageFile{Source: newImage(t, 8, 8, imaging.PNG)})
+	assert.Nil(t, err)
+	assert.Equal(t, &AnimInfo{Frames: 1}, info)
+
+	_, err = e.AnimationInfo(&imagefile.ImageFile{Source: []byte("foo")})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// autoRotateSize is the size of the thumbnail used to guess the orientation
+	autoRotateSize = 64
+	// autoRotateAxisRatio is the ratio between the variances of the row and
+	// column luminance profiles required to decide the axis of the image
+	autoRotateAxisRatio = 2.0
+	// autoRotateMinDiff is the minimal luminance difference, in range [0, 1],
+	// between both halves of the image required to decide which one is up
+	autoRotateMinDiff = 0.1
+)
+
+// autoRotate guesses the orientation of img from its content and rotates it
+// by a multiple of 90 degrees so it's upright. Horizons and text lines make
+// the luminance vary along the vertical axis and the brighter half, the sky
+// or the top margin, is up. The image is kept as is when the guess is unsure.
+func autoRotate(img image.Image) image.Image {
+	thumb := imaging.Grayscale(imaging.Fit(img, autoRotateSize, autoRotateSize, imaging.Box))
+	w, h := thumb.Bounds().Dx(), thumb.Bounds().Dy()
+	if w < 2 || h < 2 {
+		return img
+	}
+
+	rows := make([]float64, h)
+	cols := make([]float64, w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l := float64(thumb.Pix[y*thumb.Stride+x*4]) / 255
+			rows[y] += l / float64(w)
+			cols[x] += l / float64(h)
+		}
+	}
+
+	rowsVariance, colsVariance := variance(rows), variance(cols)
+
+	switch {
+	case rowsVariance > autoRotateAxisRatio*colsVariance:
+		if halvesDiff(rows) <= -autoRotateMinDiff {
+			return imaging.Rotate180(img)
+		}
+	case colsVariance > autoRotateAxisRatio*rowsVariance:
+		diff := halvesDiff(cols)
+		switch {
+		case diff >= autoRotateMinDiff:
+			// up is on the left
+			return imaging.Rotate270(img)
+		case diff <= -autoRotateMinDiff:
+			return imaging.Rotate90(img)
+		}
+	}
+
+	return img
+}
+
+func variance(values []float64) float64 {
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+
+	return sum / float64(len(values))
+}
+
+// halvesDiff returns the difference between the means of the first
+// and the second half of values.
+func halvesDiff(values []float64) float64 {
+	half := len(values) / 2
+
+	var first, second float64
+	for _, v := range values[:half] {
+		first += v
+	}
+	for _, v := range values[len(values)-half:] {
+		second += v
+	}
+
+	return (first - second) / float64(half)
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoRotate(t *testing.T) {
+	sky := color.NRGBA{210, 220, 250, 255}
+	ground := color.NRGBA{60, 90, 40, 255}
+
+	// a landscape with the horizon in the upper half
+	upright := imaging.New(120, 80, ground)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 120; x++ {
+			upright.SetNRGBA(x, y, sky)
+		}
+	}
+	// a tree breaks the symmetry
+	for y := 20; y < 60; y++ {
+		for x := 30; x < 36; x++ {
+			upright.SetNRGBA(x, y, color.NRGBA{40, 30, 20, 255})
+		}
+	}
+
+	rotations := []func(image.Image) *image.NRGBA{
+		imaging.Clone,
+		imaging.Rotate90,
+		imaging.Rotate180,
+		imaging.Rotate270,
+	}
+
+	for i, rotate := range rotations {
+		out := imaging.Clone(autoRotate(rotate(upright)))
+		assert.Equal(t, upright.Bounds(), out.Bounds(), "rotation %d", i*90)
+		assert.Equal(t, upright.Pix, out.Pix, "rotation %d", i*90)
+	}
+
+	// no orientation can be guessed
+	uniform := imaging.New(80, 120, sky)
+	assert.Equal(t, uniform.Bounds(), autoRotate(uniform).Bounds())
+}
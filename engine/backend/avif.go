@@ -0,0 +1,17 @@
+package backend
+
+import (
+	"errors"
+
+	"github.com/disintegration/imaging"
+)
+
+// AVIF is the AVIF format, it's not supported by imaging
+const AVIF imaging.Format = -2
+
+// ErrAVIFNotSupported is an error returned when an AVIF image is encoded or
+// decoded by a build without the avif tag
+var ErrAVIFNotSupported = errors.New("AVIF is not supported, picfit has to be built with the avif tag")
+
+// avifMagic matches the file type box of AVIF images and sequences
+const avifMagic = "????ftypavi"
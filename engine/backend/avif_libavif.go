@@ -0,0 +1,185 @@
+//go:build avif
+// +build avif
+
+package backend
+
+/*
+#cgo pkg-config: libavif
+#include <stdlib.h>
+#include <avif/avif.h>
+
+static avifResult picfit_avif_encode(uint8_t *pixels, uint32_t stride, uint32_t width, uint32_t height, int quality, int speed, avifRWData *output) {
+	avifImage *image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV420);
+	if (image == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = pixels;
+	rgb.rowBytes = stride;
+
+	avifResult result = avifImageRGBToYUV(image, &rgb);
+	if (result != AVIF_RESULT_OK) {
+		avifImageDestroy(image);
+		return result;
+	}
+
+	avifEncoder *encoder = avifEncoderCreate();
+	if (encoder == NULL) {
+		avifImageDestroy(image);
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	if (quality > 0) {
+		encoder->quality = quality;
+		encoder->qualityAlpha = quality;
+	}
+	if (speed > 0) {
+		encoder->speed = speed;
+	}
+
+	result = avifEncoderWrite(encoder, image, output);
+
+	avifEncoderDestroy(encoder);
+	avifImageDestroy(image);
+
+	return result;
+}
+
+static avifResult picfit_avif_decode(const uint8_t *data, size_t size, int header, avifRGBImage *rgb) {
+	avifDecoder *decoder = avifDecoderCreate();
+	if (decoder == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+
+	avifResult result = avifDecoderSetIOMemory(decoder, data, size);
+	if (result == AVIF_RESULT_OK) {
+		result = avifDecoderParse(decoder);
+	}
+	if (result == AVIF_RESULT_OK && header) {
+		rgb->width = decoder->image->width;
+		rgb->height = decoder->image->height;
+		avifDecoderDestroy(decoder);
+		return result;
+	}
+	if (result == AVIF_RESULT_OK) {
+		result = avifDecoderNextImage(decoder);
+	}
+	if (result == AVIF_RESULT_OK) {
+		avifRGBImageSetDefaults(rgb, decoder->image);
+		rgb->format = AVIF_RGB_FORMAT_RGBA;
+		rgb->depth = 8;
+		result = avifRGBImageAllocatePixels(rgb);
+	}
+	if (result == AVIF_RESULT_OK) {
+		result = avifImageYUVToRGB(decoder->image, rgb);
+		if (result != AVIF_RESULT_OK) {
+			avifRGBImageFreePixels(rgb);
+		}
+	}
+
+	avifDecoderDestroy(decoder);
+
+	return result;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+// avifSupported is true when picfit is built with the avif tag
+const avifSupported = true
+
+func init() {
+	image.RegisterFormat("avif", avifMagic, decodeAVIF, decodeAVIFConfig)
+}
+
+func avifError(result C.avifResult) error {
+	return fmt.Errorf("AVIF error: %s", C.GoString(C.avifResultToString(result)))
+}
+
+// encodeAVIF writes img to w as an AVIF, a quality or a speed of 0
+// uses the default of libavif.
+func encodeAVIF(w io.Writer, img image.Image, quality int, speed int) error {
+	src := imaging.Clone(img)
+	if src.Bounds().Empty() {
+		return fmt.Errorf("Invalid AVIF dimensions %dx%d", src.Bounds().Dx(), src.Bounds().Dy())
+	}
+
+	var output C.avifRWData
+	result := C.picfit_avif_encode((*C.uint8_t)(unsafe.Pointer(&src.Pix[0])), C.uint32_t(src.Stride),
+		C.uint32_t(src.Bounds().Dx()), C.uint32_t(src.Bounds().Dy()), C.int(quality), C.int(speed), &output)
+	if result != C.AVIF_RESULT_OK {
+		return avifError(result)
+	}
+	defer C.avifRWDataFree(&output)
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output.data), C.int(output.size)))
+
+	return err
+}
+
+func readAVIF(r io.Reader, header bool) (C.avifRGBImage, error) {
+	var rgb C.avifRGBImage
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return rgb, err
+	}
+	if len(data) == 0 {
+		return rgb, io.ErrUnexpectedEOF
+	}
+
+	flag := C.int(0)
+	if header {
+		flag = 1
+	}
+
+	result := C.picfit_avif_decode((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), flag, &rgb)
+	if result != C.AVIF_RESULT_OK {
+		return rgb, avifError(result)
+	}
+
+	return rgb, nil
+}
+
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	rgb, err := readAVIF(r, false)
+	if err != nil {
+		return nil, err
+	}
+	defer C.avifRGBImageFreePixels(&rgb)
+
+	width, height := int(rgb.width), int(rgb.height)
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	pix := C.GoBytes(unsafe.Pointer(rgb.pixels), C.int(int(rgb.rowBytes)*height))
+	for y := 0; y < height; y++ {
+		copy(img.Pix[y*img.Stride:(y+1)*img.Stride], pix[y*int(rgb.rowBytes):])
+	}
+
+	return img, nil
+}
+
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	rgb, err := readAVIF(r, true)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(rgb.width),
+		Height:     int(rgb.height),
+	}, nil
+}
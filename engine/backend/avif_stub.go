@@ -0,0 +1,25 @@
+//go:build !avif
+// +build !avif
+
+package backend
+
+import (
+	"image"
+	"io"
+)
+
+// avifSupported is true when picfit is built with the avif tag
+const avifSupported = false
+
+func init() {
+	// AVIF sources are recognized to return an explicit error
+	image.RegisterFormat("avif", avifMagic, func(io.Reader) (image.Image, error) {
+		return nil, ErrAVIFNotSupported
+	}, func(io.Reader) (image.Config, error) {
+		return image.Config{}, ErrAVIFNotSupported
+	})
+}
+
+func encodeAVIF(io.Writer, image.Image, int, int) error {
+	return ErrAVIFNotSupported
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestAVIF(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	content, err := e.Resize(img, &Options{Format: AVIF, Width: 20, Height: 20, Quality: 80, AVIFSpeed: 10})
+	if !avifSupported {
+		assert.True(t, errors.Is(err, ErrAVIFNotSupported))
+
+		// AVIF sources are recognized
+		source := append([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"), make([]byte, 16)...)
+		_, err = e.Resize(&imagefile.ImageFile{Source: source}, &Options{Format: imaging.PNG, Width: 20, Height: 20})
+		assert.True(t, errors.Is(err, ErrAVIFNotSupported))
+
+		_, ok := ResolveFormat([]string{"image/avif"})
+		assert.False(t, ok)
+
+		return
+	}
+
+	assert.Nil(t, err)
+
+	out, name, err := image.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, "avif", name)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), out.Bounds())
+
+	r, g, b, _ := out.At(10, 10).RGBA()
+	assert.InDelta(t, 200, int(r>>8), 8)
+	assert.InDelta(t, 100, int(g>>8), 8)
+	assert.InDelta(t, 50, int(b>>8), 8)
+
+	format, ok := ResolveFormat([]string{"image/avif"})
+	assert.True(t, ok)
+	assert.Equal(t, AVIF, format)
+}
@@ -2,6 +2,9 @@ package backend
 
 import (
 	"fmt"
+	stdimage "image"
+	"image/png"
+	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/pkg/errors"
@@ -11,18 +14,108 @@ import (
 // MethodNotImplementedError is an error returned if method is not implemented
 var MethodNotImplementedError = errors.New("Not implemented")
 
+// ErrTrailingData is an error returned if the source contains data after the end of the image
+var ErrTrailingData = errors.New("Image contains trailing data")
+
+const (
+	CompressionBest    = "best"
+	CompressionDefault = "default"
+	CompressionNone    = "none"
+	CompressionSpeed   = "speed"
+)
+
+// CompressionLevels are the PNG compression levels indexed by name
+var CompressionLevels = map[string]png.CompressionLevel{
+	CompressionBest:    png.BestCompression,
+	CompressionDefault: png.DefaultCompression,
+	CompressionNone:    png.NoCompression,
+	CompressionSpeed:   png.BestSpeed,
+}
+
+const (
+	ColorSpaceGray   = "gray"
+	ColorSpaceLinear = "linear"
+	ColorSpaceSRGB   = "srgb"
+)
+
 // Options is the engine options
 type Options struct {
-	Color    string
-	Degree   int
-	Format   imaging.Format
-	Height   int
-	Images   []image.ImageFile
-	Position string
-	Quality  int
-	Stick    string
-	Upscale  bool
-	Width    int
+	AcceptFormats        []string
+	AlphaThreshold       int
+	AnimatedToStill      string
+	AVIFSpeed            int
+	AutoOrient           bool
+	AutoRotateContent    bool
+	Background           string
+	BackgroundImage      []byte
+	BackgroundMode       string
+	ClipPercent          float64
+	Color                string
+	ColorSpace           string
+	CompressionLevel     png.CompressionLevel
+	CropGravity          string
+	CropRect             stdimage.Rectangle
+	Degree               int
+	Deterministic        bool
+	DPR                  float64
+	Filter               string
+	FixAlphaBleed        bool
+	ForceOrientation     int
+	Format               imaging.Format
+	GIFMaxPixelsPerFrame int
+	GIFPalette           string
+	Height               int
+	HeightPercent        float64
+	Images               []image.ImageFile
+	JPEGOptimize         bool
+	// LoopCount overrides the loop count of animated outputs,
+	// -1 keeps the source value and 0 loops forever.
+	LoopCount            int
+	Lossless             bool
+	LowPolyPoints        int
+	Mask                 string
+	MaskRadius           int
+	MaxBytes             int
+	MinFrameDelay        time.Duration
+	NormalizeOrientation string
+	Observer             Observer
+	OutputSizeHint       int
+	Page                 int
+	PaletteCount         int
+	PlaceholderMode      string
+	Position             string
+	Progressive          bool
+	PosterizeLevels      int
+	Primitives           int
+	QRCodeSize           int
+	QRCodeText           string
+	Quality              int
+	RedactRegions        []stdimage.Rectangle
+	RejectTrailingData   bool
+	RotateEdgeMode       string
+	Sequential           bool
+	Shapes               []Shape
+	Sigma                float64
+	SnapWidths           []int
+	Stick                string
+	StrictImage          bool
+	SwapRB               bool
+	Text                 string
+	TextColor            string
+	TextSize             float64
+	Upscale              bool
+	Vibrance             float64
+	Watermark            *Watermark
+	WatermarkBlend       string
+	WatermarkGravity     string
+	WatermarkMargin      stdimage.Point
+	WatermarkSpacing     int
+	WatermarkTile        bool
+	WatermarkURL         string
+	Width                int
+	WidthPercent         float64
+
+	metrics *Metrics
 }
 
 func (o Options) String() string {
@@ -32,11 +125,33 @@ func (o Options) String() string {
 
 // Engine is an interface to define an image engine
 type Backend interface {
+	AutoContrast(img *image.ImageFile, options *Options) ([]byte, error)
+	Background(img *image.ImageFile, options *Options) ([]byte, error)
+	Blur(img *image.ImageFile, options *Options) ([]byte, error)
+	Cover(img *image.ImageFile, options *Options) ([]byte, error)
+	Crop(img *image.ImageFile, options *Options) ([]byte, error)
+	CropResize(img *image.ImageFile, options *Options) ([]byte, error)
+	DominantColor(img *image.ImageFile, options *Options) ([]byte, error)
+	DrawShapes(img *image.ImageFile, options *Options) ([]byte, error)
 	Fit(img *image.ImageFile, options *Options) ([]byte, error)
 	Flat(background *image.ImageFile, options *Options) ([]byte, error)
 	Flip(img *image.ImageFile, options *Options) ([]byte, error)
+	Grayscale(img *image.ImageFile, options *Options) ([]byte, error)
+	LowPoly(img *image.ImageFile, options *Options) ([]byte, error)
+	Mask(img *image.ImageFile, options *Options) ([]byte, error)
+	Palette(img *image.ImageFile, options *Options) ([]byte, error)
+	Placeholder(img *image.ImageFile, options *Options) ([]byte, error)
+	Posterize(img *image.ImageFile, options *Options) ([]byte, error)
+	QRCode(img *image.ImageFile, options *Options) ([]byte, error)
+	Redact(img *image.ImageFile, options *Options) ([]byte, error)
 	Resize(img *image.ImageFile, options *Options) ([]byte, error)
 	Rotate(img *image.ImageFile, options *Options) ([]byte, error)
+	Sepia(img *image.ImageFile, options *Options) ([]byte, error)
+	Sharpen(img *image.ImageFile, options *Options) ([]byte, error)
+	SQIP(img *image.ImageFile, options *Options) ([]byte, error)
 	String() string
+	Text(img *image.ImageFile, options *Options) ([]byte, error)
 	Thumbnail(img *image.ImageFile, options *Options) ([]byte, error)
+	Vibrance(img *image.ImageFile, options *Options) ([]byte, error)
+	Watermark(img *image.ImageFile, options *Options) ([]byte, error)
 }
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	BackgroundFill = "fill"
+	BackgroundTile = "tile"
+)
+
+// BackgroundModes are the ways a background image covers the canvas
+var BackgroundModes = []string{
+	BackgroundFill,
+	BackgroundTile,
+}
+
+// Background composites the image over the background image of options,
+// or over the background color when no image is provided.
+func (e *GoImage) Background(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPixels(options.BackgroundImage, e.MaxPixels); err != nil {
+		return nil, err
+	}
+
+	out, err := composite(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(out, options)
+}
+
+// DefaultJPEGBackground is the color of the transparent areas of images saved as JPEG
+const DefaultJPEGBackground = "ffffff"
+
+// flatten draws img over the background color of options, white by default,
+// since JPEG doesn't support transparency, opaque images are returned as is.
+func flatten(img image.Image, options *Options) (image.Image, error) {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img, nil
+	}
+
+	background := options.Background
+	if background == "" {
+		background = DefaultJPEGBackground
+	}
+
+	rgb, err := Hex2RGB(Hex(background))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	canvas := imaging.New(bounds.Dx(), bounds.Dy(), color.NRGBA{rgb.Red, rgb.Green, rgb.Blue, 255})
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Over)
+
+	return canvas, nil
+}
+
+// composite draws img over its background, the background image is
+// resized and cropped, or tiled, to fill the bounds of img.
+func composite(img image.Image, options *Options) (*image.NRGBA, error) {
+	bounds := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+
+	var canvas *image.NRGBA
+	if len(options.BackgroundImage) == 0 {
+		bg, err := backgroundColor(options)
+		if err != nil {
+			return nil, err
+		}
+
+		canvas = imaging.New(bounds.Dx(), bounds.Dy(), bg)
+	} else {
+		bg, err := imaging.Decode(bytes.NewReader(options.BackgroundImage))
+		if err != nil {
+			return nil, err
+		}
+
+		switch options.BackgroundMode {
+		case "", BackgroundFill:
+			canvas = imaging.Fill(bg, bounds.Dx(), bounds.Dy(), imaging.Center, imaging.Lanczos)
+		case BackgroundTile:
+			canvas = image.NewNRGBA(bounds)
+			tile := bg.Bounds()
+			for y := 0; y < bounds.Dy(); y += tile.Dy() {
+				for x := 0; x < bounds.Dx(); x += tile.Dx() {
+					draw.Draw(canvas, tile.Sub(tile.Min).Add(image.Pt(x, y)), bg, tile.Min, draw.Src)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Invalid background mode %s, available values are: %v", options.BackgroundMode, BackgroundModes)
+		}
+	}
+
+	draw.Draw(canvas, bounds, img, img.Bounds().Min, draw.Over)
+
+	return canvas, nil
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestComposite(t *testing.T) {
+	// a checkerboard texture
+	texture := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for x := 0; x < 10; x++ {
+		for y := 0; y < 10; y++ {
+			c := color.NRGBA{0, 0, 255, 255}
+			if (x < 5) != (y < 5) {
+				c = color.NRGBA{255, 255, 0, 255}
+			}
+			texture.SetNRGBA(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, texture, imaging.PNG)
+	assert.Nil(t, err)
+
+	// a transparent image with an opaque red square
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for x := 10; x < 20; x++ {
+		for y := 5; y < 15; y++ {
+			img.SetNRGBA(x, y, colorRed)
+		}
+	}
+
+	out, err := composite(img, &Options{BackgroundImage: buf.Bytes(), BackgroundMode: BackgroundTile})
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), out.Bounds())
+	assert.Equal(t, colorRed, out.NRGBAAt(15, 10))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{255, 255, 0, 255}, out.NRGBAAt(25, 2))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, out.NRGBAAt(22, 12))
+
+	out, err = composite(img, &Options{BackgroundImage: buf.Bytes()})
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), out.Bounds())
+	assert.Equal(t, colorRed, out.NRGBAAt(15, 10))
+	assert.Equal(t, uint8(255), out.NRGBAAt(0, 0).A)
+
+	out, err = composite(img, &Options{Background: "00ff00"})
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{0, 255, 0, 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, colorRed, out.NRGBAAt(15, 10))
+}
+
+func TestMaxPixelsInputs(t *testing.T) {
+	e := &GoImage{MaxPixels: 1000}
+	img := &imagefile.ImageFile{Source: newImage(t, 20, 20, imaging.PNG)}
+	large := newImage(t, 40, 40, imaging.PNG)
+
+	// the background images
+	_, err := e.Background(img, &Options{Format: imaging.PNG, BackgroundImage: newImage(t, 10, 10, imaging.PNG)})
+	assert.Nil(t, err)
+
+	_, err = e.Background(img, &Options{Format: imaging.PNG, BackgroundImage: large})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+
+	// the foregrounds of a flat
+	_, err = e.Flat(img, &Options{Format: imaging.PNG, Images: []imagefile.ImageFile{{Source: large}}})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+
+	// the animated sources converted to stills
+	_, err = e.Resize(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, &Options{
+		Format:          imaging.PNG,
+		Width:           10,
+		Height:          10,
+		AnimatedToStill: "last",
+	})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+}
+
+func TestFlattenJPEG(t *testing.T) {
+	// the left half is transparent, the right half is red at half opacity
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	for x := 16; x < 32; x++ {
+		for y := 0; y < 16; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 128})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	near := func(expected color.NRGBA, actual color.Color) {
+		c := color.NRGBAModel.Convert(actual).(color.NRGBA)
+		assert.InDelta(t, int(expected.R), int(c.R), 4)
+		assert.InDelta(t, int(expected.G), int(c.G), 4)
+		assert.InDelta(t, int(expected.B), int(c.B), 4)
+	}
+
+	for background, expected := range map[string]color.NRGBA{
+		"":        {255, 255, 255, 255},
+		"#00f":    {0, 0, 255, 255},
+		"336699":  {0x33, 0x66, 0x99, 255},
+		"#ffffff": {255, 255, 255, 255},
+	} {
+		content, err := e.Resize(img, &Options{Format: imaging.JPEG, Quality: 100, Width: 32, Height: 16, Background: background})
+		assert.Nil(t, err)
+
+		out, err := jpeg.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+
+		near(expected, out.At(4, 8))
+		near(color.NRGBA{
+			R: uint8((255*128 + int(expected.R)*127) / 255),
+			G: uint8(int(expected.G) * 127 / 255),
+			B: uint8(int(expected.B) * 127 / 255),
+		}, out.At(28, 8))
+	}
+
+	_, err = e.Resize(img, &Options{Format: imaging.JPEG, Width: 32, Height: 16, Background: "nope"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"fmt"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Blur applies a gaussian blur of the sigma of options to the image.
+func (e *GoImage) Blur(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	if err := checkSigma(options.Sigma); err != nil {
+		return nil, err
+	}
+
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(imaging.Blur(image, options.Sigma), options)
+}
+
+// MaxSigma is the maximum sigma of a blur or a sharpening, the size of the
+// gaussian kernel grows with it.
+const MaxSigma = 100
+
+// checkSigma returns an error if the sigma of a blur or a sharpening isn't
+// positive or exceeds MaxSigma.
+func checkSigma(sigma float64) error {
+	// the negation also rejects NaN
+	if !(sigma > 0 && sigma <= MaxSigma) {
+		return fmt.Errorf("Invalid sigma %v, it should be between 0 and %d", sigma, MaxSigma)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestBlur(t *testing.T) {
+	e := &GoImage{}
+
+	src := imaging.New(40, 40, color.NRGBA{0, 0, 0, 255})
+	for x := 20; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Blur(img, &Options{Format: imaging.PNG, Sigma: 3})
+	assert.Nil(t, err)
+
+	out, err := png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, imaging.Blur(src, 3).Pix, imaging.Clone(out).Pix)
+
+	// the edge is smoothed
+	r, _, _, _ := out.At(19, 20).RGBA()
+	assert.True(t, r>>8 > 0 && r>>8 < 255)
+
+	// the output format and quality are handled like the other operations
+	content, err = e.Blur(img, &Options{Format: imaging.JPEG, Quality: 50, Sigma: 3})
+	assert.Nil(t, err)
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Config{ColorModel: color.YCbCrModel, Width: 40, Height: 40}, cfg)
+
+	for _, sigma := range []float64{0, -1, math.NaN(), math.Inf(1), 1e12, 1e300} {
+		_, err = e.Blur(img, &Options{Format: imaging.PNG, Sigma: sigma})
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// DefaultBlurHashComponentsX and DefaultBlurHashComponentsY are the
+	// default numbers of horizontal and vertical components of a BlurHash
+	DefaultBlurHashComponentsX = 4
+	DefaultBlurHashComponentsY = 3
+
+	// blurHashSampleSize is the size of the thumbnail the BlurHash is computed from
+	blurHashSampleSize = 32
+)
+
+// blurHashCharacters are the digits of the base 83 encoding of BlurHash
+const blurHashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHash returns the BlurHash of img with x horizontal and y vertical
+// components in range [1, 9], see https://github.com/woltapp/blurhash.
+func blurHash(img image.Image, x int, y int) (string, error) {
+	if x < 1 || x > 9 || y < 1 || y > 9 {
+		return "", fmt.Errorf("Invalid BlurHash components %dx%d, they should be between 1 and 9", x, y)
+	}
+
+	src := imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	linear := make([][3]float64, width*height)
+	for i := range linear {
+		for c := 0; c < 3; c++ {
+			linear[i][c] = sRGBToLinear(src.Pix[i*4+c])
+		}
+	}
+
+	factors := make([][3]float64, 0, x*y)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for py := 0; py < height; py++ {
+				for px := 0; px < width; px++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i*px)/float64(width)) *
+						math.Cos(math.Pi*float64(j*py)/float64(height))
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * linear[py*width+px][c]
+					}
+				}
+			}
+
+			for c := 0; c < 3; c++ {
+				factor[c] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	hash := &strings.Builder{}
+	encodeBase83(hash, (x-1)+(y-1)*9, 1)
+
+	maximum := 1.0
+	if len(factors) > 1 {
+		var actual float64
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actual = math.Max(actual, math.Abs(v))
+			}
+		}
+
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		encodeBase83(hash, quantised, 1)
+	} else {
+		encodeBase83(hash, 0, 1)
+	}
+
+	dc := factors[0]
+	encodeBase83(hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	for _, factor := range factors[1:] {
+		var value int
+		for _, v := range factor {
+			quantised := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+			value = value*19 + quantised
+		}
+		encodeBase83(hash, value, 2)
+	}
+
+	return hash.String(), nil
+}
+
+// encodeBase83 writes value as length base 83 digits.
+func encodeBase83(w *strings.Builder, value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		w.WriteByte(blurHashCharacters[digit])
+	}
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow returns |value|^exp with the sign of value.
+func signPow(value float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// pngHeaderSize is the size of the PNG signature followed by the IHDR chunk.
+const pngHeaderSize = 8 + 4 + 4 + 13 + 4
+
+// linearGammaChunk is a gAMA chunk declaring a gamma of 1.0.
+var linearGammaChunk = pngChunk("gAMA", []byte{0x00, 0x01, 0x86, 0xa0})
+
+// checkColorSpace returns an error when the color space of the output isn't
+// supported by its format, the linear color space is only encoded by PNG.
+func checkColorSpace(options *Options) error {
+	if options.ColorSpace == ColorSpaceLinear && options.Format != imaging.PNG {
+		return fmt.Errorf("Color space %s is not supported for format %s", options.ColorSpace, options.Format)
+	}
+
+	return nil
+}
+
+// toColorSpace converts img to the color space of the output.
+func toColorSpace(img image.Image, options *Options) image.Image {
+	switch options.ColorSpace {
+	case ColorSpaceGray:
+		return imaging.Grayscale(img)
+	case ColorSpaceLinear:
+		return toLinear(img)
+	}
+
+	return img
+}
+
+// grayPalette converts the colors of a palette to shades of gray, the
+// frames of a GIF are converted without being quantized again.
+func grayPalette(palette color.Palette) {
+	for i := range palette {
+		r, g, b, a := palette[i].RGBA()
+		y := uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+		palette[i] = color.NRGBA{y, y, y, uint8(a >> 8)}
+	}
+}
+
+// toLinear converts img from sRGB to linear light, the result is stored
+// with 16 bits per channel to keep precision in the dark tones.
+func toLinear(img image.Image) *image.NRGBA64 {
+	var table [256]uint16
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v = v / 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		table[i] = uint16(v*0xffff + 0.5)
+	}
+
+	b := img.Bounds()
+	out := image.NewNRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			out.SetNRGBA64(x, y, color.NRGBA64{
+				R: table[c.R],
+				G: table[c.G],
+				B: table[c.B],
+				A: uint16(c.A) * 0x101,
+			})
+		}
+	}
+
+	return out
+}
+
+// withLinearGamma inserts a gAMA chunk right after the IHDR chunk of
+// an encoded PNG so decoders know the samples are linear.
+func withLinearGamma(content []byte) ([]byte, error) {
+	if len(content) < pngHeaderSize {
+		return nil, errors.New("Invalid PNG content")
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(content)+len(linearGammaChunk)))
+	buf.Write(content[:pngHeaderSize])
+	buf.Write(linearGammaChunk)
+	buf.Write(content[pngHeaderSize:])
+
+	return buf.Bytes(), nil
+}
+
+func pngChunk(name string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(data)))
+	copy(chunk[4:8], name)
+	chunk = append(chunk, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+
+	return append(chunk, crc...)
+}
@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"bytes"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestColorSpaceGray(t *testing.T) {
+	e := &GoImage{}
+	img := imaging.New(10, 10, colorRed)
+
+	for _, format := range []imaging.Format{imaging.PNG, imaging.BMP} {
+		content, err := e.toBytes(img, &Options{Format: format, Quality: 100, ColorSpace: ColorSpaceGray})
+		assert.Nil(t, err)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+
+		r, g, b, _ := out.At(5, 5).RGBA()
+		assert.Equal(t, r, g)
+		assert.Equal(t, g, b)
+	}
+}
+
+func TestColorSpaceLinear(t *testing.T) {
+	e := &GoImage{}
+	img := imaging.New(10, 10, colorRed)
+
+	content, err := e.toBytes(img, &Options{Format: imaging.PNG, ColorSpace: ColorSpaceLinear})
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(content, []byte("gAMA")))
+
+	_, err = png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	_, err = e.toBytes(img, &Options{Format: imaging.JPEG, ColorSpace: ColorSpaceLinear})
+	assert.NotNil(t, err)
+}
+
+func TestColorSpaceGIF(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}
+
+	// the frames are converted, the source isn't passed through
+	for _, width := range []int{20, 40} {
+		content, err := e.Resize(img, &Options{Format: imaging.GIF, Width: width, Height: width, ColorSpace: ColorSpaceGray})
+		assert.Nil(t, err)
+
+		g, err := gif.DecodeAll(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(g.Image))
+
+		for _, frame := range g.Image {
+			for _, c := range frame.Palette {
+				r, g, b, _ := c.RGBA()
+				assert.True(t, r == g && g == b)
+			}
+		}
+	}
+
+	_, err := e.Resize(img, &Options{Format: imaging.GIF, Width: 20, Height: 20, ColorSpace: ColorSpaceLinear})
+	assert.NotNil(t, err)
+}
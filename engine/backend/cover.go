@@ -0,0 +1,32 @@
+package backend
+
+import (
+	"fmt"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Cover scales the image to fill the target dimensions and crops the
+// overflow placed according to the gravity, like the CSS object-fit: cover.
+// The output always has the target dimensions, the image is upscaled when needed.
+func (e *GoImage) Cover(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	opts, err := coverOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Thumbnail(img, opts)
+}
+
+// coverOptions returns a copy of options upscaling the image, both target
+// dimensions are required.
+func coverOptions(options *Options) (*Options, error) {
+	if options.Width <= 0 || options.Height <= 0 {
+		return nil, fmt.Errorf("Cover dimensions %dx%d should be positive", options.Width, options.Height)
+	}
+
+	opts := *options
+	opts.Upscale = true
+
+	return &opts, nil
+}
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/constants"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestCover(t *testing.T) {
+	e := &GoImage{}
+
+	tests := []struct {
+		name   string
+		source image.Point
+		target image.Point
+	}{
+		{"landscape", image.Pt(500, 357), image.Pt(100, 100)},
+		{"portrait", image.Pt(357, 500), image.Pt(200, 100)},
+		{"square", image.Pt(300, 300), image.Pt(100, 50)},
+		// the source is upscaled to fill the target
+		{"smaller", image.Pt(40, 30), image.Pt(100, 100)},
+		{"mixed", image.Pt(100, 100), image.Pt(50, 200)},
+	}
+
+	for _, tt := range tests {
+		img := &imagefile.ImageFile{Source: newImage(t, tt.source.X, tt.source.Y, imaging.PNG)}
+
+		options := &Options{Format: imaging.PNG, Width: tt.target.X, Height: tt.target.Y}
+		content, err := e.Cover(img, options)
+		assert.Nil(t, err, tt.name)
+		assert.False(t, options.Upscale, tt.name)
+
+		cfg, err := png.DecodeConfig(bytes.NewReader(content))
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.target, image.Pt(cfg.Width, cfg.Height), tt.name)
+	}
+
+	// the pipeline upscales the image the same way
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 30, imaging.PNG)}
+	content, err := e.Pipeline(img, []Stage{
+		{Operation: "cover", Options: &Options{Format: imaging.PNG, Width: 100, Height: 100}},
+		{Operation: "grayscale", Options: &Options{Format: imaging.PNG}},
+	})
+	assert.Nil(t, err)
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Pt(100, 100), image.Pt(cfg.Width, cfg.Height))
+
+	for _, target := range []image.Point{{100, 0}, {0, 100}, {0, 0}} {
+		_, err := e.Cover(&imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}, &Options{
+			Format: imaging.PNG,
+			Width:  target.X,
+			Height: target.Y,
+		})
+		assert.NotNil(t, err, target)
+	}
+}
+
+func TestCoverGravity(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	// the left half of the source is red and the right half is blue
+	src := imaging.New(200, 100, blue)
+	src = imaging.Paste(src, imaging.New(100, 100, red), image.Pt(0, 0))
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, src, imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+
+	for gravity, expected := range map[string]color.NRGBA{
+		constants.TopLeft:     red,
+		constants.BottomRight: blue,
+	} {
+		content, err := e.Cover(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+			Format:      imaging.PNG,
+			Width:       50,
+			Height:      50,
+			CropGravity: gravity,
+		})
+		assert.Nil(t, err, gravity)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err, gravity)
+		assert.Equal(t, image.Rect(0, 0, 50, 50), out.Bounds(), gravity)
+		assert.Equal(t, expected, color.NRGBAModel.Convert(out.At(25, 25)), gravity)
+	}
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/thoas/picfit/constants"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	// CropGravityCenter centers the cropped rectangle, the other gravities are the stick positions
+	CropGravityCenter = "center"
+	// CropGravityFace centers the cropped rectangle on the largest detected face
+	CropGravityFace = "face"
+	// CropGravitySmart places the cropped rectangle where the image has the most details
+	CropGravitySmart = "smart"
+)
+
+// CropGravities are the positions of the cropped rectangle when its origin is omitted
+var CropGravities = append([]string{CropGravityCenter, CropGravityFace, CropGravitySmart}, constants.StickPositions...)
+
+// cropper places a cropped rectangle of width x height within img
+type cropper func(img image.Image, width int, height int) image.Rectangle
+
+// cropAnchors are the anchors of imaging indexed by gravity
+var cropAnchors = map[string]imaging.Anchor{
+	"":                    imaging.Center,
+	CropGravityCenter:     imaging.Center,
+	constants.BottomLeft:  imaging.BottomLeft,
+	constants.BottomRight: imaging.BottomRight,
+	constants.TopLeft:     imaging.TopLeft,
+	constants.TopRight:    imaging.TopRight,
+}
+
+// Crop cuts the rectangle of options out of the image without resizing it,
+// when no rectangle is provided a rectangle of the target dimensions is
+// placed according to the gravity.
+func (e *GoImage) Crop(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	cropped, err := e.cropImage(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(cropped, options)
+}
+
+// cropImage returns the rectangle of options cut out of img.
+func (e *GoImage) cropImage(img image.Image, options *Options) (image.Image, error) {
+	bounds := img.Bounds()
+	size := bounds.Sub(bounds.Min)
+
+	if !options.CropRect.Empty() {
+		if !options.CropRect.In(size) {
+			return nil, fmt.Errorf("Crop rectangle %v is out of the image bounds %v", options.CropRect, size)
+		}
+
+		return imaging.Crop(img, options.CropRect.Add(bounds.Min)), nil
+	}
+
+	crop, aware := e.cropper(options.CropGravity)
+	anchor, ok := cropAnchors[options.CropGravity]
+	if !ok && !aware {
+		return nil, fmt.Errorf("Invalid crop gravity %s, available values are: %v", options.CropGravity, CropGravities)
+	}
+
+	if options.Width <= 0 || options.Height <= 0 {
+		return nil, fmt.Errorf("Crop dimensions %dx%d should be positive", options.Width, options.Height)
+	}
+
+	if options.Width > size.Dx() || options.Height > size.Dy() {
+		return nil, fmt.Errorf("Crop dimensions %dx%d exceed the image dimensions %dx%d",
+			options.Width, options.Height, size.Dx(), size.Dy())
+	}
+
+	if aware {
+		return imaging.Crop(img, crop(img, options.Width, options.Height)), nil
+	}
+
+	return imaging.CropAnchor(img, options.Width, options.Height, anchor), nil
+}
+
+// cropper returns the cropper of the gravities depending on the content
+// of the images, the other gravities are anchors.
+func (e *GoImage) cropper(gravity string) (cropper, bool) {
+	switch gravity {
+	case CropGravityFace:
+		return e.faceCrop, true
+	case CropGravitySmart:
+		return smartCrop, true
+	}
+	return nil, false
+}
+
+// thumbnail returns the transformation scaling images to cover the target
+// dimensions and cropping the rectangle placed according to gravity.
+func (e *GoImage) thumbnail(gravity string) transformation {
+	if crop, ok := e.cropper(gravity); ok {
+		return coverCrop(crop)
+	}
+
+	anchor, ok := cropAnchors[gravity]
+	if !ok || anchor == imaging.Center {
+		return imaging.Thumbnail
+	}
+
+	return func(img image.Image, width int, height int, filter imaging.ResampleFilter) *image.NRGBA {
+		return imaging.Fill(img, width, height, anchor, filter)
+	}
+}
+
+// coverCrop returns the transformation scaling images to cover the target
+// dimensions and cropping the rectangle placed by crop.
+func coverCrop(crop cropper) transformation {
+	return func(img image.Image, width int, height int, filter imaging.ResampleFilter) *image.NRGBA {
+		b := img.Bounds()
+		if width <= 0 || height <= 0 || b.Empty() {
+			return &image.NRGBA{}
+		}
+
+		var scaled *image.NRGBA
+		if b.Dx()*height > b.Dy()*width {
+			scaled = imaging.Resize(img, 0, height, filter)
+		} else {
+			scaled = imaging.Resize(img, width, 0, filter)
+		}
+
+		return imaging.Crop(scaled, crop(scaled, width, height))
+	}
+}
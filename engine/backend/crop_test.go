@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/constants"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestCrop(t *testing.T) {
+	e := &GoImage{}
+
+	src := imaging.New(100, 60, color.NRGBA{200, 100, 50, 255})
+	for x := 50; x < 100; x++ {
+		for y := 30; y < 60; y++ {
+			src.SetNRGBA(x, y, colorRed)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Crop(img, &Options{Format: imaging.PNG, CropRect: image.Rect(40, 20, 60, 40)})
+	assert.Nil(t, err)
+
+	out, err := png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), out.Bounds())
+	assert.Equal(t, imaging.Clone(src.SubImage(image.Rect(40, 20, 60, 40))).Pix, imaging.Clone(out).Pix)
+
+	gravities := map[string]color.NRGBA{
+		"":                    colorRed,
+		CropGravityCenter:     colorRed,
+		constants.TopLeft:     {200, 100, 50, 255},
+		constants.BottomRight: colorRed,
+	}
+	for gravity, expected := range gravities {
+		content, err := e.Crop(img, &Options{Format: imaging.PNG, Width: 10, Height: 10, CropGravity: gravity})
+		assert.Nil(t, err)
+
+		out, err := png.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 10, 10), out.Bounds())
+		assert.Equal(t, expected, color.NRGBAModel.Convert(out.At(9, 9)), gravity)
+	}
+
+	for _, options := range []*Options{
+		{Format: imaging.PNG, CropRect: image.Rect(90, 50, 110, 70)},
+		{Format: imaging.PNG, Width: 200, Height: 10},
+		{Format: imaging.PNG, Width: 10},
+		{Format: imaging.PNG, Width: 10, Height: 10, CropGravity: "middle"},
+	} {
+		_, err := e.Crop(img, options)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// CropResize crops the region of options then resizes it, only the pixels
+// of the region are resampled and upscaling is relative to the region.
+func (e *GoImage) CropResize(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	cropped, err := crop(image, options.CropRect)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.transform(cropped, options, imaging.Resize, stretch)
+}
+
+// crop returns the region of img, relative to its origin, clamped to its bounds.
+func crop(img image.Image, region image.Rectangle) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	region = region.Add(bounds.Min).Intersect(bounds)
+	if region.Empty() {
+		return nil, ErrEmptyRegion
+	}
+
+	return imaging.Crop(img, region), nil
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestCropResize(t *testing.T) {
+	e := &GoImage{}
+
+	src := imaging.New(100, 100, color.NRGBA{200, 100, 50, 255})
+	for x := 50; x < 100; x++ {
+		for y := 50; y < 100; y++ {
+			src.SetNRGBA(x, y, colorRed)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.CropResize(img, &Options{Format: imaging.PNG, CropRect: image.Rect(60, 60, 90, 90), Width: 10, Height: 10})
+	assert.Nil(t, err)
+
+	out, err := png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 10), out.Bounds())
+	assert.Equal(t, colorRed, color.NRGBAModel.Convert(out.At(5, 5)))
+
+	// the crop is clamped and isn't upscaled
+	content, err = e.CropResize(img, &Options{Format: imaging.PNG, CropRect: image.Rect(80, 80, 200, 200), Width: 50, Height: 50})
+	assert.Nil(t, err)
+
+	out, err = png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), out.Bounds())
+
+	_, err = e.CropResize(img, &Options{Format: imaging.PNG, CropRect: image.Rect(200, 200, 300, 300), Width: 10, Height: 10})
+	assert.Equal(t, ErrEmptyRegion, err)
+}
+
+func BenchmarkCropResize(b *testing.B) {
+	img := imaging.New(2000, 2000, color.NRGBA{200, 100, 50, 255})
+	region := image.Rect(500, 500, 700, 700)
+
+	b.Run("crop then resize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cropped, _ := crop(img, region)
+			imaging.Resize(cropped, 100, 100, imaging.Lanczos)
+		}
+	})
+
+	b.Run("resize then crop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resized := imaging.Resize(img, 1000, 1000, imaging.Lanczos)
+			imaging.Crop(resized, image.Rect(250, 250, 350, 350))
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+const (
+	// DefaultDecodeCacheMaxEntries is the default maximum number of decoded images
+	DefaultDecodeCacheMaxEntries = 32
+
+	// DefaultDecodeCacheMaxBytes is the default maximum size of the pixels of the decoded images
+	DefaultDecodeCacheMaxBytes = 256 * 1024 * 1024
+)
+
+// DecodeCache is a LRU cache of decoded images safe for concurrent use,
+// it's bounded by a number of images and by the size of their pixels.
+// Cached images are shared so they must not be modified.
+type DecodeCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	bytes   int64
+	entries *list.List
+	items   map[string]*list.Element
+}
+
+type decodeCacheEntry struct {
+	key   string
+	img   image.Image
+	bytes int64
+}
+
+// NewDecodeCache returns a DecodeCache keeping at most maxEntries images
+// and maxBytes of pixels, the defaults are used for non positive limits.
+func NewDecodeCache(maxEntries int, maxBytes int64) *DecodeCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultDecodeCacheMaxEntries
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = DefaultDecodeCacheMaxBytes
+	}
+
+	return &DecodeCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// Get returns the image cached with the key and marks it as recently used
+func (c *DecodeCache) Get(key string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.entries.MoveToFront(elem)
+
+	return elem.Value.(*decodeCacheEntry).img, true
+}
+
+// Add caches img with the key and evicts the least recently used images
+// exceeding the limits, images larger than the cache aren't kept.
+func (c *DecodeCache) Add(key string, img image.Image) {
+	bytes := imageBytes(img)
+	if bytes > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+
+	c.items[key] = c.entries.PushFront(&decodeCacheEntry{key: key, img: img, bytes: bytes})
+	c.bytes += bytes
+
+	for c.entries.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Len returns the number of cached images
+func (c *DecodeCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries.Len()
+}
+
+func (c *DecodeCache) remove(elem *list.Element) {
+	entry := c.entries.Remove(elem).(*decodeCacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.bytes
+}
+
+// imageBytes returns the size of the pixels of img, 4 bytes per pixel are
+// assumed for the images without a pixel buffer.
+func imageBytes(img image.Image) int64 {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return int64(len(m.Pix))
+	case *image.RGBA:
+		return int64(len(m.Pix))
+	case *image.NRGBA64:
+		return int64(len(m.Pix))
+	case *image.RGBA64:
+		return int64(len(m.Pix))
+	case *image.Gray:
+		return int64(len(m.Pix))
+	case *image.Gray16:
+		return int64(len(m.Pix))
+	case *image.Paletted:
+		return int64(len(m.Pix))
+	case *image.CMYK:
+		return int64(len(m.Pix))
+	case *image.YCbCr:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr))
+	case *image.NYCbCrA:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr) + len(m.A))
+	}
+
+	return int64(img.Bounds().Dx()) * int64(img.Bounds().Dy()) * 4
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestDecodeCache(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+
+	c := NewDecodeCache(2, 1000)
+	c.Add("a", img)
+	c.Add("b", img)
+
+	// a is the most recently used, b is evicted
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Add("c", img)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// the size of the pixels is bounded
+	c = NewDecodeCache(10, 1000)
+	c.Add("a", img)
+	c.Add("b", img)
+	c.Add("c", img)
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	// images larger than the cache aren't kept
+	c.Add("d", image.NewNRGBA(image.Rect(0, 0, 20, 20)))
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// replacing an image doesn't count it twice
+	c.Add("b", img)
+	c.Add("b", img)
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, int64(800), c.bytes)
+
+	c = NewDecodeCache(0, 0)
+	assert.Equal(t, DefaultDecodeCacheMaxEntries, c.maxEntries)
+	assert.Equal(t, int64(DefaultDecodeCacheMaxBytes), c.maxBytes)
+}
+
+func TestDecodeCacheConcurrency(t *testing.T) {
+	c := NewDecodeCache(8, 0)
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d", (i+j)%12)
+				if _, ok := c.Get(key); !ok {
+					c.Add(key, img)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 8, c.Len())
+}
+
+func TestGoImageDecodeCache(t *testing.T) {
+	e := &GoImage{Decoded: NewDecodeCache(0, 0)}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	first, err := e.Resize(img, &Options{Format: imaging.PNG, Width: 20, Height: 20, AutoOrient: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, e.Decoded.Len())
+
+	second, err := e.Resize(img, &Options{Format: imaging.PNG, Width: 20, Height: 20, AutoOrient: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, e.Decoded.Len())
+	assert.Equal(t, first, second)
+
+	// the derivatives don't modify the cached image
+	_, err = e.Flip(img, &Options{Format: imaging.PNG, Position: "h", AutoOrient: true})
+	assert.Nil(t, err)
+	_, err = e.Resize(img, &Options{Format: imaging.PNG, Width: 40, Height: 40, SwapRB: true, AutoOrient: true})
+	assert.Nil(t, err)
+
+	decoded, err := e.source(img, &Options{AutoOrient: true})
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(decoded.At(0, 0)))
+
+	// the sources decoded without their orientation are cached apart
+	_, err = e.source(img, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, e.Decoded.Len())
+}
+
+func TestFlatDecodeCache(t *testing.T) {
+	e := &GoImage{Decoded: NewDecodeCache(0, 0)}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 40, color.NRGBA{0, 0, 255, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	_, err = e.Flat(img, &Options{
+		Format:   imaging.PNG,
+		Position: "0.0.100.100",
+		Images:   []imagefile.ImageFile{{Source: buf.Bytes()}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, e.Decoded.Len())
+
+	// the derivatives of the source computed after the flat are unchanged
+	content, err := e.Resize(img, &Options{Format: imaging.PNG, Width: 20, Height: 20})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(0, 0)))
+}
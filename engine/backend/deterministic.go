@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// pngStableChunks are the ancillary chunks kept in deterministic mode,
+// they change how the image is rendered and don't depend on the encoding time
+var pngStableChunks = map[string]bool{
+	"cHRM": true,
+	"gAMA": true,
+	"iCCP": true,
+	"sBIT": true,
+	"sRGB": true,
+	"tRNS": true,
+}
+
+// stripPNGChunks removes the ancillary chunks of a PNG, such as timestamps,
+// text and EXIF metadata, which are not needed to render the image.
+func stripPNGChunks(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, pngHeader) {
+		return nil, errors.New("Invalid PNG content")
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(content)))
+	buf.Write(pngHeader)
+
+	offset := len(pngHeader)
+	for offset+8 <= len(content) {
+		length := int(binary.BigEndian.Uint32(content[offset : offset+4]))
+		end := offset + 12 + length
+		if length < 0 || end > len(content) {
+			return nil, errors.New("Invalid PNG content")
+		}
+
+		name := string(content[offset+4 : offset+8])
+		// critical chunks start with an uppercase letter
+		if name[0]&0x20 == 0 || pngStableChunks[name] {
+			buf.Write(content[offset:end])
+		}
+
+		offset = end
+		if name == "IEND" {
+			break
+		}
+	}
+
+	return buf.Bytes(), nil
+}
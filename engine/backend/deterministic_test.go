@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestDeterministicPNG(t *testing.T) {
+	e := &GoImage{}
+
+	source := withPNGChunks(newImage(t, 40, 40, imaging.PNG),
+		pngChunk("tIME", []byte{0x07, 0xea, 10, 15, 12, 0, 0}),
+		pngChunk("tEXt", []byte("Comment\x00picfit")))
+
+	options := &Options{Format: imaging.PNG, Width: 20, Height: 20, Deterministic: true}
+
+	first, err := e.Resize(&imagefile.ImageFile{Source: source}, options)
+	assert.Nil(t, err)
+
+	second, err := e.Resize(&imagefile.ImageFile{Source: source}, options)
+	assert.Nil(t, err)
+
+	assert.Equal(t, first, second)
+
+	_, err = png.Decode(bytes.NewReader(first))
+	assert.Nil(t, err)
+}
+
+func TestStripPNGChunks(t *testing.T) {
+	source := withPNGChunks(newImage(t, 40, 40, imaging.PNG),
+		pngChunk("gAMA", []byte{0, 0, 0xb1, 0x8f}),
+		pngChunk("tIME", []byte{0x07, 0xea, 10, 15, 12, 0, 0}),
+		pngChunk("tEXt", []byte("Comment\x00picfit")))
+
+	// the chunks are part of a valid fixture
+	for _, name := range []string{"gAMA", "tIME", "tEXt"} {
+		assert.True(t, bytes.Contains(source, []byte(name)), name)
+	}
+
+	original, err := png.Decode(bytes.NewReader(source))
+	assert.Nil(t, err)
+
+	stripped, err := stripPNGChunks(source)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(stripped, []byte("tIME")))
+	assert.False(t, bytes.Contains(stripped, []byte("tEXt")))
+	assert.True(t, bytes.Contains(stripped, []byte("gAMA")))
+	assert.Equal(t, len(source)-len(pngChunk("tIME", make([]byte, 7)))-len(pngChunk("tEXt", []byte("Comment\x00picfit"))), len(stripped))
+
+	decoded, err := png.Decode(bytes.NewReader(stripped))
+	assert.Nil(t, err)
+	assert.Equal(t, imaging.Clone(original).Pix, imaging.Clone(decoded).Pix)
+
+	_, err = stripPNGChunks([]byte("GIF89a"))
+	assert.NotNil(t, err)
+}
+
+// withPNGChunks returns the PNG content with the chunks inserted after its
+// header chunk.
+func withPNGChunks(content []byte, chunks ...[]byte) []byte {
+	// the signature is followed by the 25 bytes of the IHDR chunk
+	offset := len(pngHeader) + 25
+
+	out := append([]byte{}, content[:offset]...)
+	for i := range chunks {
+		out = append(out, chunks[i]...)
+	}
+
+	return append(out, content[offset:]...)
+}
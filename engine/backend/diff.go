@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrEmptyImage is an error returned if an image has no pixels
+var ErrEmptyImage = errors.New("Image is empty")
+
+// Diff compares a and b, b is resized to the size of a if their dimensions
+// differ. It returns a heat map where differing pixels are drawn in red over
+// a dimmed grayscale version of a and a score between 0 (identical) and 1.
+func (e *GoImage) Diff(a image.Image, b image.Image) (image.Image, float64, error) {
+	if a == nil || b == nil || a.Bounds().Empty() || b.Bounds().Empty() {
+		return nil, 0, ErrEmptyImage
+	}
+
+	src := imaging.Clone(a)
+	dst := imaging.Clone(b)
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if dst.Bounds().Dx() != width || dst.Bounds().Dy() != height {
+		dst = imaging.Resize(dst, width, height, imaging.Lanczos)
+	}
+
+	heatmap := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	var total float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*src.Stride + x*4
+			j := y*dst.Stride + x*4
+
+			var diff float64
+			for c := 0; c < 4; c++ {
+				d := int(src.Pix[i+c]) - int(dst.Pix[j+c])
+				if d < 0 {
+					d = -d
+				}
+				diff += float64(d)
+			}
+			diff /= 4 * 255
+			total += diff
+
+			luminance := 0.299*float64(src.Pix[i]) + 0.587*float64(src.Pix[i+1]) + 0.114*float64(src.Pix[i+2])
+			gray := clampUint8(luminance / 4)
+
+			if diff == 0 {
+				heatmap.SetNRGBA(x, y, color.NRGBA{gray, gray, gray, 255})
+				continue
+			}
+
+			// any difference is visible, the stronger the brighter
+			heat := 128 + diff*127
+			heatmap.SetNRGBA(x, y, color.NRGBA{clampUint8(heat), gray, gray, 255})
+		}
+	}
+
+	return heatmap, total / float64(width*height), nil
+}
@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	e := &GoImage{}
+
+	a := imaging.New(20, 20, color.NRGBA{200, 100, 50, 255})
+
+	heatmap, score, err := e.Diff(a, imaging.Clone(a))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), score)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), heatmap.Bounds())
+
+	b := imaging.Clone(a)
+	b.SetNRGBA(5, 7, color.NRGBA{0, 0, 0, 255})
+
+	heatmap, score, err = e.Diff(a, b)
+	assert.Nil(t, err)
+	assert.True(t, score > 0)
+	assert.InDelta(t, (200+100+50)/(4*255.0)/400, score, 1e-9)
+
+	r, g, _, _ := heatmap.At(5, 7).RGBA()
+	assert.True(t, r > g)
+	r, g, _, _ = heatmap.At(6, 7).RGBA()
+	assert.Equal(t, r, g)
+
+	heatmap, score, err = e.Diff(a, imaging.New(40, 40, color.NRGBA{200, 100, 50, 255}))
+	assert.Nil(t, err)
+	assert.InDelta(t, 0, score, 1e-3)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), heatmap.Bounds())
+
+	_, _, err = e.Diff(a, image.NewNRGBA(image.Rect(0, 0, 0, 0)))
+	assert.Equal(t, ErrEmptyImage, err)
+}
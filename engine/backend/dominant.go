@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/cenkalti/dominantcolor"
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// dominantSampleSize is the size of the thumbnail the dominant color is computed from
+const dominantSampleSize = 64
+
+// DominantColor returns an image of the dimensions of options filled with
+// the dominant color of the source, the source itself isn't resized.
+func (e *GoImage) DominantColor(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	src, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dominantColor(src)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := placeholderSize(src.Bounds().Dx(), src.Bounds().Dy(), options.Width, options.Height)
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	return e.toBytes(out, options)
+}
+
+// PaletteColor is a color of the palette of an image with its weight, the
+// approximate share of the pixels of the image close to the color
+type PaletteColor struct {
+	Color  string  `json:"color"`
+	Weight float64 `json:"weight"`
+}
+
+// Palette returns the JSON encoded dominant colors of the image in their
+// order of dominance, at most PaletteCount colors are returned.
+func (e *GoImage) Palette(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	src, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := imaging.Fit(src, dominantSampleSize, dominantSampleSize, imaging.Box)
+
+	colors := []PaletteColor{}
+	for _, c := range dominantcolor.FindWeight(thumb, options.PaletteCount) {
+		colors = append(colors, PaletteColor{
+			Color:  strings.ToLower(dominantcolor.Hex(c.RGBA)),
+			Weight: math.Round(c.Weight*1000) / 1000,
+		})
+	}
+
+	return json.Marshal(map[string][]PaletteColor{"colors": colors})
+}
+
+// dominantColor returns the dominant color of a downsampled copy of img.
+func dominantColor(img image.Image) (color.NRGBA, error) {
+	thumb := imaging.Fit(img, dominantSampleSize, dominantSampleSize, imaging.Box)
+
+	rgb, err := Hex2RGB(Hex(FindDominantColor(thumb)))
+	if err != nil {
+		return color.NRGBA{}, err
+	}
+
+	return color.NRGBA{rgb.Red, rgb.Green, rgb.Blue, 255}, nil
+}
+
+// placeholderSize returns the target dimensions, a zero dimension is computed
+// from the aspect ratio of the source and the source dimensions are used when
+// both are zero.
+func placeholderSize(srcWidth int, srcHeight int, width int, height int) (int, int) {
+	switch {
+	case width == 0 && height == 0:
+		return srcWidth, srcHeight
+	case width == 0:
+		width = int(math.Max(1, math.Round(float64(height)*float64(srcWidth)/float64(srcHeight))))
+	case height == 0:
+		height = int(math.Max(1, math.Round(float64(width)*float64(srcHeight)/float64(srcWidth))))
+	}
+
+	return width, height
+}
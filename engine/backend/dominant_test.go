@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestDominantColor(t *testing.T) {
+	e := &GoImage{}
+
+	// three quarters of the source are red
+	src := imaging.New(200, 100, colorRed)
+	for x := 150; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.DominantColor(img, &Options{Format: imaging.PNG, Width: 30, Height: 20})
+	assert.Nil(t, err)
+
+	out, err := png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 30, 20), out.Bounds())
+
+	expected := color.NRGBAModel.Convert(out.At(0, 0))
+	r, g, b, _ := expected.RGBA()
+	assert.True(t, r>>8 > 200 && g>>8 < 50 && b>>8 < 50)
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			assert.Equal(t, expected, color.NRGBAModel.Convert(out.At(x, y)))
+		}
+	}
+
+	// a zero dimension keeps the aspect ratio of the source
+	content, err = e.DominantColor(img, &Options{Format: imaging.PNG, Width: 50})
+	assert.Nil(t, err)
+
+	out, err = png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 25), out.Bounds())
+}
+
+func TestPalette(t *testing.T) {
+	e := &GoImage{}
+
+	// three quarters of the source are red
+	src := imaging.New(200, 100, colorRed)
+	for x := 150; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Palette(img, &Options{PaletteCount: 5})
+	assert.Nil(t, err)
+
+	palette := map[string][]PaletteColor{}
+	err = json.Unmarshal(content, &palette)
+	assert.Nil(t, err)
+
+	// the image has two colors only
+	colors := palette["colors"]
+	assert.Len(t, colors, 2)
+	assert.Equal(t, "#ff0000", colors[0].Color)
+	assert.InDelta(t, 0.75, colors[0].Weight, 0.02)
+	assert.Equal(t, "#0000ff", colors[1].Color)
+	assert.InDelta(t, 0.25, colors[1].Weight, 0.02)
+
+	content, err = e.Palette(img, &Options{PaletteCount: 1})
+	assert.Nil(t, err)
+
+	// a single color is the average color
+	err = json.Unmarshal(content, &palette)
+	assert.Nil(t, err)
+	assert.Equal(t, []PaletteColor{{Color: "#bf003f", Weight: 1}}, palette["colors"])
+}
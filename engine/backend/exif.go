@@ -1,14 +1,25 @@
 package backend
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/disintegration/imaging"
 	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
 	_ "golang.org/x/image/webp"
+
+	imagefile "github.com/thoas/picfit/image"
 )
 
+// ErrNoEXIFThumbnail is an error returned if the source has no embedded thumbnail
+var ErrNoEXIFThumbnail = errors.New("No EXIF thumbnail")
+
 //Decode is image.Decode handling orientation in EXIF tags if exists.
 //Requires io.ReadSeeker instead of io.Reader.
 func decode(reader io.ReadSeeker) (image.Image, error) {
@@ -17,27 +28,32 @@ func decode(reader io.ReadSeeker) (image.Image, error) {
 		return nil, err
 	}
 	reader.Seek(0, io.SeekStart)
-	orientation := getOrientation(reader)
-	switch orientation {
-	case "1":
+	orientation, err := strconv.Atoi(getOrientation(reader))
+	if err != nil {
 		return img, nil
-	case "2":
-		return imaging.FlipV(img), nil
-	case "3":
-		return imaging.Rotate180(img), nil
-	case "4":
-		return imaging.Rotate180(imaging.FlipV(img)), nil
-	case "5":
-		return imaging.Rotate270(imaging.FlipV(img)), nil
-	case "6":
-		return imaging.Rotate270(img), nil
-	case "7":
-		return imaging.Rotate90(imaging.FlipV(img)), nil
-	case "8":
-		return imaging.Rotate90(img), nil
-	default:
+	}
+
+	oriented, err := orient(img, orientation)
+	if err != nil {
+		// invalid orientations are ignored
 		return img, nil
 	}
+
+	return oriented, nil
+}
+
+// orient applies to img the transformations of the given EXIF orientation.
+func orient(img image.Image, orientation int) (image.Image, error) {
+	transformations, ok := orientationTransformations[orientation]
+	if !ok {
+		return nil, fmt.Errorf("Invalid orientation %d, it should be between 1 and 8", orientation)
+	}
+
+	for _, transform := range transformations {
+		img = transform(img)
+	}
+
+	return img, nil
 }
 
 // SameInputAndOutputHeader return true if image width and height
@@ -88,3 +104,114 @@ func getOrientation(reader io.Reader) string {
 
 	return "1"
 }
+
+// EXIF returns the EXIF tags of the image as a map, GPS tags are omitted
+// when OmitGPS is enabled.
+func (e *GoImage) EXIF(img *imagefile.ImageFile) (map[string]interface{}, error) {
+	x, err := exif.Decode(bytes.NewReader(img.Source))
+	if err != nil {
+		return nil, err
+	}
+
+	w := &exifWalker{tags: map[string]interface{}{}, omitGPS: e.OmitGPS}
+	if err := x.Walk(w); err != nil {
+		return nil, err
+	}
+
+	return w.tags, nil
+}
+
+type exifWalker struct {
+	tags    map[string]interface{}
+	omitGPS bool
+}
+
+func (w *exifWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	if w.omitGPS && strings.HasPrefix(string(name), "GPS") {
+		return nil
+	}
+
+	switch tag.Format() {
+	case tiff.StringVal:
+		value, err := tag.StringVal()
+		if err == nil {
+			w.tags[string(name)] = strings.TrimSpace(value)
+		}
+		return nil
+	case tiff.UndefVal, tiff.OtherVal:
+		// undefined values such as maker notes are binary blobs
+		return nil
+	}
+
+	var values []interface{}
+	for i := 0; i < int(tag.Count); i++ {
+		var (
+			value interface{}
+			err   error
+		)
+
+		switch tag.Format() {
+		case tiff.IntVal:
+			value, err = tag.Int64(i)
+		case tiff.FloatVal:
+			value, err = tag.Float(i)
+		case tiff.RatVal:
+			var num, den int64
+			num, den, err = tag.Rat2(i)
+			if den != 0 {
+				value = float64(num) / float64(den)
+			}
+		}
+
+		if err != nil || value == nil {
+			return nil
+		}
+
+		values = append(values, value)
+	}
+
+	switch len(values) {
+	case 0:
+	case 1:
+		w.tags[string(name)] = values[0]
+	default:
+		w.tags[string(name)] = values
+	}
+
+	return nil
+}
+
+// EXIFThumbnail returns the thumbnail embedded in the EXIF tags of the image
+// as is, only the EXIF segment of the source is read.
+func (e *GoImage) EXIFThumbnail(img *imagefile.ImageFile) ([]byte, error) {
+	x, err := exif.Decode(bytes.NewReader(img.Source))
+	if err != nil {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	offset, err := x.Get(exif.ThumbJPEGInterchangeFormat)
+	if err != nil {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	length, err := x.Get(exif.ThumbJPEGInterchangeFormatLength)
+	if err != nil {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	start, err := offset.Int(0)
+	if err != nil {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	size, err := length.Int(0)
+	if err != nil {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	if start <= 0 || size <= 0 || start+size > len(x.Raw) {
+		return nil, ErrNoEXIFThumbnail
+	}
+
+	return x.Raw[start : start+size], nil
+}
@@ -0,0 +1,319 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+type exifEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+// newEXIFJPEG returns a JPEG with an APP1 segment containing
+// a camera make and model, an exposure time, an ISO and a GPS latitude ref.
+func newEXIFJPEG(t *testing.T) []byte {
+	order := binary.LittleEndian
+
+	u16 := func(v uint16) []byte { b := make([]byte, 2); order.PutUint16(b, v); return b }
+	u32 := func(v uint32) []byte { b := make([]byte, 4); order.PutUint32(b, v); return b }
+
+	tiffData := []byte{'I', 'I', 0x2a, 0x00}
+	tiffData = append(tiffData, u32(8)...)
+
+	ifds := [][]exifEntry{
+		{
+			{0x010f, 2, 6, []byte("Canon\x00")},
+			{0x0110, 2, 7, []byte("EOS 5D\x00")},
+			{0x8769, 4, 1, nil},
+			{0x8825, 4, 1, nil},
+		},
+		{
+			{0x829a, 5, 1, append(u32(1), u32(250)...)},
+			{0x8827, 3, 1, append(u16(200), 0, 0)},
+		},
+		{
+			{0x0001, 2, 2, []byte("N\x00\x00\x00")},
+		},
+	}
+
+	ifdSize := func(entries []exifEntry) int { return 2 + 12*len(entries) + 4 }
+
+	// offsets of the IFDs, each one followed by the values not fitting in 4 bytes
+	offsets := make([]int, len(ifds))
+	offset := 8
+	for i, entries := range ifds {
+		offsets[i] = offset
+		offset += ifdSize(entries)
+		for _, entry := range entries {
+			if len(entry.value) > 4 {
+				offset += len(entry.value)
+			}
+		}
+	}
+	ifds[0][2].value = u32(uint32(offsets[1]))
+	ifds[0][3].value = u32(uint32(offsets[2]))
+
+	for i, entries := range ifds {
+		data := offsets[i] + ifdSize(entries)
+		var extra []byte
+
+		tiffData = append(tiffData, u16(uint16(len(entries)))...)
+		for _, entry := range entries {
+			tiffData = append(tiffData, u16(entry.tag)...)
+			tiffData = append(tiffData, u16(entry.kind)...)
+			tiffData = append(tiffData, u32(entry.count)...)
+			if len(entry.value) > 4 {
+				tiffData = append(tiffData, u32(uint32(data+len(extra)))...)
+				extra = append(extra, entry.value...)
+			} else {
+				tiffData = append(tiffData, entry.value...)
+			}
+		}
+		tiffData = append(tiffData, u32(0)...)
+		tiffData = append(tiffData, extra...)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiffData...)
+
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, imaging.New(10, 10, colorRed), nil)
+	assert.Nil(t, err)
+
+	segment := []byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	content := buf.Bytes()
+	return append(append(append([]byte{}, content[:2]...), segment...), content[2:]...)
+}
+
+func TestEXIF(t *testing.T) {
+	img := &imagefile.ImageFile{Source: newEXIFJPEG(t)}
+
+	e := &GoImage{}
+	tags, err := e.EXIF(img)
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", tags["Make"])
+	assert.Equal(t, "EOS 5D", tags["Model"])
+	assert.Equal(t, 0.004, tags["ExposureTime"])
+	assert.Equal(t, int64(200), tags["ISOSpeedRatings"])
+	assert.Equal(t, "N", tags["GPSLatitudeRef"])
+
+	e = &GoImage{OmitGPS: true}
+	tags, err = e.EXIF(img)
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", tags["Make"])
+	assert.NotContains(t, tags, "GPSLatitudeRef")
+	assert.NotContains(t, tags, "GPSInfoIFDPointer")
+
+	_, err = e.EXIF(&imagefile.ImageFile{Source: newImage(t, 10, 10, imaging.PNG)})
+	assert.NotNil(t, err)
+}
+
+func TestForceOrientation(t *testing.T) {
+	const width, height = 3, 2
+
+	src := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 50), uint8(y * 50), 0, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	// the expected position of each source pixel for every orientation
+	positions := map[int]func(x, y int) (int, int){
+		1: func(x, y int) (int, int) { return x, y },
+		2: func(x, y int) (int, int) { return width - 1 - x, y },
+		3: func(x, y int) (int, int) { return width - 1 - x, height - 1 - y },
+		4: func(x, y int) (int, int) { return x, height - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return height - 1 - y, x },
+		7: func(x, y int) (int, int) { return height - 1 - y, width - 1 - x },
+		8: func(x, y int) (int, int) { return y, width - 1 - x },
+	}
+
+	e := &GoImage{}
+	for orientation, position := range positions {
+		out, err := e.source(img, &Options{ForceOrientation: orientation})
+		assert.Nil(t, err)
+
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				ox, oy := position(x, y)
+				assert.Equal(t, src.At(x, y), color.NRGBAModel.Convert(out.At(ox, oy)), "orientation %d", orientation)
+			}
+		}
+	}
+
+	_, err = e.source(img, &Options{ForceOrientation: 9})
+	assert.NotNil(t, err)
+}
+
+// newEXIFThumbnailJPEG returns a JPEG with an APP1 segment embedding thumbnail in IFD1.
+func newEXIFThumbnailJPEG(t *testing.T, thumbnail []byte) []byte {
+	order := binary.LittleEndian
+
+	u16 := func(v uint16) []byte { b := make([]byte, 2); order.PutUint16(b, v); return b }
+	u32 := func(v uint32) []byte { b := make([]byte, 4); order.PutUint32(b, v); return b }
+	entry := func(tag uint16, kind uint16, value []byte) []byte {
+		e := append(u16(tag), u16(kind)...)
+		e = append(e, u32(1)...)
+		return append(e, value...)
+	}
+
+	// header, IFD0 at 8 with an orientation, IFD1 at 26 followed by the thumbnail at 56
+	tiffData := []byte{'I', 'I', 0x2a, 0x00}
+	tiffData = append(tiffData, u32(8)...)
+	tiffData = append(tiffData, u16(1)...)
+	tiffData = append(tiffData, entry(0x0112, 3, append(u16(1), 0, 0))...)
+	tiffData = append(tiffData, u32(26)...)
+	tiffData = append(tiffData, u16(2)...)
+	tiffData = append(tiffData, entry(0x0201, 4, u32(56))...)
+	tiffData = append(tiffData, entry(0x0202, 4, u32(uint32(len(thumbnail))))...)
+	tiffData = append(tiffData, u32(0)...)
+	tiffData = append(tiffData, thumbnail...)
+
+	payload := append([]byte("Exif\x00\x00"), tiffData...)
+
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, imaging.New(100, 100, colorRed), nil)
+	assert.Nil(t, err)
+
+	segment := []byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	content := buf.Bytes()
+	return append(append(append([]byte{}, content[:2]...), segment...), content[2:]...)
+}
+
+func TestEXIFThumbnail(t *testing.T) {
+	e := &GoImage{}
+
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, imaging.New(8, 8, colorRed), nil)
+	assert.Nil(t, err)
+
+	thumbnail, err := e.EXIFThumbnail(&imagefile.ImageFile{Source: newEXIFThumbnailJPEG(t, buf.Bytes())})
+	assert.Nil(t, err)
+	assert.Equal(t, buf.Bytes(), thumbnail)
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, cfg.Width)
+
+	_, err = e.EXIFThumbnail(&imagefile.ImageFile{Source: newEXIFJPEG(t)})
+	assert.Equal(t, ErrNoEXIFThumbnail, err)
+
+	_, err = e.EXIFThumbnail(&imagefile.ImageFile{Source: buf.Bytes()})
+	assert.Equal(t, ErrNoEXIFThumbnail, err)
+}
+
+// newOrientedJPEG returns src encoded as a JPEG with an APP1 segment
+// containing the given EXIF orientation.
+func newOrientedJPEG(t *testing.T, src image.Image, orientation uint16) []byte {
+	order := binary.LittleEndian
+
+	u16 := func(v uint16) []byte { b := make([]byte, 2); order.PutUint16(b, v); return b }
+	u32 := func(v uint32) []byte { b := make([]byte, 4); order.PutUint32(b, v); return b }
+
+	// header and IFD0 at 8 with a single orientation entry
+	tiffData := []byte{'I', 'I', 0x2a, 0x00}
+	tiffData = append(tiffData, u32(8)...)
+	tiffData = append(tiffData, u16(1)...)
+	tiffData = append(tiffData, u16(0x0112)...)
+	tiffData = append(tiffData, u16(3)...)
+	tiffData = append(tiffData, u32(1)...)
+	tiffData = append(tiffData, append(u16(orientation), 0, 0)...)
+	tiffData = append(tiffData, u32(0)...)
+
+	payload := append([]byte("Exif\x00\x00"), tiffData...)
+
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, src, &jpeg.Options{Quality: 100})
+	assert.Nil(t, err)
+
+	segment := []byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	content := buf.Bytes()
+	return append(append(append([]byte{}, content[:2]...), segment...), content[2:]...)
+}
+
+func TestAutoOrient(t *testing.T) {
+	const width, height = 64, 32
+
+	// quadrants aligned on the MCUs so their colors are kept
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+	src := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			src.SetNRGBA(x, y, colors[x/(width/2)+2*(y/(height/2))])
+		}
+	}
+
+	// the expected position of each source pixel for every orientation
+	positions := map[uint16]func(x, y int) (int, int){
+		1: func(x, y int) (int, int) { return x, y },
+		2: func(x, y int) (int, int) { return width - 1 - x, y },
+		3: func(x, y int) (int, int) { return width - 1 - x, height - 1 - y },
+		4: func(x, y int) (int, int) { return x, height - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return height - 1 - y, x },
+		7: func(x, y int) (int, int) { return height - 1 - y, width - 1 - x },
+		8: func(x, y int) (int, int) { return y, width - 1 - x },
+	}
+
+	near := func(expected color.Color, actual color.Color, msgAndArgs ...interface{}) {
+		e := color.NRGBAModel.Convert(expected).(color.NRGBA)
+		a := color.NRGBAModel.Convert(actual).(color.NRGBA)
+		assert.InDelta(t, int(e.R), int(a.R), 8, msgAndArgs...)
+		assert.InDelta(t, int(e.G), int(a.G), 8, msgAndArgs...)
+		assert.InDelta(t, int(e.B), int(a.B), 8, msgAndArgs...)
+	}
+
+	e := &GoImage{}
+	for orientation, position := range positions {
+		img := &imagefile.ImageFile{Source: newOrientedJPEG(t, src, orientation)}
+
+		out, err := e.source(img, &Options{AutoOrient: true})
+		assert.Nil(t, err)
+
+		for _, p := range []image.Point{{8, 8}, {56, 8}, {8, 24}, {56, 24}} {
+			ox, oy := position(p.X, p.Y)
+			near(src.At(p.X, p.Y), out.At(ox, oy), "orientation %d", orientation)
+		}
+
+		// the orientation is ignored when disabled
+		out, err = e.source(img, &Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, src.Bounds(), out.Bounds())
+		near(src.At(8, 8), out.At(8, 8), "orientation %d", orientation)
+
+		// the output is oriented and doesn't contain the tag anymore
+		content, err := e.Resize(img, &Options{Format: imaging.JPEG, Width: 16, AutoOrient: true})
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(content, []byte("Exif")))
+		assert.Equal(t, "1", getOrientation(bytes.NewReader(content)))
+
+		ok, err := e.WouldTransform(img, &Options{Format: imaging.JPEG, Width: 2 * width, Height: 2 * height, AutoOrient: true})
+		assert.Nil(t, err)
+		assert.Equal(t, orientation != 1, ok)
+	}
+}
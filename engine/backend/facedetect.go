@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"errors"
+	"image"
+
+	"github.com/thoas/picfit/logger"
+)
+
+// ErrFaceDetectionNotSupported is an error returned when a face detector is
+// created by a build without the facedetect tag
+var ErrFaceDetectionNotSupported = errors.New("Face detection is not supported, picfit has to be built with the facedetect tag")
+
+// faceCrop returns the rectangle of width x height centered on the largest
+// face found by the detector of the backend, the rectangle is centered on
+// the image when there is no detector or no face is found.
+func (e *GoImage) faceCrop(img image.Image, width int, height int) image.Rectangle {
+	b := img.Bounds()
+	center := b.Min.Add(b.Size().Div(2))
+
+	if e.Detector != nil {
+		faces, err := e.Detector.Detect(img)
+		if err != nil && e.Logger != nil {
+			e.Logger.Error("Face detection failed, the crop is centered", logger.Error(err))
+		}
+
+		var largest image.Rectangle
+		for _, face := range faces {
+			if face.Dx()*face.Dy() > largest.Dx()*largest.Dy() {
+				largest = face
+			}
+		}
+
+		// faces are relative to the top left corner of img
+		if !largest.Empty() {
+			center = b.Min.Add(largest.Min).Add(largest.Size().Div(2))
+		}
+	}
+
+	x := clampInt(center.X-width/2, b.Min.X, b.Max.X-width)
+	y := clampInt(center.Y-height/2, b.Min.Y, b.Max.Y-height)
+
+	return image.Rect(x, y, x+width, y+height).Intersect(b)
+}
@@ -0,0 +1,49 @@
+// +build facedetect
+
+#include <cstddef>
+#include <vector>
+
+#include <opencv2/imgproc.hpp>
+#include <opencv2/objdetect.hpp>
+
+#include "facedetect_opencv.h"
+
+picfit_cascade picfit_cascade_load(const char *path) {
+	cv::CascadeClassifier *cascade = new cv::CascadeClassifier();
+	try {
+		if (cascade->load(path)) {
+			return cascade;
+		}
+	} catch (...) {
+	}
+
+	delete cascade;
+	return NULL;
+}
+
+void picfit_cascade_free(picfit_cascade cascade) {
+	delete static_cast<cv::CascadeClassifier *>(cascade);
+}
+
+int picfit_detect_faces(picfit_cascade cascade, unsigned char *pixels, int width, int height, int *faces, int max) {
+	cv::Mat gray(height, width, CV_8UC1, pixels);
+	std::vector<cv::Rect> detected;
+
+	try {
+		cv::Mat equalized;
+		cv::equalizeHist(gray, equalized);
+		static_cast<cv::CascadeClassifier *>(cascade)->detectMultiScale(equalized, detected, 1.1, 3, 0, cv::Size(24, 24));
+	} catch (...) {
+		return -1;
+	}
+
+	int n = 0;
+	for (; n < (int)detected.size() && n < max; n++) {
+		faces[n * 4] = detected[n].x;
+		faces[n * 4 + 1] = detected[n].y;
+		faces[n * 4 + 2] = detected[n].width;
+		faces[n * 4 + 3] = detected[n].height;
+	}
+
+	return n;
+}
@@ -0,0 +1,91 @@
+//go:build facedetect
+// +build facedetect
+
+package backend
+
+/*
+#cgo pkg-config: opencv4
+#cgo CXXFLAGS: -std=c++11
+#include <stdlib.h>
+#include "facedetect_opencv.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+// faceDetectionSupported is true when picfit is built with the facedetect tag
+const faceDetectionSupported = true
+
+// faceDetectorMaxFaces is the maximum number of faces returned by a detection
+const faceDetectorMaxFaces = 64
+
+// faceDetector detects the faces of images with an OpenCV cascade classifier
+type faceDetector struct {
+	// the classifier isn't safe for concurrent use
+	mu      sync.Mutex
+	cascade C.picfit_cascade
+}
+
+// NewFaceDetector loads the OpenCV cascade classifier stored in the file
+// cascade such as haarcascade_frontalface_default.xml.
+func NewFaceDetector(cascade string) (RegionDetector, error) {
+	path := C.CString(cascade)
+	defer C.free(unsafe.Pointer(path))
+
+	c := C.picfit_cascade_load(path)
+	if c == nil {
+		return nil, fmt.Errorf("Unable to load the face cascade %s", cascade)
+	}
+
+	d := &faceDetector{cascade: c}
+	runtime.SetFinalizer(d, func(d *faceDetector) {
+		C.picfit_cascade_free(d.cascade)
+	})
+
+	return d, nil
+}
+
+// Detect implements RegionDetector, faces are relative to the top left corner of img.
+func (d *faceDetector) Detect(img image.Image) ([]image.Rectangle, error) {
+	gray := imaging.Grayscale(img)
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return nil, nil
+	}
+
+	pixels := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = gray.Pix[y*gray.Stride+x*4]
+		}
+	}
+
+	faces := make([]C.int, 4*faceDetectorMaxFaces)
+
+	d.mu.Lock()
+	n := C.picfit_detect_faces(d.cascade, (*C.uchar)(unsafe.Pointer(&pixels[0])),
+		C.int(width), C.int(height), &faces[0], C.int(faceDetectorMaxFaces))
+	d.mu.Unlock()
+	runtime.KeepAlive(d)
+
+	if n < 0 {
+		return nil, errors.New("Face detection failed")
+	}
+
+	rects := make([]image.Rectangle, int(n))
+	for i := range rects {
+		x, y := int(faces[i*4]), int(faces[i*4+1])
+		rects[i] = image.Rect(x, y, x+int(faces[i*4+2]), y+int(faces[i*4+3]))
+	}
+
+	return rects, nil
+}
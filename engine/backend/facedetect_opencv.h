@@ -0,0 +1,25 @@
+// +build facedetect
+
+#ifndef PICFIT_FACEDETECT_OPENCV_H
+#define PICFIT_FACEDETECT_OPENCV_H
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef void *picfit_cascade;
+
+// picfit_cascade_load returns the cascade classifier stored at path or NULL
+picfit_cascade picfit_cascade_load(const char *path);
+
+void picfit_cascade_free(picfit_cascade cascade);
+
+// picfit_detect_faces writes at most max faces of the grayscale pixels as
+// x, y, width and height to faces and returns their number or -1 on error
+int picfit_detect_faces(picfit_cascade cascade, unsigned char *pixels, int width, int height, int *faces, int max);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -0,0 +1,13 @@
+//go:build !facedetect
+// +build !facedetect
+
+package backend
+
+// faceDetectionSupported is true when picfit is built with the facedetect tag
+const faceDetectionSupported = false
+
+// NewFaceDetector returns ErrFaceDetectionNotSupported, picfit has to be
+// built with the facedetect tag to detect faces.
+func NewFaceDetector(cascade string) (RegionDetector, error) {
+	return nil, ErrFaceDetectionNotSupported
+}
@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// staticDetector returns the same faces for every image
+type staticDetector struct {
+	faces []image.Rectangle
+	err   error
+}
+
+func (d staticDetector) Detect(img image.Image) ([]image.Rectangle, error) {
+	return d.faces, d.err
+}
+
+// colorDetector returns the bounds of the pixels of its color as a face
+type colorDetector color.NRGBA
+
+func (d colorDetector) Detect(img image.Image) ([]image.Rectangle, error) {
+	var face image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.NRGBAModel.Convert(img.At(x, y)) == color.NRGBA(d) {
+				face = face.Union(image.Rect(x, y, x+1, y+1).Sub(b.Min))
+			}
+		}
+	}
+
+	if face.Empty() {
+		return nil, nil
+	}
+	return []image.Rectangle{face}, nil
+}
+
+func TestFaceCrop(t *testing.T) {
+	img := imaging.New(200, 100, color.White)
+
+	tests := []struct {
+		name     string
+		detector RegionDetector
+		rect     image.Rectangle
+	}{
+		{"no detector", nil, image.Rect(75, 25, 125, 75)},
+		{"no face", staticDetector{}, image.Rect(75, 25, 125, 75)},
+		{"error", staticDetector{err: errors.New("KO")}, image.Rect(75, 25, 125, 75)},
+		// the largest face is centered within the image bounds
+		{"faces", staticDetector{faces: []image.Rectangle{
+			image.Rect(10, 10, 20, 20),
+			image.Rect(150, 50, 190, 90),
+		}}, image.Rect(145, 45, 195, 95)},
+		{"edge", staticDetector{faces: []image.Rectangle{image.Rect(0, 0, 20, 20)}}, image.Rect(0, 0, 50, 50)},
+	}
+
+	for _, tt := range tests {
+		e := &GoImage{Detector: tt.detector}
+		assert.Equal(t, tt.rect, e.faceCrop(img, 50, 50), tt.name)
+	}
+}
+
+func TestFaceGravity(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+
+	src := imaging.New(200, 100, color.NRGBA{255, 255, 255, 255})
+	src = imaging.Paste(src, imaging.New(40, 40, red), image.Pt(150, 50))
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, src, imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{Detector: colorDetector(red)}
+
+	content, err := e.Crop(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:      imaging.PNG,
+		Width:       40,
+		Height:      40,
+		CropGravity: CropGravityFace,
+	})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), out.Bounds())
+	assert.Equal(t, red, color.NRGBAModel.Convert(out.At(0, 0)))
+	assert.Equal(t, red, color.NRGBAModel.Convert(out.At(39, 39)))
+
+	// the thumbnail is cropped around the face once scaled
+	content, err = e.Thumbnail(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:      imaging.PNG,
+		Width:       50,
+		Height:      50,
+		CropGravity: CropGravityFace,
+	})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), out.Bounds())
+	assert.Equal(t, red, color.NRGBAModel.Convert(out.At(35, 35)))
+	assert.NotEqual(t, red, color.NRGBAModel.Convert(out.At(10, 10)))
+}
+
+func TestNewFaceDetector(t *testing.T) {
+	if faceDetectionSupported {
+		_, err := NewFaceDetector("missing.xml")
+		assert.NotNil(t, err)
+		return
+	}
+
+	_, err := NewFaceDetector("haarcascade_frontalface_default.xml")
+	assert.True(t, errors.Is(err, ErrFaceDetectionNotSupported))
+}
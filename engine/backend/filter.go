@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	FilterBox        = "box"
+	FilterBSpline    = "bspline"
+	FilterCatmullRom = "catmullrom"
+	FilterGaussian   = "gaussian"
+	FilterHermite    = "hermite"
+	FilterLanczos    = "lanczos"
+	FilterLanczos2   = "lanczos2"
+	FilterLanczos3   = "lanczos3"
+	FilterLinear     = "linear"
+	FilterMitchell   = "mitchell"
+	FilterNearest    = "nearest"
+)
+
+// Filters are the available resampling filters
+var Filters = []string{
+	FilterBox,
+	FilterBSpline,
+	FilterCatmullRom,
+	FilterGaussian,
+	FilterHermite,
+	FilterLanczos,
+	FilterLanczos2,
+	FilterLanczos3,
+	FilterLinear,
+	FilterMitchell,
+	FilterNearest,
+}
+
+// lanczos2 is a Lanczos filter with 2 lobes, faster but softer than imaging.Lanczos
+var lanczos2 = imaging.ResampleFilter{
+	Support: 2.0,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 2.0 {
+			return sinc(x) * sinc(x/2.0)
+		}
+		return 0
+	},
+}
+
+var resampleFilters = map[string]imaging.ResampleFilter{
+	FilterBox:        imaging.Box,
+	FilterBSpline:    imaging.BSpline,
+	FilterCatmullRom: imaging.CatmullRom,
+	FilterGaussian:   imaging.Gaussian,
+	FilterHermite:    imaging.Hermite,
+	FilterLanczos:    imaging.Lanczos,
+	FilterLanczos2:   lanczos2,
+	FilterLanczos3:   imaging.Lanczos,
+	FilterLinear:     imaging.Linear,
+	FilterMitchell:   imaging.MitchellNetravali,
+	FilterNearest:    imaging.NearestNeighbor,
+}
+
+// resampleFilter returns the resampling filter with the given name,
+// Lanczos with 3 lobes is the default.
+func resampleFilter(name string) imaging.ResampleFilter {
+	if filter, ok := resampleFilters[name]; ok {
+		return filter
+	}
+
+	return imaging.Lanczos
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResampleFilter(t *testing.T) {
+	img := imaging.New(40, 40, color.NRGBA{200, 100, 50, 255})
+
+	for _, name := range Filters {
+		out := scale(img, &Options{Width: 10, Height: 10, Filter: name}, imaging.Resize, stretch)
+		assert.Equal(t, image.Rect(0, 0, 10, 10), out.Bounds())
+		assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(5, 5)))
+	}
+
+	assert.Equal(t, 3.0, resampleFilter("").Support)
+	assert.Equal(t, 2.0, resampleFilter(FilterLanczos2).Support)
+	assert.Equal(t, 0.0, resampleFilter(FilterNearest).Support)
+
+	for _, name := range Filters {
+		_, ok := resampleFilters[name]
+		assert.True(t, ok, name)
+	}
+
+	// nearest neighbor doesn't blend the pixels of a checkerboard
+	checkerboard := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			if (x+y)%2 == 0 {
+				checkerboard.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				checkerboard.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			}
+		}
+	}
+
+	nearest := scale(checkerboard, &Options{Width: 10, Height: 10, Filter: FilterNearest}, imaging.Resize, stretch)
+	box := scale(checkerboard, &Options{Width: 10, Height: 10, Filter: FilterBox}, imaging.Resize, stretch)
+	r, _, _, _ := nearest.At(5, 5).RGBA()
+	assert.True(t, r == 0 || r == 0xffff)
+	assert.Equal(t, color.NRGBA{128, 128, 128, 255}, color.NRGBAModel.Convert(box.At(5, 5)))
+}
+
+func BenchmarkResampleFilters(b *testing.B) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1024, 768))
+	for x := 0; x < 1024; x++ {
+		for y := 0; y < 768; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	for _, name := range Filters {
+		b.Run(name, func(b *testing.B) {
+			filter := resampleFilter(name)
+			for i := 0; i < b.N; i++ {
+				imaging.Resize(img, 256, 192, filter)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+// gifCanvas composes the frames of an animated GIF honoring their disposal methods
+type gifCanvas struct {
+	*image.RGBA
+
+	g *gif.GIF
+	// bounds are the bounds of the last drawn frame, the frames of g can be
+	// replaced once drawn
+	bounds image.Rectangle
+	// previous is the canvas before the frame disposed to previous was drawn
+	previous *image.RGBA
+}
+
+// newGIFCanvas returns a canvas containing the logical screen and every
+// frame of g so none is clipped.
+func newGIFCanvas(g *gif.GIF) *gifCanvas {
+	b := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		b = b.Union(frame.Bounds())
+	}
+
+	return &gifCanvas{RGBA: image.NewRGBA(b), g: g}
+}
+
+func (c *gifCanvas) disposal(i int) byte {
+	if i < len(c.g.Disposal) {
+		return c.g.Disposal[i]
+	}
+	return 0
+}
+
+// draw draws the frame i over the canvas.
+func (c *gifCanvas) draw(i int) {
+	if c.disposal(i) == gif.DisposalPrevious {
+		if c.previous == nil {
+			c.previous = image.NewRGBA(c.Rect)
+		}
+		copy(c.previous.Pix, c.Pix)
+	}
+
+	c.bounds = c.g.Image[i].Bounds()
+	draw.Draw(c.RGBA, c.bounds, c.g.Image[i], c.bounds.Min, draw.Over)
+}
+
+// dispose disposes the frame i once the canvas has been used and before
+// the next frame is drawn, the background is restored as transparent like
+// browsers do.
+func (c *gifCanvas) dispose(i int) {
+	switch c.disposal(i) {
+	case gif.DisposalBackground:
+		draw.Draw(c.RGBA, c.bounds, image.Transparent, image.Point{}, draw.Src)
+	case gif.DisposalPrevious:
+		copy(c.Pix, c.previous.Pix)
+	}
+}
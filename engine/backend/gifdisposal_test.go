@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// newDisposalGIF returns a 40x40 GIF of a red frame, a green frame covering
+// the top left quarter disposed with disposal and a blue frame covering the
+// bottom right quarter.
+func newDisposalGIF(t *testing.T, disposal byte) []byte {
+	frame := func(r image.Rectangle, c color.Color) *image.Paletted {
+		frame := image.NewPaletted(r, palette.Plan9)
+		draw.Draw(frame, r, image.NewUniform(c), image.Point{}, draw.Src)
+		return frame
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 40, 40), color.RGBA{255, 0, 0, 255}),
+			frame(image.Rect(0, 0, 20, 20), color.RGBA{0, 255, 0, 255}),
+			frame(image.Rect(20, 20, 40, 40), color.RGBA{0, 0, 255, 255}),
+		},
+		Delay:    []int{10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, disposal, gif.DisposalNone},
+		Config:   image.Config{Width: 40, Height: 40},
+	})
+	assert.Nil(t, err)
+
+	return buf.Bytes()
+}
+
+// quarters returns the colors at the center of the quarters of img from
+// left to right and top to bottom: "r", "g" or "b" for the dominant channel
+// and "." for a transparent pixel.
+func quarters(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx()/4, b.Dy()/4
+
+	var s strings.Builder
+	for _, p := range []image.Point{{w, h}, {3 * w, h}, {w, 3 * h}, {3 * w, 3 * h}} {
+		r, g, bl, a := img.At(b.Min.X+p.X, b.Min.Y+p.Y).RGBA()
+		switch {
+		case a < 0x8000:
+			s.WriteByte('.')
+		case r > g && r > bl:
+			s.WriteByte('r')
+		case g > bl:
+			s.WriteByte('g')
+		default:
+			s.WriteByte('b')
+		}
+	}
+
+	return s.String()
+}
+
+func TestGIFDisposal(t *testing.T) {
+	e := &GoImage{}
+
+	tests := []struct {
+		name     string
+		disposal byte
+		frames   []string
+	}{
+		// the green frame stays under the blue one
+		{"unspecified", 0, []string{"rrrr", "grrr", "grrb"}},
+		{"none", gif.DisposalNone, []string{"rrrr", "grrr", "grrb"}},
+		// the green frame is cleared before the blue one is drawn
+		{"background", gif.DisposalBackground, []string{"rrrr", "grrr", ".rrb"}},
+		// the red frame is restored before the blue one is drawn
+		{"previous", gif.DisposalPrevious, []string{"rrrr", "grrr", "rrrb"}},
+	}
+
+	for _, tt := range tests {
+		source := newDisposalGIF(t, tt.disposal)
+
+		for _, sequential := range []bool{true, false} {
+			content, err := e.Resize(&imagefile.ImageFile{Source: source}, &Options{
+				Format:         imaging.GIF,
+				Width:          20,
+				Height:         20,
+				LoopCount:      -1,
+				AlphaThreshold: 128,
+				Sequential:     sequential,
+			})
+			assert.Nil(t, err, tt.name)
+
+			g, err := gif.DecodeAll(bytes.NewReader(content))
+			assert.Nil(t, err, tt.name)
+
+			frames := make([]string, len(g.Image))
+			for i := range g.Image {
+				assert.Equal(t, image.Rect(0, 0, 20, 20), g.Image[i].Bounds(), tt.name)
+				frames[i] = quarters(g.Image[i])
+			}
+			assert.Equal(t, tt.frames, frames, tt.name)
+		}
+
+		// the still frames are composed the same way
+		for i, mode := range []string{AnimatedToStillFirst, AnimatedToStillMiddle, AnimatedToStillLast} {
+			img, err := animatedStill(source, mode)
+			assert.Nil(t, err, tt.name)
+			assert.Equal(t, tt.frames[i], quarters(img), "%s %s", tt.name, mode)
+		}
+	}
+}
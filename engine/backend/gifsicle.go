@@ -25,8 +25,7 @@ func (b *Gifsicle) Resize(imgfile *image.ImageFile, opts *Options) ([]byte, erro
 	if err != nil {
 		return nil, err
 	}
-	factor := scalingFactorImage(img, opts.Width, opts.Height)
-	if factor > 1 && !opts.Upscale {
+	if !opts.StrictImage && passthrough(img, opts, stretch) {
 		return imgfile.Source, nil
 	}
 
@@ -54,17 +53,21 @@ func (b *Gifsicle) Thumbnail(imgfile *image.ImageFile, opts *Options) ([]byte, e
 	if err != nil {
 		return nil, err
 	}
-	factor := scalingFactorImage(img, opts.Width, opts.Height)
-	if factor > 1 && !opts.Upscale {
+	if !opts.StrictImage && passthrough(img, opts, cover) {
 		return imgfile.Source, nil
 	}
 
+	width, height := opts.Width, opts.Height
+	if !opts.Upscale && mixedTarget(img, opts) {
+		width, height = coverTarget(img, opts)
+	}
+
 	bounds := img.Bounds()
-	left, top, cropw, croph := computecrop(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+	left, top, cropw, croph := computecrop(bounds.Dx(), bounds.Dy(), width, height)
 
 	cmd := exec.Command(b.Path,
 		"--crop", fmt.Sprintf("%d,%d+%dx%d", left, top, cropw, croph),
-		"--resize", fmt.Sprintf("%dx%d", opts.Width, opts.Height),
+		"--resize", fmt.Sprintf("%dx%d", width, height),
 	)
 	cmd.Stdin = bytes.NewReader(imgfile.Source)
 	stdout := new(bytes.Buffer)
@@ -86,6 +89,16 @@ func (b *Gifsicle) Rotate(*image.ImageFile, *Options) ([]byte, error) {
 	return nil, MethodNotImplementedError
 }
 
+// Cover implements Backend.
+func (b *Gifsicle) Cover(imgfile *image.ImageFile, opts *Options) ([]byte, error) {
+	opts, err := coverOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Thumbnail(imgfile, opts)
+}
+
 // Fit implements Backend.
 func (b *Gifsicle) Fit(*image.ImageFile, *Options) ([]byte, error) {
 	return nil, MethodNotImplementedError
@@ -101,6 +114,111 @@ func (b *Gifsicle) Flip(*image.ImageFile, *Options) ([]byte, error) {
 	return nil, MethodNotImplementedError
 }
 
+// AutoContrast implements Backend.
+func (b *Gifsicle) AutoContrast(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// DrawShapes implements Backend.
+func (b *Gifsicle) DrawShapes(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// LowPoly implements Backend.
+func (b *Gifsicle) LowPoly(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Mask implements Backend.
+func (b *Gifsicle) Mask(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Posterize implements Backend.
+func (b *Gifsicle) Posterize(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// QRCode implements Backend.
+func (b *Gifsicle) QRCode(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Redact implements Backend.
+func (b *Gifsicle) Redact(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// SQIP implements Backend.
+func (b *Gifsicle) SQIP(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Blur implements Backend.
+func (b *Gifsicle) Blur(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Grayscale implements Backend.
+func (b *Gifsicle) Grayscale(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Sepia implements Backend.
+func (b *Gifsicle) Sepia(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Sharpen implements Backend.
+func (b *Gifsicle) Sharpen(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Palette implements Backend.
+func (b *Gifsicle) Palette(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Placeholder implements Backend.
+func (b *Gifsicle) Placeholder(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Text implements Backend.
+func (b *Gifsicle) Text(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Crop implements Backend.
+func (b *Gifsicle) Crop(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// CropResize implements Backend.
+func (b *Gifsicle) CropResize(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Background implements Backend.
+func (b *Gifsicle) Background(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// DominantColor implements Backend.
+func (b *Gifsicle) DominantColor(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Vibrance implements Backend.
+func (b *Gifsicle) Vibrance(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
+// Watermark implements Backend.
+func (b *Gifsicle) Watermark(*image.ImageFile, *Options) ([]byte, error) {
+	return nil, MethodNotImplementedError
+}
+
 func computecrop(srcw, srch, destw, desth int) (left, top, cropw, croph int) {
 	srcratio := float64(srcw) / float64(srch)
 	destratio := float64(destw) / float64(desth)
@@ -2,24 +2,28 @@ package backend
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"image"
 	"image/color"
-	"image/color/palette"
 	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"math"
-	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cenkalti/dominantcolor"
 	"github.com/disintegration/imaging"
 
+	"github.com/thoas/picfit/failure"
 	imagefile "github.com/thoas/picfit/image"
+	"github.com/thoas/picfit/logger"
 
 	"golang.org/x/image/bmp"
 	"golang.org/x/image/tiff"
@@ -30,6 +34,18 @@ type (
 	transformation      func(img image.Image, width int, height int, filter imaging.ResampleFilter) *image.NRGBA
 )
 
+// fitting is the way a transformation fits the source in the target dimensions
+type fitting int
+
+const (
+	// stretch resizes the source to the target dimensions
+	stretch fitting = iota
+	// contain resizes the source to fit inside the target dimensions
+	contain
+	// cover resizes and crops the source to fill the target dimensions
+	cover
+)
+
 var (
 	flipTransformations = map[string]imageTransformation{
 		"h": imaging.FlipH,
@@ -41,6 +57,19 @@ var (
 		270: imaging.Rotate270,
 		180: imaging.Rotate180,
 	}
+
+	// orientationTransformations are the transformations applied in order
+	// to display an image with the given EXIF orientation.
+	orientationTransformations = map[int][]imageTransformation{
+		1: {},
+		2: {flipTransformations["h"]},
+		3: {rotateTransformations[180]},
+		4: {flipTransformations["v"]},
+		5: {flipTransformations["v"], rotateTransformations[270]},
+		6: {rotateTransformations[270]},
+		7: {flipTransformations["v"], rotateTransformations[90]},
+		8: {rotateTransformations[90]},
+	}
 )
 
 type Hex string
@@ -51,21 +80,42 @@ type RGB struct {
 	Blue  uint8
 }
 
-type GoImage struct{}
+type GoImage struct {
+	Detector   RegionDetector
+	OmitGPS    bool
+	Watermarks *WatermarkFetcher
+	// Font is optional, it renders the text watermarks
+	Font *TextFont
+	// Logger is optional, it receives the diagnostics of the encoding
+	Logger logger.Logger
+	// Decoded is optional, it caches the decoded sources by content
+	Decoded *DecodeCache
+	// MaxPixels is optional, it limits the pixels of the decoded images
+	MaxPixels int
+}
 
 func (h Hex) toRGB() (RGB, error) {
 	return Hex2RGB(h)
 }
 
+// Hex2RGB parses a color as 6 hexadecimal digits or the 3 digits shorthand,
+// the leading # is optional.
 func Hex2RGB(hex Hex) (RGB, error) {
-	var rgb RGB
-	values, err := strconv.ParseUint(string(hex), 16, 32)
+	digits := strings.TrimPrefix(string(hex), "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+
+	if len(digits) != 6 {
+		return RGB{}, fmt.Errorf("Invalid color %q, it should have 3 or 6 hexadecimal digits", string(hex))
+	}
 
+	values, err := strconv.ParseUint(digits, 16, 32)
 	if err != nil {
-		return RGB{}, err
+		return RGB{}, fmt.Errorf("Invalid color %q, it should have 3 or 6 hexadecimal digits", string(hex))
 	}
 
-	rgb = RGB{
+	rgb := RGB{
 		Red:   uint8(values >> 16),
 		Green: uint8((values >> 8) & 0xFF),
 		Blue:  uint8(values & 0xFF),
@@ -78,48 +128,70 @@ func (e *GoImage) String() string {
 	return "goimage"
 }
 func (e *GoImage) Resize(img *imagefile.ImageFile, options *Options) ([]byte, error) {
-	return e.resize(img, options, imaging.Resize)
+	return e.resize(img, options, imaging.Resize, stretch)
 }
 
 func (e *GoImage) Thumbnail(img *imagefile.ImageFile, options *Options) ([]byte, error) {
-	return e.resize(img, options, imaging.Thumbnail)
+	gravity := options.CropGravity
+	if _, ok := e.cropper(gravity); ok && animatedGIF(img, options) {
+		// the frames of an animation are cropped at the same place
+		gravity = CropGravityCenter
+	}
+
+	return e.resize(img, options, e.thumbnail(gravity), cover)
 }
 
 func (e *GoImage) Rotate(img *imagefile.ImageFile, options *Options) ([]byte, error) {
-	image, err := e.source(img)
+	image, err := e.source(img, options)
 	if err != nil {
 		return nil, err
 	}
 
-	deg := options.Degree
+	rotated, err := rotateImage(image, options)
+	if err != nil {
+		return nil, err
+	}
 
-	transform, ok := rotateTransformations[deg]
-	if !ok {
-		return nil, fmt.Errorf("Invalid rotate transformation degree=%d is not supported", deg)
+	return e.toBytes(rotated, options)
+}
+
+// rotateImage rotates img by the degree of options, multiples of 90 degrees are lossless.
+func rotateImage(img image.Image, options *Options) (image.Image, error) {
+	transform, ok := rotateTransformations[options.Degree]
+	if ok {
+		return transform(img), nil
 	}
 
-	return e.toBytes(transform(image), options.Format, options.Quality)
+	return rotate(img, float64(options.Degree), options)
 }
 
 func (e *GoImage) Flip(img *imagefile.ImageFile, options *Options) ([]byte, error) {
-	image, err := e.source(img)
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	flipped, err := flipImage(image, options)
 	if err != nil {
 		return nil, err
 	}
 
-	pos := options.Position
+	return e.toBytes(flipped, options)
+}
 
-	transform, ok := flipTransformations[pos]
+// flipImage flips img along the position of options.
+func flipImage(img image.Image, options *Options) (image.Image, error) {
+	transform, ok := flipTransformations[options.Position]
 	if !ok {
-		return nil, fmt.Errorf("Invalid flip transformation, %s is not supported", pos)
+		return nil, fmt.Errorf("Invalid flip transformation, %s is not supported", options.Position)
 	}
 
-	return e.toBytes(transform(image), options.Format, options.Quality)
+	return transform(img), nil
 }
 
 func (e *GoImage) Fit(img *imagefile.ImageFile, options *Options) ([]byte, error) {
-	if options.Format == imaging.GIF {
-		content, err := e.transformGIF(img, options, imaging.Thumbnail)
+	if animatedGIF(img, options) {
+		content, err := e.transformGIF(img, options, fitInside, contain)
 		if err != nil {
 			return nil, err
 		}
@@ -127,80 +199,386 @@ func (e *GoImage) Fit(img *imagefile.ImageFile, options *Options) ([]byte, error
 		return content, nil
 	}
 
-	image, err := e.source(img)
+	image, err := e.source(img, options)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.transform(image, options, imaging.Fit)
+	return e.transform(image, options, fitInside, contain)
 }
 
-func (e *GoImage) toBytes(img image.Image, format imaging.Format, quality int) ([]byte, error) {
+// WouldTransform returns false when resizing img with options reduces to a
+// passthrough of the source: same format, same colors and no scaling because
+// upscale is disabled.
+func (e *GoImage) WouldTransform(img *imagefile.ImageFile, options *Options) (bool, error) {
+	info, err := e.Info(img)
+	if err != nil {
+		return false, err
+	}
+
+	format, err := formatFromName(info.Format)
+	if err != nil {
+		return false, err
+	}
+
+	if format != options.Format || !keepsColors(options) {
+		return true, nil
+	}
+
+	if options.ForceOrientation > 1 || options.AutoRotateContent || (options.AutoOrient && getOrientation(bytes.NewReader(img.Source)) != "1") {
+		return true, nil
+	}
+
+	rotate, err := needsNormalization(info.Width, info.Height, options.NormalizeOrientation)
+	if err != nil || rotate {
+		return rotate, err
+	}
+
+	factor := scalingFactor(info.Width, info.Height, options.Width, options.Height)
+
+	return !isPassthrough(factor, options), nil
+}
+
+func (e *GoImage) toBytes(img image.Image, options *Options) ([]byte, error) {
+	if fitsInBytes(options) {
+		return e.toBytesWithin(img, options)
+	}
+
 	buf := &bytes.Buffer{}
 
-	var err error
+	hint := options.OutputSizeHint
+	if hint <= 0 {
+		hint = outputSizeEstimate(img, options)
+	}
+	buf.Grow(hint)
 
-	err = encode(buf, img, format, quality)
-	if err != nil {
+	if err := e.writeTo(buf, img, options); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
-func (e *GoImage) transformGIF(img *imagefile.ImageFile, options *Options, trans transformation) ([]byte, error) {
-	first, err := gif.Decode(bytes.NewReader(img.Source))
+// writeTo encodes img with options straight to w, the encoded image is
+// only buffered when it's post-processed: deterministic PNG, linear color
+// space or optimized JPEG.
+func (e *GoImage) writeTo(w io.Writer, img image.Image, options *Options) error {
+	defer options.metrics.addEncode(time.Now())
+
+	if err := checkColorSpace(options); err != nil {
+		return err
+	}
+
+	var err error
+	img = toColorSpace(img, options)
+
+	if options.Format == imaging.JPEG && options.Watermark != nil {
+		img, err = e.createWatermark(img, options.Watermark, options)
+		if err != nil {
+			return &failure.EncodeError{Err: err}
+		}
+	}
+
+	if options.Format == imaging.GIF && options.AlphaThreshold > 0 {
+		img = imageToPaletted(img, options.AlphaThreshold, options.GIFPalette)
+	}
+
+	deterministic := options.Format == imaging.PNG && options.Deterministic
+	optimized := options.Format == imaging.JPEG && options.JPEGOptimize && !options.Progressive
+
+	if !deterministic && !optimized && options.ColorSpace != ColorSpaceLinear {
+		if err := encode(w, img, options); err != nil {
+			return &failure.EncodeError{Err: err}
+		}
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	err = encode(buf, img, options)
+	if err != nil {
+		return &failure.EncodeError{Err: err}
+	}
+
+	content := buf.Bytes()
+	if deterministic {
+		content, err = stripPNGChunks(content)
+		if err != nil {
+			return &failure.EncodeError{Err: err}
+		}
+	}
+
+	switch {
+	case options.ColorSpace == ColorSpaceLinear:
+		content, err = withLinearGamma(content)
+	case optimized:
+		// progressive JPEGs are already encoded with optimized Huffman tables
+		content, err = optimizeJPEG(content)
+	}
+	if err != nil {
+		return &failure.EncodeError{Err: err}
+	}
+
+	_, err = w.Write(content)
+
+	return err
+}
+
+// outputSizeEstimate returns a rough estimate of the size of img encoded
+// with options, it's used to allocate the output buffer once.
+func outputSizeEstimate(img image.Image, options *Options) int {
+	pixels := img.Bounds().Dx() * img.Bounds().Dy()
+
+	switch options.Format {
+	case imaging.JPEG:
+		quality := options.Quality
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return pixels * quality / 300
+	case imaging.BMP:
+		return pixels*4 + 54
+	}
+
+	// lossless formats compress to about one byte per pixel, the buffer
+	// grows once for images compressing worse
+	return pixels
+}
+
+func (e *GoImage) transformGIF(img *imagefile.ImageFile, options *Options, trans transformation, mode fitting) ([]byte, error) {
+	if options.RejectTrailingData && trailingData(img.Source) > 0 {
+		return nil, &failure.DecodeError{Err: ErrTrailingData}
+	}
+
+	// the logical screen of the header bounds every frame
+	cfg, err := gif.DecodeConfig(bytes.NewReader(img.Source))
 	if err != nil {
+		return nil, &failure.DecodeError{Err: err}
+	}
+
+	if err := checkColorSpace(options); err != nil {
 		return nil, err
 	}
 
-	factor := scalingFactorImage(first, options.Width, options.Height)
-	if factor > 1 && !options.Upscale {
+	screen := image.Rect(0, 0, cfg.Width, cfg.Height)
+	if options.Format == imaging.GIF && !options.StrictImage && keepsColors(options) && passthrough(screen, options, mode) {
 		return img.Source, nil
 	}
 
+	// oversized GIFs are handled as still images, only their first frame is decoded
+	if options.GIFMaxPixelsPerFrame > 0 && cfg.Width*cfg.Height > options.GIFMaxPixelsPerFrame {
+		first, err := gif.Decode(bytes.NewReader(img.Source))
+		if err != nil {
+			return nil, &failure.DecodeError{Err: err}
+		}
+
+		if paletted, ok := first.(*image.Paletted); ok && options.SwapRB {
+			paletted.Palette = swapPaletteRB(paletted.Palette)
+		}
+
+		return e.transform(first, options, trans, mode)
+	}
+
 	g, err := gif.DecodeAll(bytes.NewReader(img.Source))
 	if err != nil {
-		return nil, err
+		return nil, &failure.DecodeError{Err: err}
 	}
 
-	firstFrame := g.Image[0].Bounds()
-	b := image.Rect(0, 0, firstFrame.Dx(), firstFrame.Dy())
-	im := image.NewRGBA(b)
+	// the channels are swapped in the palettes before the frames are composed
+	if options.SwapRB {
+		for i := range g.Image {
+			g.Image[i].Palette = swapPaletteRB(g.Image[i].Palette)
+		}
+	}
 
-	for i, frame := range g.Image {
-		bounds := frame.Bounds()
-		draw.Draw(im, bounds, frame, bounds.Min, draw.Over)
-		g.Image[i] = imageToPaletted(scale(im, options, trans))
+	if len(g.Image) == 1 {
+		return e.transform(g.Image[0], options, trans, mode)
 	}
 
-	srcW, srcH := imageSize(first)
+	// the frames are composed on the canvas so each scaled frame is complete,
+	// their delays and disposal methods are kept unchanged
+	im := newGIFCanvas(g)
+
+	// WebP frames keep their colors instead of being quantized
+	frames := make([]image.Image, len(g.Image))
+	scaleFrame := func(i int, canvas *image.RGBA) {
+		scaled := toColorSpace(scale(canvas, options, trans, mode), options)
+		if options.Format != WebP {
+			g.Image[i] = imageToPaletted(scaled, options.AlphaThreshold, options.GIFPalette)
+			return
+		}
 
-	if options.Width == 0 {
-		tmpW := float64(options.Height) * float64(srcW) / float64(srcH)
-		options.Width = int(math.Max(1.0, math.Floor(tmpW+0.5)))
+		// the canvas is reused by the next frames
+		if scaled == image.Image(canvas) {
+			scaled = imaging.Clone(canvas)
+		}
+		frames[i] = scaled
 	}
-	if options.Height == 0 {
-		tmpH := float64(options.Width) * float64(srcH) / float64(srcW)
-		options.Height = int(math.Max(1.0, math.Floor(tmpH+0.5)))
+
+	workers := gifWorkers(len(g.Image), im.Rect.Dx()*im.Rect.Dy(), e.MaxPixels, options)
+	if workers == 1 {
+		for i := range g.Image {
+			im.draw(i)
+			scaleFrame(i, im.RGBA)
+			im.dispose(i)
+		}
+	} else {
+		// frames are composed in order and scaled concurrently by a pool of
+		// workers, each worker scales a copy of the canvas which is reused
+		// once its frame is scaled so only workers canvases are kept in memory
+		type frame struct {
+			index  int
+			canvas *image.RGBA
+		}
+
+		free := make(chan *image.RGBA, workers)
+		for i := 0; i < workers; i++ {
+			free <- image.NewRGBA(im.Rect)
+		}
+
+		jobs := make(chan frame)
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for f := range jobs {
+					scaleFrame(f.index, f.canvas)
+					free <- f.canvas
+				}
+			}()
+		}
+
+		for i := range g.Image {
+			im.draw(i)
+
+			canvas := <-free
+			copy(canvas.Pix, im.Pix)
+
+			im.dispose(i)
+
+			jobs <- frame{index: i, canvas: canvas}
+		}
+		close(jobs)
+		wg.Wait()
 	}
 
-	g.Config.Height = options.Height
-	g.Config.Width = options.Width
+	if options.LoopCount >= 0 {
+		g.LoopCount = options.LoopCount
+	}
+
+	if options.MinFrameDelay > 0 {
+		// delays are expressed in 100ths of a second
+		minDelay := int(math.Ceil(float64(options.MinFrameDelay) / float64(10*time.Millisecond)))
+		for i := range g.Delay {
+			if g.Delay[i] < minDelay {
+				g.Delay[i] = minDelay
+			}
+		}
+	}
 
 	buf := bytes.Buffer{}
 
+	if options.Format == WebP {
+		quality := options.Quality
+		if options.Lossless {
+			quality = 100
+		}
+
+		err = encodeAnimatedWebP(&buf, frames, webpDelays(g.Delay), webpLoopCount(g.LoopCount), quality)
+		if err != nil {
+			return nil, &failure.EncodeError{Err: err}
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	// frames are scaled to the same dimensions, which depend on the fitting
+	g.Config.Width, g.Config.Height = imageSize(g.Image[0])
+
 	err = gif.EncodeAll(&buf, g)
 	if err != nil {
-		return nil, err
+		return nil, &failure.EncodeError{Err: err}
 	}
 
 	return buf.Bytes(), nil
 }
 
-func (e *GoImage) resize(img *imagefile.ImageFile, options *Options, trans transformation) ([]byte, error) {
-	if options.Format == imaging.GIF {
-		content, err := e.transformGIF(img, options, trans)
+// gifWorkers returns the number of workers scaling the frames of an animated
+// GIF concurrently, one per CPU unless the frames are scaled sequentially.
+// Each worker holds a copy of the canvas, the copies are limited to the
+// pixels of a decoded image when they are limited.
+func gifWorkers(frames int, canvasPixels int, maxPixels int, options *Options) int {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > frames {
+		workers = frames
+	}
+
+	if maxPixels > 0 && canvasPixels > 0 && workers*canvasPixels > maxPixels {
+		workers = maxPixels / canvasPixels
+	}
+
+	if options.Sequential || workers < 1 {
+		return 1
+	}
+
+	return workers
+}
+
+// keepsColors returns true when the options don't change the colors of the
+// source, alpha included, its encoded content can then be passed through.
+func keepsColors(options *Options) bool {
+	if options.Format == imaging.GIF && options.AlphaThreshold > 0 {
+		return false
+	}
+
+	return !options.SwapRB && (options.ColorSpace == "" || options.ColorSpace == ColorSpaceSRGB)
+}
+
+// animatedGIF returns true if img is a GIF transformed as an animation, only
+// the GIF and WebP encoders support animations and a still frame of a GIF
+// can be requested when converting it to WebP.
+func animatedGIF(img *imagefile.ImageFile, options *Options) bool {
+	if !isGIF(img) {
+		return false
+	}
+
+	switch options.Format {
+	case imaging.GIF:
+		return true
+	case WebP:
+		return options.AnimatedToStill == ""
+	}
+
+	return false
+}
+
+// webpDelays converts the delays of a GIF in 100ths of a second to milliseconds.
+func webpDelays(delays []int) []int {
+	ms := make([]int, len(delays))
+	for i := range delays {
+		ms[i] = delays[i] * 10
+	}
+	return ms
+}
+
+// webpLoopCount converts the loop count of a GIF, which is the number of
+// times the animation is restarted, to the number of times a WebP animation
+// is played, zero loops forever in both.
+func webpLoopCount(loopCount int) int {
+	switch {
+	case loopCount == 0:
+		return 0
+	case loopCount < 0:
+		return 1
+	case loopCount >= math.MaxUint16:
+		return math.MaxUint16
+	}
+	return loopCount + 1
+}
+
+func (e *GoImage) resize(img *imagefile.ImageFile, options *Options, trans transformation, mode fitting) ([]byte, error) {
+	if animatedGIF(img, options) {
+		content, err := e.transformGIF(img, options, trans, mode)
 		if err != nil {
 			return nil, err
 		}
@@ -208,50 +586,307 @@ func (e *GoImage) resize(img *imagefile.ImageFile, options *Options, trans trans
 		return content, nil
 	}
 
-	image, err := e.source(img)
+	image, err := e.source(img, options)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.transform(image, options, trans)
+	return e.transform(image, options, trans, mode)
 }
 
-func (e *GoImage) transform(img image.Image, options *Options, trans transformation) ([]byte, error) {
-	return e.toBytes(scale(img, options, trans), options.Format, options.Quality)
+func (e *GoImage) transform(img image.Image, options *Options, trans transformation, mode fitting) ([]byte, error) {
+	out := scale(img, options, trans, mode)
+	if options.Format == imaging.TIFF {
+		out = preserveGray(img, out)
+	}
+
+	return e.toBytes(out, options)
 }
 
-func (e *GoImage) source(img *imagefile.ImageFile) (image.Image, error) {
-	return decode(bytes.NewReader(img.Source))
+func (e *GoImage) source(img *imagefile.ImageFile, options *Options) (image.Image, error) {
+	defer options.metrics.addDecode(time.Now())
+
+	if options.RejectTrailingData && trailingData(img.Source) > 0 {
+		return nil, &failure.DecodeError{Err: ErrTrailingData}
+	}
+
+	source := img.Source
+	if options.Page > 0 {
+		var err error
+		source, err = tiffPage(source, options.Page)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the limit applies to the selected page and to the images decoded
+	// apart from the source such as the foregrounds of a flat
+	if err := checkPixels(source, e.MaxPixels); err != nil {
+		return nil, err
+	}
+
+	image, err := e.decode(source, options)
+	if err != nil {
+		return nil, &failure.DecodeError{Err: err}
+	}
+
+	if options.SwapRB {
+		image = swapRB(image)
+	}
+
+	if options.ForceOrientation > 0 {
+		image, err = orient(image, options.ForceOrientation)
+		if err != nil {
+			return nil, err
+		}
+	} else if options.AutoRotateContent && getOrientation(bytes.NewReader(source)) == "1" {
+		// the content is only used when the source has no orientation
+		image = autoRotate(image)
+	}
+
+	return normalizeOrientation(image, options.NormalizeOrientation)
+}
+
+// decode decodes the source as a still image, the decoded images are shared
+// through the decode cache when it's enabled.
+func (e *GoImage) decode(source []byte, options *Options) (image.Image, error) {
+	var (
+		mode    string
+		decoder func() (image.Image, error)
+	)
+
+	switch {
+	case isAnimatedWebP(source) || (options.AnimatedToStill != "" && bytes.HasPrefix(source, gifHeader)):
+		mode = "still:" + options.AnimatedToStill
+		decoder = func() (image.Image, error) { return animatedStill(source, options.AnimatedToStill) }
+	case options.ForceOrientation > 0 || !options.AutoOrient:
+		mode = "raw"
+		decoder = func() (image.Image, error) { return imaging.Decode(bytes.NewReader(source)) }
+	default:
+		mode = "oriented"
+		decoder = func() (image.Image, error) { return decode(bytes.NewReader(source)) }
+	}
+
+	if e.Decoded == nil {
+		return decoder()
+	}
+
+	key := fmt.Sprintf("%x:%s", sha256.Sum256(source), mode)
+	if img, ok := e.Decoded.Get(key); ok {
+		return img, nil
+	}
+
+	img, err := decoder()
+	if err != nil {
+		return nil, err
+	}
+
+	e.Decoded.Add(key, img)
+
+	return img, nil
 }
 
 func scalingFactor(srcWidth int, srcHeight int, destWidth int, destHeight int) float64 {
 	return math.Max(float64(destWidth)/float64(srcWidth), float64(destHeight)/float64(srcHeight))
 }
 
-func scalingFactorImage(img image.Image, dstWidth int, dstHeight int) float64 {
+// containFactor returns the factor fitting the source inside the target
+// dimensions, a zero dimension is computed from the aspect ratio.
+func containFactor(srcWidth int, srcHeight int, destWidth int, destHeight int) float64 {
+	switch {
+	case destWidth == 0:
+		return float64(destHeight) / float64(srcHeight)
+	case destHeight == 0:
+		return float64(destWidth) / float64(srcWidth)
+	}
+
+	return math.Min(float64(destWidth)/float64(srcWidth), float64(destHeight)/float64(srcHeight))
+}
+
+func scalingFactorImage(img image.Image, dstWidth int, dstHeight int, mode fitting) float64 {
 	width, height := imageSize(img)
 
+	if mode == contain {
+		return containFactor(width, height, dstWidth, dstHeight)
+	}
+
 	return scalingFactor(width, height, dstWidth, dstHeight)
 }
 
+// isPassthrough returns true when the scaling factor would upscale
+// the source while upscaling is disabled.
+func isPassthrough(factor float64, options *Options) bool {
+	return factor > 1 && !options.Upscale
+}
+
+func passthrough(img image.Image, options *Options, mode fitting) bool {
+	switch {
+	case mode == contain:
+		return fits(img, options)
+	case mode == cover && mixedTarget(img, options):
+		return false
+	}
+
+	return isPassthrough(scalingFactorImage(img, options.Width, options.Height, mode), options)
+}
+
+// mixedTarget returns true when the target dimensions are smaller than
+// the source in one dimension and larger in the other.
+func mixedTarget(img image.Image, options *Options) bool {
+	width, height := imageSize(img)
+	if options.Width == 0 || options.Height == 0 {
+		return false
+	}
+
+	return (options.Width < width && options.Height > height) || (options.Width > width && options.Height < height)
+}
+
 func imageSize(e image.Image) (int, int) {
 	return e.Bounds().Max.X, e.Bounds().Max.Y
 }
 
-func scale(img image.Image, options *Options, trans transformation) image.Image {
-	factor := scalingFactorImage(img, options.Width, options.Height)
+// fits returns true when img already fits inside the target dimensions of
+// options, a zero dimension is unbounded.
+func fits(img image.Image, options *Options) bool {
+	if options.Width <= 0 && options.Height <= 0 {
+		return true
+	}
 
-	if factor < 1 || options.Upscale {
-		return trans(img, options.Width, options.Height, imaging.Lanczos)
+	return scalingFactorImage(img, options.Width, options.Height, contain) >= 1
+}
+
+// scale applies trans to img, without upscale a source smaller than the target
+// is returned as is, except when the target is larger in one dimension only:
+// contain downscales the source to fit the smaller dimension and cover crops
+// the source to the target dimensions bounded by the source ones. contain
+// never upscales, a source fitting the target is returned as is.
+func scale(img image.Image, options *Options, trans transformation, mode fitting) image.Image {
+	factor := scalingFactorImage(img, options.Width, options.Height, mode)
+	width, height := options.Width, options.Height
+
+	switch {
+	case mode == contain && fits(img, options):
+		return img
+	case factor < 1 || options.Upscale:
+	case mode == cover && mixedTarget(img, options):
+		width, height = coverTarget(img, options)
+	default:
+		return img
+	}
+
+	resized := trans(img, width, height, resampleFilter(options.Filter))
+
+	// the resampler weights the colors by their alpha, the fully transparent
+	// pixels of the resized image are left black so they are bled afterwards
+	if options.FixAlphaBleed {
+		return alphaBleed(resized)
 	}
 
-	return img
+	return resized
 }
 
-func imageToPaletted(img image.Image) *image.Paletted {
+// fitInside resizes img to fit entirely inside width and height, a zero
+// dimension is unbounded. The aspect ratio is preserved, the bounding
+// dimension is matched exactly and the image is neither upscaled nor cropped.
+func fitInside(img image.Image, width int, height int, filter imaging.ResampleFilter) *image.NRGBA {
+	srcWidth, srcHeight := img.Bounds().Dx(), img.Bounds().Dy()
+	if (width <= 0 && height <= 0) || srcWidth <= 0 || srcHeight <= 0 {
+		return imaging.Clone(img)
+	}
+
+	factor := containFactor(srcWidth, srcHeight, width, height)
+	if factor >= 1 {
+		return imaging.Clone(img)
+	}
+
+	dstWidth := int(math.Max(1, math.Round(float64(srcWidth)*factor)))
+	dstHeight := int(math.Max(1, math.Round(float64(srcHeight)*factor)))
+	if width > 0 && dstWidth > width {
+		dstWidth = width
+	}
+	if height > 0 && dstHeight > height {
+		dstHeight = height
+	}
+
+	return imaging.Resize(img, dstWidth, dstHeight, filter)
+}
+
+// coverTarget returns the target dimensions bounded by the source ones.
+func coverTarget(img image.Image, options *Options) (int, int) {
+	width, height := imageSize(img)
+	if options.Width < width {
+		width = options.Width
+	}
+	if options.Height < height {
+		height = options.Height
+	}
+
+	return width, height
+}
+
+// preserveGray converts dst back to the single channel model of src
+// when src is grayscale, imaging operations always return NRGBA images.
+func preserveGray(src image.Image, dst image.Image) image.Image {
+	if src == dst {
+		return dst
+	}
+
+	var out draw.Image
+	switch src.(type) {
+	case *image.Gray:
+		out = image.NewGray(dst.Bounds())
+	case *image.Gray16:
+		out = image.NewGray16(dst.Bounds())
+	default:
+		return dst
+	}
+
+	draw.Draw(out, out.Bounds(), dst, dst.Bounds().Min, draw.Src)
+
+	return out
+}
+
+// isGIF returns true if the source of img is a GIF
+func isGIF(img *imagefile.ImageFile) bool {
+	return bytes.HasPrefix(img.Source, gifHeader)
+}
+
+// imageToPaletted quantizes img with the palette named paletteName, when alphaThreshold
+// is positive pixels with an alpha below it use the transparent index and the others become opaque.
+func imageToPaletted(img image.Image, alphaThreshold int, paletteName string) *image.Paletted {
 	b := img.Bounds()
-	pm := image.NewPaletted(b, palette.Plan9)
-	draw.FloydSteinberg.Draw(pm, b, img, image.ZP)
+	if alphaThreshold <= 0 {
+		pm := image.NewPaletted(b, gifPalette(img, paletteName, 256))
+		draw.FloydSteinberg.Draw(pm, b, img, image.ZP)
+		return pm
+	}
+
+	// the last entry of the palette is kept for the transparent color
+	p := append(color.Palette{}, gifPalette(img, paletteName, 255)...)
+	p = append(p, color.Transparent)
+	transparent := uint8(len(p) - 1)
+
+	opaque := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			c.A = 255
+			opaque.SetNRGBA(x, y, c)
+		}
+	}
+
+	pm := image.NewPaletted(b, p)
+	draw.FloydSteinberg.Draw(pm, b, opaque, b.Min)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if int(a>>8) < alphaThreshold {
+				pm.SetColorIndex(x, y, transparent)
+			}
+		}
+	}
+
 	return pm
 }
 
@@ -259,83 +894,154 @@ func FindDominantColor(img image.Image) string {
 	return dominantcolor.Hex(dominantcolor.Find(img))
 }
 
+// FindLuminenace returns the WCAG relative luminance, in range [0, 1],
+// of the red, green and blue channels of items in range [0, 255].
 func FindLuminenace(items []float32) (result float32) {
-	for _, v := range items {
-		v = v / 255
+	linear := make([]float64, 3)
+	for i := range linear {
+		v := float64(items[i]) / 255
 		if v <= 0.03928 {
-			return v / 12.92
+			linear[i] = v / 12.92
 		} else {
-			return ((v + 0.55) / 1.055) * 2
+			linear[i] = math.Pow((v+0.055)/1.055, 2.4)
 		}
 	}
-	result = (items[0]*0.2126 + items[1]*0.7152 + items[2]*0.07222)
-	return
+
+	return float32(linear[0]*0.2126 + linear[1]*0.7152 + linear[2]*0.0722)
 }
-func createWatermark(base image.Image) (outImage image.Image) {
+
+func (e *GoImage) createWatermark(base image.Image, watermark *Watermark, options *Options) (image.Image, error) {
 	var _ RGB
 	var hex = Hex(strings.Replace(FindDominantColor(base), "#", "", 1))
 	rgb, _ := Hex2RGB(hex)
 
-	var markPath = "watermark.png"
 	var list []float32
 	list = append(list, float32(rgb.Red))
 	list = append(list, float32(rgb.Green))
 	list = append(list, float32(rgb.Blue))
 	var status = (FindLuminenace(list))
 
-	if status > 1.90 {
-		markPath = "watermark_colored.png"
+	if e.Logger != nil {
+		e.Logger.Debug("Watermark luminance computed",
+			logger.Float64("luminance", float64(status)),
+			logger.String("color", string(hex)))
 	}
 
-	fmt.Print("\n\n")
-	fmt.Print("\n\n")
-	fmt.Print(status)
-	fmt.Print(rgb)
-	baseFile, _ := os.Open(markPath)
-	defer baseFile.Close()
-	mark, _ := png.Decode(baseFile)
-	baseBound := base.Bounds()
-	markBound := mark.Bounds()
-	offset := image.Pt(
-		(baseBound.Size().X/2)-(markBound.Size().X/2),
-		(baseBound.Size().Y/2)-(markBound.Size().Y/2))
+	mark, err := watermark.mark(float64(status) > watermark.Threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	// the gravity of the request takes precedence over the configured one
+	gravity := options.WatermarkGravity
+	if gravity == "" {
+		gravity = watermark.Gravity
+	}
+
+	offsets, err := watermarkOffsets(base.Bounds(), mark.Bounds(), gravity, options)
+	if err != nil {
+		return nil, err
+	}
 
-	outputImage := image.NewRGBA(baseBound)
+	return compositeWatermark(base, mark, offsets, watermark.Opacity, ""), nil
+}
+
+// watermarkOpacity is the opacity of the watermarks
+const watermarkOpacity = 64
+
+// compositeWatermark draws the mark at each offset on base with the given opacity and blend mode.
+func compositeWatermark(base image.Image, mark image.Image, offsets []image.Point, opacity uint8, blend string) image.Image {
+	outputImage := image.NewRGBA(base.Bounds())
 	draw.Draw(outputImage, outputImage.Bounds(), base, image.ZP, draw.Src)
-	draw.DrawMask(outputImage, mark.Bounds().Add(offset), mark, image.ZP, image.NewUniform(color.Alpha{64}), image.ZP, draw.Over)
-	outImage = outputImage
 
-	return
+	for _, offset := range offsets {
+		drawWatermark(outputImage, mark, offset, opacity, blend)
+	}
+
+	return outputImage
 }
 
-func encode(w io.Writer, img image.Image, format imaging.Format, quality int) error {
+// drawWatermark draws the mark at offset on outputImage, the mark is clipped by its bounds.
+func drawWatermark(outputImage *image.RGBA, mark image.Image, offset image.Point, opacity uint8, blend string) {
+	markBound := mark.Bounds()
+
+	fn, ok := watermarkBlendFuncs[blend]
+	if !ok {
+		draw.DrawMask(outputImage, markBound.Add(offset), mark, image.ZP, image.NewUniform(color.Alpha{opacity}), image.ZP, draw.Over)
+
+		return
+	}
+
+	// draw only offers Over and Src, the blend modes are applied per pixel
+	r := markBound.Add(offset).Intersect(outputImage.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m := color.NRGBAModel.Convert(mark.At(x-offset.X, y-offset.Y)).(color.NRGBA)
+			alpha := float64(m.A) / 255 * float64(opacity) / 255
+			if alpha == 0 {
+				continue
+			}
+
+			i := outputImage.PixOffset(x, y)
+			pix := outputImage.Pix[i : i+4 : i+4]
+
+			// channels of the output are premultiplied by its alpha
+			a := float64(pix[3]) / 255
+			if a == 0 {
+				continue
+			}
+
+			for c, v := range []uint8{m.R, m.G, m.B} {
+				b := float64(pix[c]) / 255 / a
+				blended := b + (fn(b, float64(v)/255)-b)*alpha
+				pix[c] = clampUint8(blended * a * 255)
+			}
+		}
+	}
+}
+
+func encode(w io.Writer, img image.Image, options *Options) error {
+	quality := options.Quality
+
 	var err error
-	switch format {
+	switch options.Format {
 	case imaging.JPEG:
-		var rgba *image.RGBA
+		img, err = flatten(img, options)
+		if err != nil {
+			return err
+		}
+
+		src := img
 		if nrgba, ok := img.(*image.NRGBA); ok {
 			if nrgba.Opaque() {
-				rgba = &image.RGBA{
+				src = &image.RGBA{
 					Pix:    nrgba.Pix,
 					Stride: nrgba.Stride,
 					Rect:   nrgba.Rect,
 				}
 			}
 		}
-		if rgba != nil {
-			err = jpeg.Encode(w, createWatermark(rgba), &jpeg.Options{Quality: quality})
+		if options.Progressive {
+			err = encodeProgressiveJPEG(w, src, quality)
 		} else {
-			err = jpeg.Encode(w, createWatermark(img), &jpeg.Options{Quality: quality})
+			err = jpeg.Encode(w, src, &jpeg.Options{Quality: quality})
 		}
 
 	case imaging.PNG:
-		err = png.Encode(w, img)
+		err = (&png.Encoder{CompressionLevel: options.CompressionLevel}).Encode(w, img)
 	case imaging.GIF:
 		err = gif.Encode(w, img, &gif.Options{NumColors: 256})
 	case imaging.TIFF:
 		err = tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
 	case imaging.BMP:
 		err = bmp.Encode(w, img)
+	case WebP:
+		if options.Lossless {
+			quality = 100
+		}
+		err = encodeWebP(w, img, quality)
+	case AVIF:
+		err = encodeAVIF(w, img, quality, options.AVIFSpeed)
 	default:
 		err = imaging.ErrUnsupportedFormat
 	}
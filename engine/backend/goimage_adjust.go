@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Vibrance boosts the saturation of muted colors more than the one of
+// already saturated colors, skin tones are protected.
+func (e *GoImage) Vibrance(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(vibrance(image, options.Vibrance), options)
+}
+
+// vibrance adjusts the vibrance of img using a percentage in range (-100, 100).
+func vibrance(img image.Image, percentage float64) *image.NRGBA {
+	strength := math.Min(math.Max(percentage, -100), 100) / 100
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+
+		max := math.Max(r, math.Max(g, b))
+		min := math.Min(r, math.Min(g, b))
+		if max == 0 {
+			return c
+		}
+
+		saturation := (max - min) / max
+		amount := strength * (1 - saturation)
+
+		// skin tones have a red dominant over green over blue
+		if r > g && g > b {
+			amount /= 2
+		}
+
+		lum := 0.299*r + 0.587*g + 0.114*b
+
+		return color.NRGBA{
+			R: clampUint8(lum + (r-lum)*(1+amount)),
+			G: clampUint8(lum + (g-lum)*(1+amount)),
+			B: clampUint8(lum + (b-lum)*(1+amount)),
+			A: c.A,
+		}
+	})
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(math.Min(math.Max(v+0.5, 0), 255))
+}
+
+// AutoContrast stretches the luminance range of the image, the darkest and
+// brightest pixels are clipped using a percentile so a few outliers
+// don't defeat the stretch.
+func (e *GoImage) AutoContrast(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(autoContrast(image, options.ClipPercent), options)
+}
+
+// autoContrast maps the luminance percentiles (clip, 100 - clip) of img to (0, 255).
+func autoContrast(img image.Image, clip float64) *image.NRGBA {
+	var (
+		histogram = imaging.Histogram(img)
+		threshold = math.Min(math.Max(clip, 0), 50) / 100
+		low       = 0
+		high      = 255
+		sum       float64
+	)
+
+	for i := range histogram {
+		sum += histogram[i]
+		if sum > threshold {
+			low = i
+			break
+		}
+	}
+
+	sum = 0
+	for i := len(histogram) - 1; i >= 0; i-- {
+		sum += histogram[i]
+		if sum > threshold {
+			high = i
+			break
+		}
+	}
+
+	if high <= low {
+		return imaging.Clone(img)
+	}
+
+	ratio := 255 / float64(high-low)
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{
+			R: clampUint8((float64(c.R) - float64(low)) * ratio),
+			G: clampUint8((float64(c.G) - float64(low)) * ratio),
+			B: clampUint8((float64(c.B) - float64(low)) * ratio),
+			A: c.A,
+		}
+	})
+}
+
+// Posterize reduces each channel of the image to a number of levels.
+func (e *GoImage) Posterize(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(posterize(image, options.PosterizeLevels), options)
+}
+
+// DefaultPosterizeLevels is the default number of levels of each channel
+// of a posterized image
+const DefaultPosterizeLevels = 4
+
+// posterize rounds each channel of img to the nearest of levels evenly spaced
+// values, levels are in range [2, 256] and zero uses the default levels.
+func posterize(img image.Image, levels int) *image.NRGBA {
+	switch {
+	case levels == 0:
+		levels = DefaultPosterizeLevels
+	case levels < 2:
+		levels = 2
+	case levels > 256:
+		levels = 256
+	}
+
+	step := 255 / float64(levels-1)
+
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = clampUint8(math.Round(float64(i)/step) * step)
+	}
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{lut[c.R], lut[c.G], lut[c.B], c.A}
+	})
+}
+
+// Grayscale converts the image to shades of gray.
+func (e *GoImage) Grayscale(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(imaging.Grayscale(image), options)
+}
+
+// Sepia gives the image the brown tones of old photographs.
+func (e *GoImage) Sepia(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(sepia(image), options)
+}
+
+// sepia applies the usual sepia color matrix to img.
+func sepia(img image.Image) *image.NRGBA {
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+
+		return color.NRGBA{
+			R: clampUint8(0.393*r + 0.769*g + 0.189*b),
+			G: clampUint8(0.349*r + 0.686*g + 0.168*b),
+			B: clampUint8(0.272*r + 0.534*g + 0.131*b),
+			A: c.A,
+		}
+	})
+}
+
+// swapRB swaps the red and blue channels of img for BGR sources
+// mislabeled as RGB.
+func swapRB(img image.Image) *image.NRGBA {
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{c.B, c.G, c.R, c.A}
+	})
+}
+
+// swapPaletteRB returns a copy of palette with the red and blue channels of
+// its colors swapped, the frames of a GIF may share their palette.
+func swapPaletteRB(palette color.Palette) color.Palette {
+	swapped := make(color.Palette, len(palette))
+	for i := range palette {
+		c := color.NRGBAModel.Convert(palette[i]).(color.NRGBA)
+		swapped[i] = color.NRGBA{c.B, c.G, c.R, c.A}
+	}
+
+	return swapped
+}
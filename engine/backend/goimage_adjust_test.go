@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func saturation(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	max := math.Max(float64(r), math.Max(float64(g), float64(b)))
+	min := math.Min(float64(r), math.Min(float64(g), float64(b)))
+
+	return (max - min) / max
+}
+
+func TestVibrance(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{110, 120, 140, 255})
+	img.Set(1, 0, color.NRGBA{20, 40, 240, 255})
+
+	out := vibrance(img, 60)
+
+	muted := saturation(out.At(0, 0)) - saturation(img.At(0, 0))
+	saturated := saturation(out.At(1, 0)) - saturation(img.At(1, 0))
+
+	assert.True(t, muted > 0)
+	assert.True(t, muted > saturated)
+}
+
+func TestAutoContrast(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			v := uint8(100 + (x+y)*50/38)
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+
+	out := autoContrast(img, 1)
+
+	// the main range is stretched despite the black outlier
+	assert.True(t, out.NRGBAAt(1, 0).R < 10)
+	assert.True(t, out.NRGBAAt(19, 19).R > 245)
+
+	// without clipping the outlier defeats the stretch
+	out = autoContrast(img, 0)
+	assert.True(t, out.NRGBAAt(1, 0).R > 90)
+}
+
+func TestPosterize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8(x*y) ^ 0x5a, 255})
+		}
+	}
+
+	out := posterize(img, 4)
+
+	colors := map[color.NRGBA]bool{}
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			c := out.NRGBAAt(x, y)
+			colors[c] = true
+			assert.Equal(t, uint8(0), c.R%85)
+		}
+	}
+
+	assert.True(t, len(colors) <= 4*4*4)
+	assert.Equal(t, color.NRGBA{0, 0, 85, 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, out.NRGBAAt(63, 63))
+
+	// zero levels are the default levels
+	assert.Equal(t, out, posterize(img, 0))
+
+	// both bounds of the levels are included
+	assert.Equal(t, uint8(255), posterize(img, 2).NRGBAAt(40, 0).R)
+	assert.Equal(t, img.Pix, posterize(img, 256).Pix)
+}
+
+func TestSwapRB(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(4, 4, colorRed), imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	out, err := e.source(img, &Options{SwapRB: true})
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, color.NRGBAModel.Convert(out.At(0, 0)))
+
+	out, err = e.source(img, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, colorRed, color.NRGBAModel.Convert(out.At(0, 0)))
+}
+
+func TestSwapRBGIF(t *testing.T) {
+	g := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{colorRed, color.NRGBA{0, 255, 0, 255}})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, g)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	// the frames are swapped, the source isn't passed through
+	for _, width := range []int{4, 8} {
+		content, err := e.Resize(img, &Options{Format: imaging.GIF, Width: width, Height: width, SwapRB: true})
+		assert.Nil(t, err)
+
+		out, err := gif.DecodeAll(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(out.Image))
+
+		for _, frame := range out.Image {
+			assert.Equal(t, color.NRGBA{0, 0, 255, 255}, color.NRGBAModel.Convert(frame.At(0, 0)))
+		}
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, newNoisyImage(40, 40), imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Grayscale(img, &Options{Format: imaging.PNG})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	nrgba := imaging.Clone(out)
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		assert.Equal(t, nrgba.Pix[i], nrgba.Pix[i+1])
+		assert.Equal(t, nrgba.Pix[i], nrgba.Pix[i+2])
+	}
+}
+
+func TestSepia(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{100, 100, 100, 128})
+	img.SetNRGBA(2, 0, color.NRGBA{255, 255, 255, 255})
+
+	out := sepia(img)
+	assert.Equal(t, color.NRGBA{0, 0, 0, 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{135, 120, 94, 128}, out.NRGBAAt(1, 0))
+	assert.Equal(t, color.NRGBA{255, 255, 239, 255}, out.NRGBAAt(2, 0))
+}
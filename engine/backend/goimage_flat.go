@@ -20,12 +20,16 @@ func (e *GoImage) Flat(backgroundFile *imagefile.ImageFile, options *Options) ([
 	var err error
 	images := make([]image.Image, len(options.Images))
 	for i := range options.Images {
-		images[i], err = e.source(&options.Images[i])
+		images[i], err = e.source(&options.Images[i], options)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if err := checkColorSpace(options); err != nil {
+		return nil, err
+	}
+
 	if options.Format == imaging.GIF {
 		g, err := gif.DecodeAll(bytes.NewReader(backgroundFile.Source))
 		if err != nil {
@@ -33,11 +37,19 @@ func (e *GoImage) Flat(backgroundFile *imagefile.ImageFile, options *Options) ([
 		}
 
 		for i := range g.Image {
+			if options.SwapRB {
+				g.Image[i].Palette = swapPaletteRB(g.Image[i].Palette)
+			}
+
 			if options.Stick != "" {
 				drawStickForeground(g.Image[i], images, options)
 			} else {
 				drawPosForeground(g.Image[i], images, options)
 			}
+
+			if options.ColorSpace == ColorSpaceGray {
+				grayPalette(g.Image[i].Palette)
+			}
 		}
 		buf := bytes.Buffer{}
 
@@ -49,16 +61,14 @@ func (e *GoImage) Flat(backgroundFile *imagefile.ImageFile, options *Options) ([
 		return buf.Bytes(), nil
 	}
 
-	background, err := e.source(backgroundFile)
+	background, err := e.source(backgroundFile, options)
 	if err != nil {
 		return nil, err
 	}
 
-	bg, ok := background.(draw.Image)
-	if !ok {
-		bg = image.NewRGBA(image.Rectangle{image.Point{}, background.Bounds().Size()})
-		draw.Draw(bg, background.Bounds(), background, image.Point{}, draw.Src)
-	}
+	// the foreground is drawn on a copy, the decoded source may be shared
+	// through the decode cache
+	bg := imaging.Clone(background)
 
 	if options.Stick != "" {
 		drawStickForeground(bg, images, options)
@@ -66,7 +76,7 @@ func (e *GoImage) Flat(backgroundFile *imagefile.ImageFile, options *Options) ([
 		drawPosForeground(bg, images, options)
 	}
 
-	return e.toBytes(bg, options.Format, options.Quality)
+	return e.toBytes(bg, options)
 }
 
 func drawStickForeground(bg draw.Image, images []image.Image, options *Options) {
@@ -77,7 +87,7 @@ func drawStickForeground(bg draw.Image, images []image.Image, options *Options)
 			Height:  options.Height,
 		}
 
-		images[i] = scale(images[i], opts, imaging.Resize)
+		images[i] = scale(images[i], opts, imaging.Resize, stretch)
 
 		bounds := images[i].Bounds()
 		var position image.Point
@@ -167,7 +177,7 @@ func drawForeground(fg draw.Image, images []image.Image, options *Options) draw.
 	}
 
 	for i := range images {
-		images[i] = scale(images[i], opts, imaging.Fit)
+		images[i] = scale(images[i], opts, fitInside, contain)
 	}
 
 	if b.Dx() > b.Dy() {
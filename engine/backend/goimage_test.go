@@ -0,0 +1,644 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/tiff"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+var colorRed = color.NRGBA{255, 0, 0, 255}
+
+func newAnimatedGIF(t testing.TB, width int, height int, frames int) []byte {
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				frame.Set(x, y, color.RGBA{uint8(i * 60), uint8(x), uint8(y), 255})
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, g)
+	assert.Nil(t, err)
+
+	return buf.Bytes()
+}
+
+func TestTransformGIFLoopCount(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}
+
+	content, err := e.Resize(img, &Options{
+		Format:    imaging.GIF,
+		Width:     20,
+		Height:    20,
+		LoopCount: 3,
+	})
+	assert.Nil(t, err)
+
+	g, err := gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, g.LoopCount)
+	assert.Equal(t, 3, len(g.Image))
+}
+
+func newImage(t *testing.T, width int, height int, format imaging.Format) []byte {
+	img := imaging.New(width, height, color.NRGBA{200, 100, 50, 255})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, img, format)
+	assert.Nil(t, err)
+
+	return buf.Bytes()
+}
+
+func TestWouldTransform(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	ok, err := e.WouldTransform(img, &Options{Format: imaging.PNG, Width: 80, Height: 80})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = e.WouldTransform(img, &Options{Format: imaging.PNG, Width: 80, Height: 80, Upscale: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = e.WouldTransform(img, &Options{Format: imaging.PNG, Width: 20, Height: 20})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = e.WouldTransform(img, &Options{Format: imaging.JPEG, Width: 80, Height: 80})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the colors of the source are changed
+	for _, options := range []*Options{
+		{Format: imaging.PNG, Width: 80, Height: 80, ColorSpace: ColorSpaceGray},
+		{Format: imaging.PNG, Width: 80, Height: 80, ColorSpace: ColorSpaceLinear},
+		{Format: imaging.PNG, Width: 80, Height: 80, SwapRB: true},
+	} {
+		ok, err = e.WouldTransform(img, options)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, err = e.WouldTransform(img, &Options{Format: imaging.PNG, Width: 80, Height: 80, ColorSpace: ColorSpaceSRGB})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	gifImg := &imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 2)}
+	ok, err = e.WouldTransform(gifImg, &Options{Format: imaging.GIF, Width: 80, Height: 80})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = e.WouldTransform(gifImg, &Options{Format: imaging.GIF, Width: 80, Height: 80, AlphaThreshold: 128})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = e.WouldTransform(&imagefile.ImageFile{Source: []byte("foo")}, &Options{})
+	assert.NotNil(t, err)
+}
+
+func TestResizeGrayTIFF(t *testing.T) {
+	e := &GoImage{}
+
+	for _, src := range []draw.Image{
+		image.NewGray(image.Rect(0, 0, 40, 40)),
+		image.NewGray16(image.Rect(0, 0, 40, 40)),
+	} {
+		for x := 0; x < 40; x++ {
+			for y := 0; y < 40; y++ {
+				src.Set(x, y, color.Gray{uint8(x * 6)})
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		err := tiff.Encode(buf, src, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		assert.Nil(t, err)
+
+		content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+			Format: imaging.TIFF,
+			Width:  20,
+			Height: 20,
+		})
+		assert.Nil(t, err)
+
+		out, err := tiff.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.IsType(t, src, out)
+		assert.Equal(t, 20, out.Bounds().Dx())
+	}
+}
+
+func TestTransformGIFMinFrameDelay(t *testing.T) {
+	e := &GoImage{}
+
+	g, err := gif.DecodeAll(bytes.NewReader(newAnimatedGIF(t, 40, 40, 3)))
+	assert.Nil(t, err)
+	g.Delay = []int{0, 0, 5}
+
+	buf := &bytes.Buffer{}
+	err = gif.EncodeAll(buf, g)
+	assert.Nil(t, err)
+
+	content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:        imaging.GIF,
+		Width:         20,
+		Height:        20,
+		LoopCount:     -1,
+		MinFrameDelay: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	g, err = gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 1, 5}, g.Delay)
+}
+
+func TestAlphaThresholdGIF(t *testing.T) {
+	e := &GoImage{}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, uint8(x * 13)})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+
+	content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:         imaging.GIF,
+		Width:          20,
+		Height:         20,
+		AlphaThreshold: 128,
+	})
+	assert.Nil(t, err)
+
+	out, err := gif.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	for x := 0; x < 20; x++ {
+		_, _, _, a := out.At(x, 10).RGBA()
+		if x*13 < 128 {
+			assert.Equal(t, uint32(0), a)
+		} else {
+			assert.Equal(t, uint32(0xffff), a)
+		}
+	}
+}
+
+func TestTransformGIFStill(t *testing.T) {
+	e := &GoImage{}
+
+	g, err := gif.DecodeAll(bytes.NewReader(newAnimatedGIF(t, 40, 40, 1)))
+	assert.Nil(t, err)
+	g.Delay = []int{50}
+
+	buf := &bytes.Buffer{}
+	err = gif.EncodeAll(buf, g)
+	assert.Nil(t, err)
+
+	// the still path doesn't keep the frame delay
+	content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:    imaging.GIF,
+		Width:     20,
+		Height:    20,
+		LoopCount: -1,
+	})
+	assert.Nil(t, err)
+
+	g, err = gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(g.Image))
+	assert.Equal(t, []int{0}, g.Delay)
+	assert.Equal(t, 20, g.Image[0].Bounds().Dx())
+
+	content, err = e.Resize(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, &Options{
+		Format:               imaging.GIF,
+		Width:                20,
+		Height:               20,
+		LoopCount:            -1,
+		GIFMaxPixelsPerFrame: 1000,
+	})
+	assert.Nil(t, err)
+
+	g, err = gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(g.Image))
+}
+
+func TestTransformGIFCanvas(t *testing.T) {
+	e := &GoImage{}
+
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+
+	first := image.NewPaletted(image.Rect(0, 0, 20, 20), palette.Plan9)
+	draw.Draw(first, first.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+	// the second frame is larger than the first one
+	second := image.NewPaletted(image.Rect(0, 0, 40, 40), palette.Plan9)
+	draw.Draw(second, second.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(second, image.Rect(20, 20, 40, 40), image.NewUniform(green), image.Point{}, draw.Src)
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, &gif.GIF{
+		Image:  []*image.Paletted{first, second},
+		Delay:  []int{10, 10},
+		Config: image.Config{Width: 40, Height: 40},
+	})
+	assert.Nil(t, err)
+
+	content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:    imaging.GIF,
+		Width:     20,
+		Height:    20,
+		LoopCount: -1,
+	})
+	assert.Nil(t, err)
+
+	g, err := gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(g.Image))
+
+	r, gr, _, _ := g.Image[1].At(15, 15).RGBA()
+	assert.True(t, gr > r)
+	r, gr, _, _ = g.Image[1].At(5, 5).RGBA()
+	assert.True(t, r > gr)
+
+	// the upscale short-circuit uses the logical screen, not the smaller first frame
+	content, err = e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+		Format:    imaging.GIF,
+		Width:     30,
+		Height:    30,
+		LoopCount: -1,
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, buf.Bytes(), content)
+
+	g, err = gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(g.Image))
+	assert.Equal(t, image.Rect(0, 0, 30, 30), g.Image[1].Bounds())
+}
+
+func TestTransformGIFDisposal(t *testing.T) {
+	e := &GoImage{}
+
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	frame := func(r image.Rectangle, c color.Color) *image.Paletted {
+		frame := image.NewPaletted(r, palette.Plan9)
+		draw.Draw(frame, r, image.NewUniform(c), image.Point{}, draw.Src)
+		return frame
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 40, 40), red),
+			frame(image.Rect(0, 0, 20, 20), green),
+			frame(image.Rect(20, 20, 40, 40), blue),
+			frame(image.Rect(20, 0, 40, 20), green),
+		},
+		Delay:     []int{10, 20, 30, 40},
+		Disposal:  []byte{gif.DisposalBackground, gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 2,
+		Config:    image.Config{Width: 40, Height: 40},
+	})
+	assert.Nil(t, err)
+
+	alpha := func(img image.Image, x int, y int) uint32 {
+		_, _, _, a := img.At(x, y).RGBA()
+		return a
+	}
+
+	for _, sequential := range []bool{true, false} {
+		content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+			Format:         imaging.GIF,
+			Width:          20,
+			Height:         20,
+			LoopCount:      -1,
+			AlphaThreshold: 128,
+			Sequential:     sequential,
+		})
+		assert.Nil(t, err)
+
+		g, err := gif.DecodeAll(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(g.Image))
+		assert.Equal(t, 2, g.LoopCount)
+		assert.Equal(t, []int{10, 20, 30, 40}, g.Delay)
+		assert.Equal(t, []byte{gif.DisposalBackground, gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone}, g.Disposal)
+
+		// the red frame is restored to the background before the green one
+		assert.Equal(t, uint32(0xffff), alpha(g.Image[1], 5, 5))
+		assert.Equal(t, uint32(0), alpha(g.Image[1], 15, 15))
+
+		// the blue frame is restored to the previous canvas before the last one
+		assert.Equal(t, uint32(0xffff), alpha(g.Image[2], 15, 15))
+		assert.Equal(t, uint32(0), alpha(g.Image[3], 15, 15))
+		r, gr, b, _ := g.Image[3].At(15, 5).RGBA()
+		assert.True(t, gr > r && gr > b)
+	}
+}
+
+func TestFit(t *testing.T) {
+	e := &GoImage{}
+
+	tests := []struct {
+		name   string
+		source image.Point
+		target image.Point
+		bounds image.Rectangle
+	}{
+		// the bounding dimension is matched, the other one keeps the aspect ratio
+		{"landscape", image.Pt(500, 357), image.Pt(100, 100), image.Rect(0, 0, 100, 71)},
+		{"portrait", image.Pt(357, 500), image.Pt(100, 100), image.Rect(0, 0, 71, 100)},
+		{"square", image.Pt(300, 300), image.Pt(100, 50), image.Rect(0, 0, 50, 50)},
+		{"landscape in portrait", image.Pt(400, 100), image.Pt(100, 400), image.Rect(0, 0, 100, 25)},
+		{"portrait in landscape", image.Pt(100, 400), image.Pt(400, 100), image.Rect(0, 0, 25, 100)},
+		// a zero dimension is unbounded
+		{"width only", image.Pt(500, 357), image.Pt(250, 0), image.Rect(0, 0, 250, 179)},
+		{"height only", image.Pt(357, 500), image.Pt(0, 250), image.Rect(0, 0, 179, 250)},
+		// the source is never upscaled
+		{"smaller landscape", image.Pt(80, 40), image.Pt(100, 100), image.Rect(0, 0, 80, 40)},
+		{"smaller portrait", image.Pt(40, 80), image.Pt(100, 100), image.Rect(0, 0, 40, 80)},
+		{"smaller square", image.Pt(40, 40), image.Pt(0, 100), image.Rect(0, 0, 40, 40)},
+		{"exact", image.Pt(100, 50), image.Pt(100, 50), image.Rect(0, 0, 100, 50)},
+		{"no target", image.Pt(100, 50), image.Pt(0, 0), image.Rect(0, 0, 100, 50)},
+		// the smaller dimension never rounds to zero
+		{"thin", image.Pt(1000, 2), image.Pt(100, 100), image.Rect(0, 0, 100, 1)},
+	}
+
+	for _, tt := range tests {
+		img := &imagefile.ImageFile{Source: newImage(t, tt.source.X, tt.source.Y, imaging.PNG)}
+
+		for _, upscale := range []bool{false, true} {
+			content, err := e.Fit(img, &Options{Format: imaging.PNG, Width: tt.target.X, Height: tt.target.Y, Upscale: upscale})
+			assert.Nil(t, err, tt.name)
+
+			cfg, err := png.DecodeConfig(bytes.NewReader(content))
+			assert.Nil(t, err, tt.name)
+			assert.Equal(t, tt.bounds, image.Rect(0, 0, cfg.Width, cfg.Height), tt.name)
+		}
+	}
+
+	// a source fitting the target is returned as is
+	src := imaging.New(40, 20, color.White)
+	for _, upscale := range []bool{false, true} {
+		out := scale(src, &Options{Width: 100, Height: 100, Upscale: upscale}, fitInside, contain)
+		assert.True(t, out == image.Image(src))
+	}
+
+	// animated GIFs fitting the target are kept as is
+	gifSource := newAnimatedGIF(t, 40, 20, 3)
+	content, err := e.Fit(&imagefile.ImageFile{Source: gifSource}, &Options{
+		Format:    imaging.GIF,
+		Width:     100,
+		Height:    100,
+		Upscale:   true,
+		LoopCount: -1,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, gifSource, content)
+}
+
+func TestMixedTarget(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 100, 100, imaging.PNG)}
+
+	tests := []struct {
+		name    string
+		op      func(*imagefile.ImageFile, *Options) ([]byte, error)
+		upscale bool
+		bounds  image.Rectangle
+	}{
+		// the source fits the smaller dimension
+		{"fit", e.Fit, false, image.Rect(0, 0, 50, 50)},
+		{"fit upscale", e.Fit, true, image.Rect(0, 0, 50, 50)},
+		// the source is cropped instead of upscaled
+		{"thumbnail", e.Thumbnail, false, image.Rect(0, 0, 50, 100)},
+		{"thumbnail upscale", e.Thumbnail, true, image.Rect(0, 0, 50, 200)},
+		// the source would be stretched in one dimension
+		{"resize", e.Resize, false, image.Rect(0, 0, 100, 100)},
+		{"resize upscale", e.Resize, true, image.Rect(0, 0, 50, 200)},
+	}
+
+	for _, tt := range tests {
+		content, err := tt.op(img, &Options{Format: imaging.PNG, Width: 50, Height: 200, Upscale: tt.upscale})
+		assert.Nil(t, err, tt.name)
+
+		cfg, err := png.DecodeConfig(bytes.NewReader(content))
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.bounds, image.Rect(0, 0, cfg.Width, cfg.Height), tt.name)
+	}
+
+	gifs := []struct {
+		name   string
+		op     func(*imagefile.ImageFile, *Options) ([]byte, error)
+		bounds image.Rectangle
+	}{
+		{"fit", e.Fit, image.Rect(0, 0, 20, 20)},
+		{"thumbnail", e.Thumbnail, image.Rect(0, 0, 20, 40)},
+	}
+
+	for _, tt := range gifs {
+		content, err := tt.op(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, &Options{
+			Format:    imaging.GIF,
+			Width:     20,
+			Height:    80,
+			LoopCount: -1,
+		})
+		assert.Nil(t, err, tt.name)
+
+		g, err := gif.DecodeAll(bytes.NewReader(content))
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.bounds, image.Rect(0, 0, g.Config.Width, g.Config.Height), tt.name)
+		assert.Equal(t, tt.bounds, g.Image[2].Bounds(), tt.name)
+	}
+}
+
+func BenchmarkTransformGIF(b *testing.B) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newAnimatedGIF(b, 320, 240, 100)}
+
+	for _, sequential := range []bool{true, false} {
+		name := "parallel"
+		if sequential {
+			name = "sequential"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := e.Resize(img, &Options{
+					Format:     imaging.GIF,
+					Width:      160,
+					Height:     120,
+					Sequential: sequential,
+				})
+				assert.Nil(b, err)
+			}
+		})
+	}
+}
+
+func BenchmarkToBytes(b *testing.B) {
+	e := &GoImage{}
+	img := image.NewNRGBA(image.Rect(0, 0, 1024, 768))
+	for x := 0; x < 1024; x++ {
+		for y := 0; y < 768; y++ {
+			// noise compresses like a photo
+			n := uint8((x*7919 + y*104729) ^ (x * y))
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x) + n/8, uint8(y) + n/8, n, 255})
+		}
+	}
+
+	content, err := e.toBytes(img, &Options{Format: imaging.PNG})
+	assert.Nil(b, err)
+
+	b.Run("unsized", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := &bytes.Buffer{}
+			encode(buf, img, &Options{Format: imaging.PNG})
+		}
+	})
+
+	b.Run("estimate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e.toBytes(img, &Options{Format: imaging.PNG})
+		}
+	})
+
+	b.Run("hint", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e.toBytes(img, &Options{Format: imaging.PNG, OutputSizeHint: len(content)})
+		}
+	})
+}
+
+func TestGIFWorkers(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// one worker per CPU, at most one per frame
+	assert.Equal(t, 4, gifWorkers(7, 1600, 0, &Options{}))
+	assert.Equal(t, 3, gifWorkers(3, 1600, 0, &Options{}))
+
+	// the frames are scaled by the calling goroutine
+	assert.Equal(t, 1, gifWorkers(7, 1600, 0, &Options{Sequential: true}))
+
+	// the copies of the canvas are limited to the pixels of a decoded image
+	assert.Equal(t, 2, gifWorkers(7, 1600, 3200, &Options{}))
+	assert.Equal(t, 1, gifWorkers(7, 1600, 1600, &Options{}))
+}
+
+func TestPNGCompression(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x / 8 * 8), uint8(y / 8 * 8), uint8((x + y) % 16), 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, src, imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+	sizes := map[string]int{}
+	for name, level := range CompressionLevels {
+		content, err := e.Resize(img, &Options{Format: imaging.PNG, Width: 200, Height: 200, CompressionLevel: level})
+		assert.Nil(t, err)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, src.Pix, imaging.Clone(out).Pix)
+
+		sizes[name] = len(content)
+	}
+
+	assert.True(t, sizes[CompressionBest] < sizes[CompressionDefault])
+	assert.True(t, sizes[CompressionDefault] < sizes[CompressionNone])
+}
+
+func TestTransformGIFOptionsReuse(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 20, 3)}
+
+	for _, fn := range []func(*imagefile.ImageFile, *Options) ([]byte, error){e.Resize, e.Thumbnail, e.Fit} {
+		// a single dimension is provided so the other one is derived
+		options := &Options{Format: imaging.GIF, Width: 20, Upscale: true}
+		expected := *options
+
+		first, err := fn(img, options)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, *options)
+
+		second, err := fn(img, options)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, *options)
+
+		fresh, err := fn(img, &Options{Format: imaging.GIF, Width: 20, Upscale: true})
+		assert.Nil(t, err)
+		assert.Equal(t, fresh, first)
+		assert.Equal(t, fresh, second)
+	}
+}
+
+func TestHex2RGB(t *testing.T) {
+	for hex, expected := range map[Hex]RGB{
+		"ffffff":  {255, 255, 255},
+		"#ffffff": {255, 255, 255},
+		"c86432":  {200, 100, 50},
+		"f00":     {255, 0, 0},
+		"#0a8":    {0, 170, 136},
+	} {
+		rgb, err := Hex2RGB(hex)
+		assert.Nil(t, err, string(hex))
+		assert.Equal(t, expected, rgb, string(hex))
+	}
+
+	for _, hex := range []Hex{"", "#", "ffff", "fffffff", "#gggggg", "+fffff"} {
+		_, err := Hex2RGB(hex)
+		assert.NotNil(t, err, string(hex))
+	}
+}
+
+func TestFindLuminenace(t *testing.T) {
+	assert.InDelta(t, 1, FindLuminenace([]float32{255, 255, 255}), 0.0001)
+	assert.InDelta(t, 0, FindLuminenace([]float32{0, 0, 0}), 0.0001)
+	assert.InDelta(t, 0.2159, FindLuminenace([]float32{128, 128, 128}), 0.0001)
+	assert.InDelta(t, 0.7152, FindLuminenace([]float32{0, 255, 0}), 0.0001)
+	assert.InDelta(t, 0.0722, FindLuminenace([]float32{0, 0, 255}), 0.0001)
+
+	// every channel is taken into account
+	assert.True(t, FindLuminenace([]float32{255, 0, 0}) < FindLuminenace([]float32{255, 255, 0}))
+	assert.True(t, FindLuminenace([]float32{30, 30, 30}) < DefaultWatermarkThreshold)
+	assert.True(t, FindLuminenace([]float32{200, 200, 200}) > DefaultWatermarkThreshold)
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Informer is implemented by backends able to describe an image without
+// decoding its pixels
+type Informer interface {
+	Info(img *imagefile.ImageFile) (*ImageInfo, error)
+}
+
+// ImageInfo holds the dimensions and the format of an image read from its header
+type ImageInfo struct {
+	Width  int
+	Height int
+	Format string
+	// HasAlpha is true when the color model of the header holds transparency
+	HasAlpha bool
+}
+
+// Pixels returns the number of pixels of the image
+func (i ImageInfo) Pixels() int64 {
+	return int64(i.Width) * int64(i.Height)
+}
+
+// Info returns the dimensions and the format of img, only its header is
+// decoded so the pixel buffer isn't allocated.
+func (e *GoImage) Info(img *imagefile.ImageFile) (*ImageInfo, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img.Source))
+	if err != nil {
+		return nil, &failure.DecodeError{Err: err}
+	}
+
+	return &ImageInfo{
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		Format:   format,
+		HasAlpha: hasAlpha(cfg.ColorModel),
+	}, nil
+}
+
+// checkPixels returns an error wrapping failure.ErrImageTooLarge when the
+// header of source declares more pixels than maxPixels, a non positive
+// maximum disables the check. The sources which cannot be decoded are
+// reported by the decoders.
+func checkPixels(source []byte, maxPixels int) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil
+	}
+
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return &failure.DecodeError{Err: fmt.Errorf("%w: %dx%d exceeds %d pixels",
+			failure.ErrImageTooLarge, cfg.Width, cfg.Height, maxPixels)}
+	}
+
+	return nil
+}
+
+// hasAlpha probes a color model for transparency: models with an alpha
+// channel and palettes with a translucent color. Opaque RGBA models, as
+// returned for truecolor PNG, and the transparent index of GIF frames,
+// which isn't part of the header, are not reported.
+func hasAlpha(model color.Model) bool {
+	switch m := model.(type) {
+	case color.Palette:
+		for _, c := range m {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch model {
+	case color.NRGBAModel, color.NRGBA64Model, color.NYCbCrAModel, color.AlphaModel, color.Alpha16Model:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestInfo(t *testing.T) {
+	e := &GoImage{}
+
+	info, err := e.Info(&imagefile.ImageFile{Source: newImage(t, 40, 20, imaging.PNG)})
+	assert.Nil(t, err)
+	assert.Equal(t, &ImageInfo{Width: 40, Height: 20, Format: "png"}, info)
+	assert.Equal(t, int64(800), info.Pixels())
+
+	info, err = e.Info(&imagefile.ImageFile{Source: newImage(t, 30, 10, imaging.GIF)})
+	assert.Nil(t, err)
+	assert.Equal(t, &ImageInfo{Width: 30, Height: 10, Format: "gif"}, info)
+
+	translucent := imaging.New(10, 10, color.NRGBA{255, 0, 0, 128})
+	paletted := image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.White, color.Transparent})
+
+	for _, img := range []image.Image{translucent, paletted} {
+		buf := &bytes.Buffer{}
+		err = imaging.Encode(buf, img, imaging.PNG)
+		assert.Nil(t, err)
+
+		info, err = e.Info(&imagefile.ImageFile{Source: buf.Bytes()})
+		assert.Nil(t, err)
+		assert.True(t, info.HasAlpha)
+	}
+
+	_, err = e.Info(&imagefile.ImageFile{Source: []byte("not an image")})
+	var decodeErr *failure.DecodeError
+	assert.True(t, errors.As(err, &decodeErr))
+}
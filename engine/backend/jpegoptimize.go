@@ -0,0 +1,529 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	jpegMarkerSOF0 = 0xc0
+	jpegMarkerSOF2 = 0xc2
+	jpegMarkerDHT  = 0xc4
+	jpegMarkerSOS  = 0xda
+	jpegMarkerDRI  = 0xdd
+)
+
+var errJPEGUnsupported = errors.New("Unsupported JPEG for optimization")
+
+type jpegComponent struct {
+	id      byte
+	h, v    int
+	dcTable int
+	acTable int
+}
+
+// jpegHuffman is a Huffman table as defined in a DHT segment
+type jpegHuffman struct {
+	bits   [17]int
+	values []byte
+
+	// decoding tables, see JPEG specification F.2.2.3
+	mincode [17]int
+	maxcode [17]int
+	valptr  [17]int
+
+	// encoding tables
+	codes [256]uint16
+	sizes [256]uint8
+}
+
+func newJPEGHuffman(bits [17]int, values []byte) *jpegHuffman {
+	h := &jpegHuffman{bits: bits, values: values}
+
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		h.valptr[l] = k
+		h.mincode[l] = code
+		for i := 0; i < bits[l]; i++ {
+			if k < len(values) {
+				h.codes[values[k]] = uint16(code)
+				h.sizes[values[k]] = uint8(l)
+			}
+			code++
+			k++
+		}
+		h.maxcode[l] = code - 1
+		if bits[l] == 0 {
+			h.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+
+	return h
+}
+
+// segment returns the content of a DHT segment for the table.
+func (h *jpegHuffman) segment(class int, id int) []byte {
+	out := []byte{byte(class<<4 | id)}
+	for l := 1; l <= 16; l++ {
+		out = append(out, byte(h.bits[l]))
+	}
+	return append(out, h.values...)
+}
+
+// optimalJPEGHuffman builds an optimal Huffman table limited to 16 bits
+// from symbol frequencies, see JPEG specification K.2.
+func optimalJPEGHuffman(frequencies [256]int) *jpegHuffman {
+	var (
+		freq     [257]int
+		codesize [257]int
+		others   [257]int
+		bits     [33]int
+	)
+
+	copy(freq[:], frequencies[:])
+	// reserve one code point so no code is all ones
+	freq[256] = 1
+
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		c1, c2 := -1, -1
+		for i := range freq {
+			if freq[i] > 0 && (c1 < 0 || freq[i] <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i := range freq {
+			if freq[i] > 0 && i != c1 && (c2 < 0 || freq[i] <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	for i := range codesize {
+		if codesize[i] > 0 {
+			bits[codesize[i]]++
+		}
+	}
+
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+
+	// remove the reserved code point
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var values []byte
+	for size := 1; size <= 32; size++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if codesize[symbol] == size {
+				values = append(values, byte(symbol))
+			}
+		}
+	}
+
+	var counts [17]int
+	copy(counts[:], bits[:17])
+
+	return newJPEGHuffman(counts, values)
+}
+
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    uint
+}
+
+func (r *jpegBitReader) readBit() (int, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			return 0, errJPEGUnsupported
+		}
+		b := r.data[r.pos]
+		r.pos++
+		if b == 0xff {
+			if r.pos >= len(r.data) || r.data[r.pos] != 0x00 {
+				return 0, errJPEGUnsupported
+			}
+			r.pos++
+		}
+		r.acc, r.n = uint32(b), 8
+	}
+	r.n--
+	return int(r.acc>>r.n) & 1, nil
+}
+
+func (r *jpegBitReader) readBits(n int) (uint16, error) {
+	var v uint16
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | uint16(bit)
+	}
+	return v, nil
+}
+
+func (r *jpegBitReader) decode(h *jpegHuffman) (byte, error) {
+	code := 0
+	for l := 1; l <= 16; l++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | bit
+		if h.maxcode[l] >= 0 && code <= h.maxcode[l] && code >= h.mincode[l] {
+			k := h.valptr[l] + code - h.mincode[l]
+			if k >= len(h.values) {
+				return 0, errJPEGUnsupported
+			}
+			return h.values[k], nil
+		}
+	}
+	return 0, errJPEGUnsupported
+}
+
+type jpegBitWriter struct {
+	buf bytes.Buffer
+	acc uint32
+	n   uint
+}
+
+func (w *jpegBitWriter) writeBits(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | (v>>uint(i))&1
+		w.n++
+		if w.n == 8 {
+			b := byte(w.acc)
+			w.buf.WriteByte(b)
+			if b == 0xff {
+				w.buf.WriteByte(0x00)
+			}
+			w.acc, w.n = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte with ones.
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.writeBits(0xff, 8-w.n)
+	}
+}
+
+// jpegSymbol is a Huffman coded symbol followed by its extra bits
+type jpegSymbol struct {
+	table int
+	value byte
+	extra uint16
+	size  uint8
+}
+
+// jpegBaseline is a baseline JPEG with a single scan containing every component
+type jpegBaseline struct {
+	data       []byte
+	headers    [][]byte
+	components []jpegComponent
+	order      []*jpegComponent
+	tables     map[int]*jpegHuffman
+	width      int
+	height     int
+	mcus       int
+	// mcusX is the number of MCUs in a row
+	mcusX int
+	sos   []byte
+	// pos is the position of the entropy coded data
+	pos int
+	// end is the position of the marker following the entropy coded data
+	end int
+}
+
+// parseJPEGBaseline parses the headers of a baseline JPEG, the DHT segments
+// are not kept in the headers.
+func parseJPEGBaseline(data []byte) (*jpegBaseline, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errJPEGUnsupported
+	}
+
+	j := &jpegBaseline{data: data, tables: map[int]*jpegHuffman{}, pos: 2}
+
+	for j.sos == nil {
+		pos := j.pos
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errJPEGUnsupported
+		}
+		marker := data[pos+1]
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if pos+2+length > len(data) || length < 2 {
+			return nil, errJPEGUnsupported
+		}
+		segment := data[pos : pos+2+length]
+		payload := segment[4:]
+		j.pos += 2 + length
+
+		switch marker {
+		case jpegMarkerSOF0:
+			if len(payload) < 6 {
+				return nil, errJPEGUnsupported
+			}
+			j.height = int(payload[1])<<8 | int(payload[2])
+			j.width = int(payload[3])<<8 | int(payload[4])
+			n := int(payload[5])
+			if len(payload) < 6+3*n {
+				return nil, errJPEGUnsupported
+			}
+			for i := 0; i < n; i++ {
+				c := payload[6+3*i:]
+				j.components = append(j.components, jpegComponent{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 0x0f)})
+			}
+		case jpegMarkerDHT:
+			for len(payload) > 0 {
+				if len(payload) < 17 {
+					return nil, errJPEGUnsupported
+				}
+				var bits [17]int
+				total := 0
+				for l := 1; l <= 16; l++ {
+					bits[l] = int(payload[l])
+					total += bits[l]
+				}
+				if len(payload) < 17+total {
+					return nil, errJPEGUnsupported
+				}
+				j.tables[int(payload[0])] = newJPEGHuffman(bits, payload[17:17+total])
+				payload = payload[17+total:]
+			}
+			continue
+		case jpegMarkerSOS:
+			j.sos = segment
+			continue
+		case jpegMarkerDRI:
+			return nil, errJPEGUnsupported
+		default:
+			if marker >= 0xc1 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc {
+				// progressive, lossless or arithmetic coding
+				return nil, errJPEGUnsupported
+			}
+		}
+
+		j.headers = append(j.headers, segment)
+	}
+
+	if j.width == 0 || j.height == 0 || len(j.components) == 0 {
+		return nil, errJPEGUnsupported
+	}
+
+	scan := j.sos[4:]
+	n := int(scan[0])
+	if n < 1 || len(scan) < 1+2*n {
+		return nil, errJPEGUnsupported
+	}
+
+	for i := 0; i < n; i++ {
+		var c *jpegComponent
+		for k := range j.components {
+			if j.components[k].id == scan[1+2*i] {
+				c = &j.components[k]
+			}
+		}
+		if c == nil {
+			return nil, errJPEGUnsupported
+		}
+		c.dcTable = int(scan[2+2*i] >> 4)
+		c.acTable = 0x10 | int(scan[2+2*i]&0x0f)
+		if j.tables[c.dcTable] == nil || j.tables[c.acTable] == nil {
+			return nil, errJPEGUnsupported
+		}
+		j.order = append(j.order, c)
+	}
+
+	if n != len(j.components) {
+		// only a single scan containing every component is supported
+		return nil, errJPEGUnsupported
+	}
+
+	hmax, vmax := j.maxSampling()
+	j.mcusX = (j.width + 8*hmax - 1) / (8 * hmax)
+	j.mcus = j.mcusX * ((j.height + 8*vmax - 1) / (8 * vmax))
+	if n == 1 {
+		// a non interleaved scan contains single blocks
+		j.order[0].h, j.order[0].v = 1, 1
+		j.mcusX = (j.width + 7) / 8
+		j.mcus = j.mcusX * ((j.height + 7) / 8)
+	}
+
+	return j, nil
+}
+
+// maxSampling returns the maximum horizontal and vertical sampling factors.
+func (j *jpegBaseline) maxSampling() (int, int) {
+	hmax, vmax := 1, 1
+	for _, c := range j.components {
+		if c.h > hmax {
+			hmax = c.h
+		}
+		if c.v > vmax {
+			vmax = c.v
+		}
+	}
+	return hmax, vmax
+}
+
+// readSymbols reads the Huffman coded symbols of the scan and calls fn
+// for each block with the symbols of the block.
+func (j *jpegBaseline) readSymbols(fn func(c *jpegComponent, m int, b int, symbols []jpegSymbol)) error {
+	reader := &jpegBitReader{data: j.data[j.pos:]}
+
+	var block []jpegSymbol
+	read := func(table int) error {
+		value, err := reader.decode(j.tables[table])
+		if err != nil {
+			return err
+		}
+
+		size := value & 0x0f
+		if table < 0x10 {
+			size = value
+		}
+
+		extra, err := reader.readBits(int(size))
+		if err != nil {
+			return err
+		}
+
+		block = append(block, jpegSymbol{table: table, value: value, extra: extra, size: size})
+		return nil
+	}
+
+	for m := 0; m < j.mcus; m++ {
+		for _, c := range j.order {
+			for b := 0; b < c.h*c.v; b++ {
+				block = block[:0]
+				if err := read(c.dcTable); err != nil {
+					return err
+				}
+
+				for k := 1; k < 64; k++ {
+					if err := read(c.acTable); err != nil {
+						return err
+					}
+
+					value := block[len(block)-1].value
+					if value == 0x00 {
+						break
+					}
+					k += int(value >> 4)
+				}
+
+				fn(c, m, b, block)
+			}
+		}
+	}
+
+	// the entropy coded data ends on the next marker
+	end := j.pos + reader.pos
+	for end+1 < len(j.data) && !(j.data[end] == 0xff && j.data[end+1] != 0x00) {
+		end++
+	}
+	j.end = end
+
+	return nil
+}
+
+// optimizeJPEG rewrites a baseline JPEG with Huffman tables computed from
+// the image content, the decoded pixels are left untouched.
+func optimizeJPEG(data []byte) ([]byte, error) {
+	j, err := parseJPEGBaseline(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []jpegSymbol
+	err = j.readSymbols(func(c *jpegComponent, m int, b int, block []jpegSymbol) {
+		symbols = append(symbols, block...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	frequencies := map[int]*[256]int{}
+	for _, s := range symbols {
+		if frequencies[s.table] == nil {
+			frequencies[s.table] = &[256]int{}
+		}
+		frequencies[s.table][s.value]++
+	}
+
+	optimized := map[int]*jpegHuffman{}
+	dht := []byte{}
+	for _, id := range []int{0x00, 0x01, 0x02, 0x03, 0x10, 0x11, 0x12, 0x13} {
+		if frequencies[id] == nil {
+			continue
+		}
+		optimized[id] = optimalJPEGHuffman(*frequencies[id])
+		dht = append(dht, optimized[id].segment(id>>4, id&0x0f)...)
+	}
+
+	writer := &jpegBitWriter{}
+	for _, s := range symbols {
+		h := optimized[s.table]
+		writer.writeBits(uint32(h.codes[s.value]), uint(h.sizes[s.value]))
+		writer.writeBits(uint32(s.extra), uint(s.size))
+	}
+	writer.flush()
+
+	out := &bytes.Buffer{}
+	out.Write(data[:2])
+	for _, header := range j.headers {
+		out.Write(header)
+	}
+	writeJPEGSegment(out, jpegMarkerDHT, dht)
+	out.Write(j.sos)
+	out.Write(writer.buf.Bytes())
+	out.Write(data[j.end:])
+
+	return out.Bytes(), nil
+}
+
+// writeJPEGSegment writes a marker segment with its length.
+func writeJPEGSegment(w *bytes.Buffer, marker byte, payload []byte) {
+	w.Write([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	w.Write(payload)
+}
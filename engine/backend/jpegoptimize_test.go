@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimizeJPEG(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 97, 61))
+	gray := image.NewGray(image.Rect(0, 0, 97, 61))
+	for x := 0; x < 97; x++ {
+		for y := 0; y < 61; y++ {
+			rgba.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 4), uint8(x * y), 255})
+			gray.Set(x, y, color.Gray{uint8(x*3 + y)})
+		}
+	}
+
+	for _, img := range []image.Image{rgba, gray} {
+		buf := &bytes.Buffer{}
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+		assert.Nil(t, err)
+
+		content, err := optimizeJPEG(buf.Bytes())
+		assert.Nil(t, err)
+		assert.True(t, len(content) < buf.Len())
+
+		expected, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+
+		out, err := jpeg.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, out)
+	}
+
+	_, err := optimizeJPEG([]byte("foo"))
+	assert.NotNil(t, err)
+}
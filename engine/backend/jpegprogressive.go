@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// jpegProgressiveScan is a scan of a progressive JPEG containing the
+// coefficients from start to end of a single component
+type jpegProgressiveScan struct {
+	component int
+	start     int
+	end       int
+}
+
+// jpegBlocks are the quantized coefficients of a component in zig-zag order
+type jpegBlocks struct {
+	blocks [][64]int
+	// stride is the number of blocks in a row
+	stride int
+}
+
+// encodeProgressiveJPEG writes img to w as a progressive JPEG, the image is
+// encoded as a baseline JPEG which is transcoded so both decode to the same pixels.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return err
+	}
+
+	content, err := progressiveJPEG(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
+
+// progressiveJPEG rewrites a baseline JPEG as a progressive JPEG using
+// spectral selection: the DC coefficients come first so a blurry preview
+// is displayed early, then the low and the high frequencies.
+func progressiveJPEG(data []byte) ([]byte, error) {
+	j, err := parseJPEGBaseline(data)
+	if err != nil {
+		return nil, err
+	}
+
+	coefficients := make([]*jpegBlocks, len(j.components))
+	for i, c := range j.components {
+		stride := j.mcusX * c.h
+		coefficients[i] = &jpegBlocks{
+			blocks: make([][64]int, stride*(j.mcus/j.mcusX)*c.v),
+			stride: stride,
+		}
+	}
+
+	predictions := make([]int, len(j.components))
+	err = j.readSymbols(func(c *jpegComponent, m int, b int, symbols []jpegSymbol) {
+		i := j.componentIndex(c)
+		x := (m%j.mcusX)*c.h + b%c.h
+		y := (m/j.mcusX)*c.v + b/c.h
+		block := &coefficients[i].blocks[y*coefficients[i].stride+x]
+
+		predictions[i] += jpegExtend(symbols[0].extra, symbols[0].size)
+		block[0] = predictions[i]
+
+		k := 1
+		for _, s := range symbols[1:] {
+			if s.value == 0x00 {
+				break
+			}
+			k += int(s.value >> 4)
+			if k < 64 {
+				block[k] = jpegExtend(s.extra, s.size)
+			}
+			k++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+	out.Write(data[:2])
+	for _, header := range j.headers {
+		if header[1] == jpegMarkerSOF0 {
+			header = append([]byte{}, header...)
+			header[1] = jpegMarkerSOF2
+		}
+		out.Write(header)
+	}
+
+	hmax, vmax := j.maxSampling()
+	for _, scan := range jpegProgressiveScript(len(j.components)) {
+		c := j.components[scan.component]
+		// non interleaved scans only contain the blocks covering the component
+		width := ((j.width*c.h+hmax-1)/hmax + 7) / 8
+		height := ((j.height*c.v+vmax-1)/vmax + 7) / 8
+
+		var symbols []jpegSymbol
+		prediction := 0
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				block := &coefficients[scan.component].blocks[y*coefficients[scan.component].stride+x]
+				if scan.start == 0 {
+					symbols = append(symbols, jpegCoefficientSymbol(0x00, 0, block[0]-prediction))
+					prediction = block[0]
+					continue
+				}
+
+				run := 0
+				for k := scan.start; k <= scan.end; k++ {
+					if block[k] == 0 {
+						run++
+						continue
+					}
+					for ; run > 15; run -= 16 {
+						symbols = append(symbols, jpegSymbol{table: 0x10, value: 0xf0})
+					}
+					symbols = append(symbols, jpegCoefficientSymbol(0x10, run, block[k]))
+					run = 0
+				}
+				if run > 0 {
+					// end of band
+					symbols = append(symbols, jpegSymbol{table: 0x10, value: 0x00})
+				}
+			}
+		}
+
+		var frequencies [256]int
+		for _, s := range symbols {
+			frequencies[s.value]++
+		}
+		table := optimalJPEGHuffman(frequencies)
+
+		class := 0
+		if scan.start > 0 {
+			class = 1
+		}
+		writeJPEGSegment(out, jpegMarkerDHT, table.segment(class, 0))
+		writeJPEGSegment(out, jpegMarkerSOS, []byte{1, c.id, 0x00, byte(scan.start), byte(scan.end), 0x00})
+
+		writer := &jpegBitWriter{}
+		for _, s := range symbols {
+			writer.writeBits(uint32(table.codes[s.value]), uint(table.sizes[s.value]))
+			writer.writeBits(uint32(s.extra), uint(s.size))
+		}
+		writer.flush()
+		out.Write(writer.buf.Bytes())
+	}
+
+	out.Write(data[j.end:])
+
+	return out.Bytes(), nil
+}
+
+// componentIndex returns the index of c in the frame components.
+func (j *jpegBaseline) componentIndex(c *jpegComponent) int {
+	for i := range j.components {
+		if &j.components[i] == c {
+			return i
+		}
+	}
+	return 0
+}
+
+// jpegProgressiveScript returns the scans of a progressive JPEG with n
+// components, the luma comes first since it carries most of the details.
+func jpegProgressiveScript(n int) []jpegProgressiveScan {
+	var scans []jpegProgressiveScan
+	for i := 0; i < n; i++ {
+		scans = append(scans, jpegProgressiveScan{component: i})
+	}
+
+	scans = append(scans, jpegProgressiveScan{component: 0, start: 1, end: 5})
+	for i := 1; i < n; i++ {
+		scans = append(scans, jpegProgressiveScan{component: i, start: 1, end: 63})
+	}
+
+	return append(scans, jpegProgressiveScan{component: 0, start: 6, end: 63})
+}
+
+// jpegExtend returns the coefficient coded by the extra bits of a symbol,
+// see JPEG specification F.2.2.1.
+func jpegExtend(extra uint16, size uint8) int {
+	if size == 0 {
+		return 0
+	}
+	if int(extra) < 1<<(size-1) {
+		return int(extra) - 1<<size + 1
+	}
+	return int(extra)
+}
+
+// jpegCoefficientSymbol returns the symbol coding a coefficient preceded by
+// run zeros, the run is always 0 for the DC coefficients.
+func jpegCoefficientSymbol(table int, run int, coefficient int) jpegSymbol {
+	magnitude := coefficient
+	if magnitude < 0 {
+		magnitude = -magnitude
+		coefficient--
+	}
+
+	var size uint8
+	for ; magnitude > 0; magnitude >>= 1 {
+		size++
+	}
+
+	return jpegSymbol{
+		table: table,
+		value: byte(run<<4) | size,
+		extra: uint16(coefficient) & (1<<size - 1),
+		size:  size,
+	}
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestProgressiveJPEG(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 97, 61))
+	gray := image.NewGray(image.Rect(0, 0, 97, 61))
+	for x := 0; x < 97; x++ {
+		for y := 0; y < 61; y++ {
+			rgba.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 4), uint8(x * y), 255})
+			gray.Set(x, y, color.Gray{uint8(x*3 + y)})
+		}
+	}
+
+	for _, img := range []image.Image{rgba, gray} {
+		buf := &bytes.Buffer{}
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+		assert.Nil(t, err)
+
+		content, err := progressiveJPEG(buf.Bytes())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, bytes.Count(content, []byte{0xff, jpegMarkerSOF2}))
+		assert.Equal(t, 0, bytes.Count(content, []byte{0xff, jpegMarkerSOF0}))
+
+		expected, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+
+		out, err := jpeg.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		// the padding blocks outside the image are not coded in progressive scans
+		assert.Equal(t, imaging.Clone(expected).Pix, imaging.Clone(out).Pix)
+	}
+
+	_, err := progressiveJPEG([]byte("foo"))
+	assert.NotNil(t, err)
+}
+
+func TestProgressiveOption(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	baseline, err := e.Resize(img, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Quality: 90})
+	assert.Nil(t, err)
+
+	expected := &bytes.Buffer{}
+	err = jpeg.Encode(expected, imaging.New(20, 20, color.NRGBA{200, 100, 50, 255}), &jpeg.Options{Quality: 90})
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Bytes(), baseline)
+
+	progressive, err := e.Resize(img, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Quality: 90, Progressive: true, JPEGOptimize: true})
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(progressive, []byte{0xff, jpegMarkerSOF2}))
+
+	out, err := jpeg.Decode(bytes.NewReader(progressive))
+	assert.Nil(t, err)
+	assert.Equal(t, 20, out.Bounds().Dx())
+}
@@ -0,0 +1,261 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// DefaultLowPolyPoints is the default number of points sampled by LowPoly
+const DefaultLowPolyPoints = 500
+
+type point struct {
+	X, Y float64
+}
+
+type triangle struct {
+	A, B, C point
+}
+
+type edge struct {
+	A, B point
+}
+
+// LowPoly renders a triangulated stylized version of the image, each triangle
+// is filled with the average color of the pixels it covers.
+func (e *GoImage) LowPoly(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	points := options.LowPolyPoints
+	if points <= 0 {
+		points = DefaultLowPolyPoints
+	}
+
+	return e.toBytes(lowPoly(image, points), options)
+}
+
+func lowPoly(img image.Image, n int) *image.NRGBA {
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w == 0 || h == 0 {
+		return dst
+	}
+
+	triangles := triangulate(samplePoints(src, n))
+
+	for _, t := range triangles {
+		var r, g, b, a, count float64
+
+		rasterize(t, w, h, func(x, y int) {
+			c := src.NRGBAAt(x, y)
+			r += float64(c.R)
+			g += float64(c.G)
+			b += float64(c.B)
+			a += float64(c.A)
+			count++
+		})
+
+		if count == 0 {
+			continue
+		}
+
+		c := color.NRGBA{
+			R: uint8(r/count + 0.5),
+			G: uint8(g/count + 0.5),
+			B: uint8(b/count + 0.5),
+			A: uint8(a/count + 0.5),
+		}
+
+		rasterize(t, w, h, func(x, y int) {
+			dst.SetNRGBA(x, y, c)
+		})
+	}
+
+	return dst
+}
+
+// samplePoints picks n points in img weighted by the edge magnitude, the image
+// corners are always included so the triangulation covers the whole canvas.
+func samplePoints(img *image.NRGBA, n int) []point {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	points := []point{
+		{0, 0},
+		{float64(w), 0},
+		{0, float64(h)},
+		{float64(w), float64(h)},
+	}
+
+	gray := imaging.Grayscale(img)
+	lum := func(x, y int) float64 {
+		return float64(gray.Pix[clampEdge(y, h)*gray.Stride+clampEdge(x, w)*4])
+	}
+
+	// cumulative sobel magnitude, a constant is added so flat areas still get points
+	weights := make([]float64, w*h)
+	var total float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := lum(x+1, y-1) + 2*lum(x+1, y) + lum(x+1, y+1) - lum(x-1, y-1) - 2*lum(x-1, y) - lum(x-1, y+1)
+			gy := lum(x-1, y+1) + 2*lum(x, y+1) + lum(x+1, y+1) - lum(x-1, y-1) - 2*lum(x, y-1) - lum(x+1, y-1)
+			total += math.Sqrt(gx*gx+gy*gy) + 1
+			weights[y*w+x] = total
+		}
+	}
+
+	// a fixed seed keeps the output deterministic for a given source
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		idx := sort.SearchFloat64s(weights, random.Float64()*total)
+		if idx >= len(weights) {
+			idx = len(weights) - 1
+		}
+
+		points = append(points, point{
+			X: float64(idx%w) + random.Float64(),
+			Y: float64(idx/w) + random.Float64(),
+		})
+	}
+
+	return points
+}
+
+// triangulate computes the Delaunay triangulation of points
+// using the Bowyer-Watson algorithm.
+func triangulate(points []point) []triangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, minY = math.Min(minX, p.X), math.Min(minY, p.Y)
+		maxX, maxY = math.Max(maxX, p.X), math.Max(maxY, p.Y)
+	}
+
+	size := math.Max(maxX-minX, maxY-minY) * 20
+	midX, midY := (minX+maxX)/2, (minY+maxY)/2
+
+	super := triangle{
+		A: point{midX - size, midY - size},
+		B: point{midX, midY + size},
+		C: point{midX + size, midY - size},
+	}
+
+	triangles := []triangle{super}
+
+	for _, p := range points {
+		var (
+			bad  []triangle
+			good []triangle
+		)
+
+		for _, t := range triangles {
+			if t.circumcircleContains(p) {
+				bad = append(bad, t)
+			} else {
+				good = append(good, t)
+			}
+		}
+
+		// the boundary of the polygonal hole is made of edges not shared by bad triangles
+		counts := make(map[edge]int)
+		for _, t := range bad {
+			for _, e := range t.edges() {
+				counts[e]++
+			}
+		}
+
+		for _, t := range bad {
+			for _, e := range t.edges() {
+				if counts[e] == 1 {
+					good = append(good, triangle{e.A, e.B, p})
+				}
+			}
+		}
+
+		triangles = good
+	}
+
+	results := triangles[:0]
+	for _, t := range triangles {
+		if t.hasVertex(super.A) || t.hasVertex(super.B) || t.hasVertex(super.C) {
+			continue
+		}
+		results = append(results, t)
+	}
+
+	return results
+}
+
+func (t triangle) edges() []edge {
+	return []edge{newEdge(t.A, t.B), newEdge(t.B, t.C), newEdge(t.C, t.A)}
+}
+
+func (t triangle) hasVertex(p point) bool {
+	return t.A == p || t.B == p || t.C == p
+}
+
+func (t triangle) circumcircleContains(p point) bool {
+	ax, ay := t.A.X-p.X, t.A.Y-p.Y
+	bx, by := t.B.X-p.X, t.B.Y-p.Y
+	cx, cy := t.C.X-p.X, t.C.Y-p.Y
+
+	det := (ax*ax+ay*ay)*(bx*cy-cx*by) -
+		(bx*bx+by*by)*(ax*cy-cx*ay) +
+		(cx*cx+cy*cy)*(ax*by-bx*ay)
+
+	// the determinant sign depends on the orientation of the triangle
+	if orientation(t.A, t.B, t.C) > 0 {
+		return det > 0
+	}
+
+	return det < 0
+}
+
+func newEdge(a point, b point) edge {
+	if a.X < b.X || (a.X == b.X && a.Y < b.Y) {
+		return edge{a, b}
+	}
+
+	return edge{b, a}
+}
+
+func orientation(a point, b point, c point) float64 {
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
+
+// rasterize calls fn for each pixel of a w x h canvas whose center lies in t.
+func rasterize(t triangle, w int, h int, fn func(x int, y int)) {
+	minX := int(math.Max(math.Floor(math.Min(t.A.X, math.Min(t.B.X, t.C.X))), 0))
+	maxX := int(math.Min(math.Ceil(math.Max(t.A.X, math.Max(t.B.X, t.C.X))), float64(w-1)))
+	minY := int(math.Max(math.Floor(math.Min(t.A.Y, math.Min(t.B.Y, t.C.Y))), 0))
+	maxY := int(math.Min(math.Ceil(math.Max(t.A.Y, math.Max(t.B.Y, t.C.Y))), float64(h-1)))
+
+	area := orientation(t.A, t.B, t.C)
+	if area == 0 {
+		return
+	}
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			p := point{float64(x) + 0.5, float64(y) + 0.5}
+
+			w0 := orientation(t.B, t.C, p) / area
+			w1 := orientation(t.C, t.A, p) / area
+			w2 := orientation(t.A, t.B, p) / area
+
+			if w0 >= 0 && w1 >= 0 && w2 >= 0 {
+				fn(x, y)
+			}
+		}
+	}
+}
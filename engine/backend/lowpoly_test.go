@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLowPoly(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8((x + y) * 2), 255})
+		}
+	}
+
+	points := 20
+	out := lowPoly(src, points)
+	assert.Equal(t, src.Bounds(), out.Bounds())
+
+	colors := make(map[color.NRGBA]int)
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			c := out.NRGBAAt(x, y)
+			assert.Equal(t, uint8(255), c.A)
+			colors[c]++
+		}
+	}
+
+	// a triangulation of n points has at most 2n triangles
+	assert.True(t, len(colors) <= 2*(points+4))
+}
+
+func TestTriangulate(t *testing.T) {
+	triangles := triangulate([]point{{0, 0}, {10, 0}, {0, 10}, {10, 10}, {5, 5}})
+	assert.Equal(t, 4, len(triangles))
+}
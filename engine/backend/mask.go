@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	// MaskCircle crops the image to a centered square masked by a circle
+	MaskCircle = "circle"
+	// MaskRounded masks the corners of the image with quarter circles
+	MaskRounded = "rounded"
+
+	// maskRadiusRatio is the default radius of the rounded corners relative
+	// to the smaller side of the image
+	maskRadiusRatio = 0.1
+)
+
+// Masks are the shapes of the alpha masks
+var Masks = []string{MaskCircle, MaskRounded}
+
+// Mask makes the image transparent outside of a circle or of a rectangle
+// with rounded corners, the output format must support transparency unless
+// a background is provided.
+func (e *GoImage) Mask(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	if err := checkMaskFormat(options); err != nil {
+		return nil, err
+	}
+
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	masked, err := maskImage(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(masked, options)
+}
+
+// checkMaskFormat returns an error when the masked image would be saved as
+// JPEG, which doesn't support transparency, without a background.
+func checkMaskFormat(options *Options) error {
+	if options.Format == imaging.JPEG && options.Background == "" {
+		return fmt.Errorf("Format %s doesn't support transparency, a background is required to mask the image", options.Format)
+	}
+
+	return nil
+}
+
+// maskImage multiplies the alpha of img by the coverage of the mask of
+// options, the edges of the mask are antialiased.
+func maskImage(img image.Image, options *Options) (*image.NRGBA, error) {
+	var (
+		dst    *image.NRGBA
+		radius = float64(options.MaskRadius)
+		b      = img.Bounds()
+		side   = b.Dx()
+	)
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	switch options.Mask {
+	case "", MaskCircle:
+		dst = imaging.CropCenter(img, side, side)
+		radius = float64(side) / 2
+	case MaskRounded:
+		dst = imaging.Clone(img)
+		if options.MaskRadius <= 0 {
+			radius = math.Round(float64(side) * maskRadiusRatio)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid mask %s, available values are: %v", options.Mask, Masks)
+	}
+
+	// the corners can't overlap
+	radius = math.Min(radius, float64(side)/2)
+
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			coverage := roundedCoverage(float64(x)+0.5, float64(y)+0.5, width, height, radius)
+			if coverage >= 1 {
+				continue
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+3] = uint8(math.Round(float64(dst.Pix[i+3]) * coverage))
+		}
+	}
+
+	return dst, nil
+}
+
+// roundedCoverage returns the part of the pixel centered on x, y covered by
+// a rectangle of width x height with corners of radius, from 0 to 1.
+func roundedCoverage(x float64, y float64, width int, height int, radius float64) float64 {
+	// the center of the nearest corner, the pixels between the corners are covered
+	cx := math.Min(math.Max(x, radius), float64(width)-radius)
+	cy := math.Min(math.Max(y, radius), float64(height)-radius)
+
+	d := math.Hypot(x-cx, y-cy)
+	if d == 0 {
+		return 1
+	}
+
+	return math.Min(1, math.Max(0, radius-d+0.5))
+}
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestMask(t *testing.T) {
+	e := &GoImage{}
+
+	alpha := func(img image.Image, x, y int) uint8 {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+	}
+
+	// the circle is inscribed in a centered square
+	content, err := e.Mask(&imagefile.ImageFile{Source: newImage(t, 120, 80, imaging.JPEG)}, &Options{
+		Format: imaging.PNG,
+		Mask:   MaskCircle,
+	})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 80, 80), out.Bounds())
+	assert.Equal(t, uint8(0), alpha(out, 0, 0))
+	assert.Equal(t, uint8(0), alpha(out, 79, 79))
+	assert.Equal(t, uint8(255), alpha(out, 40, 40))
+	assert.Equal(t, uint8(255), alpha(out, 40, 1))
+	assert.Equal(t, uint8(255), alpha(out, 1, 40))
+
+	// the edge is antialiased
+	edge := alpha(out, 11, 11)
+	assert.True(t, edge > 0 && edge < 255, edge)
+
+	// the rounded corners keep the dimensions
+	for radius, inside := range map[int]image.Point{20: {6, 6}, 0: {3, 3}} {
+		content, err = e.Mask(&imagefile.ImageFile{Source: newImage(t, 120, 80, imaging.PNG)}, &Options{
+			Format:     imaging.PNG,
+			Mask:       MaskRounded,
+			MaskRadius: radius,
+		})
+		assert.Nil(t, err, radius)
+
+		out, err = imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err, radius)
+		assert.Equal(t, image.Rect(0, 0, 120, 80), out.Bounds(), radius)
+		assert.Equal(t, uint8(0), alpha(out, 0, 0), radius)
+		assert.Equal(t, uint8(0), alpha(out, 119, 79), radius)
+		assert.Equal(t, uint8(255), alpha(out, inside.X, inside.Y), radius)
+		assert.Equal(t, uint8(255), alpha(out, 60, 0), radius)
+		assert.Equal(t, uint8(255), alpha(out, 0, 40), radius)
+	}
+
+	// a large radius is bounded by the half of the smaller side
+	content, err = e.Mask(&imagefile.ImageFile{Source: newImage(t, 80, 80, imaging.PNG)}, &Options{
+		Format:     imaging.PNG,
+		Mask:       MaskRounded,
+		MaskRadius: 1000,
+	})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), alpha(out, 5, 5))
+	assert.Equal(t, uint8(255), alpha(out, 40, 40))
+}
+
+func TestMaskFormat(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	_, err := e.Mask(img, &Options{Format: imaging.JPEG})
+	assert.NotNil(t, err)
+
+	_, err = e.Pipeline(img, []Stage{
+		{Operation: "mask", Options: &Options{Format: imaging.JPEG}},
+	})
+	assert.NotNil(t, err)
+
+	// the image is flattened onto the background
+	content, err := e.Mask(img, &Options{Format: imaging.JPEG, Background: "0000ff"})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	corner := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+	assert.True(t, corner.B > corner.R, corner)
+
+	_, err = e.Mask(img, &Options{Format: imaging.PNG, Mask: "star"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"image"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxBytesEncodings is the maximum number of encodings searching a quality
+// fitting the byte budget, the first one uses the requested quality.
+const maxBytesEncodings = 8
+
+// fitsInBytes returns true when the output size of options can be reduced
+// by lowering the quality, the output is then encoded within options.MaxBytes.
+func fitsInBytes(options *Options) bool {
+	if options.MaxBytes <= 0 {
+		return false
+	}
+
+	switch options.Format {
+	case imaging.JPEG, AVIF:
+		return true
+	case WebP:
+		return !options.Lossless
+	}
+
+	return false
+}
+
+// toBytesWithin encodes img with the highest quality up to the requested
+// one whose output doesn't exceed options.MaxBytes, the quality is found by
+// bisection. When no quality fits, the smallest output is returned.
+func (e *GoImage) toBytesWithin(img image.Image, options *Options) ([]byte, error) {
+	quality := options.Quality
+	if quality <= 0 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+
+	encode := func(quality int) ([]byte, error) {
+		opts := *options
+		opts.Quality = quality
+		opts.MaxBytes = 0
+
+		return e.toBytes(img, &opts)
+	}
+
+	content, err := encode(quality)
+	if err != nil || len(content) <= options.MaxBytes {
+		return content, err
+	}
+
+	var (
+		best     []byte
+		smallest = content
+		low      = 1
+		high     = quality - 1
+	)
+	for i := 1; i < maxBytesEncodings && low <= high; i++ {
+		q := (low + high) / 2
+
+		content, err = encode(q)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(content) <= options.MaxBytes {
+			best = content
+			low = q + 1
+			continue
+		}
+
+		if len(content) < len(smallest) {
+			smallest = content
+		}
+		high = q - 1
+	}
+
+	if best != nil {
+		return best, nil
+	}
+
+	return smallest, nil
+}
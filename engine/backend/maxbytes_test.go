@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToBytesWithin(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(r.Intn(256))
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+
+	e := &GoImage{}
+
+	full, err := e.toBytes(img, &Options{Format: imaging.JPEG, Quality: 95})
+	assert.Nil(t, err)
+
+	lowest, err := e.toBytes(img, &Options{Format: imaging.JPEG, Quality: 1})
+	assert.Nil(t, err)
+
+	// the output is encoded with a lower quality to fit the budget
+	budget := len(full) / 3
+	content, err := e.toBytes(img, &Options{Format: imaging.JPEG, Quality: 95, MaxBytes: budget})
+	assert.Nil(t, err)
+	assert.True(t, len(content) <= budget)
+	assert.True(t, len(content) > len(lowest))
+
+	out, err := jpeg.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), out.Bounds())
+
+	// the requested quality is kept when it fits
+	content, err = e.toBytes(img, &Options{Format: imaging.JPEG, Quality: 95, MaxBytes: len(full)})
+	assert.Nil(t, err)
+	assert.Equal(t, full, content)
+
+	// the smallest output is returned when no quality fits
+	content, err = e.toBytes(img, &Options{Format: imaging.JPEG, Quality: 95, MaxBytes: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, len(lowest), len(content))
+
+	// lossy WebP outputs are quantized to fit the budget
+	webp, err := e.toBytes(img, &Options{Format: WebP, Quality: 100})
+	assert.Nil(t, err)
+
+	budget = len(webp) * 2 / 3
+	content, err = e.toBytes(img, &Options{Format: WebP, Quality: 100, MaxBytes: budget})
+	assert.Nil(t, err)
+	assert.True(t, len(content) <= budget)
+
+	content, err = e.toBytes(img, &Options{Format: WebP, Quality: 100, Lossless: true, MaxBytes: budget})
+	assert.Nil(t, err)
+	assert.Equal(t, webp, content)
+
+	// lossless formats are not affected
+	png, err := e.toBytes(img, &Options{Format: imaging.PNG, MaxBytes: 10})
+	assert.Nil(t, err)
+	assert.True(t, len(png) > 10)
+}
+
+func TestFitsInBytes(t *testing.T) {
+	assert.True(t, fitsInBytes(&Options{Format: imaging.JPEG, MaxBytes: 1000}))
+	assert.True(t, fitsInBytes(&Options{Format: WebP, MaxBytes: 1000}))
+	assert.False(t, fitsInBytes(&Options{Format: WebP, Lossless: true, MaxBytes: 1000}))
+	assert.False(t, fitsInBytes(&Options{Format: imaging.PNG, MaxBytes: 1000}))
+	assert.False(t, fitsInBytes(&Options{Format: imaging.JPEG}))
+
+	img := imaging.New(10, 10, color.White)
+	content, err := (&GoImage{}).toBytes(img, &Options{Format: imaging.GIF, MaxBytes: 1})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, content)
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/thoas/picfit/image"
+)
+
+// Metrics are the timings and the sizes of an operation, the transform
+// duration is the time spent out of decoding and encoding
+type Metrics struct {
+	Operation    string
+	DecodeDur    time.Duration
+	TransformDur time.Duration
+	EncodeDur    time.Duration
+	InBytes      int
+	OutBytes     int
+}
+
+// Observer receives the Metrics of each successful operation, it lets
+// callers export metrics without the engine depending on a metrics library
+type Observer func(Metrics)
+
+func (m *Metrics) addDecode(start time.Time) {
+	if m != nil {
+		m.DecodeDur += time.Since(start)
+	}
+}
+
+func (m *Metrics) addEncode(start time.Time) {
+	if m != nil {
+		m.EncodeDur += time.Since(start)
+	}
+}
+
+// Observe runs the operation fn on img and passes its Metrics to the
+// observer of options if any.
+func Observe(operation string, img *image.ImageFile, options *Options, fn func() ([]byte, error)) ([]byte, error) {
+	if options == nil || options.Observer == nil {
+		return fn()
+	}
+
+	options.metrics = &Metrics{Operation: operation, InBytes: len(img.Source)}
+	defer func() {
+		options.metrics = nil
+	}()
+
+	start := time.Now()
+	content, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	m := *options.metrics
+	m.TransformDur = time.Since(start) - m.DecodeDur - m.EncodeDur
+	m.OutBytes = len(content)
+	options.Observer(m)
+
+	return content, nil
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// MipChain returns the encoded levels of the mipmap chain of the image,
+// from the source down to 1x1, each level halves the previous one.
+func (e *GoImage) MipChain(img *imagefile.ImageFile, options *Options) ([][]byte, error) {
+	source, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var levels [][]byte
+
+	level := source
+	for {
+		content, err := e.toBytes(level, options)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, content)
+
+		width, height := level.Bounds().Dx(), level.Bounds().Dy()
+		if width == 1 && height == 1 {
+			return levels, nil
+		}
+
+		level = mipLevel(level, width, height)
+	}
+}
+
+// mipLevel halves img, a box filter averages each 2x2 block of pixels.
+func mipLevel(img image.Image, width int, height int) image.Image {
+	width, height = width/2, height/2
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	return imaging.Resize(img, width, height, imaging.Box)
+}
@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestMipChain(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 64, 20, imaging.PNG)}
+
+	levels, err := e.MipChain(img, &Options{Format: imaging.PNG})
+	assert.Nil(t, err)
+
+	expected := []image.Point{{64, 20}, {32, 10}, {16, 5}, {8, 2}, {4, 1}, {2, 1}, {1, 1}}
+	assert.Equal(t, len(expected), len(levels))
+
+	for i, level := range levels {
+		cfg, err := png.DecodeConfig(bytes.NewReader(level))
+		assert.Nil(t, err)
+		assert.Equal(t, expected[i], image.Point{cfg.Width, cfg.Height})
+	}
+}
@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"sort"
+)
+
+const (
+	GIFPaletteAdaptive = "adaptive"
+	GIFPalettePlan9    = "plan9"
+	GIFPaletteWebSafe  = "websafe"
+)
+
+// GIFPalettes are the palettes used to quantize GIF frames, the adaptive
+// palette is the most faithful and the slowest
+var GIFPalettes = []string{
+	GIFPaletteAdaptive,
+	GIFPalettePlan9,
+	GIFPaletteWebSafe,
+}
+
+// gifPalette returns the palette named name with at most size colors to quantize img,
+// the adaptive palette is used by default.
+func gifPalette(img image.Image, name string, size int) color.Palette {
+	var p color.Palette
+	switch name {
+	case GIFPalettePlan9:
+		p = palette.Plan9
+	case GIFPaletteWebSafe:
+		p = palette.WebSafe
+	default:
+		return medianCut(img, size)
+	}
+
+	if len(p) > size {
+		return p[:size]
+	}
+	return p
+}
+
+// colorBox is a box of the RGB space containing the colors of an image
+type colorBox struct {
+	colors []colorCount
+	count  int
+}
+
+// colorCount is a color of an image with its number of pixels, colors are
+// reduced to 5 bits per channel and the sums keep their full precision
+type colorCount struct {
+	rgb   [3]uint8
+	sum   [3]int
+	count int
+}
+
+// medianCut returns a palette of at most size colors built from the colors
+// of img by recursively splitting the box with the most pixels along its
+// widest channel at the median pixel, alpha is ignored.
+func medianCut(img image.Image, size int) color.Palette {
+	b := img.Bounds()
+	histogram := map[[3]uint8]*colorCount{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			key := [3]uint8{c.R >> 3, c.G >> 3, c.B >> 3}
+			h, ok := histogram[key]
+			if !ok {
+				h = &colorCount{rgb: key}
+				histogram[key] = h
+			}
+			h.sum[0] += int(c.R)
+			h.sum[1] += int(c.G)
+			h.sum[2] += int(c.B)
+			h.count++
+		}
+	}
+
+	if len(histogram) == 0 || size <= 0 {
+		return color.Palette{color.Black}
+	}
+
+	box := colorBox{}
+	for _, h := range histogram {
+		box.colors = append(box.colors, *h)
+		box.count += h.count
+	}
+	boxes := []colorBox{box}
+
+	for len(boxes) < size {
+		// split the most populated box which contains more than one color
+		index := -1
+		for i := range boxes {
+			if len(boxes[i].colors) > 1 && (index < 0 || boxes[i].count > boxes[index].count) {
+				index = i
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		first, second := boxes[index].split()
+		boxes[index] = first
+		boxes = append(boxes, second)
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		var sum [3]int
+		for _, c := range box.colors {
+			for k := range sum {
+				sum[k] += c.sum[k]
+			}
+		}
+		p[i] = color.NRGBA{
+			R: uint8(sum[0] / box.count),
+			G: uint8(sum[1] / box.count),
+			B: uint8(sum[2] / box.count),
+			A: 255,
+		}
+	}
+
+	return p
+}
+
+// split splits the box along its widest channel at the median pixel.
+func (box colorBox) split() (colorBox, colorBox) {
+	channel, width := 0, -1
+	for k := 0; k < 3; k++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, c := range box.colors {
+			if c.rgb[k] < lo {
+				lo = c.rgb[k]
+			}
+			if c.rgb[k] > hi {
+				hi = c.rgb[k]
+			}
+		}
+		if int(hi)-int(lo) > width {
+			channel, width = k, int(hi)-int(lo)
+		}
+	}
+
+	// colors are unique so the order doesn't depend on the histogram order
+	sort.Slice(box.colors, func(i, j int) bool {
+		a, b := box.colors[i].rgb, box.colors[j].rgb
+		if a[channel] != b[channel] {
+			return a[channel] < b[channel]
+		}
+		return uint32(a[0])<<16|uint32(a[1])<<8|uint32(a[2]) < uint32(b[0])<<16|uint32(b[1])<<8|uint32(b[2])
+	})
+
+	// both boxes keep at least one color
+	median, count := 1, box.colors[0].count
+	for median < len(box.colors)-1 && count+box.colors[median].count <= box.count/2 {
+		count += box.colors[median].count
+		median++
+	}
+
+	return colorBox{colors: box.colors[:median], count: count},
+		colorBox{colors: box.colors[median:], count: box.count - count}
+}
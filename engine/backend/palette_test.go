@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// newGradientGIF returns an animated GIF whose frames are gradients
+// using the 256 colors of their own palette.
+func newGradientGIF(size int) *gif.GIF {
+	p := make(color.Palette, 256)
+	for i := range p {
+		p[i] = color.RGBA{uint8(20 + i*220/255), uint8(40 + i*140/255), uint8(200 - i*170/255), 255}
+	}
+
+	g := &gif.GIF{}
+	for f := 0; f < 2; f++ {
+		frame := image.NewPaletted(image.Rect(0, 0, size, size), p)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				frame.SetColorIndex(x, y, uint8((x+y+f*size/2)*255/(2*size)))
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	return g
+}
+
+// averageColorError returns the average distance per pixel and channel
+// between the frames of a and b.
+func averageColorError(a *gif.GIF, b *gif.GIF) float64 {
+	var sum, count float64
+	for i := range a.Image {
+		bounds := a.Image[i].Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r1, g1, b1, _ := a.Image[i].At(x, y).RGBA()
+				r2, g2, b2, _ := b.Image[i].At(x, y).RGBA()
+				for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+					if d < 0 {
+						d = -d
+					}
+					sum += float64(d)
+				}
+				count += 3
+			}
+		}
+	}
+
+	return sum / count
+}
+
+func TestGIFPalette(t *testing.T) {
+	source := newGradientGIF(64)
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, source)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	errors := map[string]float64{}
+	for _, name := range GIFPalettes {
+		content, err := e.Resize(img, &Options{Format: imaging.GIF, Width: 64, Height: 64, GIFPalette: name, StrictImage: true})
+		assert.Nil(t, err)
+
+		out, err := gif.DecodeAll(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(out.Image))
+
+		errors[name] = averageColorError(source, out)
+	}
+
+	assert.True(t, errors[GIFPaletteAdaptive] < 2, "%v", errors)
+	assert.True(t, errors[GIFPaletteAdaptive] < errors[GIFPalettePlan9], "%v", errors)
+	assert.True(t, errors[GIFPaletteAdaptive] < errors[GIFPaletteWebSafe], "%v", errors)
+}
+
+func TestMedianCut(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 10))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 10; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x / 10 * 80), 0, 0, 255})
+		}
+	}
+
+	p := medianCut(img, 256)
+	assert.Equal(t, 4, len(p))
+	for x := 0; x < 40; x += 10 {
+		assert.Equal(t, img.At(x, 0), p.Convert(img.At(x, 0)))
+	}
+
+	p = medianCut(img, 2)
+	assert.Equal(t, 2, len(p))
+	assert.Equal(t, p, medianCut(img, 2))
+}
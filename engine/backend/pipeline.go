@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"errors"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Pipeliner is implemented by backends able to apply several operations to
+// an image decoded once, the result is encoded once after the last operation
+// instead of after each of them.
+type Pipeliner interface {
+	Pipeline(img *imagefile.ImageFile, stages []Stage) ([]byte, error)
+}
+
+// Encoder encodes a transformed image straight to w
+type Encoder func(w io.Writer) error
+
+// PipelineEncoder is implemented by backends able to apply the operations of
+// a pipeline before the image is encoded, the result is then encoded straight
+// to a writer instead of a buffer.
+type PipelineEncoder interface {
+	PipelineEncoder(img *imagefile.ImageFile, stages []Stage) (Encoder, error)
+}
+
+// Stage is an operation of a pipeline with its options
+type Stage struct {
+	Operation string
+	Options   *Options
+}
+
+// ErrNotPipelinable is an error returned if an operation of a pipeline
+// cannot be applied to a decoded image
+var ErrNotPipelinable = errors.New("Operations cannot be applied in a pipeline")
+
+// imageOperation applies an operation to a decoded image
+type imageOperation func(e *GoImage, img image.Image, options *Options) (image.Image, error)
+
+// imageOperations are the operations which can be applied in a pipeline
+// indexed by name, the operations producing something else than the
+// transformed image such as placeholders aren't part of them.
+var imageOperations = map[string]imageOperation{
+	"autocontrast": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return autoContrast(img, options.ClipPercent), nil
+	},
+	"blur": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		if err := checkSigma(options.Sigma); err != nil {
+			return nil, err
+		}
+		return imaging.Blur(img, options.Sigma), nil
+	},
+	"cover": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		opts, err := coverOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		return scale(img, opts, e.thumbnail(opts.CropGravity), cover), nil
+	},
+	"crop": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return e.cropImage(img, options)
+	},
+	"cropresize": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		cropped, err := crop(img, options.CropRect)
+		if err != nil {
+			return nil, err
+		}
+		return scale(cropped, options, imaging.Resize, stretch), nil
+	},
+	"fit": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return scale(img, options, fitInside, contain), nil
+	},
+	"flip": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return flipImage(img, options)
+	},
+	"grayscale": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return imaging.Grayscale(img), nil
+	},
+	"mask": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		if err := checkMaskFormat(options); err != nil {
+			return nil, err
+		}
+		return maskImage(img, options)
+	},
+	"posterize": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return posterize(img, options.PosterizeLevels), nil
+	},
+	"redact": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return e.redactImage(img, options)
+	},
+	"resize": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return scale(img, options, imaging.Resize, stretch), nil
+	},
+	"rotate": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return rotateImage(img, options)
+	},
+	"sepia": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return sepia(img), nil
+	},
+	"sharpen": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		if err := checkSigma(options.Sigma); err != nil {
+			return nil, err
+		}
+		return imaging.Sharpen(img, options.Sigma), nil
+	},
+	"text": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return e.text(img, options)
+	},
+	"thumbnail": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return scale(img, options, e.thumbnail(options.CropGravity), cover), nil
+	},
+	"vibrance": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return vibrance(img, options.Vibrance), nil
+	},
+	"watermark": func(e *GoImage, img image.Image, options *Options) (image.Image, error) {
+		return e.watermark(img, options)
+	},
+}
+
+// Pipeline decodes img with the options of the first stage, applies the
+// operations of the stages in order and encodes the result with the options
+// of the last stage. Animated GIFs kept animated by the output format, GIF
+// or WebP, are not supported since their frames are transformed separately.
+func (e *GoImage) Pipeline(img *imagefile.ImageFile, stages []Stage) ([]byte, error) {
+	out, options, err := e.pipeline(img, stages)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(out, options)
+}
+
+// PipelineEncoder applies the operations of the stages like Pipeline, the
+// result is encoded by the returned Encoder. The images limited in bytes are
+// not supported since they may be encoded several times.
+func (e *GoImage) PipelineEncoder(img *imagefile.ImageFile, stages []Stage) (Encoder, error) {
+	if len(stages) > 0 && fitsInBytes(stages[len(stages)-1].Options) {
+		return nil, ErrNotPipelinable
+	}
+
+	out, options, err := e.pipeline(img, stages)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer) error {
+		return e.writeTo(w, out, options)
+	}, nil
+}
+
+// pipeline returns the image transformed by the stages with the options to
+// encode it.
+func (e *GoImage) pipeline(img *imagefile.ImageFile, stages []Stage) (image.Image, *Options, error) {
+	if len(stages) == 0 {
+		return nil, nil, ErrNotPipelinable
+	}
+
+	operations := make([]imageOperation, len(stages))
+	for i := range stages {
+		operation, ok := imageOperations[stages[i].Operation]
+		if !ok {
+			return nil, nil, ErrNotPipelinable
+		}
+		operations[i] = operation
+	}
+
+	first, last := stages[0].Options, stages[len(stages)-1].Options
+	if animatedGIF(img, last) && gifFrames(img.Source) > 1 {
+		return nil, nil, ErrNotPipelinable
+	}
+
+	out, err := e.source(img, first)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range stages {
+		out, err = operations[i](e, out, stages[i].Options)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// the metrics are collected by the options of the first stage
+	options := *last
+	options.metrics = first.metrics
+
+	return out, &options, nil
+}
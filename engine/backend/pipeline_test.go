@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestPipeline(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, newNoisyImage(67, 45), imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	resize := &Options{Format: imaging.PNG, Width: 40, Height: 30}
+	blur := &Options{Format: imaging.PNG, Sigma: 2}
+	gray := &Options{Format: imaging.PNG}
+
+	content, err := e.Pipeline(img, []Stage{
+		{Operation: "resize", Options: resize},
+		{Operation: "blur", Options: blur},
+		{Operation: "grayscale", Options: gray},
+	})
+	assert.Nil(t, err)
+
+	// the result is the one of the operations applied one after the other
+	expected, err := e.Resize(img, resize)
+	assert.Nil(t, err)
+	expected, err = e.Blur(&imagefile.ImageFile{Source: expected}, blur)
+	assert.Nil(t, err)
+	expected, err = e.Grayscale(&imagefile.ImageFile{Source: expected}, gray)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, content)
+
+	// an error of a stage is returned
+	_, err = e.Pipeline(img, []Stage{
+		{Operation: "resize", Options: resize},
+		{Operation: "blur", Options: &Options{Format: imaging.PNG}},
+	})
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrNotPipelinable, err)
+
+	// operations which don't transform the image are not supported
+	_, err = e.Pipeline(img, []Stage{
+		{Operation: "resize", Options: resize},
+		{Operation: "sqip", Options: gray},
+	})
+	assert.Equal(t, ErrNotPipelinable, err)
+
+	// neither are animated GIFs
+	_, err = e.Pipeline(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, []Stage{
+		{Operation: "resize", Options: &Options{Format: imaging.GIF, Width: 20, Height: 20}},
+		{Operation: "grayscale", Options: &Options{Format: imaging.GIF}},
+	})
+	assert.Equal(t, ErrNotPipelinable, err)
+
+	// nor animated GIFs converted to animated WebPs
+	_, err = e.Pipeline(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, []Stage{
+		{Operation: "resize", Options: &Options{Format: WebP, Width: 20, Height: 20}},
+		{Operation: "grayscale", Options: &Options{Format: WebP}},
+	})
+	assert.Equal(t, ErrNotPipelinable, err)
+
+	// the first frame of the animated GIFs converted to stills is transformed
+	_, err = e.Pipeline(&imagefile.ImageFile{Source: newAnimatedGIF(t, 40, 40, 3)}, []Stage{
+		{Operation: "resize", Options: &Options{Format: imaging.PNG, Width: 20, Height: 20}},
+		{Operation: "grayscale", Options: &Options{Format: imaging.PNG}},
+	})
+	assert.Nil(t, err)
+}
+
+func TestPipelineEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, newNoisyImage(67, 45), imaging.PNG)
+	assert.Nil(t, err)
+
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	stages := []Stage{
+		{Operation: "resize", Options: &Options{Format: imaging.PNG, Width: 40, Height: 30}},
+		{Operation: "grayscale", Options: &Options{Format: imaging.PNG}},
+	}
+
+	encoder, err := e.PipelineEncoder(img, stages)
+	assert.Nil(t, err)
+
+	// the encoded image is the one of the buffered pipeline
+	out := &bytes.Buffer{}
+	err = encoder(out)
+	assert.Nil(t, err)
+
+	expected, err := e.Pipeline(img, stages)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, out.Bytes())
+
+	// the errors of the operations are returned before the encoding
+	_, err = e.PipelineEncoder(img, []Stage{
+		{Operation: "blur", Options: &Options{Format: imaging.PNG}},
+	})
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrNotPipelinable, err)
+
+	// the images limited in bytes are encoded several times
+	_, err = e.PipelineEncoder(img, []Stage{
+		{Operation: "resize", Options: &Options{Format: imaging.JPEG, Width: 40, Height: 30, MaxBytes: 1000}},
+	})
+	assert.Equal(t, ErrNotPipelinable, err)
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	PlaceholderAverage  = "average"
+	PlaceholderBlurHash = "blurhash"
+	PlaceholderDataURI  = "datauri"
+)
+
+// Placeholders are the kinds of placeholders, the average color image is
+// the default one, the other ones are text
+var Placeholders = []string{
+	PlaceholderAverage,
+	PlaceholderBlurHash,
+	PlaceholderDataURI,
+}
+
+// placeholderDataURISize is the size of the images encoded as data URIs
+const placeholderDataURISize = 16
+
+// Placeholder returns a tiny placeholder of the image for lazy loading: a
+// 1 pixel image of the average color, a BlurHash or a data URI of a few
+// pixels wide image.
+func (e *GoImage) Placeholder(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.PlaceholderMode {
+	case "", PlaceholderAverage:
+		return e.toBytes(imaging.Resize(image, 1, 1, imaging.Box), options)
+	case PlaceholderBlurHash:
+		hash, err := blurHash(image, DefaultBlurHashComponentsX, DefaultBlurHashComponentsY)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(hash), nil
+	case PlaceholderDataURI:
+		thumb := imaging.Fit(image, placeholderDataURISize, placeholderDataURISize, imaging.Box)
+
+		content, err := e.toBytes(thumb, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(fmt.Sprintf("data:%s;base64,%s", mimeType(options.Format), base64.StdEncoding.EncodeToString(content))), nil
+	}
+
+	return nil, fmt.Errorf("Invalid placeholder %s, available values are: %v", options.PlaceholderMode, Placeholders)
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// decodeBase83 decodes the base 83 digits of a BlurHash.
+func decodeBase83(digits string) int {
+	var value int
+	for i := range digits {
+		value = value*83 + strings.IndexByte(blurHashCharacters, digits[i])
+	}
+	return value
+}
+
+func TestBlurHash(t *testing.T) {
+	hash, err := blurHash(imaging.New(40, 30, color.NRGBA{200, 100, 50, 255}), 4, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "L7M|T9^4fQ^4}XoKfQoKfQfQfQfQ", hash)
+
+	// a single component is the average color
+	hash, err = blurHash(imaging.New(40, 30, color.NRGBA{200, 100, 50, 255}), 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "00M|T9", hash)
+
+	// the left half is dark and the right half bright
+	src := imaging.New(40, 30, color.White)
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 30; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+		}
+	}
+
+	hash, err = blurHash(src, 4, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "L~Lqe900Rj-;t7WBayj[fQfQfQfQ", hash)
+
+	// the first horizontal component is negative on every channel
+	horizontal := decodeBase83(hash[6:8])
+	assert.Equal(t, horizontal/361, horizontal%19)
+	assert.True(t, horizontal%19 < 9)
+
+	_, err = blurHash(src, 10, 3)
+	assert.NotNil(t, err)
+}
+
+func TestPlaceholder(t *testing.T) {
+	e := &GoImage{}
+
+	// the left half is red and the right half blue
+	src := imaging.New(40, 30, color.NRGBA{0, 0, 255, 255})
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 30; y++ {
+			src.SetNRGBA(x, y, colorRed)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, src, imaging.PNG)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Placeholder(img, &Options{Format: imaging.PNG})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, out.Bounds().Dx())
+	assert.Equal(t, 1, out.Bounds().Dy())
+	average := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+	assert.InDelta(t, 128, int(average.R), 1)
+	assert.InDelta(t, 0, int(average.G), 1)
+	assert.InDelta(t, 128, int(average.B), 1)
+
+	content, err = e.Placeholder(img, &Options{Format: imaging.PNG, PlaceholderMode: PlaceholderDataURI})
+	assert.Nil(t, err)
+
+	uri := string(content)
+	assert.True(t, strings.HasPrefix(uri, "data:image/png;base64,"))
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,"))
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(decoded))
+	assert.Nil(t, err)
+	assert.Equal(t, 16, out.Bounds().Dx())
+	assert.Equal(t, 12, out.Bounds().Dy())
+
+	file, err := imagefile.FromDataURI(uri)
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", file.ContentType())
+
+	content, err = e.Placeholder(img, &Options{PlaceholderMode: PlaceholderBlurHash})
+	assert.Nil(t, err)
+	assert.Len(t, content, 28)
+
+	_, err = e.Placeholder(img, &Options{PlaceholderMode: "lqip"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/thoas/picfit/constants"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// DefaultQRCodeSize is the default size of the QR code in percent of the smallest side of the image
+const DefaultQRCodeSize = 25
+
+// QRCode draws a QR code encoding the text defined in options, the code is
+// drawn with its quiet zone in the corner defined by the stick option.
+func (e *GoImage) QRCode(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := drawQRCode(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(out, options)
+}
+
+func drawQRCode(img image.Image, options *Options) (*image.NRGBA, error) {
+	code, err := qrcode.New(options.QRCodeText, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	// the bitmap contains the quiet zone
+	bitmap := code.Bitmap()
+
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+
+	percent := options.QRCodeSize
+	if percent <= 0 {
+		percent = DefaultQRCodeSize
+	}
+
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	// modules are drawn with an integer size so they stay sharp
+	module := side * percent / 100 / len(bitmap)
+	if module < 1 {
+		module = 1
+	}
+	size := module * len(bitmap)
+
+	var position image.Point
+	switch options.Stick {
+	case constants.TopLeft:
+		position = image.Point{}
+	case constants.TopRight:
+		position = image.Point{X: b.Dx() - size}
+	case constants.BottomLeft:
+		position = image.Point{Y: b.Dy() - size}
+	default:
+		position = image.Point{X: b.Dx() - size, Y: b.Dy() - size}
+	}
+
+	for y, row := range bitmap {
+		for x, black := range row {
+			c := color.White
+			if black {
+				c = color.Black
+			}
+
+			r := image.Rect(x*module, y*module, (x+1)*module, (y+1)*module).Add(position)
+			draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/constants"
+)
+
+func TestDrawQRCode(t *testing.T) {
+	img := imaging.New(300, 200, color.NRGBA{200, 100, 50, 255})
+	text := "https://example.com/picfit"
+
+	out, err := drawQRCode(img, &Options{QRCodeText: text, QRCodeSize: 50})
+	assert.Nil(t, err)
+
+	code, err := qrcode.New(text, qrcode.Medium)
+	assert.Nil(t, err)
+	bitmap := code.Bitmap()
+
+	module := 200 * 50 / 100 / len(bitmap)
+	size := module * len(bitmap)
+	x0, y0 := 300-size, 200-size
+
+	// read the modules back from the center of each one
+	for y, row := range bitmap {
+		for x, black := range row {
+			r, _, _, _ := out.At(x0+x*module+module/2, y0+y*module+module/2).RGBA()
+			assert.Equal(t, black, r < 0x8000, "module %d,%d", x, y)
+		}
+	}
+
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, out.NRGBAAt(x0-1, y0-1))
+
+	out, err = drawQRCode(img, &Options{QRCodeText: text, QRCodeSize: 50, Stick: constants.TopLeft})
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, out.NRGBAAt(299, 199))
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// DefaultRedactSigma is the default strength of the redaction blur
+const DefaultRedactSigma = 10
+
+// RegionDetector detects the regions of an image to redact such as faces or plates
+type RegionDetector interface {
+	Detect(img image.Image) ([]image.Rectangle, error)
+}
+
+// Redact blurs the regions defined in options, the detector of the backend
+// is used when no region is defined.
+func (e *GoImage) Redact(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted, err := e.redactImage(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(redacted, options)
+}
+
+// redactImage blurs the regions of img defined in options or found by the detector.
+func (e *GoImage) redactImage(img image.Image, options *Options) (image.Image, error) {
+	regions := options.RedactRegions
+	if len(regions) == 0 && e.Detector != nil {
+		var err error
+		regions, err = e.Detector.Detect(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sigma := options.Sigma
+	if sigma == 0 {
+		sigma = DefaultRedactSigma
+	}
+	if err := checkSigma(sigma); err != nil {
+		return nil, err
+	}
+
+	return redact(img, regions, sigma), nil
+}
+
+// redact returns a copy of img where the regions are blurred,
+// regions are relative to the top left corner of img.
+func redact(img image.Image, regions []image.Rectangle, sigma float64) *image.NRGBA {
+	dst := imaging.Clone(img)
+
+	for _, region := range regions {
+		r := region.Intersect(dst.Bounds())
+		if r.Empty() {
+			continue
+		}
+
+		blurred := imaging.Blur(imaging.Crop(dst, r), sigma)
+		draw.Draw(dst, r, blurred, image.Point{}, draw.Src)
+	}
+
+	return dst
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			if (x+y)%2 == 0 {
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			}
+		}
+	}
+
+	region := image.Rect(10, 10, 20, 25)
+	out := redact(img, []image.Rectangle{region, image.Rect(50, 50, 60, 60)}, 2)
+
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 40; y++ {
+			if image.Pt(x, y).In(region) {
+				assert.NotEqual(t, img.At(x, y), out.At(x, y))
+			} else {
+				assert.Equal(t, img.At(x, y), out.At(x, y))
+			}
+		}
+	}
+}
+
+func TestRedactImageSigma(t *testing.T) {
+	e := &GoImage{}
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+
+	_, err := e.redactImage(img, &Options{RedactRegions: []image.Rectangle{img.Bounds()}})
+	assert.Nil(t, err)
+
+	_, err = e.redactImage(img, &Options{RedactRegions: []image.Rectangle{img.Bounds()}, Sigma: 1e300})
+	assert.NotNil(t, err)
+}
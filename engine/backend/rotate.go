@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	RotateEdgeClamp  = "clamp"
+	RotateEdgeFill   = "fill"
+	RotateEdgeMirror = "mirror"
+)
+
+// RotateEdgeModes are the available modes to fill the corners exposed
+// by an arbitrary angle rotation.
+var RotateEdgeModes = []string{
+	RotateEdgeFill,
+	RotateEdgeMirror,
+	RotateEdgeClamp,
+}
+
+const (
+	OrientationLandscape = "landscape"
+	OrientationPortrait  = "portrait"
+)
+
+// Orientations are the aspect orientations the images can be normalized to.
+var Orientations = []string{
+	OrientationLandscape,
+	OrientationPortrait,
+}
+
+// needsNormalization returns true if an image of the given dimensions has
+// to be rotated to match the aspect orientation, square images never do.
+func needsNormalization(width int, height int, orientation string) (bool, error) {
+	switch orientation {
+	case "":
+		return false, nil
+	case OrientationLandscape:
+		return height > width, nil
+	case OrientationPortrait:
+		return width > height, nil
+	}
+
+	return false, fmt.Errorf("Invalid orientation %s, available values are: %v", orientation, Orientations)
+}
+
+// normalizeOrientation rotates img by 90 degrees when its longer side
+// doesn't match the aspect orientation, it's left as is otherwise.
+func normalizeOrientation(img image.Image, orientation string) (image.Image, error) {
+	ok, err := needsNormalization(img.Bounds().Dx(), img.Bounds().Dy(), orientation)
+	if err != nil || !ok {
+		return img, err
+	}
+
+	return rotateTransformations[90](img), nil
+}
+
+// rotate rotates img counter-clockwise by angle degrees, the corners exposed
+// by the rotation are filled depending on the edge mode.
+func rotate(img image.Image, angle float64, options *Options) (*image.NRGBA, error) {
+	var edge func(v int, size int) int
+
+	switch options.RotateEdgeMode {
+	case "", RotateEdgeFill:
+		bg, err := backgroundColor(options)
+		if err != nil {
+			return nil, err
+		}
+
+		return imaging.Rotate(img, angle, bg), nil
+	case RotateEdgeClamp:
+		edge = clampEdge
+	case RotateEdgeMirror:
+		edge = mirrorEdge
+	default:
+		return nil, fmt.Errorf("Invalid rotate edge mode %s is not supported", options.RotateEdgeMode)
+	}
+
+	src := imaging.Clone(img)
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	sin, cos := math.Sincos(math.Pi * angle / 180)
+	dstW, dstH := rotatedSize(srcW, srcH, sin, cos)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	srcXOff := float64(srcW)/2 - 0.5
+	srcYOff := float64(srcH)/2 - 0.5
+	dstXOff := float64(dstW)/2 - 0.5
+	dstYOff := float64(dstH)/2 - 0.5
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			dx, dy := float64(x)-dstXOff, float64(y)-dstYOff
+			xf := dx*cos - dy*sin + srcXOff
+			yf := dx*sin + dy*cos + srcYOff
+
+			dst.SetNRGBA(x, y, interpolate(src, xf, yf, edge))
+		}
+	}
+
+	return dst, nil
+}
+
+// rotatedSize returns the size of the canvas containing the rotated image,
+// it matches the one computed by imaging.Rotate.
+func rotatedSize(w int, h int, sin float64, cos float64) (int, int) {
+	xs := []float64{0, float64(w-1) * cos, float64(w-1)*cos - float64(h-1)*sin, -float64(h-1) * sin}
+	ys := []float64{0, float64(w-1) * sin, float64(w-1)*sin + float64(h-1)*cos, float64(h-1) * cos}
+
+	size := func(values []float64) int {
+		min, max := values[0], values[0]
+		for _, v := range values {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+
+		n := max - min + 1
+		if n-math.Floor(n) > 0.1 {
+			n++
+		}
+
+		return int(n)
+	}
+
+	return size(xs), size(ys)
+}
+
+// interpolate samples src at (xf, yf) with a bilinear interpolation,
+// out of bounds coordinates are remapped using edge.
+func interpolate(src *image.NRGBA, xf float64, yf float64, edge func(v int, size int) int) color.NRGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	x0, y0 := int(math.Floor(xf)), int(math.Floor(yf))
+	dx, dy := xf-float64(x0), yf-float64(y0)
+
+	var r, g, b, a float64
+	for _, p := range []struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - dx) * (1 - dy)},
+		{x0 + 1, y0, dx * (1 - dy)},
+		{x0, y0 + 1, (1 - dx) * dy},
+		{x0 + 1, y0 + 1, dx * dy},
+	} {
+		c := src.NRGBAAt(edge(p.x, w), edge(p.y, h))
+		r += float64(c.R) * p.weight
+		g += float64(c.G) * p.weight
+		b += float64(c.B) * p.weight
+		a += float64(c.A) * p.weight
+	}
+
+	return color.NRGBA{clampUint8(r), clampUint8(g), clampUint8(b), clampUint8(a)}
+}
+
+func clampEdge(v int, size int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= size {
+		return size - 1
+	}
+
+	return v
+}
+
+func mirrorEdge(v int, size int) int {
+	if size == 1 {
+		return 0
+	}
+
+	period := 2 * (size - 1)
+	v = v % period
+	if v < 0 {
+		v += period
+	}
+	if v >= size {
+		v = period - v
+	}
+
+	return v
+}
+
+// backgroundColor returns the background color from options,
+// it defaults to transparent.
+func backgroundColor(options *Options) (color.Color, error) {
+	if options.Background == "" {
+		return color.Transparent, nil
+	}
+
+	rgb, err := Hex2RGB(Hex(options.Background))
+	if err != nil {
+		return nil, err
+	}
+
+	return color.NRGBA{rgb.Red, rgb.Green, rgb.Blue, 255}, nil
+}
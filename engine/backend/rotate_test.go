@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestRotateEdgeMode(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 6), uint8(y * 12), 0, 255})
+		}
+	}
+
+	fill, err := rotate(src, 30, &Options{RotateEdgeMode: RotateEdgeFill, Background: "0000ff"})
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, fill.NRGBAAt(0, 0))
+
+	clamp, err := rotate(src, 30, &Options{RotateEdgeMode: RotateEdgeClamp})
+	assert.Nil(t, err)
+
+	mirror, err := rotate(src, 30, &Options{RotateEdgeMode: RotateEdgeMirror})
+	assert.Nil(t, err)
+
+	assert.Equal(t, fill.Bounds(), clamp.Bounds())
+	assert.Equal(t, fill.Bounds(), mirror.Bounds())
+
+	for _, pt := range []image.Point{{0, 0}, {clamp.Bounds().Dx() - 1, clamp.Bounds().Dy() - 1}} {
+		assert.Equal(t, uint8(255), clamp.NRGBAAt(pt.X, pt.Y).A)
+		assert.Equal(t, uint8(255), mirror.NRGBAAt(pt.X, pt.Y).A)
+		assert.NotEqual(t, clamp.NRGBAAt(pt.X, pt.Y), mirror.NRGBAAt(pt.X, pt.Y))
+	}
+
+	// the top left corner lies above the source, clamping extends its first row
+	assert.Equal(t, uint8(0), clamp.NRGBAAt(0, 0).G)
+
+	_, err = rotate(src, 30, &Options{RotateEdgeMode: "foo"})
+	assert.NotNil(t, err)
+}
+
+func TestMirrorEdge(t *testing.T) {
+	assert.Equal(t, 1, mirrorEdge(-1, 4))
+	assert.Equal(t, 2, mirrorEdge(4, 4))
+	assert.Equal(t, 3, mirrorEdge(3, 4))
+	assert.Equal(t, 0, clampEdge(-3, 4))
+	assert.Equal(t, 3, clampEdge(7, 4))
+}
+
+func TestNormalizeOrientation(t *testing.T) {
+	e := &GoImage{}
+
+	// a portrait source is rotated to landscape
+	img := &imagefile.ImageFile{Source: newImage(t, 20, 40, imaging.PNG)}
+	ok, err := e.WouldTransform(img, &Options{Format: imaging.PNG, NormalizeOrientation: OrientationLandscape})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	content, err := e.Resize(img, &Options{Format: imaging.PNG, Width: 20, NormalizeOrientation: OrientationLandscape})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Pt(20, 10), out.Bounds().Size())
+
+	// a landscape source is kept as is
+	img = &imagefile.ImageFile{Source: newImage(t, 40, 20, imaging.PNG)}
+	ok, err = e.WouldTransform(img, &Options{Format: imaging.PNG, Width: 80, Height: 40, NormalizeOrientation: OrientationLandscape})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	content, err = e.Resize(img, &Options{Format: imaging.PNG, Width: 20, NormalizeOrientation: OrientationLandscape})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Pt(20, 10), out.Bounds().Size())
+
+	src := imaging.New(40, 20, color.White)
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+
+	rotated, err := normalizeOrientation(src, OrientationPortrait)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Pt(20, 40), rotated.Bounds().Size())
+	// the image is rotated counter-clockwise
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, color.NRGBAModel.Convert(rotated.At(0, 39)))
+
+	square := imaging.New(20, 20, color.White)
+	rotated, err = normalizeOrientation(square, OrientationPortrait)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Image(square), rotated)
+
+	_, err = normalizeOrientation(src, "diagonal")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	ShapeRect    = "rect"
+	ShapeRounded = "rounded"
+)
+
+// Shape is a filled shape drawn over an image
+type Shape struct {
+	Kind   string
+	X      int
+	Y      int
+	Width  int
+	Height int
+	Radius int
+	Color  string
+}
+
+// DrawShapes draws the shapes defined in options over the image.
+func (e *GoImage) DrawShapes(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := drawShapes(image, options.Shapes)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(out, options)
+}
+
+func drawShapes(img image.Image, shapes []Shape) (draw.Image, error) {
+	dst := image.NewNRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	for _, shape := range shapes {
+		rgb, err := Hex2RGB(Hex(shape.Color))
+		if err != nil {
+			return nil, err
+		}
+
+		// shapes are relative to the top left corner of the image
+		mask, err := shapeMask(shape, dst.Bounds().Sub(dst.Bounds().Min))
+		if err != nil {
+			return nil, err
+		}
+		if mask == nil {
+			continue
+		}
+
+		r := mask.Bounds().Add(dst.Bounds().Min)
+		fill := image.NewUniform(color.NRGBA{rgb.Red, rgb.Green, rgb.Blue, 255})
+
+		draw.DrawMask(dst, r, fill, image.ZP, mask, mask.Bounds().Min, draw.Over)
+	}
+
+	return dst, nil
+}
+
+// shapeMask returns the coverage of the shape within bounds, rounded corners
+// are antialiased. The mask is nil when the shape is outside of bounds.
+func shapeMask(shape Shape, bounds image.Rectangle) (*image.Alpha, error) {
+	if shape.Width <= 0 || shape.Height <= 0 {
+		return nil, fmt.Errorf("Invalid shape dimensions %dx%d", shape.Width, shape.Height)
+	}
+
+	if shape.Kind != ShapeRect && shape.Kind != ShapeRounded {
+		return nil, fmt.Errorf("Invalid shape %s is not supported", shape.Kind)
+	}
+
+	r := image.Rect(shape.X, shape.Y, shape.X+shape.Width, shape.Y+shape.Height)
+
+	// only the visible part of the shape is allocated
+	visible := r.Intersect(bounds)
+	if visible.Empty() {
+		return nil, nil
+	}
+
+	mask := image.NewAlpha(visible)
+
+	switch shape.Kind {
+	case ShapeRect:
+		draw.Draw(mask, visible, image.Opaque, image.ZP, draw.Src)
+	case ShapeRounded:
+		radius := math.Min(float64(shape.Radius), math.Min(float64(shape.Width), float64(shape.Height))/2)
+
+		for y := visible.Min.Y; y < visible.Max.Y; y++ {
+			for x := visible.Min.X; x < visible.Max.X; x++ {
+				// distance from the pixel center to the nearest corner circle center
+				px, py := float64(x)+0.5, float64(y)+0.5
+				cx := math.Min(math.Max(px, float64(r.Min.X)+radius), float64(r.Max.X)-radius)
+				cy := math.Min(math.Max(py, float64(r.Min.Y)+radius), float64(r.Max.Y)-radius)
+				coverage := math.Min(math.Max(radius-math.Hypot(px-cx, py-cy)+0.5, 0), 1)
+
+				mask.SetAlpha(x, y, color.Alpha{uint8(coverage * 255)})
+			}
+		}
+	}
+
+	return mask, nil
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrawShapes(t *testing.T) {
+	img := imaging.New(50, 50, color.NRGBA{255, 255, 255, 255})
+
+	out, err := drawShapes(img, []Shape{
+		{Kind: ShapeRect, X: 10, Y: 10, Width: 20, Height: 5, Color: "ff0000"},
+		{Kind: ShapeRounded, X: 10, Y: 30, Width: 30, Height: 10, Radius: 5, Color: "#0000ff"},
+	})
+	assert.Nil(t, err)
+
+	red := color.NRGBA{255, 0, 0, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+
+	for x := 10; x < 30; x++ {
+		for y := 10; y < 15; y++ {
+			assert.Equal(t, red, color.NRGBAModel.Convert(out.At(x, y)))
+		}
+	}
+
+	assert.Equal(t, white, color.NRGBAModel.Convert(out.At(9, 10)))
+	assert.Equal(t, white, color.NRGBAModel.Convert(out.At(30, 14)))
+	assert.Equal(t, white, color.NRGBAModel.Convert(out.At(10, 15)))
+
+	// rounded corners are not filled
+	assert.Equal(t, white, color.NRGBAModel.Convert(out.At(10, 30)))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, color.NRGBAModel.Convert(out.At(25, 35)))
+
+	_, err = drawShapes(img, []Shape{{Kind: "circle", Width: 1, Height: 1, Color: "ff0000"}})
+	assert.NotNil(t, err)
+}
+
+func TestShapeMaskBounds(t *testing.T) {
+	bounds := image.Rect(0, 0, 50, 50)
+
+	// only the visible part of the shape is allocated
+	mask, err := shapeMask(Shape{Kind: ShapeRounded, X: 40, Y: 0, Width: 1 << 30, Height: 1 << 30, Radius: 5}, bounds)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(40, 0, 50, 50), mask.Bounds())
+	assert.Equal(t, color.Alpha{0}, mask.AlphaAt(40, 0))
+	assert.Equal(t, color.Alpha{255}, mask.AlphaAt(45, 20))
+
+	mask, err = shapeMask(Shape{Kind: ShapeRect, X: 60, Y: 60, Width: 10, Height: 10}, bounds)
+	assert.Nil(t, err)
+	assert.Nil(t, mask)
+
+	img := imaging.New(50, 50, color.NRGBA{255, 255, 255, 255})
+	out, err := drawShapes(img, []Shape{{Kind: ShapeRect, X: 60, Y: 60, Width: 10, Height: 10, Color: "ff0000"}})
+	assert.Nil(t, err)
+	assert.Equal(t, img.Pix, imaging.Clone(out).Pix)
+}
@@ -0,0 +1,22 @@
+package backend
+
+import (
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Sharpen sharpens the image with the sigma of options, it restores the
+// details lost by a large downscale when applied after it.
+func (e *GoImage) Sharpen(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	if err := checkSigma(options.Sigma); err != nil {
+		return nil, err
+	}
+
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(imaging.Sharpen(image, options.Sigma), options)
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestSharpen(t *testing.T) {
+	e := &GoImage{}
+
+	src := imaging.Blur(newNoisyImage(40, 40), 2)
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, src)
+	assert.Nil(t, err)
+	img := &imagefile.ImageFile{Source: buf.Bytes()}
+
+	content, err := e.Sharpen(img, &Options{Format: imaging.PNG, Sigma: 1.5})
+	assert.Nil(t, err)
+
+	out, err := png.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, src.Bounds(), out.Bounds())
+	assert.NotEqual(t, src.Pix, imaging.Clone(out).Pix)
+	assert.Equal(t, imaging.Sharpen(src, 1.5).Pix, imaging.Clone(out).Pix)
+
+	for _, sigma := range []float64{0, -1} {
+		_, err = e.Sharpen(img, &Options{Format: imaging.PNG, Sigma: sigma})
+		assert.NotNil(t, err)
+	}
+}
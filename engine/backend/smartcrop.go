@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// smartCropGridSize is the maximum number of cells per side of the grid
+// the crop rectangle is searched on
+const smartCropGridSize = 256
+
+// smartCrop returns the rectangle of width x height within img containing
+// the most edges, which are where the luminance changes the most, so flat
+// areas like the sky or a background are cropped first. The centered
+// rectangle is kept when the image is flat.
+func smartCrop(img image.Image, width int, height int) image.Rectangle {
+	b := img.Bounds()
+	if width >= b.Dx() && height >= b.Dy() {
+		return b
+	}
+
+	// the gradients are summed in cells so large images are searched
+	// on a grid of at most smartCropGridSize cells per side
+	cell := (maxSize(b) + smartCropGridSize - 1) / smartCropGridSize
+	gw, gh := (b.Dx()+cell-1)/cell, (b.Dy()+cell-1)/cell
+	cw := clampInt((width+cell/2)/cell, 1, gw)
+	ch := clampInt((height+cell/2)/cell, 1, gh)
+
+	gray := imaging.Grayscale(img)
+	lum := func(x int, y int) int {
+		return int(gray.Pix[y*gray.Stride+x*4])
+	}
+
+	// energy is the summed-area table of the luminance gradients of the cells
+	stride := gw + 1
+	energy := make([]int, stride*(gh+1))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var gradient int
+			if x+1 < b.Dx() {
+				gradient += absInt(lum(x+1, y) - lum(x, y))
+			}
+			if y+1 < b.Dy() {
+				gradient += absInt(lum(x, y+1) - lum(x, y))
+			}
+			energy[(y/cell+1)*stride+x/cell+1] += gradient
+		}
+	}
+	for y := 1; y <= gh; y++ {
+		for x := 1; x <= gw; x++ {
+			energy[y*stride+x] += energy[(y-1)*stride+x] + energy[y*stride+x-1] - energy[(y-1)*stride+x-1]
+		}
+	}
+
+	centerX, centerY := (gw-cw)/2, (gh-ch)/2
+	bestX, bestY, best, distance := centerX, centerY, -1, 0
+	for y := 0; y <= gh-ch; y++ {
+		for x := 0; x <= gw-cw; x++ {
+			e := energy[(y+ch)*stride+x+cw] - energy[y*stride+x+cw] - energy[(y+ch)*stride+x] + energy[y*stride+x]
+			d := absInt(x-centerX) + absInt(y-centerY)
+			if e > best || (e == best && d < distance) {
+				bestX, bestY, best, distance = x, y, e, d
+			}
+		}
+	}
+
+	// the rectangle is centered on the best cells
+	x := clampInt(bestX*cell+(cw*cell-width)/2, 0, b.Dx()-width)
+	y := clampInt(bestY*cell+(ch*cell-height)/2, 0, b.Dy()-height)
+
+	return image.Rect(x, y, x+width, y+height).Add(b.Min).Intersect(b)
+}
+
+func maxSize(b image.Rectangle) int {
+	if b.Dx() > b.Dy() {
+		return b.Dx()
+	}
+	return b.Dy()
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// clampInt returns v bounded by lo and hi, lo wins when hi is lower.
+func clampInt(v int, lo int, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
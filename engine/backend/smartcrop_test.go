@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// newDetailedCornerImage returns a flat gray image with a checkerboard in
+// the rectangle detail.
+func newDetailedCornerImage(width int, height int, detail image.Rectangle) *image.NRGBA {
+	img := imaging.New(width, height, color.NRGBA{128, 128, 128, 255})
+	for y := detail.Min.Y; y < detail.Max.Y; y++ {
+		for x := detail.Min.X; x < detail.Max.X; x++ {
+			if (x/2+y/2)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return img
+}
+
+func TestSmartCrop(t *testing.T) {
+	detail := image.Rect(160, 0, 200, 40)
+	img := newDetailedCornerImage(200, 100, detail)
+
+	rect := smartCrop(img, 50, 50)
+	assert.Equal(t, image.Pt(50, 50), rect.Size())
+	assert.True(t, detail.In(rect.Inset(-2)), "%v should contain %v", rect, detail)
+
+	// images larger than the sample are downscaled first
+	detail = image.Rect(0, 900, 100, 1000)
+	img = newDetailedCornerImage(1000, 1000, detail)
+
+	rect = smartCrop(img, 200, 200)
+	assert.Equal(t, image.Pt(200, 200), rect.Size())
+	assert.True(t, detail.In(rect), "%v should contain %v", rect, detail)
+
+	// flat images are cropped at the center
+	rect = smartCrop(imaging.New(200, 100, color.White), 50, 50)
+	assert.Equal(t, image.Rect(75, 25, 125, 75), rect)
+}
+
+func TestSmartThumbnail(t *testing.T) {
+	e := &GoImage{}
+
+	src := newDetailedCornerImage(400, 200, image.Rect(320, 0, 400, 80))
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, src, imaging.PNG)
+	assert.Nil(t, err)
+
+	for gravity, detailed := range map[string]bool{"": false, CropGravitySmart: true} {
+		content, err := e.Thumbnail(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{
+			Format:      imaging.PNG,
+			Width:       100,
+			Height:      100,
+			CropGravity: gravity,
+		})
+		assert.Nil(t, err)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 100, 100), out.Bounds())
+
+		// the checkerboard is in the top right corner of the smart thumbnail
+		r, _, _, _ := out.At(90, 10).RGBA()
+		r2, _, _, _ := out.At(91, 10).RGBA()
+		assert.Equal(t, detailed, r != r2, "gravity %q", gravity)
+	}
+}
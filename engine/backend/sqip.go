@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// DefaultSQIPPrimitives is the default number of primitives drawn by SQIP
+const DefaultSQIPPrimitives = 8
+
+const (
+	// sqipSize is the size of the largest side of the image primitives are fitted on
+	sqipSize       = 64
+	sqipAlpha      = 0.5
+	sqipCandidates = 64
+	sqipMutations  = 64
+	sqipBlur       = 4
+)
+
+// SQIP returns a SVG placeholder composed of a few triangles approximating the image.
+func (e *GoImage) SQIP(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	primitives := options.Primitives
+	if primitives <= 0 {
+		primitives = DefaultSQIPPrimitives
+	}
+
+	return sqip(image, primitives), nil
+}
+
+// sqipCanvas holds the target and the current rendering as RGB values
+type sqipCanvas struct {
+	w, h    int
+	target  []float64
+	current []float64
+}
+
+// fit returns the color minimizing the error when t is drawn over the current
+// rendering and the resulting difference of the squared error.
+func (c *sqipCanvas) fit(t triangle) ([3]float64, float64) {
+	var (
+		sum   [3]float64
+		count float64
+		col   [3]float64
+		delta float64
+	)
+
+	rasterize(t, c.w, c.h, func(x, y int) {
+		i := (y*c.w + x) * 3
+		for k := 0; k < 3; k++ {
+			sum[k] += (c.target[i+k] - (1-sqipAlpha)*c.current[i+k]) / sqipAlpha
+		}
+		count++
+	})
+
+	if count == 0 {
+		return col, 0
+	}
+
+	for k := 0; k < 3; k++ {
+		col[k] = float64(clampUint8(sum[k] / count))
+	}
+
+	rasterize(t, c.w, c.h, func(x, y int) {
+		i := (y*c.w + x) * 3
+		for k := 0; k < 3; k++ {
+			before := c.current[i+k] - c.target[i+k]
+			after := (1-sqipAlpha)*c.current[i+k] + sqipAlpha*col[k] - c.target[i+k]
+			delta += after*after - before*before
+		}
+	})
+
+	return col, delta
+}
+
+func (c *sqipCanvas) draw(t triangle, col [3]float64) {
+	rasterize(t, c.w, c.h, func(x, y int) {
+		i := (y*c.w + x) * 3
+		for k := 0; k < 3; k++ {
+			c.current[i+k] = (1-sqipAlpha)*c.current[i+k] + sqipAlpha*col[k]
+		}
+	})
+}
+
+func randomTriangle(r *rand.Rand, w int, h int) triangle {
+	center := point{r.Float64() * float64(w), r.Float64() * float64(h)}
+	size := float64(w+h) / 4
+
+	vertex := func() point {
+		return point{center.X + (r.Float64()-0.5)*size, center.Y + (r.Float64()-0.5)*size}
+	}
+
+	return triangle{vertex(), vertex(), vertex()}
+}
+
+func mutateTriangle(r *rand.Rand, t triangle) triangle {
+	vertices := []*point{&t.A, &t.B, &t.C}
+	v := vertices[r.Intn(len(vertices))]
+	v.X += r.NormFloat64() * 4
+	v.Y += r.NormFloat64() * 4
+
+	return t
+}
+
+// sqip greedily fits n triangles on a downscaled version of img
+// and renders them as a blurred SVG.
+func sqip(img image.Image, n int) []byte {
+	src := imaging.Fit(img, sqipSize, sqipSize, imaging.Box)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	canvas := &sqipCanvas{
+		w:       w,
+		h:       h,
+		target:  make([]float64, w*h*3),
+		current: make([]float64, w*h*3),
+	}
+
+	var background [3]float64
+	for i := 0; i < w*h; i++ {
+		for k := 0; k < 3; k++ {
+			canvas.target[i*3+k] = float64(src.Pix[i*4+k])
+			background[k] += canvas.target[i*3+k]
+		}
+	}
+
+	for k := 0; k < 3; k++ {
+		background[k] /= float64(w * h)
+	}
+
+	for i := 0; i < w*h; i++ {
+		copy(canvas.current[i*3:i*3+3], background[:])
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d">`, w, h)
+	fmt.Fprintf(buf, `<filter id="b"><feGaussianBlur stdDeviation="%d"/></filter>`, sqipBlur)
+	fmt.Fprintf(buf, `<rect width="100%%" height="100%%" fill="%s"/>`, sqipHex(background))
+	fmt.Fprintf(buf, `<g filter="url(#b)" fill-opacity="%g">`, sqipAlpha)
+
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < n; i++ {
+		var (
+			best      triangle
+			bestColor [3]float64
+			bestDelta float64
+		)
+
+		for j := 0; j < sqipCandidates; j++ {
+			t := randomTriangle(r, w, h)
+			if col, delta := canvas.fit(t); delta < bestDelta {
+				best, bestColor, bestDelta = t, col, delta
+			}
+		}
+
+		if bestDelta >= 0 {
+			break
+		}
+
+		for j := 0; j < sqipMutations; j++ {
+			t := mutateTriangle(r, best)
+			if col, delta := canvas.fit(t); delta < bestDelta {
+				best, bestColor, bestDelta = t, col, delta
+			}
+		}
+
+		canvas.draw(best, bestColor)
+
+		fmt.Fprintf(buf, `<polygon points="%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="%s"/>`,
+			best.A.X, best.A.Y, best.B.X, best.B.Y, best.C.X, best.C.Y, sqipHex(bestColor))
+	}
+
+	buf.WriteString(`</g></svg>`)
+
+	return buf.Bytes()
+}
+
+func sqipHex(c [3]float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", clampUint8(c[0]), clampUint8(c[1]), clampUint8(c[2]))
+}
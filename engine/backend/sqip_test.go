@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQIP(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			if x < 100 {
+				img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	content := sqip(img, 8)
+	assert.True(t, len(content) < 2048)
+
+	var polygons int
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "polygon" {
+			polygons++
+		}
+	}
+
+	assert.True(t, polygons > 0)
+	assert.True(t, polygons <= 8)
+	assert.Equal(t, content, sqip(img, 8))
+}
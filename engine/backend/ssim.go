@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// DefaultSSIMTarget is the default SSIM the encoded image should reach
+	DefaultSSIMTarget = 0.98
+
+	ssimWindow = 8
+	// ssimMaxIterations caps the number of encodes, enough to bisect 1 to 100
+	ssimMaxIterations = 7
+)
+
+// ErrSSIMSize is an error returned if images compared with SSIM have different sizes
+var ErrSSIMSize = errors.New("Images must have the same size to compute SSIM")
+
+// JPEGQualityForSSIM encodes img as JPEG with the lowest quality whose
+// decoded result reaches the target SSIM against img, it returns the
+// content and the chosen quality.
+func (e *GoImage) JPEGQualityForSSIM(img image.Image, target float64) ([]byte, int, error) {
+	if target <= 0 {
+		target = DefaultSSIMTarget
+	}
+
+	var (
+		content []byte
+		quality int
+		low     = 1
+		high    = 100
+	)
+
+	for i := 0; i < ssimMaxIterations && low <= high; i++ {
+		q := (low + high) / 2
+
+		buf, score, err := jpegSSIM(img, q)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if score >= target {
+			content, quality = buf, q
+			high = q - 1
+		} else {
+			low = q + 1
+		}
+	}
+
+	if content == nil {
+		buf, _, err := jpegSSIM(img, 100)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return buf, 100, nil
+	}
+
+	return content, quality, nil
+}
+
+// jpegSSIM encodes img as JPEG with the given quality and returns
+// the content and the SSIM of the decoded result against img.
+func jpegSSIM(img image.Image, quality int) ([]byte, float64, error) {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, 0, err
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	score, err := ssim(img, decoded)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), score, nil
+}
+
+// ssim returns the mean structural similarity of the luminance of a and b
+// computed over non overlapping windows.
+func ssim(a image.Image, b image.Image) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, ErrSSIMSize
+	}
+
+	ga := imaging.Grayscale(a)
+	gb := imaging.Grayscale(b)
+
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	w, h := ga.Bounds().Dx(), ga.Bounds().Dy()
+
+	var (
+		total   float64
+		windows int
+	)
+
+	for y := 0; y < h; y += ssimWindow {
+		for x := 0; x < w; x += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+
+			for j := y; j < y+ssimWindow && j < h; j++ {
+				for i := x; i < x+ssimWindow && i < w; i++ {
+					va := float64(ga.Pix[j*ga.Stride+i*4])
+					vb := float64(gb.Pix[j*gb.Stride+i*4])
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+					n++
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1, nil
+	}
+
+	return total / float64(windows), nil
+}
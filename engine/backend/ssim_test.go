@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJPEGQualityForSSIM(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for x := 0; x < 128; x++ {
+		for y := 0; y < 128; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 2), uint8(y * 2), uint8(x * y / 64), 255})
+		}
+	}
+
+	e := &GoImage{}
+	content, quality, err := e.JPEGQualityForSSIM(img, 0.98)
+	assert.Nil(t, err)
+	assert.True(t, quality < 95)
+
+	decoded, err := jpeg.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	score, err := ssim(img, decoded)
+	assert.Nil(t, err)
+	assert.True(t, score >= 0.98)
+
+	buf := &bytes.Buffer{}
+	err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 95})
+	assert.Nil(t, err)
+	assert.True(t, len(content) < buf.Len())
+}
+
+func TestSSIM(t *testing.T) {
+	img := imaging.New(16, 16, color.NRGBA{100, 100, 100, 255})
+
+	score, err := ssim(img, img)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, score, 1e-9)
+
+	_, err = ssim(img, imaging.New(8, 8, color.NRGBA{}))
+	assert.Equal(t, ErrSSIMSize, err)
+}
@@ -0,0 +1,298 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/disintegration/imaging"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// Streamer is implemented by backends able to process an image read from
+// a seekable source without buffering it, the memory is then bounded by
+// the processed region instead of the size of the source.
+type Streamer interface {
+	StreamCrop(r io.ReadSeeker, w io.Writer, region image.Rectangle, options *Options) error
+}
+
+var (
+	// ErrNotStreamable is an error returned if an image cannot be read by parts
+	ErrNotStreamable = errors.New("Image is not streamable")
+	// ErrEmptyRegion is an error returned if a region doesn't intersect the image
+	ErrEmptyRegion = errors.New("Region is outside of the image")
+)
+
+const (
+	tiffTagImageWidth      = 256
+	tiffTagImageLength     = 257
+	tiffTagBitsPerSample   = 258
+	tiffTagCompression     = 259
+	tiffTagPhotometric     = 262
+	tiffTagStripOffsets    = 273
+	tiffTagSamplesPerPixel = 277
+	tiffTagRowsPerStrip    = 278
+	tiffTagPlanarConfig    = 284
+	tiffTagExtraSamples    = 338
+)
+
+// tiffStrips describes an uncompressed TIFF stored in strips with 8 bits samples
+type tiffStrips struct {
+	bounds          image.Rectangle
+	samplesPerPixel int
+	rowsPerStrip    int
+	offsets         []int64
+	photometric     int
+	associated      bool
+}
+
+// readSeekerAt reads at an offset of a source which only supports seeking
+type readSeekerAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *readSeekerAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.rs.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(r.rs, p)
+}
+
+func newReaderAt(r io.ReadSeeker) io.ReaderAt {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra
+	}
+
+	return &readSeekerAt{rs: r}
+}
+
+// StreamCrop writes the region of the image read from r to w, uncompressed
+// TIFFs are read by rows, the other images are buffered and decoded.
+func (e *GoImage) StreamCrop(r io.ReadSeeker, w io.Writer, region image.Rectangle, options *Options) error {
+	ra := newReaderAt(r)
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var out image.Image
+
+	strips, err := readTIFFStrips(ra, size)
+	if err == nil {
+		out, err = strips.crop(ra, region)
+	} else if err == ErrNotStreamable {
+		out, err = e.bufferedCrop(r, region, options)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the encoded region isn't buffered, it's written to w as it's encoded
+	return e.writeTo(w, out, options)
+}
+
+func (e *GoImage) bufferedCrop(r io.ReadSeeker, region image.Rectangle, options *Options) (image.Image, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := e.source(&imagefile.ImageFile{Source: source}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	region = region.Intersect(img.Bounds())
+	if region.Empty() {
+		return nil, ErrEmptyRegion
+	}
+
+	return imaging.Crop(img, region), nil
+}
+
+// readTIFFStrips reads the first image file directory of a TIFF of size
+// bytes, it returns ErrNotStreamable if the image is not an uncompressed
+// TIFF in strips.
+func readTIFFStrips(ra io.ReaderAt, size int64) (*tiffStrips, error) {
+	header := make([]byte, 8)
+	if _, err := ra.ReadAt(header, 0); err != nil || !isTIFF(header) {
+		return nil, ErrNotStreamable
+	}
+
+	order := tiffByteOrder(header)
+	offset := int64(order.Uint32(header[4:]))
+
+	count := make([]byte, 2)
+	if _, err := ra.ReadAt(count, offset); err != nil {
+		return nil, ErrInvalidTIFF
+	}
+
+	entries := make([]byte, 12*int(order.Uint16(count)))
+	if _, err := ra.ReadAt(entries, offset+2); err != nil {
+		return nil, ErrInvalidTIFF
+	}
+
+	tags := map[uint16][]int64{}
+	for i := 0; i < len(entries); i += 12 {
+		values, err := tiffValues(ra, size, order, entries[i:i+12])
+		if err != nil {
+			return nil, err
+		}
+		tags[order.Uint16(entries[i:])] = values
+	}
+
+	value := func(tag uint16, def int64) int64 {
+		if values := tags[tag]; len(values) > 0 {
+			return values[0]
+		}
+		return def
+	}
+
+	strips := &tiffStrips{
+		bounds:          image.Rect(0, 0, int(value(tiffTagImageWidth, 0)), int(value(tiffTagImageLength, 0))),
+		samplesPerPixel: int(value(tiffTagSamplesPerPixel, 1)),
+		rowsPerStrip:    int(value(tiffTagRowsPerStrip, value(tiffTagImageLength, 0))),
+		offsets:         tags[tiffTagStripOffsets],
+		photometric:     int(value(tiffTagPhotometric, -1)),
+		associated:      value(tiffTagExtraSamples, 0) == 1,
+	}
+
+	if value(tiffTagCompression, 1) != 1 || value(tiffTagPlanarConfig, 1) != 1 {
+		return nil, ErrNotStreamable
+	}
+
+	for _, bits := range tags[tiffTagBitsPerSample] {
+		if bits != 8 {
+			return nil, ErrNotStreamable
+		}
+	}
+
+	switch {
+	case strips.photometric <= 1 && strips.samplesPerPixel == 1:
+	case strips.photometric == 2 && (strips.samplesPerPixel == 3 || strips.samplesPerPixel == 4):
+	default:
+		return nil, ErrNotStreamable
+	}
+
+	if strips.bounds.Empty() || strips.rowsPerStrip <= 0 ||
+		len(strips.offsets) < (strips.bounds.Dy()+strips.rowsPerStrip-1)/strips.rowsPerStrip {
+		return nil, ErrInvalidTIFF
+	}
+
+	return strips, nil
+}
+
+// tiffValues returns the values of an IFD entry of type SHORT or LONG of a
+// TIFF of fileSize bytes, the other types are skipped.
+func tiffValues(ra io.ReaderAt, fileSize int64, order binary.ByteOrder, entry []byte) ([]int64, error) {
+	var size int64
+	switch order.Uint16(entry[2:]) {
+	case 3:
+		size = 2
+	case 4:
+		size = 4
+	default:
+		return nil, nil
+	}
+
+	count := int64(order.Uint32(entry[4:]))
+	data := entry[8:12]
+	if count*size > 4 {
+		// the values are checked against the file before being allocated
+		offset := int64(order.Uint32(entry[8:]))
+		if offset > fileSize || count*size > fileSize-offset {
+			return nil, ErrInvalidTIFF
+		}
+
+		data = make([]byte, count*size)
+		if _, err := ra.ReadAt(data, offset); err != nil {
+			return nil, ErrInvalidTIFF
+		}
+	}
+
+	values := make([]int64, count)
+	for i := range values {
+		if size == 2 {
+			values[i] = int64(order.Uint16(data[int64(i)*size:]))
+		} else {
+			values[i] = int64(order.Uint32(data[int64(i)*size:]))
+		}
+	}
+
+	return values, nil
+}
+
+// crop reads the rows of the region, only the pixels of the region are read.
+func (t *tiffStrips) crop(ra io.ReaderAt, region image.Rectangle) (image.Image, error) {
+	region = region.Intersect(t.bounds)
+	if region.Empty() {
+		return nil, ErrEmptyRegion
+	}
+
+	spp := t.samplesPerPixel
+	rowSize := int64(t.bounds.Dx() * spp)
+	row := make([]byte, region.Dx()*spp)
+
+	rect := image.Rect(0, 0, region.Dx(), region.Dy())
+
+	var (
+		pix    []byte
+		stride int
+		out    image.Image
+	)
+
+	switch {
+	case spp == 1:
+		gray := image.NewGray(rect)
+		pix, stride, out = gray.Pix, gray.Stride, gray
+	case t.associated:
+		rgba := image.NewRGBA(rect)
+		pix, stride, out = rgba.Pix, rgba.Stride, rgba
+	default:
+		nrgba := image.NewNRGBA(rect)
+		pix, stride, out = nrgba.Pix, nrgba.Stride, nrgba
+	}
+
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		strip := y / t.rowsPerStrip
+		offset := t.offsets[strip] + int64(y%t.rowsPerStrip)*rowSize + int64(region.Min.X*spp)
+		if _, err := ra.ReadAt(row, offset); err != nil {
+			return nil, ErrInvalidTIFF
+		}
+
+		dst := pix[(y-region.Min.Y)*stride:]
+		switch spp {
+		case 1:
+			copy(dst, row)
+			if t.photometric == 0 {
+				// white is zero
+				for i := range row {
+					dst[i] = 255 - row[i]
+				}
+			}
+		case 3:
+			for i := 0; i < region.Dx(); i++ {
+				copy(dst[i*4:i*4+3], row[i*3:i*3+3])
+				dst[i*4+3] = 255
+			}
+		default:
+			copy(dst, row)
+		}
+	}
+
+	return out, nil
+}
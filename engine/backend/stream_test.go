@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/tiff"
+)
+
+// countingReadSeeker counts the bytes read from a source which isn't an io.ReaderAt
+type countingReadSeeker struct {
+	rs   io.ReadSeeker
+	read int
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.rs.Read(p)
+	c.read += n
+	return n, err
+}
+
+func (c *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return c.rs.Seek(offset, whence)
+}
+
+func TestStreamCrop(t *testing.T) {
+	e := &GoImage{}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 1200, 1000))
+	for x := 0; x < 1200; x++ {
+		for y := 0; y < 1000; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := tiff.Encode(buf, img, &tiff.Options{Compression: tiff.Uncompressed})
+	assert.Nil(t, err)
+
+	region := image.Rect(300, 200, 400, 250)
+	source := &countingReadSeeker{rs: bytes.NewReader(buf.Bytes())}
+
+	out := &bytes.Buffer{}
+	err = e.StreamCrop(source, out, region, &Options{Format: imaging.PNG})
+	assert.Nil(t, err)
+
+	// only the rows of the region are read
+	assert.True(t, source.read < buf.Len()/100, "%d bytes read", source.read)
+
+	cropped, err := png.Decode(out)
+	assert.Nil(t, err)
+	assert.Equal(t, imaging.Crop(img, region).Pix, imaging.Clone(cropped).Pix)
+
+	// compressed TIFFs are buffered
+	buf.Reset()
+	err = tiff.Encode(buf, img, &tiff.Options{Compression: tiff.Deflate})
+	assert.Nil(t, err)
+
+	out.Reset()
+	err = e.StreamCrop(bytes.NewReader(buf.Bytes()), out, region, &Options{Format: imaging.PNG})
+	assert.Nil(t, err)
+
+	cropped, err = png.Decode(out)
+	assert.Nil(t, err)
+	assert.Equal(t, imaging.Crop(img, region).Pix, imaging.Clone(cropped).Pix)
+
+	err = e.StreamCrop(bytes.NewReader(buf.Bytes()), out, image.Rect(2000, 2000, 2100, 2100), &Options{Format: imaging.PNG})
+	assert.Equal(t, ErrEmptyRegion, err)
+}
+
+// chunkWriter records the size of each write
+type chunkWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return c.Buffer.Write(p)
+}
+
+func TestWriteTo(t *testing.T) {
+	e := &GoImage{}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 400))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 400; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	for _, options := range []*Options{
+		{Format: imaging.PNG},
+		{Format: imaging.JPEG, Quality: 80},
+		{Format: imaging.PNG, Deterministic: true},
+		{Format: imaging.JPEG, Quality: 80, JPEGOptimize: true},
+	} {
+		content, err := e.toBytes(img, options)
+		assert.Nil(t, err)
+
+		w := &chunkWriter{}
+		err = e.writeTo(w, img, options)
+		assert.Nil(t, err)
+		assert.Equal(t, content, w.Bytes())
+
+		// the post-processed images are written at once, the others as they're encoded
+		if options.Deterministic || options.JPEGOptimize {
+			assert.Equal(t, []int{len(content)}, w.writes)
+		} else {
+			assert.True(t, len(w.writes) > 1, "%d writes", len(w.writes))
+		}
+	}
+}
+
+func TestReadTIFFStripsCount(t *testing.T) {
+	// a single StripOffsets entry declaring 2^32-1 LONG values
+	source := make([]byte, 100)
+	copy(source, []byte("II*\x00\x08\x00\x00\x00\x01\x00"))
+	copy(source[10:], []byte{0x11, 0x01, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff, 0x1a, 0x00, 0x00, 0x00})
+
+	_, err := readTIFFStrips(bytes.NewReader(source), int64(len(source)))
+	assert.Equal(t, ErrInvalidTIFF, err)
+}
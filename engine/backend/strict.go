@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var (
+	gifHeader  = []byte("GIF8")
+	jpegHeader = []byte{0xff, 0xd8}
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// trailingData returns the number of bytes found after the logical end
+// of the image contained in source, it returns 0 for unknown formats.
+func trailingData(source []byte) int {
+	var end int
+
+	switch {
+	case bytes.HasPrefix(source, pngHeader):
+		end = pngEnd(source)
+	case bytes.HasPrefix(source, gifHeader):
+		end = gifEnd(source)
+	case bytes.HasPrefix(source, jpegHeader):
+		end = jpegEnd(source)
+	default:
+		return 0
+	}
+
+	if end < 0 || end > len(source) {
+		return 0
+	}
+
+	return len(source) - end
+}
+
+// pngEnd returns the offset following the IEND chunk.
+func pngEnd(source []byte) int {
+	offset := len(pngHeader)
+	for offset+8 <= len(source) {
+		length := int(binary.BigEndian.Uint32(source[offset : offset+4]))
+		name := string(source[offset+4 : offset+8])
+		offset += 12 + length
+		if name == "IEND" {
+			return offset
+		}
+	}
+
+	return -1
+}
+
+// gifEnd returns the offset following the trailer block.
+func gifEnd(source []byte) int {
+	end, _, _ := gifScan(source)
+	return end
+}
+
+// gifFrames returns the number of frames of a GIF, -1 if it cannot be parsed.
+func gifFrames(source []byte) int {
+	end, frames, _ := gifScan(source)
+	if end < 0 {
+		return -1
+	}
+
+	return frames
+}
+
+// gifScan walks the blocks of a GIF and returns the offset following
+// the trailer block, the number of image descriptors and the sum of
+// the delays of the graphic control extensions in 100ths of a second.
+func gifScan(source []byte) (int, int, int) {
+	// header and logical screen descriptor
+	offset := 13
+	if len(source) < offset {
+		return -1, 0, 0
+	}
+
+	if source[10]&0x80 != 0 {
+		offset += 3 << (uint(source[10]&0x07) + 1)
+	}
+
+	var frames, delay int
+	for offset < len(source) {
+		switch source[offset] {
+		case 0x21:
+			// graphic control extension: block size, flags and delay
+			if offset+6 <= len(source) && source[offset+1] == 0xf9 && source[offset+2] == 4 {
+				delay += int(binary.LittleEndian.Uint16(source[offset+4 : offset+6]))
+			}
+			// extension: introducer and label followed by sub-blocks
+			offset = gifSkipSubBlocks(source, offset+2)
+		case 0x2c:
+			// image descriptor, optional local color table, LZW code size and sub-blocks
+			if offset+10 > len(source) {
+				return -1, frames, delay
+			}
+			flags := source[offset+9]
+			offset += 10
+			if flags&0x80 != 0 {
+				offset += 3 << (uint(flags&0x07) + 1)
+			}
+			offset = gifSkipSubBlocks(source, offset+1)
+			frames++
+		case 0x3b:
+			return offset + 1, frames, delay
+		default:
+			return -1, frames, delay
+		}
+
+		if offset < 0 {
+			return -1, frames, delay
+		}
+	}
+
+	return -1, frames, delay
+}
+
+func gifSkipSubBlocks(source []byte, offset int) int {
+	for offset < len(source) {
+		size := int(source[offset])
+		offset++
+		if size == 0 {
+			return offset
+		}
+		offset += size
+	}
+
+	return -1
+}
+
+// jpegEnd returns the offset following the EOI marker.
+func jpegEnd(source []byte) int {
+	offset := len(jpegHeader)
+	for offset+4 <= len(source) {
+		if source[offset] != 0xff {
+			return -1
+		}
+
+		marker := source[offset+1]
+		switch {
+		case marker == 0xd9:
+			return offset + 2
+		case marker == 0xff:
+			// fill byte
+			offset++
+			continue
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			offset += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(source[offset+2 : offset+4]))
+		offset += 2 + length
+
+		if marker != 0xda {
+			continue
+		}
+
+		// entropy coded data follows the start of scan segment
+		for offset+1 < len(source) {
+			if source[offset] == 0xff {
+				next := source[offset+1]
+				if next != 0x00 && (next < 0xd0 || next > 0xd7) {
+					break
+				}
+			}
+			offset++
+		}
+	}
+
+	return -1
+}
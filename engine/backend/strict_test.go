@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestTrailingData(t *testing.T) {
+	payload := []byte("<script>alert(1)</script>")
+
+	for _, format := range []imaging.Format{imaging.PNG, imaging.JPEG, imaging.GIF} {
+		source := newImage(t, 20, 20, format)
+		assert.Equal(t, 0, trailingData(source))
+
+		polyglot := append(append([]byte{}, source...), payload...)
+		assert.Equal(t, len(payload), trailingData(polyglot))
+	}
+}
+
+func TestStrictImageGIFPolyglot(t *testing.T) {
+	e := &GoImage{}
+
+	payload := []byte("<html><script>alert(1)</script></html>")
+	source := append(newAnimatedGIF(t, 20, 20, 2), payload...)
+
+	img := &imagefile.ImageFile{Source: source}
+	options := &Options{
+		Format:      imaging.GIF,
+		Width:       40,
+		Height:      40,
+		StrictImage: true,
+	}
+
+	content, err := e.Resize(img, options)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, payload))
+	assert.Equal(t, 0, trailingData(content))
+
+	_, err = gif.DecodeAll(bytes.NewReader(content))
+	assert.Nil(t, err)
+
+	options.RejectTrailingData = true
+
+	_, err = e.Resize(img, options)
+	assert.Equal(t, &failure.DecodeError{Err: ErrTrailingData}, err)
+}
@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	// DefaultTextSize is the default size in points of the text watermarks
+	DefaultTextSize = 24
+	// DefaultTextColor is the default color of the text watermarks
+	DefaultTextColor = "000000"
+)
+
+// TextFont is the font of the text watermarks loaded from a TTF file,
+// the Go regular font is used when Path is empty
+type TextFont struct {
+	Path string
+
+	once sync.Once
+	font *opentype.Font
+	err  error
+}
+
+// defaultTextFont is the font used when the backend has none
+var defaultTextFont = &TextFont{}
+
+func (f *TextFont) load() {
+	content := goregular.TTF
+	if f.Path != "" {
+		content, f.err = ioutil.ReadFile(f.Path)
+		if f.err != nil {
+			return
+		}
+	}
+
+	f.font, f.err = opentype.Parse(content)
+}
+
+// face returns a face of the font with the given size in points,
+// faces are not safe for concurrent use so a new one is returned each time.
+func (f *TextFont) face(size float64) (font.Face, error) {
+	f.once.Do(f.load)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return opentype.NewFace(f.font, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// Text renders the text of options on the image at the watermark gravity.
+func (e *GoImage) Text(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	marked, err := e.text(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(marked, options)
+}
+
+// text draws the text of options on img like a watermark.
+func (e *GoImage) text(img image.Image, options *Options) (image.Image, error) {
+	if options.Text == "" {
+		return nil, fmt.Errorf("Text is empty")
+	}
+
+	textColor := options.TextColor
+	if textColor == "" {
+		textColor = DefaultTextColor
+	}
+
+	rgb, err := Hex2RGB(Hex(textColor))
+	if err != nil {
+		return nil, err
+	}
+
+	size := options.TextSize
+	if size == 0 {
+		size = DefaultTextSize
+	}
+
+	f := e.Font
+	if f == nil {
+		f = defaultTextFont
+	}
+
+	face, err := f.face(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	mark := textMark(face, options.Text, color.NRGBA{rgb.Red, rgb.Green, rgb.Blue, 255}, img.Bounds().Size())
+
+	offsets, err := watermarkOffsets(img.Bounds(), mark.Bounds(), options.WatermarkGravity, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return compositeWatermark(img, mark, offsets, 255, options.WatermarkBlend), nil
+}
+
+// textMark returns an image fitting the line of text drawn with face, the
+// text is decoded as UTF-8 and the glyphs missing from the font are drawn
+// as the fallback glyph of the font. The image is clipped to max, the size
+// of the image the text is drawn on.
+func textMark(face font.Face, text string, c color.Color, max image.Point) image.Image {
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	if width > max.X {
+		width = max.X
+	}
+	if height > max.Y {
+		height = max.Y
+	}
+
+	mark := image.NewNRGBA(image.Rect(0, 0, width, height))
+	drawer := &font.Drawer{
+		Dst:  mark,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	drawer.DrawString(text)
+
+	return mark
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/font/gofont/goregular"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestTextMark(t *testing.T) {
+	face, err := defaultTextFont.face(20)
+	assert.Nil(t, err)
+	defer face.Close()
+
+	// multi-byte characters are measured as a single glyph
+	advance, ok := face.GlyphAdvance('é')
+	assert.True(t, ok)
+
+	mark := textMark(face, "ééé", color.Black, image.Pt(1000, 1000))
+	assert.Equal(t, (3 * advance).Ceil(), mark.Bounds().Dx())
+
+	// the mark is clipped to the image it's drawn on
+	long := textMark(face, strings.Repeat("é", 1000), color.Black, image.Pt(50, 10))
+	assert.Equal(t, image.Rect(0, 0, 50, 10), long.Bounds())
+
+	var opaque int
+	b := mark.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := mark.At(x, y).RGBA(); a > 0 {
+				opaque++
+			}
+		}
+	}
+	assert.True(t, opaque > 0 && opaque < b.Dx()*b.Dy())
+}
+
+func TestText(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 100, 40, imaging.PNG)}
+
+	content, err := e.Text(img, &Options{
+		Format:           imaging.PNG,
+		Text:             "Proof ✓ 日本",
+		TextColor:        "fff",
+		TextSize:         16,
+		WatermarkGravity: WatermarkGravitySouthEast,
+	})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 40), out.Bounds())
+
+	// the text is drawn at the bottom right corner only
+	var white int
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+			if c == (color.NRGBA{255, 255, 255, 255}) {
+				white++
+				assert.True(t, x > 20 && y > 15, "%d %d", x, y)
+			}
+		}
+	}
+	assert.True(t, white > 0)
+
+	_, err = e.Text(img, &Options{Format: imaging.PNG})
+	assert.NotNil(t, err)
+
+	_, err = e.Text(img, &Options{Format: imaging.PNG, Text: "proof", TextColor: "red"})
+	assert.NotNil(t, err)
+}
+
+func TestTextFont(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "font.ttf")
+	assert.Nil(t, os.WriteFile(path, goregular.TTF, 0644))
+
+	e := &GoImage{Font: &TextFont{Path: path}}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	_, err := e.Text(img, &Options{Format: imaging.PNG, Text: "proof"})
+	assert.Nil(t, err)
+
+	e = &GoImage{Font: &TextFont{Path: filepath.Join(t.TempDir(), "missing.ttf")}}
+	_, err = e.Text(img, &Options{Format: imaging.PNG, Text: "proof"})
+	assert.NotNil(t, err)
+}
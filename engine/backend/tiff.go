@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	tiffLittleEndianHeader = []byte("II\x2a\x00")
+	tiffBigEndianHeader    = []byte("MM\x00\x2a")
+)
+
+// ErrInvalidTIFF is an error returned if the IFDs of a TIFF cannot be read
+var ErrInvalidTIFF = errors.New("Invalid TIFF")
+
+func isTIFF(source []byte) bool {
+	return bytes.HasPrefix(source, tiffLittleEndianHeader) || bytes.HasPrefix(source, tiffBigEndianHeader)
+}
+
+func tiffByteOrder(source []byte) binary.ByteOrder {
+	if bytes.HasPrefix(source, tiffBigEndianHeader) {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
+
+// tiffPages returns the offsets of the image file directories of a TIFF,
+// one for each page.
+func tiffPages(source []byte) ([]uint32, error) {
+	if !isTIFF(source) || len(source) < 8 {
+		return nil, ErrInvalidTIFF
+	}
+
+	order := tiffByteOrder(source)
+
+	var (
+		offsets []uint32
+		visited = map[uint32]bool{}
+		offset  = order.Uint32(source[4:8])
+	)
+
+	for offset != 0 {
+		if visited[offset] || int64(offset)+2 > int64(len(source)) {
+			return nil, ErrInvalidTIFF
+		}
+		visited[offset] = true
+		offsets = append(offsets, offset)
+
+		entries := int64(order.Uint16(source[offset:]))
+		next := int64(offset) + 2 + entries*12
+		if next+4 > int64(len(source)) {
+			return nil, ErrInvalidTIFF
+		}
+
+		offset = order.Uint32(source[next:])
+	}
+
+	return offsets, nil
+}
+
+// tiffPage returns a copy of source where the given page, starting from 1,
+// is the first image so it is read by decoders supporting a single page.
+func tiffPage(source []byte, page int) ([]byte, error) {
+	if !isTIFF(source) {
+		if page != 1 {
+			return nil, fmt.Errorf("Page %d is out of range, the image has 1 page", page)
+		}
+
+		return source, nil
+	}
+
+	offsets, err := tiffPages(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 || page > len(offsets) {
+		return nil, fmt.Errorf("Page %d is out of range, the image has %d pages", page, len(offsets))
+	}
+
+	out := make([]byte, len(source))
+	copy(out, source)
+	tiffByteOrder(source).PutUint32(out[4:8], offsets[page-1])
+
+	return out, nil
+}
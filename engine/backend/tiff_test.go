@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+// newMultiPageTIFF returns an uncompressed grayscale TIFF where each page
+// is filled with the given value.
+func newMultiPageTIFF(width int, height int, values []uint8) []byte {
+	sizes := make([]image.Point, len(values))
+	for i := range sizes {
+		sizes[i] = image.Pt(width, height)
+	}
+
+	return newTIFFPages(sizes, values)
+}
+
+// newTIFFPages returns an uncompressed grayscale TIFF where each page has
+// the given size and is filled with the given value.
+func newTIFFPages(sizes []image.Point, values []uint8) []byte {
+	order := binary.LittleEndian
+	buf := &bytes.Buffer{}
+	buf.Write(tiffLittleEndianHeader)
+	binary.Write(buf, order, uint32(8))
+
+	const entries = 8
+	ifdSize := 2 + entries*12 + 4
+
+	for i, value := range values {
+		width, height := sizes[i].X, sizes[i].Y
+		offset := buf.Len()
+		pixels := uint32(offset + ifdSize)
+
+		next := uint32(0)
+		if i < len(values)-1 {
+			next = pixels + uint32(width*height)
+		}
+
+		binary.Write(buf, order, uint16(entries))
+		for _, entry := range [][3]uint32{
+			{256, 4, uint32(width)},          // ImageWidth
+			{257, 4, uint32(height)},         // ImageLength
+			{258, 3, 8},                      // BitsPerSample
+			{259, 3, 1},                      // Compression
+			{262, 3, 1},                      // PhotometricInterpretation
+			{273, 4, pixels},                 // StripOffsets
+			{278, 4, uint32(height)},         // RowsPerStrip
+			{279, 4, uint32(width * height)}, // StripByteCounts
+		} {
+			binary.Write(buf, order, uint16(entry[0]))
+			binary.Write(buf, order, uint16(entry[1]))
+			binary.Write(buf, order, uint32(1))
+			if entry[1] == 3 {
+				binary.Write(buf, order, uint16(entry[2]))
+				binary.Write(buf, order, uint16(0))
+			} else {
+				binary.Write(buf, order, entry[2])
+			}
+		}
+		binary.Write(buf, order, next)
+
+		buf.Write(bytes.Repeat([]byte{value}, width*height))
+	}
+
+	return buf.Bytes()
+}
+
+func TestTIFFPage(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newMultiPageTIFF(4, 3, []uint8{10, 20, 30})}
+
+	offsets, err := tiffPages(img.Source)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(offsets))
+
+	out, err := e.source(img, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(10), out.(*image.Gray).GrayAt(0, 0).Y)
+
+	out, err = e.source(img, &Options{Page: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 3), out.Bounds())
+	assert.Equal(t, uint8(20), out.(*image.Gray).GrayAt(1, 1).Y)
+
+	_, err = e.source(img, &Options{Page: 4})
+	assert.EqualError(t, err, "Page 4 is out of range, the image has 3 pages")
+
+	_, err = e.source(&imagefile.ImageFile{Source: newImage(t, 4, 4, imaging.PNG)}, &Options{Page: 2})
+	assert.EqualError(t, err, "Page 2 is out of range, the image has 1 page")
+}
+
+func TestTIFFPageMaxPixels(t *testing.T) {
+	e := &GoImage{MaxPixels: 100}
+	img := &imagefile.ImageFile{Source: newTIFFPages([]image.Point{{4, 3}, {20, 10}}, []uint8{10, 20})}
+
+	_, err := e.source(img, &Options{Page: 1})
+	assert.Nil(t, err)
+
+	// the limit applies to the decoded page, not only to the first one
+	_, err = e.source(img, &Options{Page: 2})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+}
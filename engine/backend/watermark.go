@@ -0,0 +1,381 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/thoas/picfit/constants"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+const (
+	// DefaultWatermarkTimeout is the default timeout to fetch a watermark
+	DefaultWatermarkTimeout = 5 * time.Second
+	// DefaultWatermarkMaxSize is the default maximum size in bytes of a watermark
+	DefaultWatermarkMaxSize = 5 << 20
+)
+
+const (
+	WatermarkBlendMultiply = "multiply"
+	WatermarkBlendScreen   = "screen"
+	WatermarkBlendOverlay  = "overlay"
+)
+
+// WatermarkBlends are the available blend modes of watermarks,
+// watermarks are drawn over the image by default
+var WatermarkBlends = []string{
+	WatermarkBlendMultiply,
+	WatermarkBlendScreen,
+	WatermarkBlendOverlay,
+}
+
+// watermarkBlendFuncs blend the channels of the image and of the watermark in range [0, 1]
+var watermarkBlendFuncs = map[string]func(base float64, mark float64) float64{
+	WatermarkBlendMultiply: func(base float64, mark float64) float64 {
+		return base * mark
+	},
+	WatermarkBlendScreen: func(base float64, mark float64) float64 {
+		return 1 - (1-base)*(1-mark)
+	},
+	WatermarkBlendOverlay: func(base float64, mark float64) float64 {
+		if base < 0.5 {
+			return 2 * base * mark
+		}
+		return 1 - 2*(1-base)*(1-mark)
+	},
+}
+
+const (
+	WatermarkGravityCenter    = "center"
+	WatermarkGravityNorth     = "n"
+	WatermarkGravityNorthEast = "ne"
+	WatermarkGravityEast      = "e"
+	WatermarkGravitySouthEast = "se"
+	WatermarkGravitySouth     = "s"
+	WatermarkGravitySouthWest = "sw"
+	WatermarkGravityWest      = "w"
+	WatermarkGravityNorthWest = "nw"
+)
+
+// DefaultWatermarkOpacity is the default opacity of the watermark in range [0, 255]
+const DefaultWatermarkOpacity = 64
+
+// DefaultWatermarkThreshold is the default relative luminance of the dominant
+// color above which the colored watermark is used, an image brighter than it
+// contrasts more with black than with white
+const DefaultWatermarkThreshold = 0.179
+
+// WatermarkGravities are the available positions of the watermark, the
+// stick positions are aliases of the corner gravities
+var WatermarkGravities = append([]string{
+	WatermarkGravityCenter,
+	WatermarkGravityNorth,
+	WatermarkGravityNorthEast,
+	WatermarkGravityEast,
+	WatermarkGravitySouthEast,
+	WatermarkGravitySouth,
+	WatermarkGravitySouthWest,
+	WatermarkGravityWest,
+	WatermarkGravityNorthWest,
+}, constants.StickPositions...)
+
+// watermarkAnchors are the horizontal and vertical anchors of the gravities,
+// -1 sticks to the left or top edge, 0 centers and 1 sticks to the right or bottom edge
+var watermarkAnchors = map[string]image.Point{
+	"":                        {0, 0},
+	WatermarkGravityCenter:    {0, 0},
+	WatermarkGravityNorth:     {0, -1},
+	WatermarkGravityNorthEast: {1, -1},
+	WatermarkGravityEast:      {1, 0},
+	WatermarkGravitySouthEast: {1, 1},
+	WatermarkGravitySouth:     {0, 1},
+	WatermarkGravitySouthWest: {-1, 1},
+	WatermarkGravityWest:      {-1, 0},
+	WatermarkGravityNorthWest: {-1, -1},
+	constants.TopLeft:         {-1, -1},
+	constants.TopRight:        {1, -1},
+	constants.BottomLeft:      {-1, 1},
+	constants.BottomRight:     {1, 1},
+}
+
+// Watermark is drawn on the JPEG outputs when set in options
+type Watermark struct {
+	// Path is the path of the watermark, ColoredPath is the optional
+	// path of the watermark used on bright images
+	Path        string
+	ColoredPath string
+	// Content is the encoded watermark, it takes precedence over the paths
+	Content []byte
+	Opacity uint8
+	Gravity string
+	// Threshold is the relative luminance in range [0, 1] of the dominant
+	// color above which the image is bright
+	Threshold float64
+
+	once    sync.Once
+	plain   image.Image
+	colored image.Image
+	err     error
+}
+
+func (w *Watermark) load() {
+	if len(w.Content) > 0 {
+		w.plain, w.err = imaging.Decode(bytes.NewReader(w.Content))
+		return
+	}
+
+	w.plain, w.err = imaging.Open(w.Path)
+	if w.err != nil || w.ColoredPath == "" {
+		return
+	}
+
+	w.colored, w.err = imaging.Open(w.ColoredPath)
+}
+
+// mark returns the watermark, the colored one on bright images if any.
+func (w *Watermark) mark(bright bool) (image.Image, error) {
+	w.once.Do(w.load)
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	if bright && w.colored != nil {
+		return w.colored, nil
+	}
+
+	return w.plain, nil
+}
+
+// watermarkOffset returns the position of the mark on base depending on the
+// gravity, the margin is kept between the mark and the edges it sticks to.
+func watermarkOffset(base image.Rectangle, mark image.Rectangle, gravity string, margin image.Point) (image.Point, error) {
+	anchor, ok := watermarkAnchors[gravity]
+	if !ok {
+		return image.Point{}, fmt.Errorf("Invalid watermark gravity %s, available values are: %v", gravity, WatermarkGravities)
+	}
+
+	return image.Pt(
+		watermarkPosition(base.Dx(), mark.Dx(), anchor.X, margin.X),
+		watermarkPosition(base.Dy(), mark.Dy(), anchor.Y, margin.Y),
+	), nil
+}
+
+// maxWatermarkTiles is the maximum number of marks drawn by a tiled watermark
+const maxWatermarkTiles = 4096
+
+// watermarkTiles returns the positions of the mark repeated across base,
+// the marks are separated by spacing and the last ones are clipped by base.
+// An error is returned when more than maxWatermarkTiles marks are needed.
+func watermarkTiles(base image.Rectangle, mark image.Rectangle, spacing int) ([]image.Point, error) {
+	if mark.Empty() {
+		return nil, nil
+	}
+
+	stepX, stepY := mark.Dx()+spacing, mark.Dy()+spacing
+	columns := (int64(base.Dx()) + int64(stepX) - 1) / int64(stepX)
+	rows := (int64(base.Dy()) + int64(stepY) - 1) / int64(stepY)
+	if columns*rows > maxWatermarkTiles {
+		return nil, fmt.Errorf("Watermark %dx%d needs %d tiles, the maximum is %d",
+			mark.Dx(), mark.Dy(), columns*rows, maxWatermarkTiles)
+	}
+
+	offsets := make([]image.Point, 0, columns*rows)
+	for y := 0; y < base.Dy(); y += stepY {
+		for x := 0; x < base.Dx(); x += stepX {
+			offsets = append(offsets, image.Pt(x, y))
+		}
+	}
+
+	return offsets, nil
+}
+
+// watermarkOffsets returns the positions of the mark on base, repeated when
+// options tile the watermark or at the gravity otherwise.
+func watermarkOffsets(base image.Rectangle, mark image.Rectangle, gravity string, options *Options) ([]image.Point, error) {
+	if options.WatermarkTile {
+		return watermarkTiles(base, mark, options.WatermarkSpacing)
+	}
+
+	offset, err := watermarkOffset(base, mark, gravity, options.WatermarkMargin)
+	if err != nil {
+		return nil, err
+	}
+
+	return []image.Point{offset}, nil
+}
+
+// watermarkPosition returns the position of the mark of the given size on an axis of the base.
+func watermarkPosition(base int, mark int, anchor int, margin int) int {
+	switch anchor {
+	case -1:
+		return margin
+	case 1:
+		return base - mark - margin
+	}
+	return (base - mark) / 2
+}
+
+// ErrWatermarkNotAllowed is an error returned if the watermark host is not in the allowlist
+var ErrWatermarkNotAllowed = errors.New("Watermark URL is not allowed")
+
+// ErrWatermarkTooLarge is an error returned if the watermark exceeds the maximum size
+var ErrWatermarkTooLarge = errors.New("Watermark is too large")
+
+// maxWatermarkRedirects is the maximum number of redirects followed to fetch a watermark
+const maxWatermarkRedirects = 10
+
+// WatermarkFetcher retrieves watermarks from remote URLs and caches them by
+// URL in a LRU cache.
+type WatermarkFetcher struct {
+	allowedHosts []string
+	maxSize      int64
+	maxPixels    int
+	client       *http.Client
+	marks        *DecodeCache
+}
+
+// NewWatermarkFetcher returns a WatermarkFetcher fetching only from the
+// allowed hosts, the redirects are followed to the allowed hosts only.
+// The watermarks with more pixels than maxPixels are rejected, a non
+// positive maximum disables the check.
+func NewWatermarkFetcher(allowedHosts []string, timeout time.Duration, maxSize int64, maxPixels int) *WatermarkFetcher {
+	if timeout <= 0 {
+		timeout = DefaultWatermarkTimeout
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultWatermarkMaxSize
+	}
+
+	f := &WatermarkFetcher{
+		allowedHosts: allowedHosts,
+		maxSize:      maxSize,
+		maxPixels:    maxPixels,
+		marks:        NewDecodeCache(0, 0),
+	}
+
+	f.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWatermarkRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWatermarkRedirects)
+			}
+			if !f.allowed(req.URL) {
+				return ErrWatermarkNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return f
+}
+
+func (f *WatermarkFetcher) allowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	for i := range f.allowedHosts {
+		if f.allowedHosts[i] == u.Host || f.allowedHosts[i] == u.Hostname() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetch returns the watermark located at rawURL
+func (f *WatermarkFetcher) Fetch(rawURL string) (image.Image, error) {
+	mark, ok := f.marks.Get(rawURL)
+	if ok {
+		return mark, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.allowed(u) {
+		return nil, ErrWatermarkNotAllowed
+	}
+
+	resp, err := f.client.Get(u.String())
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok && uerr.Err == ErrWatermarkNotAllowed {
+			return nil, ErrWatermarkNotAllowed
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s [status: %d]", u.String(), resp.StatusCode)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > f.maxSize {
+		return nil, ErrWatermarkTooLarge
+	}
+
+	if err := checkPixels(content, f.maxPixels); err != nil {
+		return nil, err
+	}
+
+	mark, err = imaging.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode watermark: %s", u.String())
+	}
+
+	f.marks.Add(rawURL, mark)
+
+	return mark, nil
+}
+
+// Watermark overlays the watermark fetched from the watermark URL on the image.
+func (e *GoImage) Watermark(img *imagefile.ImageFile, options *Options) ([]byte, error) {
+	image, err := e.source(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	marked, err := e.watermark(image, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toBytes(marked, options)
+}
+
+// watermark overlays the watermark of options fetched from its URL on img at their gravity.
+func (e *GoImage) watermark(img image.Image, options *Options) (image.Image, error) {
+	if e.Watermarks == nil {
+		return nil, ErrWatermarkNotAllowed
+	}
+
+	mark, err := e.Watermarks.Fetch(options.WatermarkURL)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := watermarkOffsets(img.Bounds(), mark.Bounds(), options.WatermarkGravity, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return compositeWatermark(img, mark, offsets, watermarkOpacity, options.WatermarkBlend), nil
+}
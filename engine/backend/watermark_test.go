@@ -0,0 +1,349 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/thoas/picfit/failure"
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func TestWatermarkURL(t *testing.T) {
+	var hits int
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(10, 10, colorRed), imaging.PNG)
+	assert.Nil(t, err)
+	mark := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write(mark)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+
+	e := &GoImage{Watermarks: NewWatermarkFetcher([]string{u.Hostname()}, 0, 0, 0)}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	for i := 0; i < 2; i++ {
+		content, err := e.Watermark(img, &Options{Format: imaging.PNG, WatermarkURL: server.URL + "/mark.png"})
+		assert.Nil(t, err)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, 40, out.Bounds().Dx())
+		assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(0, 0)))
+		assert.NotEqual(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(20, 20)))
+	}
+	assert.Equal(t, 1, hits)
+
+	e = &GoImage{Watermarks: NewWatermarkFetcher([]string{"example.com"}, 0, 0, 0)}
+	_, err = e.Watermark(img, &Options{Format: imaging.PNG, WatermarkURL: server.URL + "/mark.png"})
+	assert.Equal(t, ErrWatermarkNotAllowed, err)
+
+	e = &GoImage{Watermarks: NewWatermarkFetcher([]string{u.Hostname()}, 0, 10, 0)}
+	_, err = e.Watermark(img, &Options{Format: imaging.PNG, WatermarkURL: server.URL + "/mark.png"})
+	assert.Equal(t, ErrWatermarkTooLarge, err)
+
+	e = &GoImage{}
+	_, err = e.Watermark(img, &Options{Format: imaging.PNG, WatermarkURL: server.URL + "/mark.png"})
+	assert.Equal(t, ErrWatermarkNotAllowed, err)
+
+	e = &GoImage{Watermarks: NewWatermarkFetcher([]string{u.Hostname()}, 0, 0, 99)}
+	_, err = e.Watermark(img, &Options{Format: imaging.PNG, WatermarkURL: server.URL + "/mark.png"})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+}
+
+func TestWatermarkURLRedirect(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(10, 10, colorRed), imaging.PNG)
+	assert.Nil(t, err)
+
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer internal.Close()
+
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal.png" {
+			http.Redirect(w, r, internal.URL+"/mark.png", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/internal.png", http.StatusFound)
+	}))
+	defer allowed.Close()
+
+	u, err := url.Parse(allowed.URL)
+	assert.Nil(t, err)
+
+	// the hosts are allowed with their port so the servers are told apart
+	f := NewWatermarkFetcher([]string{u.Host}, 0, 0, 0)
+
+	_, err = f.Fetch(allowed.URL + "/mark.png")
+	assert.Equal(t, ErrWatermarkNotAllowed, err)
+}
+
+func TestWatermarkBlend(t *testing.T) {
+	base := imaging.New(40, 40, color.NRGBA{200, 100, 50, 255})
+	white := imaging.New(20, 20, color.White)
+	black := imaging.New(20, 20, color.Black)
+	center := image.Pt(10, 10)
+
+	// multiplying by white is a no-op
+	out := imaging.Clone(compositeWatermark(base, white, []image.Point{center}, watermarkOpacity, WatermarkBlendMultiply))
+	assert.Equal(t, base.Pix, out.Pix)
+
+	// multiplying by black darkens the center only
+	out = imaging.Clone(compositeWatermark(base, black, []image.Point{center}, watermarkOpacity, WatermarkBlendMultiply))
+	pixel := out.NRGBAAt(20, 20)
+	assert.True(t, pixel.R < 200 && pixel.G < 100 && pixel.B < 50)
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, out.NRGBAAt(5, 5))
+
+	// screening with black is a no-op and with white lightens
+	out = imaging.Clone(compositeWatermark(base, black, []image.Point{center}, watermarkOpacity, WatermarkBlendScreen))
+	assert.Equal(t, base.Pix, out.Pix)
+
+	out = imaging.Clone(compositeWatermark(base, white, []image.Point{center}, watermarkOpacity, WatermarkBlendScreen))
+	pixel = out.NRGBAAt(20, 20)
+	assert.True(t, pixel.R > 200 && pixel.G > 100 && pixel.B > 50)
+
+	// overlay keeps the contrast of the image
+	out = imaging.Clone(compositeWatermark(base, white, []image.Point{center}, watermarkOpacity, WatermarkBlendOverlay))
+	pixel = out.NRGBAAt(20, 20)
+	assert.True(t, pixel.R > 200 && pixel.B > 50)
+}
+
+func TestWatermarkJPEG(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	// JPEG outputs are encoded untouched without a watermark
+	content, err := e.Resize(img, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Quality: 100})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	center := color.NRGBAModel.Convert(out.At(10, 10)).(color.NRGBA)
+	assert.InDelta(t, 200, int(center.R), 4)
+	assert.InDelta(t, 100, int(center.G), 4)
+
+	buf := &bytes.Buffer{}
+	err = imaging.Encode(buf, imaging.New(8, 8, color.Black), imaging.PNG)
+	assert.Nil(t, err)
+
+	watermark := &Watermark{Content: buf.Bytes(), Opacity: 255, Gravity: "bottom-right"}
+	content, err = e.Resize(img, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Quality: 100, Watermark: watermark})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	corner := color.NRGBAModel.Convert(out.At(17, 17)).(color.NRGBA)
+	assert.True(t, corner.R < 20 && corner.G < 20)
+	origin := color.NRGBAModel.Convert(out.At(2, 2)).(color.NRGBA)
+	assert.InDelta(t, 200, int(origin.R), 4)
+
+	// PNG outputs are not watermarked
+	content, err = e.Resize(img, &Options{Format: imaging.PNG, Width: 20, Height: 20, Watermark: watermark})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(17, 17)))
+
+	watermark = &Watermark{Content: buf.Bytes(), Gravity: "middle"}
+	_, err = e.Resize(img, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Watermark: watermark})
+	assert.NotNil(t, err)
+}
+
+func TestWatermarkLogging(t *testing.T) {
+	logs := &bytes.Buffer{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewDevelopmentEncoderConfig()), zapcore.AddSync(logs), zapcore.DebugLevel)
+	e := &GoImage{Logger: zap.New(core)}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(8, 8, color.Black), imaging.PNG)
+	assert.Nil(t, err)
+
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+	options := &Options{Format: imaging.JPEG, Width: 20, Height: 20, Watermark: &Watermark{Content: buf.Bytes()}}
+
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = w
+	_, err = e.Resize(img, options)
+	os.Stdout = stdout
+	w.Close()
+	assert.Nil(t, err)
+
+	// the encoding doesn't write to stdout
+	out := &bytes.Buffer{}
+	out.ReadFrom(r)
+	assert.Equal(t, 0, out.Len())
+
+	assert.Contains(t, logs.String(), `"luminance":`)
+}
+
+func TestWatermarkThreshold(t *testing.T) {
+	e := &GoImage{}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watermark.png")
+	coloredPath := filepath.Join(dir, "watermark_colored.png")
+	assert.Nil(t, imaging.Save(imaging.New(8, 8, color.White), path))
+	assert.Nil(t, imaging.Save(imaging.New(8, 8, color.Black), coloredPath))
+
+	for _, tc := range []struct {
+		background color.NRGBA
+		threshold  float64
+		expected   uint8
+	}{
+		// the dark watermark is drawn on bright images
+		{color.NRGBA{230, 230, 230, 255}, DefaultWatermarkThreshold, 0},
+		{color.NRGBA{255, 255, 0, 255}, DefaultWatermarkThreshold, 0},
+		// the light watermark is drawn on dark images
+		{color.NRGBA{20, 20, 20, 255}, DefaultWatermarkThreshold, 255},
+		{color.NRGBA{0, 0, 160, 255}, DefaultWatermarkThreshold, 255},
+		{color.NRGBA{230, 230, 230, 255}, 0.9, 255},
+	} {
+		buf := &bytes.Buffer{}
+		err := imaging.Encode(buf, imaging.New(40, 40, tc.background), imaging.PNG)
+		assert.Nil(t, err)
+
+		watermark := &Watermark{Path: path, ColoredPath: coloredPath, Opacity: 255, Threshold: tc.threshold}
+		content, err := e.Resize(&imagefile.ImageFile{Source: buf.Bytes()}, &Options{Format: imaging.JPEG, Width: 20, Height: 20, Quality: 100, Watermark: watermark})
+		assert.Nil(t, err)
+
+		out, err := imaging.Decode(bytes.NewReader(content))
+		assert.Nil(t, err)
+		center := color.NRGBAModel.Convert(out.At(10, 10)).(color.NRGBA)
+		assert.InDelta(t, int(tc.expected), int(center.G), 4, "%v", tc.background)
+	}
+}
+
+func TestWatermarkOffset(t *testing.T) {
+	base := image.Rect(0, 0, 100, 60)
+	mark := image.Rect(0, 0, 20, 10)
+	margin := image.Pt(5, 3)
+
+	for gravity, expected := range map[string]image.Point{
+		WatermarkGravityCenter:    {40, 25},
+		WatermarkGravityNorth:     {40, 3},
+		WatermarkGravityNorthEast: {75, 3},
+		WatermarkGravityEast:      {75, 25},
+		WatermarkGravitySouthEast: {75, 47},
+		WatermarkGravitySouth:     {40, 47},
+		WatermarkGravitySouthWest: {5, 47},
+		WatermarkGravityWest:      {5, 25},
+		WatermarkGravityNorthWest: {5, 3},
+		"bottom-right":            {75, 47},
+		"":                        {40, 25},
+	} {
+		offset, err := watermarkOffset(base, mark, gravity, margin)
+		assert.Nil(t, err, gravity)
+		assert.Equal(t, expected, offset, gravity)
+	}
+
+	_, err := watermarkOffset(base, mark, "middle", margin)
+	assert.NotNil(t, err)
+}
+
+func TestWatermarkGravity(t *testing.T) {
+	e := &GoImage{}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(8, 8, color.Black), imaging.PNG)
+	assert.Nil(t, err)
+
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	// the gravity of the options takes precedence over the configured one
+	watermark := &Watermark{Content: buf.Bytes(), Opacity: 255, Gravity: "top-left"}
+	content, err := e.Resize(img, &Options{
+		Format:           imaging.JPEG,
+		Width:            40,
+		Height:           40,
+		Quality:          100,
+		Watermark:        watermark,
+		WatermarkGravity: WatermarkGravitySouthEast,
+		WatermarkMargin:  image.Pt(4, 4),
+	})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.True(t, color.GrayModel.Convert(out.At(31, 31)).(color.Gray).Y < 20)
+	assert.True(t, color.GrayModel.Convert(out.At(37, 37)).(color.Gray).Y > 80)
+	assert.True(t, color.GrayModel.Convert(out.At(2, 2)).(color.Gray).Y > 80)
+}
+
+func TestWatermarkTiles(t *testing.T) {
+	// 100 / (20 + 5) and 60 / (10 + 5) marks fit exactly
+	offsets, err := watermarkTiles(image.Rect(0, 0, 100, 60), image.Rect(0, 0, 20, 10), 5)
+	assert.Nil(t, err)
+	assert.Len(t, offsets, 4*4)
+	assert.Equal(t, image.Pt(0, 0), offsets[0])
+	assert.Equal(t, image.Pt(75, 45), offsets[len(offsets)-1])
+
+	// the last marks are clipped
+	offsets, err = watermarkTiles(image.Rect(0, 0, 100, 60), image.Rect(0, 0, 30, 25), 0)
+	assert.Nil(t, err)
+	assert.Len(t, offsets, 4*3)
+	for _, offset := range offsets {
+		assert.True(t, offset.In(image.Rect(0, 0, 100, 60)))
+	}
+
+	offsets, err = watermarkTiles(image.Rect(0, 0, 10, 10), image.Rect(0, 0, 20, 20), 0)
+	assert.Nil(t, err)
+	assert.Len(t, offsets, 1)
+
+	offsets, err = watermarkTiles(image.Rect(0, 0, 10, 10), image.Rectangle{}, 0)
+	assert.Nil(t, err)
+	assert.Len(t, offsets, 0)
+
+	// a tiny mark on a large image would be drawn once per pixel
+	_, err = watermarkTiles(image.Rect(0, 0, 4000, 3000), image.Rect(0, 0, 1, 1), 0)
+	assert.NotNil(t, err)
+
+	offsets, err = watermarkTiles(image.Rect(0, 0, 4000, 3000), image.Rect(0, 0, 1, 1), 62)
+	assert.Nil(t, err)
+	assert.Len(t, offsets, 64*48)
+}
+
+func TestWatermarkTile(t *testing.T) {
+	e := &GoImage{}
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(8, 8, color.Black), imaging.PNG)
+	assert.Nil(t, err)
+
+	watermark := &Watermark{Content: buf.Bytes(), Opacity: 128}
+	base := imaging.New(40, 40, color.NRGBA{200, 100, 50, 255})
+	marked, err := e.createWatermark(base, watermark, &Options{WatermarkTile: true, WatermarkSpacing: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, base.Bounds(), marked.Bounds())
+
+	// marks are drawn with the opacity every 12 pixels
+	for _, p := range []image.Point{{2, 2}, {14, 2}, {38, 38}, {26, 14}} {
+		c := color.NRGBAModel.Convert(marked.At(p.X, p.Y)).(color.NRGBA)
+		assert.InDelta(t, 100, int(c.R), 1, "%v", p)
+	}
+	for _, p := range []image.Point{{9, 9}, {10, 2}, {2, 34}} {
+		assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(marked.At(p.X, p.Y)), "%v", p)
+	}
+}
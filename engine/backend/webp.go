@@ -0,0 +1,620 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// WebP is the WebP format, it's not supported by imaging
+const WebP imaging.Format = -1
+
+const (
+	// webpMaxSize is the maximum width and height of a lossless WebP
+	webpMaxSize = 1 << 14
+	// webpMaxLength is the maximum length of a backward reference
+	webpMaxLength = 4096
+	// webpPredictorBits is the log-2 size of the tiles of the predictor transform,
+	// a single predictor is used for the whole image
+	webpPredictorBits = 9
+	// webpPredictorAverage is the predictor averaging the left and top pixels
+	webpPredictorAverage = 7
+	// webpLeftDistance is the distance code of the pixel on the left
+	webpLeftDistance = 2
+)
+
+// webpCodeLengthOrder is the order of the code lengths of the code length code
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeWebP writes img to w as a WebP using the lossless bitstream, a quality
+// lower than 100 quantizes the colors before encoding so the output is smaller
+// but close to lossless, it's the only lossy encoding supported.
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	data, _, err := webpBitstream(img, quality)
+	if err != nil {
+		return err
+	}
+
+	body := &bytes.Buffer{}
+	writeWebPChunk(body, "VP8L", data)
+
+	return writeWebP(w, body.Bytes())
+}
+
+// encodeAnimatedWebP writes frames to w as an animated WebP, the frames must
+// have the same bounds since each one replaces the previous one. Delays are
+// in milliseconds and a zero loop count loops forever.
+func encodeAnimatedWebP(w io.Writer, frames []image.Image, delays []int, loopCount int, quality int) error {
+	b := frames[0].Bounds()
+
+	alpha := false
+	animation := &bytes.Buffer{}
+	for i, frame := range frames {
+		data, frameAlpha, err := webpBitstream(frame, quality)
+		if err != nil {
+			return err
+		}
+		alpha = alpha || frameAlpha
+
+		// the frame is at the origin of the canvas and isn't blended
+		anmf := make([]byte, 16)
+		putUint24(anmf[6:], b.Dx()-1)
+		putUint24(anmf[9:], b.Dy()-1)
+		putUint24(anmf[12:], delays[i])
+		anmf[15] = 0x02
+
+		chunk := bytes.NewBuffer(anmf)
+		writeWebPChunk(chunk, "VP8L", data)
+		writeWebPChunk(animation, "ANMF", chunk.Bytes())
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02
+	if alpha {
+		vp8x[0] |= 0x10
+	}
+	putUint24(vp8x[4:], b.Dx()-1)
+	putUint24(vp8x[7:], b.Dy()-1)
+
+	// the background color is transparent
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loopCount))
+
+	body := &bytes.Buffer{}
+	writeWebPChunk(body, "VP8X", vp8x)
+	writeWebPChunk(body, "ANIM", anim)
+	body.Write(animation.Bytes())
+
+	return writeWebP(w, body.Bytes())
+}
+
+// writeWebP writes the chunks of body to w in a RIFF container.
+func writeWebP(w io.Writer, body []byte) error {
+	header := make([]byte, 12)
+	copy(header[0:], riffHeader)
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+len(body)))
+	copy(header[8:], webpHeader)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+
+	return err
+}
+
+// writeWebPChunk writes a chunk to buf, chunks are padded to an even size.
+func writeWebPChunk(buf *bytes.Buffer, id string, data []byte) {
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(data)))
+
+	buf.WriteString(id)
+	buf.Write(size)
+	buf.Write(data)
+	if len(data)&1 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// webpBitstream returns the lossless bitstream of img and whether it has
+// transparent pixels.
+func webpBitstream(img image.Image, quality int) ([]byte, bool, error) {
+	src := imaging.Clone(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return nil, false, fmt.Errorf("Invalid WebP dimensions %dx%d, they should be between 1 and %d", width, height, webpMaxSize)
+	}
+
+	pix := src.Pix
+	alpha := false
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0xff {
+			alpha = true
+			break
+		}
+	}
+
+	if quality > 0 && quality < 100 {
+		quantize(pix, uint((100-quality)/20+1))
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// subtract green transform
+	bw.write(1, 1)
+	bw.write(2, 2)
+	subtractGreen(pix)
+
+	// predictor transform, its modes are stored in the green channel of a sub-image
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(webpPredictorBits-2, 3)
+	tiles := func(size int) int {
+		return (size + 1<<webpPredictorBits - 1) >> webpPredictorBits
+	}
+	modes := make([]uint8, 4*tiles(width)*tiles(height))
+	for i := 1; i < len(modes); i += 4 {
+		modes[i] = webpPredictorAverage
+	}
+	writeWebPImage(bw, modes, false)
+	pix = predict(pix, width, height)
+
+	bw.write(0, 1)
+	writeWebPImage(bw, pix, true)
+
+	return bw.bytes(), alpha, nil
+}
+
+// quantize rounds the color channels of pix to a multiple of 1<<shift,
+// alpha is kept as is.
+func quantize(pix []uint8, shift uint) {
+	half := 1 << shift >> 1
+	for i := range pix {
+		if i&3 == 3 {
+			continue
+		}
+
+		v := (int(pix[i]) + half) >> shift << shift
+		if v > 0xff {
+			v = 0xff
+		}
+		pix[i] = uint8(v)
+	}
+}
+
+func subtractGreen(pix []uint8) {
+	for i := 0; i < len(pix); i += 4 {
+		pix[i] -= pix[i+1]
+		pix[i+2] -= pix[i+1]
+	}
+}
+
+// predict returns the residuals of pix predicted by the average of the left and
+// top pixels, the first row is predicted by the left pixel and the first column
+// by the top one as the decoder does.
+func predict(pix []uint8, width int, height int) []uint8 {
+	out := make([]uint8, len(pix))
+	stride := 4 * width
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := y*stride + 4*x
+			for c := 0; c < 4; c++ {
+				var pred uint8
+				switch {
+				case x == 0 && y == 0:
+					if c == 3 {
+						pred = 0xff
+					}
+				case y == 0:
+					pred = pix[p-4+c]
+				case x == 0:
+					pred = pix[p-stride+c]
+				default:
+					pred = uint8((int(pix[p-4+c]) + int(pix[p-stride+c])) / 2)
+				}
+				out[p+c] = pix[p+c] - pred
+			}
+		}
+	}
+
+	return out
+}
+
+// webpToken is a literal pixel or a backward reference to the pixel on the left
+// repeated length times.
+type webpToken struct {
+	pixel  []uint8
+	length int
+}
+
+// writeWebPImage writes the entropy coded RGBA pixels, runs of identical pixels
+// are written as backward references.
+func writeWebPImage(bw *bitWriter, pix []uint8, topLevel bool) {
+	// no color cache
+	bw.write(0, 1)
+	if topLevel {
+		// no meta prefix codes
+		bw.write(0, 1)
+	}
+
+	var tokens []webpToken
+	for i := 0; i < len(pix); {
+		run := 0
+		if i > 0 {
+			for j := i; j < len(pix) && run < webpMaxLength && samePixel(pix, j, j-4); j += 4 {
+				run++
+			}
+		}
+
+		if run >= 3 {
+			tokens = append(tokens, webpToken{length: run})
+			i += 4 * run
+			continue
+		}
+
+		tokens = append(tokens, webpToken{pixel: pix[i : i+4]})
+		i += 4
+	}
+
+	var (
+		green    = make([]int, 256+24)
+		red      = make([]int, 256)
+		blue     = make([]int, 256)
+		alpha    = make([]int, 256)
+		distance = make([]int, 40)
+	)
+
+	distancePrefix, _, _ := prefixEncode(webpLeftDistance)
+	for _, token := range tokens {
+		if token.length > 0 {
+			prefix, _, _ := prefixEncode(token.length)
+			green[256+prefix]++
+			distance[distancePrefix]++
+			continue
+		}
+
+		red[token.pixel[0]]++
+		green[token.pixel[1]]++
+		blue[token.pixel[2]]++
+		alpha[token.pixel[3]]++
+	}
+
+	codes := []*prefixCode{
+		writePrefixCode(bw, green),
+		writePrefixCode(bw, red),
+		writePrefixCode(bw, blue),
+		writePrefixCode(bw, alpha),
+		writePrefixCode(bw, distance),
+	}
+
+	for _, token := range tokens {
+		if token.length > 0 {
+			prefix, n, extra := prefixEncode(token.length)
+			codes[0].write(bw, 256+prefix)
+			bw.write(uint32(extra), n)
+
+			prefix, n, extra = prefixEncode(webpLeftDistance)
+			codes[4].write(bw, prefix)
+			bw.write(uint32(extra), n)
+			continue
+		}
+
+		codes[0].write(bw, int(token.pixel[1]))
+		codes[1].write(bw, int(token.pixel[0]))
+		codes[2].write(bw, int(token.pixel[2]))
+		codes[3].write(bw, int(token.pixel[3]))
+	}
+}
+
+func samePixel(pix []uint8, i int, j int) bool {
+	return pix[i] == pix[j] && pix[i+1] == pix[j+1] && pix[i+2] == pix[j+2] && pix[i+3] == pix[j+3]
+}
+
+// prefixEncode returns the prefix symbol, the number of extra bits and the
+// extra bits of a backward reference length or distance code.
+func prefixEncode(value int) (int, uint, int) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+
+	high := bits.Len(uint(v)) - 1
+	second := v >> uint(high-1) & 1
+	n := uint(high - 1)
+
+	return 2*high + second, n, v & (1<<n - 1)
+}
+
+// prefixCode is a canonical Huffman code
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+	// single is true when the code has a single symbol written with 0 bits
+	single bool
+}
+
+func (c *prefixCode) write(bw *bitWriter, symbol int) {
+	if c.single {
+		return
+	}
+
+	// codes are read from their most significant bit
+	bw.write(reverse(c.codes[symbol], c.lengths[symbol]), uint(c.lengths[symbol]))
+}
+
+func reverse(code uint32, length int) uint32 {
+	return bits.Reverse32(code) >> uint(32-length)
+}
+
+// writePrefixCode writes the prefix code of the symbol frequencies and returns it.
+func writePrefixCode(bw *bitWriter, freqs []int) *prefixCode {
+	var symbols []int
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	// simple codes describe up to two symbols lower than 256
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+	if len(symbols) <= 2 && symbols[len(symbols)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+
+		code := &prefixCode{lengths: make([]int, len(freqs)), codes: make([]uint32, len(freqs)), single: len(symbols) == 1}
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+			code.lengths[symbols[0]], code.lengths[symbols[1]] = 1, 1
+			code.codes[symbols[1]] = 1
+		}
+
+		return code
+	}
+
+	bw.write(0, 1)
+	code := newPrefixCode(freqs, 15)
+
+	// the code lengths are written with a code, zeros are run length encoded
+	type token struct {
+		symbol int
+		extra  uint32
+	}
+
+	var tokens []token
+	for i := 0; i < len(code.lengths); {
+		length := code.lengths[i]
+		run := 1
+		for i+run < len(code.lengths) && code.lengths[i+run] == length {
+			run++
+		}
+
+		switch {
+		case length == 0 && run >= 11:
+			if run > 138 {
+				run = 138
+			}
+			tokens = append(tokens, token{18, uint32(run - 11)})
+		case length == 0 && run >= 3:
+			tokens = append(tokens, token{17, uint32(run - 3)})
+		case length != 0 && run >= 4:
+			if run > 7 {
+				run = 7
+			}
+			tokens = append(tokens, token{length, 0}, token{16, uint32(run - 4)})
+		default:
+			run = 1
+			tokens = append(tokens, token{length, 0})
+		}
+		i += run
+	}
+
+	freqs = make([]int, len(webpCodeLengthOrder))
+	for _, t := range tokens {
+		freqs[t.symbol]++
+	}
+	lengthCode := newPrefixCode(freqs, 7)
+
+	n := 4
+	for i := range webpCodeLengthOrder {
+		if lengthCode.lengths[webpCodeLengthOrder[i]] > 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(uint32(n-4), 4)
+	for i := 0; i < n; i++ {
+		bw.write(uint32(lengthCode.lengths[webpCodeLengthOrder[i]]), 3)
+	}
+
+	// the code lengths of all the symbols are written
+	bw.write(0, 1)
+	for _, t := range tokens {
+		lengthCode.write(bw, t.symbol)
+		switch t.symbol {
+		case 16:
+			bw.write(t.extra, 2)
+		case 17:
+			bw.write(t.extra, 3)
+		case 18:
+			bw.write(t.extra, 7)
+		}
+	}
+
+	return code
+}
+
+// newPrefixCode returns the canonical Huffman code of the symbol frequencies,
+// the code lengths are limited to maxLength bits by flattening the frequencies.
+func newPrefixCode(freqs []int, maxLength int) *prefixCode {
+	weights := make([]int, len(freqs))
+	copy(weights, freqs)
+
+	code := &prefixCode{codes: make([]uint32, len(freqs))}
+	for {
+		code.lengths = huffmanLengths(weights)
+
+		longest := 0
+		for _, length := range code.lengths {
+			if length > longest {
+				longest = length
+			}
+		}
+		if longest <= maxLength {
+			break
+		}
+
+		for i := range weights {
+			if weights[i] > 0 {
+				weights[i] = (weights[i] + 1) / 2
+			}
+		}
+	}
+
+	var symbols int
+	for _, length := range code.lengths {
+		if length > 0 {
+			symbols++
+		}
+	}
+	code.single = symbols == 1
+
+	var (
+		count [16]uint32
+		next  [16]uint32
+	)
+	for _, length := range code.lengths {
+		if length > 0 {
+			count[length]++
+		}
+	}
+	for length := 1; length < len(next); length++ {
+		next[length] = (next[length-1] + count[length-1]) << 1
+	}
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			code.codes[symbol] = next[length]
+			next[length]++
+		}
+	}
+
+	return code
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for the weights,
+// a single symbol gets a length of 1.
+func huffmanLengths(weights []int) []int {
+	type node struct {
+		weight int
+		parent int
+	}
+
+	var nodes []node
+	var leaves []int
+	for symbol, weight := range weights {
+		if weight > 0 {
+			leaves = append(leaves, symbol)
+			nodes = append(nodes, node{weight: weight, parent: -1})
+		}
+	}
+
+	lengths := make([]int, len(weights))
+	if len(leaves) == 1 {
+		lengths[leaves[0]] = 1
+	}
+	if len(leaves) < 2 {
+		return lengths
+	}
+
+	queue := make([]int, len(nodes))
+	for i := range queue {
+		queue[i] = i
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		return nodes[queue[i]].weight < nodes[queue[j]].weight
+	})
+
+	// leaves are sorted by weight and merged nodes are created by increasing
+	// weight, the two lightest nodes are at the head of both queues
+	var merged []int
+	pop := func() int {
+		if len(merged) == 0 || (len(queue) > 0 && nodes[queue[0]].weight <= nodes[merged[0]].weight) {
+			i := queue[0]
+			queue = queue[1:]
+			return i
+		}
+
+		i := merged[0]
+		merged = merged[1:]
+		return i
+	}
+
+	for len(queue)+len(merged) > 1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+		merged = append(merged, len(nodes)-1)
+	}
+
+	for i, symbol := range leaves {
+		for p := nodes[i].parent; p >= 0; p = nodes[p].parent {
+			lengths[symbol]++
+		}
+	}
+
+	return lengths
+}
+
+// bitWriter writes values starting from their least significant bit
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (w *bitWriter) write(value uint32, n uint) {
+	w.bits |= uint64(value) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nBits = 0, 0
+	}
+
+	return w.buf
+}
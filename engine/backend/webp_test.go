@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+
+	imagefile "github.com/thoas/picfit/image"
+)
+
+func newNoisyImage(width int, height int) *image.NRGBA {
+	r := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y*3 + r.Intn(8)), uint8(r.Intn(256)), uint8(255 - x)})
+		}
+	}
+
+	return img
+}
+
+func TestEncodeWebP(t *testing.T) {
+	for _, src := range []*image.NRGBA{
+		newNoisyImage(67, 45),
+		imaging.New(40, 30, color.NRGBA{200, 100, 50, 255}),
+		imaging.New(1, 1, color.NRGBA{0, 0, 0, 0}),
+	} {
+		buf := &bytes.Buffer{}
+		err := encodeWebP(buf, src, 100)
+		assert.Nil(t, err)
+
+		out, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, src.Pix, imaging.Clone(out).Pix)
+	}
+
+	// a lower quality quantizes the colors
+	src := newNoisyImage(67, 45)
+	lossless := &bytes.Buffer{}
+	err := encodeWebP(lossless, src, 100)
+	assert.Nil(t, err)
+
+	lossy := &bytes.Buffer{}
+	err = encodeWebP(lossy, src, 60)
+	assert.Nil(t, err)
+	assert.True(t, lossy.Len() < lossless.Len())
+
+	out, err := webp.Decode(bytes.NewReader(lossy.Bytes()))
+	assert.Nil(t, err)
+	pix := imaging.Clone(out).Pix
+	for i := range pix {
+		assert.InDelta(t, int(src.Pix[i]), int(pix[i]), 8)
+	}
+
+	err = encodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, webpMaxSize+1, 1)), 100)
+	assert.NotNil(t, err)
+}
+
+func TestWebPFormat(t *testing.T) {
+	e := &GoImage{}
+	img := &imagefile.ImageFile{Source: newImage(t, 40, 40, imaging.PNG)}
+
+	content, err := e.Resize(img, &Options{Format: WebP, Width: 20, Height: 20, Quality: 90, Lossless: true})
+	assert.Nil(t, err)
+
+	out, err := webp.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 20, out.Bounds().Dx())
+	assert.Equal(t, color.NRGBA{200, 100, 50, 255}, color.NRGBAModel.Convert(out.At(10, 10)))
+
+	// WebP sources are decoded
+	img = &imagefile.ImageFile{Source: content}
+	ok, err := e.WouldTransform(img, &Options{Format: WebP, Width: 40, Height: 40})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	content, err = e.Resize(img, &Options{Format: imaging.PNG, Width: 10, Height: 10})
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, out.Bounds().Dx())
+
+	format, ok := ResolveFormat([]string{"image/avif", "image/webp"})
+	assert.True(t, ok)
+	if avifSupported {
+		assert.Equal(t, AVIF, format)
+	} else {
+		assert.Equal(t, WebP, format)
+	}
+}
+
+func TestNewPrefixCode(t *testing.T) {
+	// fibonacci frequencies produce the deepest Huffman trees
+	freqs := make([]int, 19)
+	a, b := 1, 1
+	for i := range freqs {
+		freqs[i] = a
+		a, b = b, a+b
+	}
+
+	code := newPrefixCode(freqs, 7)
+
+	var kraft float64
+	for _, length := range code.lengths {
+		assert.True(t, length > 0 && length <= 7)
+		kraft += 1 / float64(int(1)<<uint(length))
+	}
+	assert.Equal(t, 1.0, kraft)
+
+	code = newPrefixCode([]int{0, 0, 5, 0}, 15)
+	assert.True(t, code.single)
+	assert.Equal(t, 1, code.lengths[2])
+}
+
+func TestAnimatedWebPFromGIF(t *testing.T) {
+	e := &GoImage{}
+	source := newColoredGIF(t, 40, 40, []int{10, 20, 30, 40})
+
+	for _, sequential := range []bool{true, false} {
+		content, err := e.Resize(&imagefile.ImageFile{Source: source}, &Options{
+			Format:     WebP,
+			Width:      20,
+			Height:     20,
+			Quality:    100,
+			LoopCount:  -1,
+			Sequential: sequential,
+		})
+		assert.Nil(t, err)
+		assert.True(t, isAnimatedWebP(content))
+
+		canvas, frames, err := webpFrames(content)
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 20, 20), canvas)
+		assert.Equal(t, 4, len(frames))
+
+		for i := range frames {
+			assert.Equal(t, (i+1)*100, frames[i].duration)
+			assert.Equal(t, canvas, frames[i].bounds)
+			assert.False(t, frames[i].blend)
+
+			out, err := frames[i].decode()
+			assert.Nil(t, err)
+			assert.Equal(t, animatedColors[i], color.NRGBAModel.Convert(out.At(10, 10)))
+		}
+
+		// the GIF loops forever
+		var loopCount = -1
+		webpChunks(content[12:], func(id string, chunk []byte) error {
+			if id == "ANIM" {
+				loopCount = int(binary.LittleEndian.Uint16(chunk[4:]))
+			}
+			return nil
+		})
+		assert.Equal(t, 0, loopCount)
+	}
+
+	// a still frame is requested
+	content, err := e.Resize(&imagefile.ImageFile{Source: source}, &Options{
+		Format:          WebP,
+		Width:           20,
+		Height:          20,
+		Quality:         100,
+		AnimatedToStill: AnimatedToStillLast,
+	})
+	assert.Nil(t, err)
+	assert.False(t, isAnimatedWebP(content))
+
+	out, err := webp.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, animatedColors[3], color.NRGBAModel.Convert(out.At(10, 10)))
+}
+
+func TestWebPLoopCount(t *testing.T) {
+	assert.Equal(t, 0, webpLoopCount(0))
+	assert.Equal(t, 1, webpLoopCount(-1))
+	assert.Equal(t, 4, webpLoopCount(3))
+	assert.Equal(t, math.MaxUint16, webpLoopCount(math.MaxUint16))
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/thoas/picfit/image"
+)
+
+// BatchItem is an image to process with its operations
+type BatchItem struct {
+	Image      *image.ImageFile
+	Operations []EngineOperation
+}
+
+// BatchResult is the result of a BatchItem at the same index
+type BatchResult struct {
+	Image *image.ImageFile
+	Error error
+}
+
+// ProcessBatch processes items concurrently with a bounded number of workers,
+// a failing item doesn't abort the others.
+func (e Engine) ProcessBatch(items []BatchItem) []BatchResult {
+	results := make([]BatchResult, len(items))
+
+	workers := e.BatchWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				file, err := e.Transform(items[index].Image, items[index].Operations)
+				results[index] = BatchResult{Image: file, Error: err}
+			}
+		}()
+	}
+
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/engine/backend"
+	"github.com/thoas/picfit/engine/config"
+	"github.com/thoas/picfit/image"
+)
+
+func TestProcessBatch(t *testing.T) {
+	e := newEngine(t, config.Config{BatchWorkers: 2})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 40, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	var items []BatchItem
+	for i := 0; i < 6; i++ {
+		source := buf.Bytes()
+		if i%3 == 0 {
+			source = []byte("foo")
+		}
+
+		items = append(items, BatchItem{
+			Image: &image.ImageFile{
+				Source:   source,
+				Filepath: "image.png",
+				Headers:  map[string]string{"Content-Type": "image/png"},
+			},
+			Operations: []EngineOperation{{
+				Operation: Resize,
+				Options:   &backend.Options{Format: imaging.PNG, Width: 10 + i, Height: 10, Upscale: true},
+			}},
+		})
+	}
+
+	results := e.ProcessBatch(items)
+	assert.Equal(t, len(items), len(results))
+
+	for i, result := range results {
+		if i%3 == 0 {
+			assert.NotNil(t, result.Error)
+			continue
+		}
+
+		assert.Nil(t, result.Error)
+
+		out, err := imaging.Decode(bytes.NewReader(result.Image.Processed))
+		assert.Nil(t, err)
+		assert.Equal(t, 10+i, out.Bounds().Dx())
+	}
+}
@@ -16,15 +16,60 @@ type CommandBackend struct {
 	Weight    int
 }
 
+// Watermark is the config to fetch watermarks from remote URLs and to
+// watermark the JPEG outputs
+type Watermark struct {
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	MaxSize      int64    `mapstructure:"max_size"`
+	Timeout      int      `mapstructure:"timeout"`
+
+	// Path, ColoredPath, Opacity, Gravity and Threshold configure the
+	// watermark drawn on the JPEG outputs
+	Path        string   `mapstructure:"path"`
+	ColoredPath string   `mapstructure:"colored_path"`
+	Opacity     *int     `mapstructure:"opacity"`
+	Gravity     string   `mapstructure:"gravity"`
+	Threshold   *float64 `mapstructure:"threshold"`
+
+	// FontPath is the path of the TTF font of the text watermarks
+	FontPath string `mapstructure:"font_path"`
+}
+
+// FaceDetection is the config of the face detector used by the face
+// gravity and the redaction, picfit has to be built with the facedetect tag
+type FaceDetection struct {
+	// Cascade is the path of the OpenCV cascade classifier of the faces
+	Cascade string `mapstructure:"cascade"`
+}
+
+// DecodeCache is the config of the in-process cache of the decoded sources
+type DecodeCache struct {
+	MaxEntries int   `mapstructure:"max_entries"`
+	MaxBytes   int64 `mapstructure:"max_bytes"`
+}
+
 // Config is the engine config
 type Config struct {
-	Backends        *Backends `mapstructure:"backends"`
-	DefaultFormat   string    `mapstructure:"default_format"`
-	Format          string    `mapstructure:"format"`
-	Quality         int       `mapstructure:"quality"`
-	MaxBufferSize   int       `mapstructure:"max_buffer_size"`
-	ImageBufferSize int       `mapstructure:"image_buffer_size"`
-	JpegQuality     int       `mapstructure:"jpeg_quality"`
-	PngCompression  int       `mapstructure:"png_compression"`
-	WebpQuality     int       `mapstructure:"webp_quality"`
+	Backends        *Backends  `mapstructure:"backends"`
+	BatchWorkers    int        `mapstructure:"batch_workers"`
+	DefaultFormat   string     `mapstructure:"default_format"`
+	Format          string     `mapstructure:"format"`
+	Quality         int        `mapstructure:"quality"`
+	MaxBufferSize   int        `mapstructure:"max_buffer_size"`
+	ImageBufferSize int        `mapstructure:"image_buffer_size"`
+	JpegQuality     int        `mapstructure:"jpeg_quality"`
+	PngCompression  int        `mapstructure:"png_compression"`
+	WebpQuality     int        `mapstructure:"webp_quality"`
+	Watermark       *Watermark `mapstructure:"watermark"`
+
+	DecodeCache          *DecodeCache   `mapstructure:"decode_cache"`
+	FaceDetection        *FaceDetection `mapstructure:"face_detection"`
+	GIFMaxPixelsPerFrame int            `mapstructure:"gif_max_pixels_per_frame"`
+	MaxOutputHeight      int            `mapstructure:"max_output_height"`
+	MaxOutputWidth       int            `mapstructure:"max_output_width"`
+	MaxSourcePixels      int            `mapstructure:"max_source_pixels"`
+	OmitEXIFGPS          bool           `mapstructure:"omit_exif_gps"`
+	RejectTrailingData   bool           `mapstructure:"reject_trailing_data"`
+	SnapWidths           []int          `mapstructure:"snap_widths"`
+	StrictImage          bool           `mapstructure:"strict_image"`
 }
@@ -12,4 +12,8 @@ const (
 
 	// DefaultImageBufferSize is the default image buffer size for lilliput
 	DefaultImageBufferSize = 50 * 1024 * 1024
+
+	// DefaultMaxSourcePixels is the default maximum number of pixels of the
+	// sources, larger sources are rejected before being decoded
+	DefaultMaxSourcePixels = 50 * 1000 * 1000
 )
@@ -1,19 +1,52 @@
 package engine
 
+import (
+	"github.com/disintegration/imaging"
+
+	"github.com/thoas/picfit/engine/backend"
+)
+
+// SVGContentType is the content type of the SQIP placeholders
+const SVGContentType = "image/svg+xml"
+
+// JSONContentType is the content type of the palettes
+const JSONContentType = "application/json"
+
+// TextContentType is the content type of the text placeholders
+const TextContentType = "text/plain"
+
 var (
+	// FormatContentTypes are the content types of the output formats
+	FormatContentTypes = map[imaging.Format]string{
+		backend.AVIF: "image/avif",
+		imaging.BMP:  "image/bmp",
+		imaging.GIF:  "image/gif",
+		imaging.JPEG: "image/jpeg",
+		imaging.PNG:  "image/png",
+		imaging.TIFF: "image/tiff",
+		backend.WebP: "image/webp",
+	}
+
+	// ContentTypes are the content types of the output formats by name
 	ContentTypes = map[string]string{
-		"bmp":  "image/bmp",
-		"gif":  "image/gif",
-		"jpeg": "image/jpeg",
-		"jpg":  "image/jpeg",
-		"png":  "image/png",
-		"webp": "image/webp",
+		"avif": FormatContentTypes[backend.AVIF],
+		"bmp":  FormatContentTypes[imaging.BMP],
+		"gif":  FormatContentTypes[imaging.GIF],
+		"jpeg": FormatContentTypes[imaging.JPEG],
+		"jpg":  FormatContentTypes[imaging.JPEG],
+		"png":  FormatContentTypes[imaging.PNG],
+		"tif":  FormatContentTypes[imaging.TIFF],
+		"tiff": FormatContentTypes[imaging.TIFF],
+		"webp": FormatContentTypes[backend.WebP],
 	}
 
 	MimeTypes = []string{
+		"image/avif",
 		"image/bmp",
 		"image/gif",
 		"image/jpeg",
 		"image/png",
+		"image/tiff",
+		"image/webp",
 	}
 )
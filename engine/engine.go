@@ -2,22 +2,38 @@ package engine
 
 import (
 	"fmt"
+	"image/png"
+	"math"
 	"os/exec"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
 
 	"github.com/thoas/picfit/engine/backend"
 	"github.com/thoas/picfit/engine/config"
+	"github.com/thoas/picfit/failure"
 	"github.com/thoas/picfit/image"
 	"github.com/thoas/picfit/logger"
 )
 
 type Engine struct {
-	DefaultFormat  string
-	DefaultQuality int
-	Format         string
-	backends       []*backendWrapper
-	logger         logger.Logger
+	BatchWorkers         int
+	DefaultFormat        string
+	DefaultQuality       int
+	Format               string
+	GIFMaxPixelsPerFrame int
+	MaxOutputHeight      int
+	MaxOutputWidth       int
+	MaxSourcePixels      int
+	PNGCompression       png.CompressionLevel
+	SnapWidths           []int
+	RejectTrailingData   bool
+	StrictImage          bool
+	Watermark            *backend.Watermark
+	backends             []*backendWrapper
+	logger               logger.Logger
 }
 
 type backendWrapper struct {
@@ -26,13 +42,46 @@ type backendWrapper struct {
 	weight    int
 }
 
-// New initializes an Engine
-func New(cfg config.Config, logger logger.Logger) *Engine {
+// handles returns true if the backend processes images of the content type ct.
+func (b backendWrapper) handles(ct string) bool {
+	for i := range b.mimetypes {
+		if ct == b.mimetypes[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// New initializes an Engine, an error is returned when the watermark of the
+// config is invalid.
+func New(cfg config.Config, logger logger.Logger) (*Engine, error) {
 	var b []*backendWrapper
 
+	goimage := &backend.GoImage{OmitGPS: cfg.OmitEXIFGPS, Logger: logger, MaxPixels: maxSourcePixels(cfg)}
+	if cfg.DecodeCache != nil {
+		goimage.Decoded = backend.NewDecodeCache(cfg.DecodeCache.MaxEntries, cfg.DecodeCache.MaxBytes)
+	}
+	if cfg.FaceDetection != nil {
+		goimage.Detector = faceDetector(cfg.FaceDetection, logger)
+	}
+	if cfg.Watermark != nil {
+		goimage.Watermarks = backend.NewWatermarkFetcher(
+			cfg.Watermark.AllowedHosts,
+			time.Duration(cfg.Watermark.Timeout)*time.Millisecond,
+			cfg.Watermark.MaxSize,
+			maxSourcePixels(cfg),
+		)
+		goimage.Font = &backend.TextFont{Path: cfg.Watermark.FontPath}
+	}
+
+	watermark, err := newWatermark(cfg.Watermark)
+	if err != nil {
+		return nil, err
+	}
+
 	if cfg.Backends == nil {
 		b = append(b, &backendWrapper{
-			backend:   &backend.GoImage{},
+			backend:   goimage,
 			mimetypes: MimeTypes,
 		})
 	} else {
@@ -52,7 +101,7 @@ func New(cfg config.Config, logger logger.Logger) *Engine {
 		}
 		if cfg.Backends.GoImage != nil {
 			b = append(b, &backendWrapper{
-				backend:   &backend.GoImage{},
+				backend:   goimage,
 				mimetypes: cfg.Backends.GoImage.Mimetypes,
 				weight:    cfg.Backends.GoImage.Weight,
 			})
@@ -69,12 +118,85 @@ func New(cfg config.Config, logger logger.Logger) *Engine {
 	}
 
 	return &Engine{
-		DefaultFormat:  cfg.DefaultFormat,
-		DefaultQuality: quality,
-		Format:         cfg.Format,
-		backends:       b,
-		logger:         logger,
+		BatchWorkers:         cfg.BatchWorkers,
+		DefaultFormat:        cfg.DefaultFormat,
+		DefaultQuality:       quality,
+		Format:               cfg.Format,
+		GIFMaxPixelsPerFrame: cfg.GIFMaxPixelsPerFrame,
+		MaxOutputHeight:      cfg.MaxOutputHeight,
+		MaxOutputWidth:       cfg.MaxOutputWidth,
+		MaxSourcePixels:      maxSourcePixels(cfg),
+		PNGCompression:       pngCompression(cfg.PngCompression),
+		SnapWidths:           cfg.SnapWidths,
+		RejectTrailingData:   cfg.RejectTrailingData,
+		StrictImage:          cfg.StrictImage,
+		Watermark:            watermark,
+		backends:             b,
+		logger:               logger,
+	}, nil
+}
+
+// newWatermark returns the watermark drawn on the JPEG outputs, the opacity
+// and the gravity are checked so an invalid config fails at startup.
+func newWatermark(cfg *config.Watermark) (*backend.Watermark, error) {
+	if cfg == nil || cfg.Path == "" {
+		return nil, nil
+	}
+
+	watermark := &backend.Watermark{
+		Path:        cfg.Path,
+		ColoredPath: cfg.ColoredPath,
+		Opacity:     backend.DefaultWatermarkOpacity,
+		Gravity:     cfg.Gravity,
+		Threshold:   backend.DefaultWatermarkThreshold,
+	}
+	if cfg.Opacity != nil {
+		if *cfg.Opacity < 0 || *cfg.Opacity > 255 {
+			return nil, fmt.Errorf("Watermark opacity %d should be between 0 and 255", *cfg.Opacity)
+		}
+		watermark.Opacity = uint8(*cfg.Opacity)
+	}
+	if cfg.Threshold != nil {
+		watermark.Threshold = *cfg.Threshold
+	}
+
+	if cfg.Gravity != "" {
+		valid := false
+		for i := range backend.WatermarkGravities {
+			if cfg.Gravity == backend.WatermarkGravities[i] {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return nil, fmt.Errorf("Watermark gravity %s is invalid, available values are: %v", cfg.Gravity, backend.WatermarkGravities)
+		}
+	}
+
+	return watermark, nil
+}
+
+// maxSourcePixels returns the maximum number of pixels of the decoded
+// images, a negative maximum disables the check of the sources.
+func maxSourcePixels(cfg config.Config) int {
+	if cfg.MaxSourcePixels != 0 {
+		return cfg.MaxSourcePixels
+	}
+
+	return config.DefaultMaxSourcePixels
+}
+
+// faceDetector returns the face detector of the config, the face gravity
+// centers the crops when the detector cannot be created.
+func faceDetector(cfg *config.FaceDetection, log logger.Logger) backend.RegionDetector {
+	detector, err := backend.NewFaceDetector(cfg.Cascade)
+	if err != nil {
+		log.Error("Face detection is disabled", logger.Error(err))
+		return nil
 	}
+
+	return detector
 }
 
 func (e Engine) String() string {
@@ -93,18 +215,29 @@ func (e Engine) Transform(output *image.ImageFile, operations []EngineOperation)
 		source    = output.Source
 	)
 
+	// the options are resolved on copies, the operations of the caller may
+	// be transformed again such as the items of a batch
+	operations = copyOperations(operations)
+
 	ct := output.ContentType()
+	if err := e.prepare(output, operations); err != nil {
+		return nil, err
+	}
+
+	if len(operations) > 1 {
+		processed, err = e.pipeline(ct, output, operations)
+		if err == nil {
+			output.Processed = processed
+			return output, nil
+		}
+		if err != backend.ErrNotPipelinable {
+			return nil, failure.WrapTransformError(err)
+		}
+	}
+
 	for i := range operations {
 		for j := range e.backends {
-			var processing bool
-			for k := range e.backends[j].mimetypes {
-				if ct == e.backends[j].mimetypes[k] {
-					processing = true
-					break
-				}
-			}
-
-			if !processing {
+			if !e.backends[j].handles(ct) {
 				continue
 			}
 
@@ -114,13 +247,26 @@ func (e Engine) Transform(output *image.ImageFile, operations []EngineOperation)
 				logger.String("options", operations[i].Options.String()),
 			)
 
-			processed, err = operate(e.backends[j].backend, output, operations[i].Operation, operations[i].Options)
+			processed, err = backend.Observe(operations[i].Operation.String(), output, operations[i].Options, func() ([]byte, error) {
+				return operate(e.backends[j].backend, output, operations[i].Operation, operations[i].Options)
+			})
 			if err == nil {
 				output.Source = processed
+				switch operations[i].Operation {
+				case SQIP:
+					output.Headers["Content-Type"] = SVGContentType
+				case Palette:
+					output.Headers["Content-Type"] = JSONContentType
+				case Placeholder:
+					if operations[i].Options.PlaceholderMode == backend.PlaceholderBlurHash ||
+						operations[i].Options.PlaceholderMode == backend.PlaceholderDataURI {
+						output.Headers["Content-Type"] = TextContentType
+					}
+				}
 				break
 			}
 			if err != backend.MethodNotImplementedError {
-				return nil, err
+				return nil, failure.WrapTransformError(err)
 			}
 		}
 	}
@@ -131,10 +277,335 @@ func (e Engine) Transform(output *image.ImageFile, operations []EngineOperation)
 	return output, err
 }
 
+// Encoder returns the encoder of the output transformed by the operations,
+// the transformed image is encoded straight to a writer instead of being
+// buffered. backend.ErrNotPipelinable is returned when the operations
+// cannot be applied by a pipeline, the output is then transformed by
+// Transform.
+func (e Engine) Encoder(output *image.ImageFile, operations []EngineOperation) (backend.Encoder, error) {
+	if len(operations) == 0 {
+		return nil, backend.ErrNotPipelinable
+	}
+
+	// the options are resolved on copies like in Transform
+	operations = copyOperations(operations)
+
+	ct := output.ContentType()
+	if err := e.prepare(output, operations); err != nil {
+		return nil, err
+	}
+
+	stages := make([]backend.Stage, len(operations))
+	for i := range operations {
+		stages[i] = backend.Stage{
+			Operation: operations[i].Operation.String(),
+			Options:   operations[i].Options,
+		}
+	}
+
+	for j := range e.backends {
+		if !e.backends[j].handles(ct) {
+			continue
+		}
+
+		pipeliner, ok := e.backends[j].backend.(backend.PipelineEncoder)
+		if !ok {
+			continue
+		}
+
+		encoder, err := pipeliner.PipelineEncoder(output, stages)
+		if err == backend.ErrNotPipelinable {
+			continue
+		}
+		if err != nil {
+			return nil, failure.WrapTransformError(err)
+		}
+
+		return encoder, nil
+	}
+
+	return nil, backend.ErrNotPipelinable
+}
+
+// copyOperations returns the operations with copies of their options
+func copyOperations(operations []EngineOperation) []EngineOperation {
+	copied := make([]EngineOperation, len(operations))
+	for i := range operations {
+		options := *operations[i].Options
+		copied[i] = EngineOperation{Operation: operations[i].Operation, Options: &options}
+	}
+
+	return copied
+}
+
+// prepare checks the source of the output and resolves the options of the
+// operations, the content type of the output is written in its headers.
+func (e Engine) prepare(output *image.ImageFile, operations []EngineOperation) error {
+	var (
+		err  error
+		info *backend.ImageInfo
+	)
+
+	if err := e.checkSource(output); err != nil {
+		return err
+	}
+
+	if output.Headers == nil {
+		output.Headers = map[string]string{}
+	}
+
+	for i := range operations {
+		if operations[i].Options.WidthPercent > 0 || operations[i].Options.HeightPercent > 0 {
+			// the header is read once for all the operations
+			if info == nil {
+				if info, err = e.Info(output); err != nil {
+					return err
+				}
+			}
+
+			resolvePercentages(operations[i].Options, info)
+		}
+
+		scaleDimensions(operations[i].Options)
+		snapDimensions(operations[i].Options)
+		e.capDimensions(operations[i].Options)
+
+		if format, ok := backend.ResolveFormat(operations[i].Options.AcceptFormats); ok {
+			operations[i].Options.Format = format
+		}
+	}
+
+	// the content type is the one of the output format, the source may have
+	// been converted or served with an imprecise content type
+	if len(operations) > 0 {
+		if outputType := ContentType(operations[len(operations)-1].Options.Format); outputType != "" {
+			output.Headers["Content-Type"] = outputType
+		}
+	}
+
+	return nil
+}
+
+// Analyze returns the analysis of the image by the first backend of its
+// content type able to analyze it.
+func (e Engine) Analyze(img *image.ImageFile) (*backend.Analysis, error) {
+	if err := e.checkSource(img); err != nil {
+		return nil, err
+	}
+
+	ct := img.ContentType()
+	for j := range e.backends {
+		if !e.backends[j].handles(ct) {
+			continue
+		}
+
+		analyzer, ok := e.backends[j].backend.(backend.Analyzer)
+		if !ok {
+			continue
+		}
+
+		analysis, err := analyzer.Analyze(img)
+		if err != nil {
+			return nil, failure.WrapTransformError(err)
+		}
+
+		return analysis, nil
+	}
+
+	return nil, failure.WrapTransformError(backend.MethodNotImplementedError)
+}
+
+// pipeline applies the operations to the image decoded once with the first
+// backend of the content type able to apply all of them in memory.
+func (e Engine) pipeline(ct string, output *image.ImageFile, operations []EngineOperation) ([]byte, error) {
+	stages := make([]backend.Stage, len(operations))
+	for i := range operations {
+		stages[i] = backend.Stage{
+			Operation: operations[i].Operation.String(),
+			Options:   operations[i].Options,
+		}
+	}
+
+	for j := range e.backends {
+		if !e.backends[j].handles(ct) {
+			continue
+		}
+
+		pipeliner, ok := e.backends[j].backend.(backend.Pipeliner)
+		if !ok {
+			continue
+		}
+
+		processed, err := backend.Observe("pipeline", output, operations[0].Options, func() ([]byte, error) {
+			return pipeliner.Pipeline(output, stages)
+		})
+		if err != backend.ErrNotPipelinable {
+			return processed, err
+		}
+	}
+
+	return nil, backend.ErrNotPipelinable
+}
+
+// pngCompression maps a zlib compression level, from 1 to 9, to the
+// levels of the PNG encoder, 0 is the default compression.
+func pngCompression(level int) png.CompressionLevel {
+	switch {
+	case level <= 0:
+		return png.DefaultCompression
+	case level <= 3:
+		return png.BestSpeed
+	case level <= 6:
+		return png.DefaultCompression
+	}
+
+	return png.BestCompression
+}
+
+// ContentType returns the content type of the output format
+func ContentType(format imaging.Format) string {
+	return FormatContentTypes[format]
+}
+
+// resolvePercentages replaces the percentages of the source dimensions of
+// options by pixels.
+func resolvePercentages(options *backend.Options, info *backend.ImageInfo) {
+	if options.WidthPercent > 0 {
+		options.Width = PercentOf(info.Width, options.WidthPercent)
+		options.WidthPercent = 0
+	}
+	if options.HeightPercent > 0 {
+		options.Height = PercentOf(info.Height, options.HeightPercent)
+		options.HeightPercent = 0
+	}
+}
+
+// PercentOf returns the pixels of a percentage of size, at least one pixel
+func PercentOf(size int, percent float64) int {
+	return int(math.Max(1, math.Round(float64(size)*percent/100)))
+}
+
+// scaleDimensions multiplies the target dimensions of options by their
+// device pixel ratio, so the same logical dimensions produce retina images.
+func scaleDimensions(options *backend.Options) {
+	if options.DPR <= 0 || options.DPR == 1 {
+		return
+	}
+
+	if options.Width > 0 {
+		options.Width = int(math.Max(1, math.Round(float64(options.Width)*options.DPR)))
+	}
+	if options.Height > 0 {
+		options.Height = int(math.Max(1, math.Round(float64(options.Height)*options.DPR)))
+	}
+	// the dimensions are only scaled once
+	options.DPR = 1
+}
+
+// snapDimensions replaces the target width of options by the nearest
+// allowed width, the target height keeps the requested aspect ratio.
+func snapDimensions(options *backend.Options) {
+	if len(options.SnapWidths) == 0 || options.Width <= 0 {
+		return
+	}
+
+	width := options.SnapWidths[0]
+	for _, w := range options.SnapWidths[1:] {
+		d, nearest := abs(w-options.Width), abs(width-options.Width)
+		if d < nearest || (d == nearest && w > width) {
+			width = w
+		}
+	}
+
+	if options.Height > 0 {
+		options.Height = int(math.Max(1, math.Round(float64(options.Height)*float64(width)/float64(options.Width))))
+	}
+	options.Width = width
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// checkSource returns an error wrapping failure.ErrImageTooLarge when the
+// source has more pixels than the maximum, only its header is decoded so
+// decompression bombs are rejected before their pixels are allocated.
+func (e Engine) checkSource(img *image.ImageFile) error {
+	if e.MaxSourcePixels <= 0 {
+		return nil
+	}
+
+	// the backends report the sources which cannot be decoded
+	info, err := e.Info(img)
+	if err != nil {
+		return nil
+	}
+
+	if info.Pixels() > int64(e.MaxSourcePixels) {
+		return &failure.DecodeError{Err: fmt.Errorf("%w: %dx%d exceeds %d pixels",
+			failure.ErrImageTooLarge, info.Width, info.Height, e.MaxSourcePixels)}
+	}
+
+	return nil
+}
+
+// Info returns the dimensions and the format of img read by the first
+// backend of its content type able to, without decoding its pixels.
+func (e Engine) Info(img *image.ImageFile) (*backend.ImageInfo, error) {
+	ct := img.ContentType()
+	for j := range e.backends {
+		if !e.backends[j].handles(ct) {
+			continue
+		}
+
+		informer, ok := e.backends[j].backend.(backend.Informer)
+		if !ok {
+			continue
+		}
+
+		return informer.Info(img)
+	}
+
+	return (&backend.GoImage{}).Info(img)
+}
+
+// capDimensions reduces the target dimensions of options, preserving their
+// aspect ratio, so they don't exceed the maximum output dimensions.
+func (e Engine) capDimensions(options *backend.Options) {
+	ratio := 1.0
+	if e.MaxOutputWidth > 0 && options.Width > e.MaxOutputWidth {
+		ratio = float64(e.MaxOutputWidth) / float64(options.Width)
+	}
+	if e.MaxOutputHeight > 0 && options.Height > e.MaxOutputHeight {
+		ratio = math.Min(ratio, float64(e.MaxOutputHeight)/float64(options.Height))
+	}
+
+	if ratio == 1 {
+		return
+	}
+
+	if options.Width > 0 {
+		options.Width = int(math.Max(1, math.Round(float64(options.Width)*ratio)))
+	}
+	if options.Height > 0 {
+		options.Height = int(math.Max(1, math.Round(float64(options.Height)*ratio)))
+	}
+}
+
 func operate(b backend.Backend, img *image.ImageFile, operation Operation, options *backend.Options) ([]byte, error) {
 	switch operation {
 	case Noop:
-		return img.Source, nil
+		if !options.StrictImage {
+			return img.Source, nil
+		}
+
+		// strict images are re-encoded without being transformed
+		opts := *options
+		opts.Width, opts.Height = 0, 0
+		return b.Fit(img, &opts)
 	case Flip:
 		return b.Flip(img, options)
 	case Rotate:
@@ -145,8 +616,52 @@ func operate(b backend.Backend, img *image.ImageFile, operation Operation, optio
 		return b.Thumbnail(img, options)
 	case Fit:
 		return b.Fit(img, options)
+	case Cover:
+		return b.Cover(img, options)
 	case Flat:
 		return b.Flat(img, options)
+	case AutoContrast:
+		return b.AutoContrast(img, options)
+	case DrawShapes:
+		return b.DrawShapes(img, options)
+	case LowPoly:
+		return b.LowPoly(img, options)
+	case Mask:
+		return b.Mask(img, options)
+	case Grayscale:
+		return b.Grayscale(img, options)
+	case Sepia:
+		return b.Sepia(img, options)
+	case Posterize:
+		return b.Posterize(img, options)
+	case QRCode:
+		return b.QRCode(img, options)
+	case Redact:
+		return b.Redact(img, options)
+	case SQIP:
+		return b.SQIP(img, options)
+	case Palette:
+		return b.Palette(img, options)
+	case Placeholder:
+		return b.Placeholder(img, options)
+	case Sharpen:
+		return b.Sharpen(img, options)
+	case Blur:
+		return b.Blur(img, options)
+	case Crop:
+		return b.Crop(img, options)
+	case CropResize:
+		return b.CropResize(img, options)
+	case Background:
+		return b.Background(img, options)
+	case DominantColor:
+		return b.DominantColor(img, options)
+	case Vibrance:
+		return b.Vibrance(img, options)
+	case Watermark:
+		return b.Watermark(img, options)
+	case Text:
+		return b.Text(img, options)
 	default:
 		return nil, fmt.Errorf("Operation not found for %s", operation)
 	}
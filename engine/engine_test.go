@@ -0,0 +1,492 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit/engine/backend"
+	"github.com/thoas/picfit/engine/config"
+	"github.com/thoas/picfit/failure"
+	"github.com/thoas/picfit/image"
+	"github.com/thoas/picfit/logger"
+)
+
+func TestTransformObserver(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(400, 300, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	var metrics []backend.Metrics
+	file, err := e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+		Headers:  map[string]string{"Content-Type": "image/png"},
+	}, []EngineOperation{{
+		Operation: Resize,
+		Options: &backend.Options{
+			Format: imaging.PNG,
+			Width:  200,
+			Height: 150,
+			Observer: func(m backend.Metrics) {
+				metrics = append(metrics, m)
+			},
+		},
+	}})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(metrics))
+	assert.Equal(t, "resize", metrics[0].Operation)
+	assert.Equal(t, buf.Len(), metrics[0].InBytes)
+	assert.Equal(t, len(file.Processed), metrics[0].OutBytes)
+	assert.True(t, metrics[0].DecodeDur > 0)
+	assert.True(t, metrics[0].TransformDur > 0)
+	assert.True(t, metrics[0].EncodeDur > 0)
+}
+
+func TestTransformMaxOutputDimensions(t *testing.T) {
+	e := newEngine(t, config.Config{MaxOutputWidth: 100, MaxOutputHeight: 400})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(400, 200, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	file, err := e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+		Headers:  map[string]string{"Content-Type": "image/png"},
+	}, []EngineOperation{{
+		Operation: Resize,
+		Options:   &backend.Options{Format: imaging.PNG, Width: 10000, Height: 5000, Upscale: true},
+	}})
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(file.Processed))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, out.Bounds().Dx())
+	assert.Equal(t, 50, out.Bounds().Dy())
+}
+
+func TestTransformPercentages(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(400, 201, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	operations := []EngineOperation{{
+		Operation: Resize,
+		Options:   &backend.Options{Format: imaging.PNG, WidthPercent: 25, HeightPercent: 50, Upscale: true},
+	}}
+
+	file, err := e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+		Headers:  map[string]string{"Content-Type": "image/png"},
+	}, operations)
+	assert.Nil(t, err)
+
+	out, err := imaging.Decode(bytes.NewReader(file.Processed))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, out.Bounds().Dx())
+	assert.Equal(t, 101, out.Bounds().Dy())
+
+	// the options of the caller are left untouched, the percentages are
+	// resolved again against another source
+	assert.Equal(t, 0, operations[0].Options.Width)
+	assert.Equal(t, 0, operations[0].Options.Height)
+
+	buf.Reset()
+	err = imaging.Encode(buf, imaging.New(200, 100, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	file, err = e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+		Headers:  map[string]string{"Content-Type": "image/png"},
+	}, operations)
+	assert.Nil(t, err)
+
+	out, err = imaging.Decode(bytes.NewReader(file.Processed))
+	assert.Nil(t, err)
+	assert.Equal(t, 50, out.Bounds().Dx())
+	assert.Equal(t, 50, out.Bounds().Dy())
+}
+
+func TestScaleDimensions(t *testing.T) {
+	options := &backend.Options{Width: 100, Height: 50, DPR: 2}
+	scaleDimensions(options)
+	assert.Equal(t, 200, options.Width)
+	assert.Equal(t, 100, options.Height)
+
+	// the dimensions are only scaled once
+	scaleDimensions(options)
+	assert.Equal(t, 200, options.Width)
+
+	options = &backend.Options{Width: 101, DPR: 1.5}
+	scaleDimensions(options)
+	assert.Equal(t, 152, options.Width)
+	assert.Equal(t, 0, options.Height)
+
+	options = &backend.Options{Width: 100, Height: 50}
+	scaleDimensions(options)
+	assert.Equal(t, 100, options.Width)
+	assert.Equal(t, 50, options.Height)
+}
+
+func TestSnapDimensions(t *testing.T) {
+	widths := []int{160, 320, 640, 1280}
+
+	options := &backend.Options{Width: 500, Height: 250, SnapWidths: widths}
+	snapDimensions(options)
+	assert.Equal(t, 640, options.Width)
+	assert.Equal(t, 320, options.Height)
+
+	options = &backend.Options{Width: 200, SnapWidths: widths}
+	snapDimensions(options)
+	assert.Equal(t, 160, options.Width)
+	assert.Equal(t, 0, options.Height)
+
+	// ties are snapped to the larger width
+	options = &backend.Options{Width: 480, Height: 480, SnapWidths: widths}
+	snapDimensions(options)
+	assert.Equal(t, 640, options.Width)
+	assert.Equal(t, 640, options.Height)
+
+	options = &backend.Options{Width: 500, Height: 250}
+	snapDimensions(options)
+	assert.Equal(t, 500, options.Width)
+}
+
+func TestTransformAcceptFormats(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 20, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	transform := func(accept ...string) *image.ImageFile {
+		file, err := e.Transform(&image.ImageFile{
+			Source:   buf.Bytes(),
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, []EngineOperation{{
+			Operation: Resize,
+			Options: &backend.Options{
+				Format:        imaging.PNG,
+				Width:         20,
+				AcceptFormats: accept,
+			},
+		}})
+		assert.Nil(t, err)
+
+		return file
+	}
+
+	file := transform("image/webp", "image/png")
+	assert.Equal(t, "image/webp", file.Headers["Content-Type"])
+	assert.Equal(t, "RIFF", string(file.Processed[:4]))
+
+	file = transform("image/jpeg", "image/webp")
+	assert.Equal(t, "image/jpeg", file.Headers["Content-Type"])
+	assert.Equal(t, []byte{0xff, 0xd8}, file.Processed[:2])
+
+	// the format is kept when no accepted format is supported
+	file = transform("image/heic")
+	assert.Equal(t, "image/png", file.Headers["Content-Type"])
+}
+
+func TestTransformContentType(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 20, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	for format, contentType := range FormatContentTypes {
+		// AVIF is only encoded when picfit is built with the avif tag
+		if _, ok := backend.ResolveFormat([]string{contentType}); format == backend.AVIF && !ok {
+			continue
+		}
+
+		// the source content type isn't precise and differs from the output
+		file, err := e.Transform(&image.ImageFile{
+			Source:   buf.Bytes(),
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, []EngineOperation{
+			{Operation: Resize, Options: &backend.Options{Format: format, Width: 20}},
+		})
+		assert.Nil(t, err, contentType)
+		assert.Equal(t, contentType, file.Headers["Content-Type"])
+		assert.Equal(t, contentType, file.ContentType())
+	}
+
+	// the content type is sniffed from the extension of the files without headers
+	file, err := e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+	}, []EngineOperation{
+		{Operation: Resize, Options: &backend.Options{Format: imaging.JPEG, Width: 20}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "image/jpeg", file.Headers["Content-Type"])
+}
+
+func TestTransformErrors(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 20, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	transform := func(source []byte, options *backend.Options) error {
+		_, err := e.Transform(&image.ImageFile{
+			Source:   source,
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, []EngineOperation{{Operation: Resize, Options: options}})
+
+		return err
+	}
+
+	err = transform([]byte("not an image"), &backend.Options{Format: imaging.PNG, Width: 20})
+	assert.IsType(t, &failure.DecodeError{}, err)
+
+	err = transform(buf.Bytes(), &backend.Options{Format: imaging.PNG, Width: 20, NormalizeOrientation: "diagonal"})
+	assert.IsType(t, &failure.TransformError{}, err)
+
+	err = transform(buf.Bytes(), &backend.Options{Format: imaging.Format(42), Width: 20})
+	assert.IsType(t, &failure.EncodeError{}, err)
+	assert.True(t, errors.Is(err, imaging.ErrUnsupportedFormat))
+}
+
+func TestTransformNoopStrictImage(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 20, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	payload := []byte("<html><script>alert(1)</script></html>")
+	source := append(buf.Bytes(), payload...)
+
+	noop := func(strict bool) []byte {
+		file, err := e.Transform(&image.ImageFile{
+			Source:   source,
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, []EngineOperation{{
+			Operation: Noop,
+			Options:   &backend.Options{Format: imaging.PNG, Width: 20, StrictImage: strict},
+		}})
+		assert.Nil(t, err)
+
+		return file.Processed
+	}
+
+	assert.Equal(t, source, noop(false))
+
+	// strict images are re-encoded without being transformed
+	content := noop(true)
+	assert.False(t, bytes.Contains(content, payload))
+
+	out, err := imaging.Decode(bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.Equal(t, 40, out.Bounds().Dx())
+	assert.Equal(t, 20, out.Bounds().Dy())
+}
+
+func TestMaxSourcePixels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 20, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	img := func() *image.ImageFile {
+		return &image.ImageFile{
+			Source:   buf.Bytes(),
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}
+	}
+	operations := func() []EngineOperation {
+		return []EngineOperation{{Operation: Resize, Options: &backend.Options{Format: imaging.PNG, Width: 20}}}
+	}
+
+	e := newEngine(t, config.Config{})
+	assert.Equal(t, config.DefaultMaxSourcePixels, e.MaxSourcePixels)
+
+	e = newEngine(t, config.Config{MaxSourcePixels: 799})
+
+	_, err = e.Transform(img(), operations())
+	assert.IsType(t, &failure.DecodeError{}, err)
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+
+	_, err = e.Analyze(img())
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+
+	e = newEngine(t, config.Config{MaxSourcePixels: 800})
+	_, err = e.Transform(img(), operations())
+	assert.Nil(t, err)
+
+	// the check is disabled
+	e = newEngine(t, config.Config{MaxSourcePixels: -1})
+	_, err = e.Transform(img(), operations())
+	assert.Nil(t, err)
+}
+
+func TestTransformPipeline(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(400, 300, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	transform := func(operations ...Operation) (*image.ImageFile, []backend.Metrics) {
+		var metrics []backend.Metrics
+		engineOperations := make([]EngineOperation, len(operations))
+		for i := range operations {
+			engineOperations[i] = EngineOperation{
+				Operation: operations[i],
+				Options: &backend.Options{
+					Format: imaging.PNG,
+					Width:  200,
+					Height: 150,
+					Observer: func(m backend.Metrics) {
+						metrics = append(metrics, m)
+					},
+				},
+			}
+		}
+
+		file, err := e.Transform(&image.ImageFile{
+			Source:   buf.Bytes(),
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, engineOperations)
+		assert.Nil(t, err)
+
+		return file, metrics
+	}
+
+	// the image is decoded and encoded once
+	file, metrics := transform(Resize, Grayscale)
+	assert.Equal(t, 1, len(metrics))
+	assert.Equal(t, "pipeline", metrics[0].Operation)
+	assert.Equal(t, len(file.Processed), metrics[0].OutBytes)
+	assert.Equal(t, buf.Bytes(), file.Source)
+
+	out, err := imaging.Decode(bytes.NewReader(file.Processed))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, out.Bounds().Dx())
+	r, g, b, _ := out.At(10, 10).RGBA()
+	assert.Equal(t, r, g)
+	assert.Equal(t, r, b)
+
+	// operations which cannot be pipelined are applied one after the other
+	_, metrics = transform(Resize, DominantColor)
+	assert.Equal(t, 2, len(metrics))
+	assert.Equal(t, "resize", metrics[0].Operation)
+	assert.Equal(t, "dominantcolor", metrics[1].Operation)
+}
+
+func TestTransformPalette(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 30, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	file, err := e.Transform(&image.ImageFile{
+		Source:   buf.Bytes(),
+		Filepath: "image.png",
+		Headers:  map[string]string{"Content-Type": "image/png"},
+	}, []EngineOperation{{Operation: Palette, Options: &backend.Options{PaletteCount: 5}}})
+	assert.Nil(t, err)
+
+	// palettes aren't images
+	assert.Equal(t, JSONContentType, file.ContentType())
+	assert.Equal(t, "json", file.Format())
+	assert.JSONEq(t, `{"colors": [{"color": "#c86432", "weight": 1}]}`, string(file.Content()))
+}
+
+func TestTransformPlaceholder(t *testing.T) {
+	e := newEngine(t, config.Config{})
+
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(40, 30, color.NRGBA{200, 100, 50, 255}), imaging.PNG)
+	assert.Nil(t, err)
+
+	transform := func(mode string) *image.ImageFile {
+		file, err := e.Transform(&image.ImageFile{
+			Source:   buf.Bytes(),
+			Filepath: "image.png",
+			Headers:  map[string]string{"Content-Type": "image/png"},
+		}, []EngineOperation{{Operation: Placeholder, Options: &backend.Options{Format: imaging.PNG, PlaceholderMode: mode}}})
+		assert.Nil(t, err)
+
+		return file
+	}
+
+	// the average color placeholder is an image
+	assert.Equal(t, "image/png", transform(backend.PlaceholderAverage).ContentType())
+
+	file := transform(backend.PlaceholderBlurHash)
+	assert.Equal(t, TextContentType, file.ContentType())
+	assert.Equal(t, "txt", file.Format())
+	assert.Equal(t, "L7M|T9^4fQ^4}XoKfQoKfQfQfQfQ", string(file.Content()))
+}
+
+func TestNewFaceDetection(t *testing.T) {
+	e := newEngine(t, config.Config{
+		FaceDetection: &config.FaceDetection{Cascade: "missing.xml"},
+	})
+
+	// the crops are centered when the detector cannot be created
+	goimage := e.backends[0].backend.(*backend.GoImage)
+	assert.Nil(t, goimage.Detector)
+}
+
+func TestNewDecodeCache(t *testing.T) {
+	e := newEngine(t, config.Config{})
+	assert.Nil(t, e.backends[0].backend.(*backend.GoImage).Decoded)
+
+	e = newEngine(t, config.Config{
+		DecodeCache: &config.DecodeCache{MaxEntries: 4},
+	})
+	assert.NotNil(t, e.backends[0].backend.(*backend.GoImage).Decoded)
+}
+
+func TestNewWatermark(t *testing.T) {
+	opacity := func(v int) *int { return &v }
+
+	e := newEngine(t, config.Config{
+		Watermark: &config.Watermark{Path: "watermark.png", Opacity: opacity(128), Gravity: "se"},
+	})
+	assert.Equal(t, uint8(128), e.Watermark.Opacity)
+	assert.Equal(t, "se", e.Watermark.Gravity)
+
+	// an invalid watermark fails at startup instead of on each request
+	for _, cfg := range []*config.Watermark{
+		{Path: "watermark.png", Opacity: opacity(256)},
+		{Path: "watermark.png", Opacity: opacity(-1)},
+		{Path: "watermark.png", Gravity: "middle"},
+	} {
+		_, err := New(config.Config{Watermark: cfg}, logger.New(logger.Config{Level: logger.ProductionLevel}))
+		assert.NotNil(t, err)
+	}
+}
+
+// newEngine returns the Engine of the config, the config must be valid
+func newEngine(t *testing.T, cfg config.Config) *Engine {
+	e, err := New(cfg, logger.New(logger.Config{Level: logger.ProductionLevel}))
+	assert.Nil(t, err)
+
+	return e
+}
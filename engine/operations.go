@@ -9,23 +9,67 @@ func (o Operation) String() string {
 }
 
 const (
-	Fit       = Operation("fit")
-	Flat      = Operation("flat")
-	Flip      = Operation("flip")
-	Noop      = Operation("noop")
-	Resize    = Operation("resize")
-	Rotate    = Operation("rotate")
-	Thumbnail = Operation("thumbnail")
+	AutoContrast  = Operation("autocontrast")
+	Background    = Operation("background")
+	Blur          = Operation("blur")
+	Cover         = Operation("cover")
+	Crop          = Operation("crop")
+	CropResize    = Operation("cropresize")
+	DominantColor = Operation("dominantcolor")
+	DrawShapes    = Operation("shapes")
+	Fit           = Operation("fit")
+	Flat          = Operation("flat")
+	Flip          = Operation("flip")
+	Grayscale     = Operation("grayscale")
+	LowPoly       = Operation("lowpoly")
+	Mask          = Operation("mask")
+	Noop          = Operation("noop")
+	Palette       = Operation("palette")
+	Placeholder   = Operation("placeholder")
+	Posterize     = Operation("posterize")
+	QRCode        = Operation("qrcode")
+	Redact        = Operation("redact")
+	Resize        = Operation("resize")
+	Rotate        = Operation("rotate")
+	Sepia         = Operation("sepia")
+	Sharpen       = Operation("sharpen")
+	SQIP          = Operation("sqip")
+	Text          = Operation("text")
+	Thumbnail     = Operation("thumbnail")
+	Vibrance      = Operation("vibrance")
+	Watermark     = Operation("watermark")
 )
 
 var Operations = map[string]Operation{
-	Fit.String():       Fit,
-	Flat.String():      Flat,
-	Flip.String():      Flip,
-	Noop.String():      Noop,
-	Resize.String():    Resize,
-	Rotate.String():    Rotate,
-	Thumbnail.String(): Thumbnail,
+	AutoContrast.String():  AutoContrast,
+	Background.String():    Background,
+	Blur.String():          Blur,
+	Cover.String():         Cover,
+	Crop.String():          Crop,
+	CropResize.String():    CropResize,
+	DominantColor.String(): DominantColor,
+	DrawShapes.String():    DrawShapes,
+	Fit.String():           Fit,
+	Flat.String():          Flat,
+	Flip.String():          Flip,
+	Grayscale.String():     Grayscale,
+	LowPoly.String():       LowPoly,
+	Mask.String():          Mask,
+	Noop.String():          Noop,
+	Palette.String():       Palette,
+	Placeholder.String():   Placeholder,
+	Posterize.String():     Posterize,
+	QRCode.String():        QRCode,
+	Redact.String():        Redact,
+	Resize.String():        Resize,
+	Rotate.String():        Rotate,
+	Sepia.String():         Sepia,
+	Sharpen.String():       Sharpen,
+	SQIP.String():          SQIP,
+	Text.String():          Text,
+	Thumbnail.String():     Thumbnail,
+	Vibrance.String():      Vibrance,
+	Watermark.String():     Watermark,
 }
 
 type EngineOperation struct {
@@ -0,0 +1,20 @@
+package engine
+
+import (
+	stdimage "image"
+	"io"
+
+	"github.com/thoas/picfit/engine/backend"
+)
+
+// StreamCrop writes the region of the image read from r to w with the first
+// backend able to process images without buffering their source.
+func (e Engine) StreamCrop(r io.ReadSeeker, w io.Writer, region stdimage.Rectangle, options *backend.Options) error {
+	for i := range e.backends {
+		if streamer, ok := e.backends[i].backend.(backend.Streamer); ok {
+			return streamer.StreamCrop(r, w, region, options)
+		}
+	}
+
+	return backend.MethodNotImplementedError
+}
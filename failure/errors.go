@@ -19,4 +19,76 @@ var (
 
 	// ErrFileNotModified is an error when file is not modified
 	ErrFileNotModified = errors.New("File not modified")
+	// ErrImageTooLarge is an error when the dimensions of the source exceed the maximum
+	ErrImageTooLarge = errors.New("Image is too large")
 )
+
+// ParameterError is returned when the parameters of the request are invalid
+type ParameterError struct {
+	Err error
+}
+
+func (e *ParameterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParameterError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the wrapped error so the errors of the parameters, such as
+// a missing file, are still reported by their cause
+func (e *ParameterError) Cause() error {
+	return e.Err
+}
+
+// DecodeError is returned when the source cannot be decoded, the input is invalid
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "Unable to decode image: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TransformError is returned when an operation cannot be applied to the
+// decoded image, the operation or its parameters are invalid
+type TransformError struct {
+	Err error
+}
+
+func (e *TransformError) Error() string {
+	return "Unable to transform image: " + e.Err.Error()
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeError is returned when the transformed image cannot be encoded
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return "Unable to encode image: " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// WrapTransformError wraps err in a TransformError unless it's already
+// classified by stage
+func WrapTransformError(err error) error {
+	switch err.(type) {
+	case nil, *DecodeError, *TransformError, *EncodeError:
+		return err
+	}
+
+	return &TransformError{Err: err}
+}
@@ -31,6 +31,32 @@ func Handle(h Handler) gin.HandlerFunc {
 				c.String(http.StatusBadRequest, cerr.Error())
 			}
 
+			// errors of the image processing are mapped by stage
+			var (
+				parameterErr *ParameterError
+				decodeErr    *DecodeError
+				transformErr *TransformError
+				encodeErr    *EncodeError
+			)
+
+			switch {
+			case errors.Is(err, ErrImageTooLarge):
+				c.String(http.StatusRequestEntityTooLarge, err.Error())
+				return
+			case errors.As(err, &parameterErr):
+				c.String(http.StatusBadRequest, parameterErr.Error())
+				return
+			case errors.As(err, &decodeErr):
+				c.String(http.StatusBadRequest, decodeErr.Error())
+				return
+			case errors.As(err, &transformErr):
+				c.String(http.StatusUnprocessableEntity, transformErr.Error())
+				return
+			case errors.As(err, &encodeErr):
+				c.String(http.StatusInternalServerError, encodeErr.Error())
+				return
+			}
+
 			panic(err)
 		}
 	}
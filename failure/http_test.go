@@ -0,0 +1,44 @@
+package failure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleStageErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cause := errors.New("boom")
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&DecodeError{Err: cause}, http.StatusBadRequest},
+		{&TransformError{Err: cause}, http.StatusUnprocessableEntity},
+		{&EncodeError{Err: cause}, http.StatusInternalServerError},
+		{errors.Wrap(&DecodeError{Err: cause}, "unable to process image"), http.StatusBadRequest},
+		{errors.Wrap(WrapTransformError(cause), "unable to process image"), http.StatusUnprocessableEntity},
+		{WrapTransformError(&EncodeError{Err: cause}), http.StatusInternalServerError},
+		{errors.Wrap(&DecodeError{Err: fmt.Errorf("%w: 50000x50000", ErrImageTooLarge)}, "unable to process image"), http.StatusRequestEntityTooLarge},
+		{errors.Wrap(&ParameterError{Err: cause}, "unable to process image"), http.StatusBadRequest},
+		{errors.Wrap(&ParameterError{Err: errors.Wrap(ErrFileNotExists, "file does not exist")}, "unable to process image"), http.StatusNotFound},
+		{&ParameterError{Err: fmt.Errorf("%w: 50000x50000", ErrImageTooLarge)}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		Handle(func(*gin.Context) error {
+			return tt.err
+		})(c)
+
+		assert.Equal(t, tt.status, w.Code, "%v", tt.err)
+	}
+}
@@ -43,7 +43,10 @@ require (
 	gopkg.in/redis.v5 v5.2.9 // indirect
 )
 
-require github.com/cenkalti/dominantcolor v1.0.0
+require (
+	github.com/cenkalti/dominantcolor v1.0.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+)
 
 require (
 	cloud.google.com/go v0.81.0 // indirect
@@ -2,11 +2,16 @@ package image
 
 var (
 	Extensions = map[string]string{
-		"image/bmp":  "bmp",
-		"image/gif":  "gif",
-		"image/jpeg": "jpg",
-		"image/png":  "png",
-		"image/webp": "webp",
+		"application/json": "json",
+		"image/avif":       "avif",
+		"image/bmp":        "bmp",
+		"image/gif":        "gif",
+		"image/jpeg":       "jpg",
+		"image/png":        "png",
+		"image/svg+xml":    "svg",
+		"image/tiff":       "tiff",
+		"image/webp":       "webp",
+		"text/plain":       "txt",
 	}
 
 	HeaderKeys = []string{
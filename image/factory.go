@@ -1,14 +1,112 @@
 package image
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	stdimage "image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/ulule/gostorages"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
 
+	"github.com/thoas/picfit/hash"
 	"github.com/thoas/picfit/storage"
 )
 
+const dataURIPrefix = "data:"
+
+// IsDataURI returns true if the given value is a data URI
+func IsDataURI(value string) bool {
+	return strings.HasPrefix(value, dataURIPrefix)
+}
+
+// NewImageFile returns an ImageFile from a source after checking its header
+// can be decoded as a supported image, the format and the dimensions are
+// read from the header.
+func NewImageFile(source []byte) (*ImageFile, error) {
+	cfg, format, err := stdimage.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode image: %s", err)
+	}
+
+	mimetype := "image/" + format
+	extension, ok := Extensions[mimetype]
+	if !ok {
+		return nil, fmt.Errorf("Mimetype %s is not supported", mimetype)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("Image has invalid dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+
+	return &ImageFile{
+		Source:   source,
+		Headers:  map[string]string{"Content-Type": mimetype},
+		Filepath: fmt.Sprintf("%s.%s", hash.Tokey(string(source)), extension),
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+	}, nil
+}
+
+// FromDataURI retrieves an ImageFile from a data URI, the declared mimetype
+// must match the one sniffed from the content
+func FromDataURI(uri string) (*ImageFile, error) {
+	if !IsDataURI(uri) {
+		return nil, fmt.Errorf("%s is not a data URI", uri)
+	}
+
+	index := strings.Index(uri, ",")
+	if index == -1 {
+		return nil, fmt.Errorf("Data URI is malformed, missing data")
+	}
+
+	var (
+		metadata = strings.Split(uri[len(dataURIPrefix):index], ";")
+		data     = uri[index+1:]
+		mimetype = metadata[0]
+		content  []byte
+		err      error
+	)
+
+	if metadata[len(metadata)-1] == "base64" {
+		content, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(data)
+		content = []byte(unescaped)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Data URI is malformed: %s", err)
+	}
+
+	extension, ok := Extensions[mimetype]
+	if !ok {
+		return nil, fmt.Errorf("Mimetype %s is not supported", mimetype)
+	}
+
+	sniffed := http.DetectContentType(content)
+	if sniffed != mimetype {
+		return nil, fmt.Errorf("Mimetype %s does not match content mimetype %s", mimetype, sniffed)
+	}
+
+	file, err := NewImageFile(content)
+	if err != nil {
+		return nil, err
+	}
+	// the path depends on the content, not on how it's encoded
+	file.Filepath = fmt.Sprintf("%s.%s", hash.Tokey(string(content)), extension)
+
+	return file, nil
+}
+
 // FromURL retrieves an ImageFile from an url
 func FromURL(u *url.URL, userAgent string) (*ImageFile, error) {
 	storage := &storage.HTTPStorage{UserAgent: userAgent}
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromDataURI(t *testing.T) {
+	content, err := ioutil.ReadFile("../tests/fixtures/avatar.png")
+	assert.Nil(t, err)
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	file, err := FromDataURI("data:image/png;base64," + encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, content, file.Source)
+	assert.Equal(t, "image/png", file.ContentType())
+	assert.Equal(t, "png", file.Format())
+
+	// the filepath is the same for the encodings of the same content
+	for _, uri := range []string{
+		"data:image/png;base64," + encoded[:40] + "\r\n" + encoded[40:],
+		"data:image/png;charset=binary;base64," + encoded,
+		"data:image/png," + url.PathEscape(string(content)),
+	} {
+		other, err := FromDataURI(uri)
+		assert.Nil(t, err)
+		assert.Equal(t, file.Filepath, other.Filepath)
+	}
+
+	_, err = FromDataURI("data:image/jpeg;base64," + encoded)
+	assert.NotNil(t, err)
+
+	_, err = FromDataURI("data:image/png;base64,!!!")
+	assert.NotNil(t, err)
+
+	_, err = FromDataURI("http://example.com/avatar.png")
+	assert.NotNil(t, err)
+}
+
+func TestNewImageFile(t *testing.T) {
+	content, err := ioutil.ReadFile("../tests/fixtures/avatar.png")
+	assert.Nil(t, err)
+
+	file, err := NewImageFile(content)
+	assert.Nil(t, err)
+	assert.Equal(t, "png", file.Format())
+	assert.True(t, file.Width > 0)
+	assert.True(t, file.Height > 0)
+
+	content, err = ioutil.ReadFile("../tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	file, err = NewImageFile(content)
+	assert.Nil(t, err)
+	assert.Equal(t, "jpg", file.Format())
+	assert.Equal(t, 500, file.Width)
+	assert.Equal(t, 357, file.Height)
+
+	_, err = NewImageFile([]byte("<html><body>foo</body></html>"))
+	assert.NotNil(t, err)
+
+	_, err = NewImageFile([]byte("\x89PNG\r\n\x1a\n"))
+	assert.NotNil(t, err)
+}
@@ -11,10 +11,12 @@ import (
 type ImageFile struct {
 	Filepath  string
 	Headers   map[string]string
+	Height    int
 	Key       string
 	Processed []byte
 	Source    []byte
 	Storage   gostorages.Storage
+	Width     int
 }
 
 func (i *ImageFile) Content() []byte {
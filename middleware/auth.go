@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -44,6 +45,16 @@ func RestrictSizes(sizes []config.AllowedSize) gin.HandlerFunc {
 	handler := func(c *gin.Context, sizes []config.AllowedSize) {
 		params := c.MustGet("parameters").(map[string]interface{})
 
+		// percentages are only resolved against the source, they cannot
+		// match an allowed size
+		for _, name := range []string{"w", "h"} {
+			if value, _ := params[name].(string); strings.HasSuffix(value, "%") {
+				c.String(http.StatusForbidden, "Requested size not allowed")
+				c.Abort()
+				return
+			}
+		}
+
 		var w int
 		var h int
 		var err error
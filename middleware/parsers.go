@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/thoas/picfit/constants"
 	"github.com/thoas/picfit/engine"
+	"github.com/thoas/picfit/engine/backend"
 	"github.com/thoas/picfit/hash"
 	"github.com/thoas/picfit/image"
 	"github.com/thoas/picfit/util"
@@ -60,15 +62,9 @@ func KeyParser() gin.HandlerFunc {
 			queryString = params.(map[string]interface{})
 		}
 
-		sorted := setParamsFromURLValues(queryString, c.Request.URL.Query())
-		delete(sorted, constants.SigParamName)
-		delete(sorted, constants.ForceParamName)
-
-		if len(sorted) != 0 {
-			serialized := hash.Serialize(sorted)
-
-			key := hash.Tokey(serialized)
+		setParamsFromURLValues(queryString, c.Request.URL.Query())
 
+		if key, ok := ParametersKey(queryString); ok {
 			c.Set("key", key)
 			c.Set("parameters", queryString)
 		}
@@ -77,6 +73,91 @@ func KeyParser() gin.HandlerFunc {
 	}
 }
 
+// ParametersKey returns the unique key of the parameters, the signature
+// and the force parameters are ignored.
+func ParametersKey(params map[string]interface{}) (string, bool) {
+	sorted := util.SortMapString(params)
+	delete(sorted, constants.SigParamName)
+	delete(sorted, constants.ForceParamName)
+
+	if len(sorted) == 0 {
+		return "", false
+	}
+
+	return hash.Tokey(hash.Serialize(sorted)), true
+}
+
+// negotiatedFormats are the formats the output can be negotiated to by order
+// of preference, the output keeps its format when the client accepts none of them
+var negotiatedFormats = []string{"avif", "webp"}
+
+// negotiateFormat returns the preferred negotiated format accepted by the
+// Accept header, the formats must be explicitly accepted so wildcards are ignored.
+func negotiateFormat(accept string) (string, bool) {
+	accepted := map[string]bool{}
+	for _, value := range strings.Split(accept, ",") {
+		parts := strings.Split(value, ";")
+
+		// a zero quality value means not acceptable
+		refused := false
+		for _, param := range parts[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				quality, err := strconv.ParseFloat(q[2:], 64)
+				refused = err == nil && quality == 0
+			}
+		}
+
+		if !refused {
+			accepted[strings.ToLower(strings.TrimSpace(parts[0]))] = true
+		}
+	}
+
+	for _, format := range negotiatedFormats {
+		contentType := engine.ContentTypes[format]
+		if !accepted[contentType] {
+			continue
+		}
+
+		if _, ok := backend.ResolveFormat([]string{contentType}); ok {
+			return format, true
+		}
+	}
+
+	return "", false
+}
+
+// FormatNegotiator sets the output format of the requests without format
+// from their Accept header, the key is computed again with the format so
+// each format is cached separately and the response varies on Accept.
+func FormatNegotiator(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parameters, exists := c.Get("parameters")
+		if !enabled || !exists {
+			c.Next()
+			return
+		}
+
+		params := parameters.(map[string]interface{})
+		if _, ok := params[constants.FormatParamName]; ok {
+			c.Next()
+			return
+		}
+
+		// the response depends on Accept even when the source format is kept
+		c.Header("Vary", "Accept")
+
+		format, ok := negotiateFormat(c.GetHeader("Accept"))
+		if ok {
+			params[constants.FormatParamName] = format
+			if key, ok := ParametersKey(params); ok {
+				c.Set("key", key)
+			}
+		}
+
+		c.Next()
+	}
+}
+
 func setParamsFromURLValues(params map[string]interface{}, values url.Values) map[string]interface{} {
 	for k, v := range values {
 		if k != constants.OperationParamName {
@@ -1,9 +1,12 @@
 package middleware
 
 import (
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +34,75 @@ func TestSetParamsFromURLValues(t *testing.T) {
 	assert.Equal(t, params["op"].([]string)[0], "resize")
 	assert.Equal(t, params["op"].([]string)[1], "rotate")
 }
+
+func TestParametersKey(t *testing.T) {
+	key, ok := ParametersKey(map[string]interface{}{"url": "http://example.com/a.png", "w": "100"})
+	assert.True(t, ok)
+
+	// the variants of the device pixel ratios don't collide
+	retina, ok := ParametersKey(map[string]interface{}{"url": "http://example.com/a.png", "w": "100", "dpr": "2"})
+	assert.True(t, ok)
+	assert.NotEqual(t, key, retina)
+
+	signed, ok := ParametersKey(map[string]interface{}{"url": "http://example.com/a.png", "w": "100", "sig": "abc"})
+	assert.True(t, ok)
+	assert.Equal(t, key, signed)
+
+	_, ok = ParametersKey(map[string]interface{}{})
+	assert.False(t, ok)
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	format, ok := negotiateFormat("image/webp,image/apng,image/*,*/*;q=0.8")
+	assert.True(t, ok)
+	assert.Equal(t, "webp", format)
+
+	format, ok = negotiateFormat("Image/WebP ; q=0.5")
+	assert.True(t, ok)
+	assert.Equal(t, "webp", format)
+
+	_, ok = negotiateFormat("image/webp;q=0")
+	assert.False(t, ok)
+
+	_, ok = negotiateFormat("image/*,*/*")
+	assert.False(t, ok)
+
+	_, ok = negotiateFormat("")
+	assert.False(t, ok)
+}
+
+func TestFormatNegotiator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	negotiate := func(enabled bool, rawQuery string, accept string) (map[string]interface{}, string) {
+		res := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(res)
+		c.Request = httptest.NewRequest("GET", "/display?"+rawQuery, nil)
+		c.Request.Header.Set("Accept", accept)
+
+		KeyParser()(c)
+		FormatNegotiator(enabled)(c)
+
+		vary := ""
+		if enabled && !strings.Contains(rawQuery, "fmt=") {
+			vary = "Accept"
+		}
+		assert.Equal(t, vary, res.Header().Get("Vary"))
+
+		return c.MustGet("parameters").(map[string]interface{}), c.GetString("key")
+	}
+
+	params, key := negotiate(true, "url=http://example.com/a.png&w=100", "image/webp")
+	assert.Equal(t, "webp", params["fmt"])
+
+	_, explicit := negotiate(true, "url=http://example.com/a.png&w=100&fmt=webp", "")
+	assert.Equal(t, explicit, key)
+
+	params, source := negotiate(true, "url=http://example.com/a.png&w=100", "image/png")
+	assert.NotContains(t, params, "fmt")
+	assert.NotEqual(t, key, source)
+
+	params, disabled := negotiate(false, "url=http://example.com/a.png&w=100", "image/webp")
+	assert.NotContains(t, params, "fmt")
+	assert.Equal(t, source, disabled)
+}
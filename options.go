@@ -7,6 +7,9 @@ type Option func(*Options)
 type Options struct {
 	Async bool
 	Load  bool
+
+	// the processed images are streamed instead of being stored
+	stream bool
 }
 
 // NewOptions initializes server options.
@@ -2,8 +2,12 @@ package picfit
 
 import (
 	"fmt"
+	stdimage "image"
+	"math"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/disintegration/imaging"
 	"github.com/pkg/errors"
@@ -16,18 +20,44 @@ import (
 )
 
 const (
-	defaultDegree  = 90
-	defaultHeight  = 0
-	defaultUpscale = true
-	defaultWidth   = 0
+	defaultAutoOrient   = true
+	defaultClipPercent  = 1.0
+	defaultDegree       = 90
+	defaultHeight       = 0
+	defaultLoopCount    = -1
+	defaultPaletteCount = 5
+	defaultUpscale      = true
+	defaultWidth        = 0
+
+	maxBackgroundImageLength = 512 << 10
+	maxDPR                   = 3
+	maxLowPolyPoints         = 5000
+	maxPaletteCount          = 32
+	maxPrimitives            = 100
+	maxTextLength            = 256
+	maxTextSize              = 500
 )
 
 var formats = map[string]imaging.Format{
+	"avif": backend.AVIF,
 	"bmp":  imaging.BMP,
 	"gif":  imaging.GIF,
 	"jpeg": imaging.JPEG,
 	"jpg":  imaging.JPEG,
 	"png":  imaging.PNG,
+	"tif":  imaging.TIFF,
+	"tiff": imaging.TIFF,
+	"webp": backend.WebP,
+}
+
+// alphaFormats are the formats supporting transparency
+var alphaFormats = map[string]bool{
+	"avif": true,
+	"gif":  true,
+	"png":  true,
+	"tif":  true,
+	"tiff": true,
+	"webp": true,
 }
 
 type Parameters struct {
@@ -37,14 +67,19 @@ type Parameters struct {
 
 // newParameters returns Parameters for engine.
 func (p *Processor) NewParameters(input *image.ImageFile, qs map[string]interface{}) (*Parameters, error) {
-	format, ok := qs["fmt"].(string)
+	format, explicit := qs["fmt"].(string)
 	filepath := input.Filepath
 
-	if ok {
+	if explicit {
 		if _, ok := engine.ContentTypes[format]; !ok {
 			return nil, fmt.Errorf("Unknown format %s", format)
 		}
 
+		if format == "avif" {
+			if _, ok := backend.ResolveFormat([]string{engine.ContentTypes[format]}); !ok {
+				return nil, fmt.Errorf("Parameter \"fmt\" has wrong value, avif is not supported without the avif build tag")
+			}
+		}
 	}
 
 	if format == "" && p.engine.Format != "" {
@@ -59,23 +94,6 @@ func (p *Processor) NewParameters(input *image.ImageFile, qs map[string]interfac
 		format = p.engine.DefaultFormat
 	}
 
-	if format != input.Format() {
-		index := len(filepath) - len(input.Format())
-
-		filepath = filepath[:index] + format
-
-		if contentType, ok := engine.ContentTypes[format]; ok {
-			input.Headers["Content-Type"] = contentType
-		}
-	}
-
-	output := &image.ImageFile{
-		Source:   input.Source,
-		Key:      input.Key,
-		Headers:  input.Headers,
-		Filepath: filepath,
-	}
-
 	var operations []engine.EngineOperation
 
 	op, ok := qs["op"].(string)
@@ -119,12 +137,49 @@ func (p *Processor) NewParameters(input *image.ImageFile, qs map[string]interfac
 		}
 	}
 
+	// masks need transparency, a format without alpha channel is replaced
+	// unless it has been requested
+	if !explicit && p.engine.Format == "" && !alphaFormats[format] && hasOperation(operations, engine.Mask) {
+		format = "png"
+		for i := range operations {
+			operations[i].Options.Format = formats[format]
+		}
+	}
+
+	if format != input.Format() {
+		index := len(filepath) - len(input.Format())
+
+		filepath = filepath[:index] + format
+
+		if f, ok := formats[format]; ok {
+			input.Headers["Content-Type"] = engine.ContentType(f)
+		}
+	}
+
+	output := &image.ImageFile{
+		Source:   input.Source,
+		Key:      input.Key,
+		Headers:  input.Headers,
+		Filepath: filepath,
+	}
+
 	return &Parameters{
 		output:     output,
 		operations: operations,
 	}, nil
 }
 
+// hasOperation returns true when operation is part of operations
+func hasOperation(operations []engine.EngineOperation, operation engine.Operation) bool {
+	for i := range operations {
+		if operations[i].Operation == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (p Processor) NewEngineOperationFromQuery(op string) (*engine.EngineOperation, error) {
 	params := make(map[string]interface{})
 	var imagePaths []string
@@ -170,12 +225,15 @@ func (p Processor) NewEngineOperationFromQuery(op string) (*engine.EngineOperati
 
 func (p Processor) newBackendOptionsFromParameters(operation engine.Operation, qs map[string]interface{}) (*backend.Options, error) {
 	var (
-		err     error
-		quality = p.engine.DefaultQuality
-		upscale = defaultUpscale
-		height  = defaultHeight
-		width   = defaultWidth
-		degree  = defaultDegree
+		err       error
+		quality   = p.engine.DefaultQuality
+		upscale   = defaultUpscale
+		height    = defaultHeight
+		width     = defaultWidth
+		degree    = defaultDegree
+		loopCount = defaultLoopCount
+		vibrance  float64
+		clip      = defaultClipPercent
 	)
 
 	q, ok := qs["q"].(string)
@@ -225,28 +283,806 @@ func (p Processor) newBackendOptionsFromParameters(operation engine.Operation, q
 		}
 	}
 
+	var widthPercent, heightPercent float64
 	if w, ok := qs["w"].(string); ok {
-		width, err = strconv.Atoi(w)
+		width, widthPercent, err = parseDimension("w", w)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if h, ok := qs["h"].(string); ok {
-		height, err = strconv.Atoi(h)
+		height, heightPercent, err = parseDimension("h", h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if operation == engine.Cover && (width <= 0 && widthPercent <= 0 || height <= 0 && heightPercent <= 0) {
+		return nil, fmt.Errorf("Parameters \"w\" and \"h\" are required")
+	}
+
+	var dpr float64
+	if d, ok := qs["dpr"].(string); ok {
+		dpr, err = strconv.ParseFloat(d, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if !(dpr > 0) || math.IsInf(dpr, 1) {
+			return nil, fmt.Errorf("Parameter \"dpr\" should be a positive finite number")
+		}
+
+		dpr = math.Min(dpr, maxDPR)
+	}
+
+	var maxBytes int
+	if m, ok := qs["max_bytes"].(string); ok {
+		maxBytes, err = strconv.Atoi(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxBytes <= 0 {
+			return nil, fmt.Errorf("Parameter \"max_bytes\" should be positive")
+		}
+	}
+
+	if loop, ok := qs["loop"].(string); ok {
+		loopCount, err = strconv.Atoi(loop)
+		if err != nil {
+			return nil, err
+		}
+		if loopCount < 0 {
+			return nil, fmt.Errorf("Parameter \"loop\" should be positive")
+		}
+	}
+
+	v, ok := qs["vibrance"].(string)
+	if !ok && operation == engine.Vibrance {
+		return nil, fmt.Errorf("Parameter \"vibrance\" not found in query string")
+	}
+	if ok {
+		vibrance, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if vibrance < -100 || vibrance > 100 {
+			return nil, fmt.Errorf("Parameter \"vibrance\" should be between -100 and 100")
+		}
+	}
+
+	colorSpace, ok := qs["colorspace"].(string)
+	if ok {
+		switch colorSpace {
+		case backend.ColorSpaceGray, backend.ColorSpaceLinear, backend.ColorSpaceSRGB:
+		default:
+			return nil, fmt.Errorf("Parameter \"colorspace\" has wrong value. Available values are: %v",
+				[]string{backend.ColorSpaceSRGB, backend.ColorSpaceGray, backend.ColorSpaceLinear})
+		}
+	}
+
+	if c, ok := qs["clip"].(string); ok {
+		clip, err = strconv.ParseFloat(c, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if clip < 0 || clip >= 50 {
+			return nil, fmt.Errorf("Parameter \"clip\" should be between 0 and 50")
+		}
+	}
+
+	levels := backend.DefaultPosterizeLevels
+	if l, ok := qs["levels"].(string); ok {
+		levels, err = strconv.Atoi(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if levels < 2 || levels > 256 {
+			return nil, fmt.Errorf("Parameter \"levels\" should be between 2 and 256")
+		}
+	}
+
+	var shapes []backend.Shape
+	s, ok := qs["shapes"].(string)
+	if !ok && operation == engine.DrawShapes {
+		return nil, fmt.Errorf("Parameter \"shapes\" not found in query string")
+	}
+	if ok {
+		shapes, err = parseShapes(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var minFrameDelay time.Duration
+	if d, ok := qs["min_delay"].(string); ok {
+		delay, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, err
+		}
+
+		minFrameDelay = time.Duration(delay) * time.Millisecond
+	}
+
+	var alphaThreshold int
+	if a, ok := qs["alpha_threshold"].(string); ok {
+		alphaThreshold, err = strconv.Atoi(a)
+		if err != nil {
+			return nil, err
+		}
+
+		if alphaThreshold < 0 || alphaThreshold > 255 {
+			return nil, fmt.Errorf("Parameter \"alpha_threshold\" should be between 0 and 255")
+		}
+	}
+
+	var orientation int
+	if o, ok := qs["orientation"].(string); ok {
+		orientation, err = strconv.Atoi(o)
+		if err != nil {
+			return nil, err
+		}
+
+		if orientation < 1 || orientation > 8 {
+			return nil, fmt.Errorf("Parameter \"orientation\" should be between 1 and 8")
+		}
+	}
+
+	var regions []stdimage.Rectangle
+	if r, ok := qs["regions"].(string); ok {
+		regions, err = parseRegions("regions", r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var cropRect stdimage.Rectangle
+	c, ok := qs["crop"].(string)
+	if !ok && operation == engine.CropResize {
+		return nil, fmt.Errorf("Parameter \"crop\" not found in query string")
+	}
+	if ok {
+		rects, err := parseRegions("crop", c)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rects) != 1 {
+			return nil, fmt.Errorf("Parameter \"crop\" has wrong value %s", c)
+		}
+		cropRect = rects[0]
+	}
+
+	x, okX := qs["x"].(string)
+	y, okY := qs["y"].(string)
+	if okX != okY {
+		return nil, fmt.Errorf("Parameters \"x\" and \"y\" should be provided together")
+	}
+	if okX {
+		left, err := strconv.Atoi(x)
+		if err != nil {
+			return nil, err
+		}
+
+		top, err := strconv.Atoi(y)
+		if err != nil {
+			return nil, err
+		}
+
+		cropRect = stdimage.Rect(left, top, left+width, top+height)
+	}
+
+	gravity, ok := qs["gravity"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.CropGravities {
+			if gravity == backend.CropGravities[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"gravity\" has wrong value. Available values are: %v", backend.CropGravities)
+		}
+	}
+
+	mask, ok := qs["mask"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.Masks {
+			if mask == backend.Masks[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"mask\" has wrong value. Available values are: %v", backend.Masks)
+		}
+	}
+
+	var maskRadius int
+	if r, ok := qs["radius"].(string); ok {
+		maskRadius, err = strconv.Atoi(r)
+		if err != nil {
+			return nil, err
+		}
+		if maskRadius <= 0 {
+			return nil, fmt.Errorf("Parameter \"radius\" should be positive")
+		}
+	}
+
+	var sigma float64
+	if s, ok := qs["sigma"].(string); ok {
+		sigma, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if !(sigma > 0 && sigma <= backend.MaxSigma) {
+			return nil, fmt.Errorf("Parameter \"sigma\" should be between 0 and %d", backend.MaxSigma)
+		}
+	}
+
+	var primitives int
+	if prim, ok := qs["primitives"].(string); ok {
+		primitives, err = strconv.Atoi(prim)
+		if err != nil {
+			return nil, err
+		}
+
+		if primitives < 1 || primitives > maxPrimitives {
+			return nil, fmt.Errorf("Parameter \"primitives\" should be between 1 and %d", maxPrimitives)
+		}
+	}
+
+	var page int
+	if pg, ok := qs["page"].(string); ok {
+		page, err = strconv.Atoi(pg)
+		if err != nil {
+			return nil, err
+		}
+
+		if page < 1 {
+			return nil, fmt.Errorf("Parameter \"page\" should be greater than 0")
+		}
+	}
+
+	still, ok := qs["still"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.AnimatedToStillModes {
+			if still == backend.AnimatedToStillModes[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"still\" has wrong value. Available values are: %v", backend.AnimatedToStillModes)
+		}
+	}
+
+	var swapRB bool
+	if s, ok := qs["swap_rb"].(string); ok {
+		swapRB, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter, ok := qs["filter"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.Filters {
+			if filter == backend.Filters[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"filter\" has wrong value. Available values are: %v", backend.Filters)
+		}
+	}
+
+	qrText, ok := qs["qr"].(string)
+	if !ok && operation == engine.QRCode {
+		return nil, fmt.Errorf("Parameter \"qr\" not found in query string")
+	}
+
+	var qrSize int
+	if s, ok := qs["qr_size"].(string); ok {
+		qrSize, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if qrSize < 1 || qrSize > 100 {
+			return nil, fmt.Errorf("Parameter \"qr_size\" should be between 1 and 100")
+		}
+	}
+
+	text, ok := qs["text"].(string)
+	if !ok && operation == engine.Text {
+		return nil, fmt.Errorf("Parameter \"text\" not found in query string")
+	}
+	if utf8.RuneCountInString(text) > maxTextLength {
+		return nil, fmt.Errorf("Parameter \"text\" should be at most %d characters", maxTextLength)
+	}
+
+	textColor, ok := qs["text_color"].(string)
+	if ok {
+		if _, err := backend.Hex2RGB(backend.Hex(textColor)); err != nil {
+			return nil, err
+		}
+	}
+
+	var textSize float64
+	if s, ok := qs["text_size"].(string); ok {
+		textSize, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if !(textSize > 0 && textSize <= maxTextSize) {
+			return nil, fmt.Errorf("Parameter \"text_size\" should be between 0 and %d", maxTextSize)
+		}
+	}
+
+	paletteCount := defaultPaletteCount
+	if c, ok := qs["count"].(string); ok {
+		paletteCount, err = strconv.Atoi(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if paletteCount < 1 || paletteCount > maxPaletteCount {
+			return nil, fmt.Errorf("Parameter \"count\" should be between 1 and %d", maxPaletteCount)
+		}
+	}
+
+	placeholder, ok := qs["placeholder"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.Placeholders {
+			if placeholder == backend.Placeholders[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"placeholder\" has wrong value. Available values are: %v", backend.Placeholders)
+		}
+	}
+
+	var optimize bool
+	if o, ok := qs["optimize"].(string); ok {
+		optimize, err = strconv.ParseBool(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var autoRotate bool
+	if a, ok := qs["auto_rotate"].(string); ok {
+		autoRotate, err = strconv.ParseBool(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fixAlphaBleed bool
+	if f, ok := qs["fix_alpha_bleed"].(string); ok {
+		fixAlphaBleed, err = strconv.ParseBool(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var deterministic bool
+	if d, ok := qs["deterministic"].(string); ok {
+		deterministic, err = strconv.ParseBool(d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lossless bool
+	if l, ok := qs["lossless"].(string); ok {
+		lossless, err = strconv.ParseBool(l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gifPalette, ok := qs["palette"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.GIFPalettes {
+			if gifPalette == backend.GIFPalettes[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"palette\" has wrong value. Available values are: %v", backend.GIFPalettes)
+		}
+	}
+
+	autoOrient := defaultAutoOrient
+	if a, ok := qs["auto_orient"].(string); ok {
+		autoOrient, err = strconv.ParseBool(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var progressive bool
+	if pr, ok := qs["progressive"].(string); ok {
+		progressive, err = strconv.ParseBool(pr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compression := p.engine.PNGCompression
+	if c, ok := qs["compression"].(string); ok {
+		level, ok := backend.CompressionLevels[c]
+		if !ok {
+			return nil, fmt.Errorf("Parameter \"compression\" has wrong value. Available values are: %v", []string{
+				backend.CompressionBest,
+				backend.CompressionDefault,
+				backend.CompressionNone,
+				backend.CompressionSpeed,
+			})
+		}
+		compression = level
+	}
+
+	var avifSpeed int
+	if s, ok := qs["avif_speed"].(string); ok {
+		avifSpeed, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if avifSpeed < 1 || avifSpeed > 10 {
+			return nil, fmt.Errorf("Parameter \"avif_speed\" should be between 1 and 10")
+		}
+	}
+
+	watermarkURL, ok := qs["watermark_url"].(string)
+	if !ok && operation == engine.Watermark {
+		return nil, fmt.Errorf("Parameter \"watermark_url\" not found in query string")
+	}
+
+	background, ok := qs["bg"].(string)
+	if ok {
+		if _, err := backend.Hex2RGB(backend.Hex(background)); err != nil {
+			return nil, err
+		}
+	}
+
+	// masks saved as JPEG, which doesn't support transparency, are flattened
+	// on the background
+	if operation == engine.Mask && background == "" {
+		format, ok := qs["fmt"].(string)
+		if !ok {
+			format = p.engine.Format
+		}
+
+		if f, ok := formats[format]; ok && f == imaging.JPEG {
+			return nil, fmt.Errorf("Parameter \"bg\" is required to mask an image saved as %s", format)
+		}
+	}
+
+	var points int
+	if pts, ok := qs["points"].(string); ok {
+		points, err = strconv.Atoi(pts)
+		if err != nil {
+			return nil, err
+		}
+
+		if points < 1 || points > maxLowPolyPoints {
+			return nil, fmt.Errorf("Parameter \"points\" should be between 1 and %d", maxLowPolyPoints)
+		}
+	}
+
+	edge, ok := qs["edge"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.RotateEdgeModes {
+			if edge == backend.RotateEdgeModes[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"edge\" has wrong value. Available values are: %v", backend.RotateEdgeModes)
+		}
+	}
+
+	var backgroundImage []byte
+	if uri, ok := qs["bg_image"].(string); ok {
+		if len(uri) > maxBackgroundImageLength {
+			return nil, fmt.Errorf("Parameter \"bg_image\" should be at most %d bytes", maxBackgroundImageLength)
+		}
+
+		file, err := image.FromDataURI(uri)
 		if err != nil {
 			return nil, err
 		}
+
+		// the header is checked before the image is decoded by the backend
+		if max := p.engine.MaxSourcePixels; max > 0 && int64(file.Width)*int64(file.Height) > int64(max) {
+			return nil, fmt.Errorf("%w: background image %dx%d exceeds %d pixels",
+				failure.ErrImageTooLarge, file.Width, file.Height, max)
+		}
+		backgroundImage = file.Source
+	}
+
+	backgroundMode, ok := qs["bg_mode"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.BackgroundModes {
+			if backgroundMode == backend.BackgroundModes[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"bg_mode\" has wrong value. Available values are: %v", backend.BackgroundModes)
+		}
+	}
+
+	normalizeOrientation, ok := qs["normalize_orientation"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.Orientations {
+			if normalizeOrientation == backend.Orientations[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"normalize_orientation\" has wrong value. Available values are: %v", backend.Orientations)
+		}
+	}
+
+	blend, ok := qs["watermark_blend"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.WatermarkBlends {
+			if blend == backend.WatermarkBlends[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"watermark_blend\" has wrong value. Available values are: %v", backend.WatermarkBlends)
+		}
+	}
+
+	watermarkGravity, ok := qs["watermark_gravity"].(string)
+	if ok {
+		var exists bool
+		for i := range backend.WatermarkGravities {
+			if watermarkGravity == backend.WatermarkGravities[i] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("Parameter \"watermark_gravity\" has wrong value. Available values are: %v", backend.WatermarkGravities)
+		}
+	}
+
+	var watermarkMargin stdimage.Point
+	if m, ok := qs["watermark_margin_x"].(string); ok {
+		watermarkMargin.X, err = strconv.Atoi(m)
+		if err != nil {
+			return nil, err
+		}
+		if watermarkMargin.X < 0 {
+			return nil, fmt.Errorf("Parameter \"watermark_margin_x\" should be positive")
+		}
+	}
+
+	if m, ok := qs["watermark_margin_y"].(string); ok {
+		watermarkMargin.Y, err = strconv.Atoi(m)
+		if err != nil {
+			return nil, err
+		}
+		if watermarkMargin.Y < 0 {
+			return nil, fmt.Errorf("Parameter \"watermark_margin_y\" should be positive")
+		}
+	}
+
+	var watermarkTile bool
+	if tile, ok := qs["watermark_tile"].(string); ok {
+		watermarkTile, err = strconv.ParseBool(tile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var watermarkSpacing int
+	if s, ok := qs["watermark_spacing"].(string); ok {
+		watermarkSpacing, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		if watermarkSpacing < 0 {
+			return nil, fmt.Errorf("Parameter \"watermark_spacing\" should be positive")
+		}
 	}
 
 	return &backend.Options{
-		Width:    width,
-		Height:   height,
-		Upscale:  upscale,
-		Position: position,
-		Stick:    stick,
-		Quality:  quality,
-		Degree:   degree,
-		Color:    color,
+		Width:                width,
+		Height:               height,
+		WidthPercent:         widthPercent,
+		HeightPercent:        heightPercent,
+		DPR:                  dpr,
+		Upscale:              upscale,
+		Position:             position,
+		Stick:                stick,
+		Quality:              quality,
+		MaxBytes:             maxBytes,
+		Degree:               degree,
+		Color:                color,
+		ColorSpace:           colorSpace,
+		LoopCount:            loopCount,
+		Vibrance:             vibrance,
+		Background:           background,
+		RotateEdgeMode:       edge,
+		LowPolyPoints:        points,
+		ClipPercent:          clip,
+		Shapes:               shapes,
+		MinFrameDelay:        minFrameDelay,
+		AlphaThreshold:       alphaThreshold,
+		JPEGOptimize:         optimize,
+		WatermarkURL:         watermarkURL,
+		ForceOrientation:     orientation,
+		RedactRegions:        regions,
+		Sigma:                sigma,
+		Primitives:           primitives,
+		Page:                 page,
+		PaletteCount:         paletteCount,
+		PlaceholderMode:      placeholder,
+		AnimatedToStill:      still,
+		SwapRB:               swapRB,
+		Filter:               filter,
+		QRCodeText:           qrText,
+		QRCodeSize:           qrSize,
+		Text:                 text,
+		TextColor:            textColor,
+		TextSize:             textSize,
+		Deterministic:        deterministic,
+		AutoRotateContent:    autoRotate,
+		WatermarkBlend:       blend,
+		WatermarkGravity:     watermarkGravity,
+		WatermarkMargin:      watermarkMargin,
+		WatermarkTile:        watermarkTile,
+		WatermarkSpacing:     watermarkSpacing,
+		PosterizeLevels:      levels,
+		BackgroundImage:      backgroundImage,
+		BackgroundMode:       backgroundMode,
+		CropRect:             cropRect,
+		CropGravity:          gravity,
+		Mask:                 mask,
+		MaskRadius:           maskRadius,
+		FixAlphaBleed:        fixAlphaBleed,
+		NormalizeOrientation: normalizeOrientation,
+		Lossless:             lossless,
+		AVIFSpeed:            avifSpeed,
+		CompressionLevel:     compression,
+		Progressive:          progressive,
+		GIFPalette:           gifPalette,
+		AutoOrient:           autoOrient,
+
+		GIFMaxPixelsPerFrame: p.engine.GIFMaxPixelsPerFrame,
+		RejectTrailingData:   p.engine.RejectTrailingData,
+		SnapWidths:           p.engine.SnapWidths,
+		StrictImage:          p.engine.StrictImage,
+		Watermark:            p.engine.Watermark,
 	}, nil
 }
+
+// parseDimension parses a dimension in pixels or, with a "%" suffix, in
+// percentage of the source dimension
+func parseDimension(name string, value string) (int, float64, error) {
+	if !strings.HasSuffix(value, "%") {
+		pixels, err := strconv.Atoi(value)
+		return pixels, 0, err
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !(percent > 0 && percent <= 100) {
+		return 0, 0, fmt.Errorf("Parameter \"%s\" should be a percentage in (0, 100]", name)
+	}
+
+	return 0, percent, nil
+}
+
+// parseShapes parses shapes separated by "|", a shape is defined as
+// rect,{x},{y},{width},{height},{color} or rounded,{x},{y},{width},{height},{radius},{color}
+func parseShapes(value string) ([]backend.Shape, error) {
+	var shapes []backend.Shape
+
+	for _, raw := range strings.Split(value, "|") {
+		fields := strings.Split(raw, ",")
+
+		var size int
+		switch fields[0] {
+		case backend.ShapeRect:
+			size = 6
+		case backend.ShapeRounded:
+			size = 7
+		default:
+			return nil, fmt.Errorf("Parameter \"shapes\" has wrong value. Available shapes are: %v",
+				[]string{backend.ShapeRect, backend.ShapeRounded})
+		}
+
+		if len(fields) != size {
+			return nil, fmt.Errorf("Parameter \"shapes\" has wrong value %s", raw)
+		}
+
+		values := make([]int, size-2)
+		for i := range values {
+			v, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		shape := backend.Shape{
+			Kind:   fields[0],
+			X:      values[0],
+			Y:      values[1],
+			Width:  values[2],
+			Height: values[3],
+			Color:  fields[size-1],
+		}
+
+		if shape.Kind == backend.ShapeRounded {
+			shape.Radius = values[4]
+		}
+
+		shapes = append(shapes, shape)
+	}
+
+	return shapes, nil
+}
+
+// parseRegions parses rectangles separated by "|", a rectangle is defined as
+// {x},{y},{width},{height}
+func parseRegions(name string, value string) ([]stdimage.Rectangle, error) {
+	var regions []stdimage.Rectangle
+
+	for _, raw := range strings.Split(value, "|") {
+		fields := strings.Split(raw, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("Parameter \"%s\" has wrong value %s", name, raw)
+		}
+
+		values := make([]int, len(fields))
+		for i := range fields {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		regions = append(regions, stdimage.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3]))
+	}
+
+	return regions, nil
+}
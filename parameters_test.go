@@ -1,9 +1,21 @@
 package picfit_test
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/color"
+	"strings"
 	"testing"
 
+	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/thoas/picfit"
+	"github.com/thoas/picfit/config"
+	"github.com/thoas/picfit/engine/backend"
+	"github.com/thoas/picfit/failure"
+	"github.com/thoas/picfit/image"
 	"github.com/thoas/picfit/tests"
 )
 
@@ -20,3 +32,222 @@ func TestEngineOperationFromQuery(t *testing.T) {
 	assert.Equal(t, operation.Options.Quality, 99)
 	assert.True(t, operation.Options.Upscale)
 }
+
+func TestEngineOperationFromQueryDPR(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:resize w:100 h:50 dpr:2")
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, operation.Options.DPR)
+
+	// the ratio is clamped to its maximum
+	operation, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:50 dpr:10")
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, operation.Options.DPR)
+
+	for _, dpr := range []string{"0", "NaN", "Inf", "-Inf"} {
+		_, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:50 dpr:" + dpr)
+		assert.NotNil(t, err, dpr)
+	}
+}
+
+func TestEngineOperationFromQueryPercentages(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:resize w:50% h:12.5%")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, operation.Options.Width)
+	assert.Equal(t, 50.0, operation.Options.WidthPercent)
+	assert.Equal(t, 12.5, operation.Options.HeightPercent)
+
+	operation, err = processor.NewEngineOperationFromQuery("op:resize w:100% h:50")
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, operation.Options.WidthPercent)
+
+	for _, percent := range []string{"0", "-10", "100.5", "1e300", "NaN", "Inf", "+Inf", "-Inf"} {
+		_, err = processor.NewEngineOperationFromQuery("op:resize w:" + percent + "% h:50")
+		assert.NotNil(t, err, percent)
+	}
+
+	_, err = processor.NewEngineOperationFromQuery("op:resize w:abc% h:50")
+	assert.NotNil(t, err)
+}
+
+func TestEngineOperationFromQueryAutoOrient(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	// the EXIF orientation is applied by default
+	operation, err := processor.NewEngineOperationFromQuery("op:resize w:100 h:100")
+	assert.Nil(t, err)
+	assert.True(t, operation.Options.AutoOrient)
+
+	operation, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:100 auto_orient:false")
+	assert.Nil(t, err)
+	assert.False(t, operation.Options.AutoOrient)
+
+	_, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:100 auto_orient:maybe")
+	assert.NotNil(t, err)
+}
+
+func TestEngineOperationFromQueryMaxBytes(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:resize w:100 h:50 max_bytes:20000")
+	assert.Nil(t, err)
+	assert.Equal(t, 20000, operation.Options.MaxBytes)
+
+	_, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:50 max_bytes:0")
+	assert.NotNil(t, err)
+}
+
+func TestEngineOperationFromQueryCover(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:cover w:100 h:50 gravity:top-left")
+	assert.Nil(t, err)
+	assert.Equal(t, "cover", operation.Operation.String())
+	assert.Equal(t, "top-left", operation.Options.CropGravity)
+
+	_, err = processor.NewEngineOperationFromQuery("op:cover w:100")
+	assert.NotNil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:cover h:100")
+	assert.NotNil(t, err)
+}
+
+func TestEngineOperationFromQueryMask(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:mask mask:rounded radius:12")
+	assert.Nil(t, err)
+	assert.Equal(t, "mask", operation.Operation.String())
+	assert.Equal(t, "rounded", operation.Options.Mask)
+	assert.Equal(t, 12, operation.Options.MaskRadius)
+
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:star")
+	assert.NotNil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:rounded radius:0")
+	assert.NotNil(t, err)
+
+	// JPEG doesn't support transparency, a background is required
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:circle fmt:jpg")
+	assert.NotNil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:circle fmt:jpg bg:fff")
+	assert.Nil(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Engine.Format = "jpeg"
+	processor, err = picfit.NewProcessor(cfg)
+	assert.Nil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:circle")
+	assert.NotNil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:mask mask:circle fmt:png")
+	assert.Nil(t, err)
+}
+
+func TestEngineOperationFromQuerySigma(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:blur sigma:100")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(100), operation.Options.Sigma)
+
+	for _, sigma := range []string{"0", "NaN", "Inf", "1e12", "1e300"} {
+		_, err = processor.NewEngineOperationFromQuery("op:blur sigma:" + sigma)
+		assert.NotNil(t, err, sigma)
+	}
+}
+
+func TestEngineOperationFromQueryLoop(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	// the loop count of the source is kept by default
+	operation, err := processor.NewEngineOperationFromQuery("op:resize w:100 h:100")
+	assert.Nil(t, err)
+	assert.Equal(t, -1, operation.Options.LoopCount)
+
+	operation, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:100 loop:0")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, operation.Options.LoopCount)
+
+	_, err = processor.NewEngineOperationFromQuery("op:resize w:100 h:100 loop:-1")
+	assert.NotNil(t, err)
+}
+
+func TestEngineOperationFromQueryBackground(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:rotate deg:45 bg:#f00")
+	assert.Nil(t, err)
+	assert.Equal(t, "#f00", operation.Options.Background)
+
+	for _, bg := range []string{"red", "ff00", "gg0000", ""} {
+		_, err = processor.NewEngineOperationFromQuery("op:rotate deg:45 bg:" + bg)
+		assert.NotNil(t, err, bg)
+	}
+}
+
+func TestEngineOperationFromQueryText(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+
+	operation, err := processor.NewEngineOperationFromQuery("op:text text:" + strings.Repeat("é", 256))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("é", 256), operation.Options.Text)
+
+	_, err = processor.NewEngineOperationFromQuery("op:text text:" + strings.Repeat("a", 257))
+	assert.NotNil(t, err)
+
+	_, err = processor.NewEngineOperationFromQuery("op:text text:a text_size:NaN")
+	assert.NotNil(t, err)
+}
+
+func TestNewParametersBackgroundImage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := imaging.Encode(buf, imaging.New(20, 20, color.White), imaging.PNG)
+	assert.Nil(t, err)
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	cfg := config.DefaultConfig()
+	cfg.Engine.MaxSourcePixels = 400
+	processor, err := picfit.NewProcessor(cfg)
+	assert.Nil(t, err)
+
+	input := func() *image.ImageFile {
+		return &image.ImageFile{Source: buf.Bytes(), Filepath: "image.png", Headers: map[string]string{}}
+	}
+
+	_, err = processor.NewParameters(input(), map[string]interface{}{"op": "background", "bg_image": uri})
+	assert.Nil(t, err)
+
+	// the pixels of the background image are limited like the ones of the sources
+	cfg.Engine.MaxSourcePixels = 399
+	processor, err = picfit.NewProcessor(cfg)
+	assert.Nil(t, err)
+
+	_, err = processor.NewParameters(input(), map[string]interface{}{"op": "background", "bg_image": uri})
+	assert.True(t, errors.Is(err, failure.ErrImageTooLarge))
+
+	_, err = processor.NewParameters(input(), map[string]interface{}{
+		"op":       "background",
+		"bg_image": uri + strings.Repeat("A", 512<<10),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestNewParametersAVIF(t *testing.T) {
+	processor := tests.NewDummyProcessor()
+	input := &image.ImageFile{Filepath: "image.png", Headers: map[string]string{}}
+
+	_, err := processor.NewParameters(input, map[string]interface{}{"op": "resize", "w": "10", "fmt": "avif"})
+
+	// avif is only accepted when picfit is built with the avif tag
+	if _, ok := backend.ResolveFormat([]string{"image/avif"}); ok {
+		assert.Nil(t, err)
+	} else {
+		assert.NotNil(t, err)
+	}
+}
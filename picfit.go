@@ -25,7 +25,10 @@ func NewProcessor(cfg *config.Config) (*Processor, error) {
 		return nil, err
 	}
 
-	e := engine.New(*cfg.Engine, log.With(logger.String("logger", "engine")))
+	e, err := engine.New(*cfg.Engine, log.With(logger.String("logger", "engine")))
+	if err != nil {
+		return nil, err
+	}
 
 	log.Debug("Image engine configured",
 		logger.String("engine", e.String()))
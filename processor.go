@@ -2,11 +2,16 @@ package picfit
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	conv "github.com/cstockton/go-conv"
 	"github.com/gin-gonic/gin"
@@ -15,14 +20,25 @@ import (
 
 	"github.com/thoas/picfit/config"
 	"github.com/thoas/picfit/engine"
+	"github.com/thoas/picfit/engine/backend"
 	"github.com/thoas/picfit/failure"
 	"github.com/thoas/picfit/hash"
 	"github.com/thoas/picfit/image"
 	"github.com/thoas/picfit/logger"
+	"github.com/thoas/picfit/middleware"
 	"github.com/thoas/picfit/payload"
 	"github.com/thoas/picfit/store"
 )
 
+// Metadata describes a source image read from its header
+type Metadata struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+	Bytes    int    `json:"bytes"`
+	HasAlpha bool   `json:"has_alpha"`
+}
+
 type Processor struct {
 	config             *config.Config
 	destinationStorage gostorages.Storage
@@ -205,51 +221,104 @@ func (p *Processor) Delete(filepath string) error {
 
 // ProcessContext processes a gin.Context generates and retrieves an ImageFile
 func (p *Processor) ProcessContext(c *gin.Context, opts ...Option) (*image.ImageFile, error) {
+	file, _, err := p.processContext(c, newOptions(opts...))
+	return file, err
+}
+
+// StreamContext processes a gin.Context like ProcessContext without storing
+// the processed images, the stored images are loaded. The images which can
+// be processed by a pipeline are returned with an encoder writing them
+// straight to the response instead of being buffered.
+func (p *Processor) StreamContext(c *gin.Context) (*image.ImageFile, backend.Encoder, error) {
+	return p.processContext(c, Options{Load: true, stream: true})
+}
+
+func (p *Processor) processContext(c *gin.Context, options Options) (*image.ImageFile, backend.Encoder, error) {
+	if err := p.resolvePercentages(c); err != nil {
+		return nil, nil, err
+	}
+
+	clampDPR(c)
+
 	var (
 		storeKey = c.MustGet("key").(string)
 		force    = c.Query("force")
-		options  = newOptions(opts...)
 	)
 
-	modifiedSince := c.Request.Header.Get("If-Modified-Since")
-	if modifiedSince != "" && force == "" {
-		exists, err := p.store.Exists(storeKey)
+	var (
+		modified = p.sourceModifiedTime(c)
+		etag     = ETag(storeKey, modified)
+	)
+
+	// the not modified responses keep the validators of the image
+	c.Set("etag", etag)
+
+	if force == "" {
+		notModified, err := p.notModified(c.Request, storeKey, etag, modified)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		if exists {
-			p.logger.Info("Key already exists on store, file not modified",
-				logger.String("key", storeKey),
-				logger.String("modified-since", modifiedSince))
-
-			return nil, failure.ErrFileNotModified
+		if notModified {
+			return nil, nil, failure.ErrFileNotModified
 		}
 	}
 
+	file, encoder, err := p.retrieve(c, storeKey, force, modified, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the image is as recent as its source, the header is omitted when the
+	// modification time of the source is unknown
+	if file.Headers == nil {
+		file.Headers = map[string]string{}
+	}
+	if modified.IsZero() {
+		delete(file.Headers, "Last-Modified")
+	} else {
+		file.Headers["Last-Modified"] = modified.UTC().Format(http.TimeFormat)
+	}
+	file.Headers["ETag"] = etag
+
+	return file, encoder, nil
+}
+
+// retrieve returns the image stored with the key or processes it, stored
+// images older than their source modified at the given time are processed
+// again.
+func (p *Processor) retrieve(c *gin.Context, storeKey string, force string, modified time.Time, options Options) (*image.ImageFile, backend.Encoder, error) {
 	if force == "" {
 		// try to retrieve image from the k/v rtore
 		filepathRaw, err := p.store.Get(storeKey)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if filepathRaw != nil {
 			filepath, err := conv.String(filepathRaw)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			p.logger.Info("Key found in store",
 				logger.String("key", storeKey),
 				logger.String("filepath", filepath))
 
+			if p.stale(filepath, modified) {
+				p.logger.Info("Source modified since the key was stored, key will be re-processed",
+					logger.String("key", storeKey),
+					logger.String("filepath", filepath))
+
+				return p.processImage(c, storeKey, options)
+			}
+
 			img, err := p.fileFromStorage(storeKey, filepath, options.Load)
 			//no such file, just reprocess (maybe file cache was purged)
 			if err != nil && os.IsNotExist(err) {
-				return p.processImage(c, storeKey, options.Async)
+				return p.processImage(c, storeKey, options)
 			}
-			return img, err
+			return img, nil, err
 		}
 
 		// Image not found from the Store, we need to process it
@@ -261,7 +330,160 @@ func (p *Processor) ProcessContext(c *gin.Context, opts ...Option) (*image.Image
 			logger.String("key", storeKey))
 	}
 
-	return p.processImage(c, storeKey, options.Async)
+	return p.processImage(c, storeKey, options)
+}
+
+// resolvePercentages replaces the dimensions of the parameters expressed in
+// percentage of the source by pixels, so the key of an image doesn't depend
+// on how its dimensions are expressed.
+func (p *Processor) resolvePercentages(c *gin.Context) error {
+	qs, _ := c.Get("parameters")
+	params, ok := qs.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var metadata *Metadata
+	for _, name := range []string{"w", "h"} {
+		value, _ := params[name].(string)
+		if !strings.HasSuffix(value, "%") {
+			continue
+		}
+
+		_, percent, err := parseDimension(name, value)
+		if err != nil {
+			return &failure.ParameterError{Err: err}
+		}
+
+		// the dimensions of the source are cached in the k/v store
+		if metadata == nil {
+			metadata, err = p.Metadata(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		size := metadata.Width
+		if name == "h" {
+			size = metadata.Height
+		}
+
+		params[name] = strconv.Itoa(engine.PercentOf(size, percent))
+	}
+
+	if metadata == nil {
+		return nil
+	}
+
+	if key, ok := middleware.ParametersKey(params); ok {
+		c.Set("key", key)
+	}
+
+	return nil
+}
+
+// clampDPR replaces the pixel ratio of the parameters above the maximum by the
+// maximum, so the key of an image doesn't depend on the ratio it's clamped from.
+// Invalid ratios are kept and rejected with the other parameters.
+func clampDPR(c *gin.Context) {
+	qs, _ := c.Get("parameters")
+	params, ok := qs.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	value, _ := params["dpr"].(string)
+	dpr, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(dpr) || math.IsInf(dpr, 1) || dpr <= maxDPR {
+		return
+	}
+
+	params["dpr"] = strconv.Itoa(maxDPR)
+
+	if key, ok := middleware.ParametersKey(params); ok {
+		c.Set("key", key)
+	}
+}
+
+// notModified returns true when the image stored with the key matches the
+// conditional headers of the request: If-None-Match lists the etag or its
+// source wasn't modified since the If-Modified-Since date. When the
+// modification time of the source is unknown, an image is not modified
+// since it has been stored.
+func (p *Processor) notModified(r *http.Request, key string, etag string, modified time.Time) (bool, error) {
+	var (
+		noneMatch     = r.Header.Get("If-None-Match")
+		modifiedSince = r.Header.Get("If-Modified-Since")
+	)
+
+	switch {
+	case noneMatch != "":
+		// If-Modified-Since is ignored when If-None-Match is sent
+		if !etagMatches(noneMatch, etag) {
+			return false, nil
+		}
+	case modifiedSince != "":
+		if !modified.IsZero() {
+			since, err := http.ParseTime(modifiedSince)
+			// the header has a precision of a second
+			if err != nil || modified.Truncate(time.Second).After(since) {
+				return false, nil
+			}
+		}
+	default:
+		return false, nil
+	}
+
+	exists, err := p.store.Exists(key)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		p.logger.Info("Key already exists on store, file not modified",
+			logger.String("key", key),
+			logger.String("if-none-match", noneMatch),
+			logger.String("modified-since", modifiedSince))
+	}
+
+	return exists, nil
+}
+
+// sourceModifiedTime returns the modification time of the source image of
+// the context from the source storage, it's zero when it's unknown.
+func (p *Processor) sourceModifiedTime(c *gin.Context) time.Time {
+	if _, exists := c.Get("url"); exists {
+		return time.Time{}
+	}
+
+	qs, _ := c.Get("parameters")
+	params, _ := qs.(map[string]interface{})
+	filepath, _ := params["path"].(string)
+	if filepath == "" {
+		return time.Time{}
+	}
+
+	modified, err := p.sourceStorage.ModifiedTime(filepath)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return modified
+}
+
+// stale returns true when the image stored in the destination storage at
+// the filepath is older than its source modified at the given time.
+func (p *Processor) stale(filepath string, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+
+	stored, err := p.destinationStorage.ModifiedTime(filepath)
+	if err != nil {
+		return false
+	}
+
+	return stored.Before(modified)
 }
 
 func (p *Processor) fileFromStorage(key string, filepath string, load bool) (*image.ImageFile, error) {
@@ -280,68 +502,241 @@ func (p *Processor) fileFromStorage(key string, filepath string, load bool) (*im
 		if err != nil {
 			return nil, err
 		}
+		file.Key = key
 	}
 
-	file.Headers["ETag"] = key
 	return file, nil
 }
 
-func (p *Processor) processImage(c *gin.Context, storeKey string, async bool) (*image.ImageFile, error) {
-	var (
-		filepath string
-		err      error
-	)
-
-	file := &image.ImageFile{
-		Key:     storeKey,
-		Storage: p.destinationStorage,
-		Headers: map[string]string{},
+// sourceFile retrieves the source image of the context from its URL or from
+// the source storage, the filepath is empty for URLs.
+func (p *Processor) sourceFile(c *gin.Context) (*image.ImageFile, string, error) {
+	u, exists := c.Get("url")
+	if exists {
+		file, err := image.FromURL(u.(*url.URL), p.config.Options.DefaultUserAgent)
+		return file, "", err
 	}
 
 	qs := c.MustGet("parameters").(map[string]interface{})
 
-	u, exists := c.Get("url")
-	if exists {
-		file, err = image.FromURL(u.(*url.URL), p.config.Options.DefaultUserAgent)
-	} else {
-		// URL provided we use http protocol to retrieve it
-		filepath = qs["path"].(string)
-		if !p.sourceStorage.Exists(filepath) {
-			return nil, errors.Wrapf(failure.ErrFileNotExists, "unable to process image, file does exist: %s", filepath)
-		}
-
-		file, err = image.FromStorage(p.sourceStorage, filepath)
+	// URL provided we use http protocol to retrieve it
+	filepath, _ := qs["path"].(string)
+	if !p.sourceStorage.Exists(filepath) {
+		return nil, filepath, errors.Wrapf(failure.ErrFileNotExists, "unable to process image, file does exist: %s", filepath)
 	}
+
+	file, err := image.FromStorage(p.sourceStorage, filepath)
+	return file, filepath, err
+}
+
+// processImage processes the source image of the context and stores it, the
+// streamed images aren't stored and are returned with their encoder when
+// they can be processed by a pipeline.
+func (p *Processor) processImage(c *gin.Context, storeKey string, options Options) (*image.ImageFile, backend.Encoder, error) {
+	qs := c.MustGet("parameters").(map[string]interface{})
+
+	file, filepath, err := p.sourceFile(c)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to process image")
+		return nil, nil, errors.Wrap(err, "unable to process image")
 	}
 
 	parameters, err := p.NewParameters(file, qs)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to process image")
+		return nil, nil, errors.Wrap(&failure.ParameterError{Err: err}, "unable to process image")
+	}
+
+	if options.stream {
+		encoder, err := p.engine.Encoder(parameters.output, parameters.operations)
+		if err == nil {
+			parameters.output.Key = storeKey
+			return parameters.output, encoder, nil
+		}
+		if err != backend.ErrNotPipelinable {
+			return nil, nil, errors.Wrap(err, "unable to process image")
+		}
 	}
 
 	file, err = p.engine.Transform(parameters.output, parameters.operations)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to process image")
+		return nil, nil, errors.Wrap(err, "unable to process image")
+	}
+
+	file.Key = storeKey
+	if options.stream {
+		return file, nil, nil
 	}
 
 	filename := p.ShardFilename(storeKey)
 	file.Filepath = fmt.Sprintf("%s.%s", filename, file.Format())
 	file.Storage = p.destinationStorage
-	file.Key = storeKey
-	file.Headers["ETag"] = storeKey
 
-	if async == true {
+	if options.Async == true {
 		go p.Store(filepath, file)
 	} else {
 		err = p.Store(filepath, file)
 		if err != nil {
-			return nil, errors.Wrapf(err, "unable to store processed image: %s", filepath)
+			return nil, nil, errors.Wrapf(err, "unable to store processed image: %s", filepath)
 		}
 	}
 
-	return file, nil
+	return file, nil, nil
+}
+
+// DominantColor returns the dominant color of the source image of the context
+// as a lowercase hex color, colors are cached in the store by content of
+// the source so they follow the changes of the source.
+func (p *Processor) DominantColor(c *gin.Context) (string, error) {
+	file, key, err := p.sourceContent(c)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to find dominant color")
+	}
+
+	key = fmt.Sprintf("%s:dominant", key)
+
+	raw, err := p.store.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	if raw != nil {
+		p.logger.Info("Dominant color found in store",
+			logger.String("key", key))
+
+		return conv.String(raw)
+	}
+
+	analysis, err := p.engine.Analyze(file)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to find dominant color")
+	}
+
+	color := strings.ToLower(analysis.DominantColor)
+
+	err = p.store.Set(key, color)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to store dominant color %s", key)
+	}
+
+	return color, nil
+}
+
+// Metadata returns the metadata of the source image of the context without
+// decoding its pixels, metadata are cached in the store by content of the
+// source.
+func (p *Processor) Metadata(c *gin.Context) (*Metadata, error) {
+	file, key, err := p.sourceContent(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve metadata")
+	}
+
+	key = fmt.Sprintf("%s:metadata", key)
+
+	raw, err := p.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &Metadata{}
+
+	if raw != nil {
+		p.logger.Info("Metadata found in store",
+			logger.String("key", key))
+
+		content, err := conv.String(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return metadata, json.Unmarshal([]byte(content), metadata)
+	}
+
+	info, err := p.engine.Info(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve metadata")
+	}
+
+	metadata = &Metadata{
+		Width:    info.Width,
+		Height:   info.Height,
+		Format:   info.Format,
+		Bytes:    len(file.Source),
+		HasAlpha: info.HasAlpha,
+	}
+
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.store.Set(key, string(content))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to store metadata %s", key)
+	}
+
+	return metadata, nil
+}
+
+// ETag returns the entity tag of the image stored with the key generated from
+// a source modified at the given time, images are deterministic functions of
+// their source and of the options hashed in the key. The version of the
+// source is omitted when its modification time is unknown.
+func ETag(key string, modified time.Time) string {
+	if modified.IsZero() {
+		return `"` + key + `"`
+	}
+
+	return fmt.Sprintf(`"%s-%x"`, key, modified.UnixNano())
+}
+
+// ETagKey returns the key of the image stored with the entity tag, it strips
+// the version of the source appended by ETag.
+func ETagKey(etag string) string {
+	key := strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	if i := strings.IndexByte(key, '-'); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+// etagMatches returns true when the If-None-Match header lists the entity
+// tag, entity tags are compared with the weak comparison.
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sourceReference returns the URL or the path of the source image of the context
+func sourceReference(c *gin.Context) string {
+	parameters, _ := c.Get("parameters")
+	params, _ := parameters.(map[string]interface{})
+	reference, _ := params["path"].(string)
+	if u, exists := c.Get("url"); exists {
+		reference = u.(*url.URL).String()
+	}
+
+	return reference
+}
+
+// sourceContent returns the source image of the context with the key of its
+// content, the results computed from the source are cached by this key.
+func (p *Processor) sourceContent(c *gin.Context) (*image.ImageFile, string, error) {
+	if sourceReference(c) == "" {
+		return nil, "", &failure.ParameterError{Err: errors.New("Parameter \"path\" or \"url\" is required")}
+	}
+
+	file, _, err := p.sourceFile(c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, hash.Tokey(string(file.Source)), nil
 }
 
 // ShardFilename shards a filename based on config
@@ -1,12 +1,16 @@
 package picfit_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/color"
+	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -20,9 +24,11 @@ import (
 	conv "github.com/cstockton/go-conv"
 
 	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/thoas/picfit"
 	"github.com/thoas/picfit/config"
 	"github.com/thoas/picfit/server"
 	"github.com/thoas/picfit/signature"
@@ -131,6 +137,19 @@ func TestSizeRestrictedApplicationNotAuthorized(t *testing.T) {
 
 		assert.Equal(t, 403, res.Code)
 
+		// percentages cannot be checked against the allowed sizes
+		params = fmt.Sprintf("url=%s&w=100%%25&h=100%%25&op=resize", u.String())
+
+		location = fmt.Sprintf("http://example.com/display?%s", params)
+
+		request, _ = http.NewRequest("GET", location, nil)
+
+		res = httptest.NewRecorder()
+
+		server.ServeHTTP(res, request)
+
+		assert.Equal(t, 403, res.Code)
+
 		// allowed size
 		params = fmt.Sprintf("url=%s&w=100&h=100&op=resize", u.String())
 
@@ -414,13 +433,13 @@ func TestStorageApplicationWithPath(t *testing.T) {
 		timer1 := time.NewTimer(time.Second * 2)
 		<-timer1.C
 
-		etag := res.Header().Get("ETag")
+		key := picfit.ETagKey(res.Header().Get("ETag"))
 
-		exists, err := suite.Processor.KeyExists(etag)
+		exists, err := suite.Processor.KeyExists(key)
 		assert.Nil(t, err)
 		assert.True(t, exists)
 
-		raw, err := suite.Processor.GetKey(etag)
+		raw, err := suite.Processor.GetKey(key)
 		assert.Nil(t, err)
 
 		filepath, err := conv.String(raw)
@@ -525,13 +544,13 @@ func TestStorageApplicationWithURL(t *testing.T) {
 		timer1 := time.NewTimer(time.Second * 2)
 		<-timer1.C
 
-		etag := res.Header().Get("ETag")
+		key := picfit.ETagKey(res.Header().Get("ETag"))
 
-		exists, err := suite.Processor.KeyExists(etag)
+		exists, err := suite.Processor.KeyExists(key)
 		assert.Nil(t, err)
 		assert.True(t, exists)
 
-		raw, err := suite.Processor.GetKey(etag)
+		raw, err := suite.Processor.GetKey(key)
 		assert.Nil(t, err)
 
 		filepath, err := conv.String(raw)
@@ -602,6 +621,28 @@ func TestDummyApplication(t *testing.T) {
 				},
 				ContentType: "image/jpeg",
 			},
+			{
+				URL: fmt.Sprintf("http://example.com/display?url=%s&w=50&h=50&op=thumbnail&fmt=tiff", u.String()),
+				Dimensions: &tests.Dimension{
+					Width:  50,
+					Height: 50,
+				},
+				ContentType: "image/tiff",
+			},
+			{
+				URL: fmt.Sprintf("http://example.com/display?url=%s&w=50&h=30&op=resize&dpr=2", u.String()),
+				Dimensions: &tests.Dimension{
+					Width:  100,
+					Height: 60,
+				},
+			},
+			{
+				URL: fmt.Sprintf("http://example.com/display?url=%s&w=20&h=10&op=thumbnail&dpr=5", u.String()),
+				Dimensions: &tests.Dimension{
+					Width:  60,
+					Height: 30,
+				},
+			},
 			{
 				URL: fmt.Sprintf("http://example.com/display?url=%s&op=op:resize+w:100+h:50&op=op:rotate+deg:90", u.String()),
 				Dimensions: &tests.Dimension{
@@ -647,3 +688,776 @@ func TestDummyApplication(t *testing.T) {
 		}
 	}
 }
+
+func TestDominantColorHandler(t *testing.T) {
+	tmp, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmp, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmp)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		dominant := func() (int, map[string]string) {
+			req, err := http.NewRequest("GET", "http://www.example.com/dominantcolor?path=image.jpg", nil)
+			assert.Nil(t, err)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			body := map[string]string{}
+			json.Unmarshal(res.Body.Bytes(), &body)
+
+			return res.Code, body
+		}
+
+		code, body := dominant()
+		assert.Equal(t, 200, code)
+		assert.Regexp(t, "^#[0-9a-f]{6}$", body["dominant"])
+
+		code, cached := dominant()
+		assert.Equal(t, 200, code)
+		assert.Equal(t, body, cached)
+
+		// the color is cached by content, a new source is analyzed again
+		err = imaging.Save(imaging.New(50, 40, color.NRGBA{255, 0, 0, 255}), filepath.Join(tmp, "image.jpg"))
+		assert.Nil(t, err)
+
+		code, changed := dominant()
+		assert.Equal(t, 200, code)
+		assert.NotEqual(t, body, changed)
+
+		req, err := http.NewRequest("GET", "http://www.example.com/dominantcolor?path=missing.jpg", nil)
+		assert.Nil(t, err)
+
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		assert.Equal(t, 404, res.Code)
+
+		req, err = http.NewRequest("GET", "http://www.example.com/dominantcolor", nil)
+		assert.Nil(t, err)
+
+		res = httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		assert.Equal(t, 400, res.Code)
+	}, tests.WithConfig(cfg))
+}
+
+func TestMetadataHandler(t *testing.T) {
+	tmp, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmp, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmp)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		metadata := func(path string) (int, map[string]interface{}) {
+			req, err := http.NewRequest("GET", "http://www.example.com/metadata?path="+path, nil)
+			assert.Nil(t, err)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			body := map[string]interface{}{}
+			json.Unmarshal(res.Body.Bytes(), &body)
+
+			return res.Code, body
+		}
+
+		code, body := metadata("image.jpg")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, map[string]interface{}{
+			"width":     float64(500),
+			"height":    float64(357),
+			"format":    "jpeg",
+			"bytes":     float64(len(img)),
+			"has_alpha": false,
+		}, body)
+
+		code, cached := metadata("image.jpg")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, body, cached)
+
+		// the metadata are cached by content, a new source is read again
+		err = imaging.Save(imaging.New(50, 40, color.NRGBA{255, 0, 0, 255}), filepath.Join(tmp, "image.jpg"))
+		assert.Nil(t, err)
+
+		code, changed := metadata("image.jpg")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, float64(50), changed["width"])
+		assert.Equal(t, float64(40), changed["height"])
+
+		code, _ = metadata("missing.jpg")
+		assert.Equal(t, 404, code)
+
+		code, _ = metadata("")
+		assert.Equal(t, 400, code)
+
+		// parameters of another shape have no path
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("parameters", "image.jpg")
+
+		_, err = suite.Processor.Metadata(c)
+		assert.NotNil(t, err)
+	}, tests.WithConfig(cfg))
+}
+
+func TestFormatNegotiation(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpSrcStorage, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"options": {
+		"enable_format_negotiation": true
+	},
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		get := func(accept string) map[string]string {
+			// use "get" instead of "display" here to force synchronized behaviour
+			req, err := http.NewRequest("GET", "http://www.example.com/get/resize/100x100/image.jpg", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Accept", accept)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+			assert.Equal(t, 200, res.Code)
+			assert.Equal(t, "Accept", res.Header().Get("Vary"))
+
+			body := map[string]string{}
+			err = json.Unmarshal(res.Body.Bytes(), &body)
+			assert.Nil(t, err)
+
+			return body
+		}
+
+		webp := get("image/webp,image/*,*/*;q=0.8")
+		jpeg := get("image/jpeg,image/*")
+
+		assert.NotEqual(t, webp["key"], jpeg["key"])
+		assert.Equal(t, ".webp", filepath.Ext(webp["filename"]))
+		assert.Equal(t, ".jpg", filepath.Ext(jpeg["filename"]))
+
+		// each variant is served from its own cache entry
+		assert.Equal(t, webp, get("image/webp"))
+		assert.Equal(t, jpeg, get("image/jpeg"))
+
+		files, err := ioutil.ReadDir(tmpDstStorage)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(files))
+	}, tests.WithConfig(cfg))
+}
+
+func TestDPRKey(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpSrcStorage, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		get := func(dpr string) (int, map[string]string) {
+			req, err := http.NewRequest("GET", "http://www.example.com/get/resize/100x100/image.jpg?dpr="+url.QueryEscape(dpr), nil)
+			assert.Nil(t, err)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			body := map[string]string{}
+			json.Unmarshal(res.Body.Bytes(), &body)
+
+			return res.Code, body
+		}
+
+		// the clamped ratios share the image of the maximum ratio
+		code, max := get("3")
+		assert.Equal(t, 200, code)
+
+		code, clamped := get("10")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, max["key"], clamped["key"])
+
+		code, retina := get("2")
+		assert.Equal(t, 200, code)
+		assert.NotEqual(t, max["key"], retina["key"])
+
+		for _, dpr := range []string{"NaN", "Inf"} {
+			code, _ = get(dpr)
+			assert.Equal(t, 400, code, dpr)
+		}
+	}, tests.WithConfig(cfg))
+}
+
+func TestCacheHeaders(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	source := filepath.Join(tmpSrcStorage, "image.jpg")
+	err = ioutil.WriteFile(source, img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"options": {
+		"cache_max_age": 3600
+	},
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		display := func(location string, noneMatch string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("GET", "http://www.example.com"+location, nil)
+			assert.Nil(t, err)
+			if noneMatch != "" {
+				req.Header.Set("If-None-Match", noneMatch)
+			}
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			return res
+		}
+
+		// use "get" instead of "display" here to store the image synchronously
+		res := display("/get/resize/100x100/image.jpg", "")
+		assert.Equal(t, 200, res.Code)
+
+		body := map[string]string{}
+		err = json.Unmarshal(res.Body.Bytes(), &body)
+		assert.Nil(t, err)
+
+		res = display("/display/resize/100x100/image.jpg", "")
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, "public, max-age=3600", res.Header().Get("Cache-Control"))
+
+		etag := res.Header().Get("ETag")
+		assert.True(t, strings.HasPrefix(etag, `"`+body["key"]+"-"), etag)
+		assert.Equal(t, body["key"], picfit.ETagKey(etag))
+		assert.Equal(t, body["key"], picfit.ETagKey("W/"+etag))
+
+		for _, noneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			res = display("/display/resize/100x100/image.jpg", noneMatch)
+			assert.Equal(t, 304, res.Code, noneMatch)
+			assert.Equal(t, etag, res.Header().Get("ETag"))
+			assert.Equal(t, "public, max-age=3600", res.Header().Get("Cache-Control"))
+			assert.Equal(t, 0, res.Body.Len())
+		}
+
+		// the ETag changes with the options
+		res = display("/display/resize/50x50/image.jpg", etag)
+		assert.Equal(t, 200, res.Code)
+		assert.NotEqual(t, etag, res.Header().Get("ETag"))
+		assert.NotEqual(t, "", res.Header().Get("ETag"))
+
+		// and with the version of the source
+		updated := time.Now().Add(time.Hour)
+		err = os.Chtimes(source, updated, updated)
+		assert.Nil(t, err)
+
+		res = display("/display/resize/100x100/image.jpg", etag)
+		assert.Equal(t, 200, res.Code)
+		assert.NotEqual(t, etag, res.Header().Get("ETag"))
+
+		res = display("/display/resize/100x100/image.jpg", res.Header().Get("ETag"))
+		assert.Equal(t, 304, res.Code)
+	}, tests.WithConfig(cfg))
+}
+
+func TestLastModified(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	source := filepath.Join(tmpSrcStorage, "image.jpg")
+	err = ioutil.WriteFile(source, img, 0644)
+	assert.Nil(t, err)
+
+	modified := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	err = os.Chtimes(source, modified, modified)
+	assert.Nil(t, err)
+
+	ts := tests.NewImageServer()
+	defer ts.Close()
+
+	cfg := `
+{
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		display := func(location string, modifiedSince string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("GET", "http://www.example.com"+location, nil)
+			assert.Nil(t, err)
+			if modifiedSince != "" {
+				req.Header.Set("If-Modified-Since", modifiedSince)
+			}
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			return res
+		}
+
+		// use "get" instead of "display" here to store the image synchronously
+		res := display("/get/resize/100x100/image.jpg", "")
+		assert.Equal(t, 200, res.Code)
+
+		res = display("/display/resize/100x100/image.jpg", "")
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, modified.Format(http.TimeFormat), res.Header().Get("Last-Modified"))
+
+		for _, since := range []time.Time{modified, modified.Add(time.Hour)} {
+			res = display("/display/resize/100x100/image.jpg", since.Format(http.TimeFormat))
+			assert.Equal(t, 304, res.Code, since)
+			assert.Equal(t, 0, res.Body.Len())
+		}
+
+		res = display("/display/resize/100x100/image.jpg", modified.Add(-time.Hour).Format(http.TimeFormat))
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, modified.Format(http.TimeFormat), res.Header().Get("Last-Modified"))
+		stale := res.Body.Bytes()
+
+		// the derivative is generated again once its source is modified
+		decoded, err := imaging.Decode(bytes.NewReader(img))
+		assert.Nil(t, err)
+		buf := &bytes.Buffer{}
+		err = imaging.Encode(buf, imaging.FlipH(decoded), imaging.JPEG)
+		assert.Nil(t, err)
+		err = ioutil.WriteFile(source, buf.Bytes(), 0644)
+		assert.Nil(t, err)
+
+		updated := time.Now().UTC().Add(time.Hour)
+		err = os.Chtimes(source, updated, updated)
+		assert.Nil(t, err)
+
+		res = display("/display/resize/100x100/image.jpg", modified.Format(http.TimeFormat))
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, updated.Format(http.TimeFormat), res.Header().Get("Last-Modified"))
+		assert.NotEqual(t, stale, res.Body.Bytes())
+
+		res = display("/display/resize/100x100/image.jpg", "")
+		assert.Equal(t, 200, res.Code)
+		assert.NotEqual(t, stale, res.Body.Bytes())
+
+		res = display("/display/resize/100x100/image.jpg", "invalid")
+		assert.Equal(t, 200, res.Code)
+
+		// the modification time of the images fetched from an URL is unknown
+		u, _ := url.Parse(ts.URL + "/schwarzy.jpg")
+		location := "/display?url=" + url.QueryEscape(u.String()) + "&w=50&h=50&op=resize"
+
+		res = display(location, "")
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, "", res.Header().Get("Last-Modified"))
+	}, tests.WithConfig(cfg))
+}
+
+// failingWriter fails to write the body of the response
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+// hijackableFailingWriter fails to write the body of the response and hands
+// over the server side of conn when it's hijacked
+type hijackableFailingWriter struct {
+	failingWriter
+	conn net.Conn
+}
+
+func (w hijackableFailingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, nil, nil
+}
+
+func TestDisplayStreaming(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpSrcStorage, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"options": {
+		"enable_streaming": true
+	},
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		for _, location := range []string{
+			"/display/resize/100x100/image.jpg",
+			"/display?path=image.jpg&op=resize&w=100&h=100&op=op:flip+pos:h&fmt=png",
+			"/display?path=image.jpg&op=resize&w=100&h=100&max_bytes=5000",
+		} {
+			req, err := http.NewRequest("GET", "http://www.example.com"+location, nil)
+			assert.Nil(t, err)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+			assert.Equal(t, 200, res.Code, location)
+			assert.NotEqual(t, "", res.Header().Get("ETag"), location)
+			assert.True(t, strings.HasPrefix(res.Header().Get("Content-Type"), "image/"), location)
+
+			out, err := imaging.Decode(bytes.NewReader(res.Body.Bytes()))
+			assert.Nil(t, err, location)
+			assert.Equal(t, 100, out.Bounds().Dx(), location)
+		}
+
+		// the streamed images are not stored
+		files, err := ioutil.ReadDir(tmpDstStorage)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(files))
+
+		// the connection is aborted when the image cannot be written
+		req, err := http.NewRequest("GET", "http://www.example.com/display/resize/100x100/image.jpg", nil)
+		assert.Nil(t, err)
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			server.ServeHTTP(failingWriter{httptest.NewRecorder()}, req)
+		})
+	}, tests.WithConfig(cfg))
+}
+
+func TestDisplayStreamingAbort(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpSrcStorage, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	// the recovery middlewares of debug and sentry are enabled
+	cfg := `
+{
+	"debug": true,
+	"sentry": {"dsn": ""},
+	"options": {
+		"enable_streaming": true
+	},
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		req, err := http.NewRequest("GET", "http://www.example.com/display/resize/100x100/image.jpg", nil)
+		assert.Nil(t, err)
+
+		client, conn := net.Pipe()
+		defer client.Close()
+		err = client.SetReadDeadline(time.Now().Add(time.Second))
+		assert.Nil(t, err)
+
+		assert.NotPanics(t, func() {
+			server.ServeHTTP(hijackableFailingWriter{failingWriter{httptest.NewRecorder()}, conn}, req)
+		})
+
+		// the connection is closed without ending the response
+		_, err = client.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	}, tests.WithConfig(cfg))
+}
+
+func TestPercentageDimensions(t *testing.T) {
+	tmpSrcStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpSrcStorage)
+
+	tmpDstStorage, err := ioutil.TempDir("", tests.RandString(10))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDstStorage)
+
+	img, err := ioutil.ReadFile("tests/fixtures/schwarzy.jpg")
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpSrcStorage, "image.jpg"), img, 0644)
+	assert.Nil(t, err)
+
+	cfg := `
+{
+	"kvstore": {"type": "cache"},
+	"storage": {
+		"src": {
+			"type": "fs",
+			"location": "%s"
+		},
+		"dst": {
+			"type": "fs",
+			"location": "%s"
+		}
+	}
+}
+	`
+
+	cfg = fmt.Sprintf(cfg, tmpSrcStorage, tmpDstStorage)
+	tests.Run(t, func(t *testing.T, suite *tests.Suite) {
+		server, err := server.New(suite.Config)
+		assert.Nil(t, err)
+
+		get := func(location string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("GET", "http://www.example.com"+location, nil)
+			assert.Nil(t, err)
+
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+
+			return res
+		}
+
+		key := func(location string) string {
+			res := get(location)
+			assert.Equal(t, 200, res.Code)
+
+			body := map[string]string{}
+			err := json.Unmarshal(res.Body.Bytes(), &body)
+			assert.Nil(t, err)
+
+			return body["key"]
+		}
+
+		// the percentages are resolved against the 500x357 source so the
+		// image is stored once
+		percentages := key("/get?path=image.jpg&w=50%25&h=50%25&op=resize")
+		assert.NotEqual(t, "", percentages)
+		assert.Equal(t, key("/get?path=image.jpg&w=250&h=179&op=resize"), percentages)
+
+		res := get("/display?path=image.jpg&w=20%25&op=resize")
+		assert.Equal(t, 200, res.Code)
+
+		out, err := imaging.Decode(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, out.Bounds().Dx())
+		assert.Equal(t, 71, out.Bounds().Dy())
+
+		// the percentages of the operations are resolved by the engine
+		res = get("/display?path=image.jpg&op=op:resize+w:10%25+h:10%25&op=op:rotate+deg:90")
+		assert.Equal(t, 200, res.Code)
+
+		out, err = imaging.Decode(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, 36, out.Bounds().Dx())
+		assert.Equal(t, 50, out.Bounds().Dy())
+
+		for _, percent := range []string{"NaN", "Inf", "1e300", "150", "abc"} {
+			res = get("/display?path=image.jpg&w=" + percent + "%25&op=resize")
+			assert.Equal(t, 400, res.Code, percent)
+		}
+	}, tests.WithConfig(cfg))
+}
+
+func TestMaskFormat(t *testing.T) {
+	ts := tests.NewImageServer()
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	server, err := server.New(config.DefaultConfig())
+	assert.Nil(t, err)
+
+	u, _ := url.Parse(ts.URL + "/schwarzy.jpg")
+
+	for query, contentType := range map[string]string{
+		// the JPEG source is saved as PNG to keep the transparency
+		"op=mask":                       "image/png",
+		"op=mask&mask=rounded&radius=8": "image/png",
+		"op=mask&fmt=webp":              "image/webp",
+		"op=mask&fmt=jpg&bg=ffffff":     "image/jpeg",
+	} {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("http://example.com/display?url=%s&%s", u.String(), query), nil)
+
+		res := httptest.NewRecorder()
+
+		server.ServeHTTP(res, request)
+		assert.Equal(t, 200, res.Code, query)
+		assert.Equal(t, contentType, res.Header().Get("Content-Type"), query)
+
+		_, err := imaging.Decode(res.Body)
+		assert.Nil(t, err, query)
+	}
+
+	// JPEG doesn't support transparency
+	request, _ := http.NewRequest("GET", fmt.Sprintf("http://example.com/display?url=%s&op=mask&fmt=jpg", u.String()), nil)
+
+	res := httptest.NewRecorder()
+
+	server.ServeHTTP(res, request)
+	assert.Equal(t, 400, res.Code)
+}
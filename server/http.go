@@ -44,7 +44,7 @@ func NewHTTPServer(cfg *config.Config, processor *picfit.Processor) (*HTTPServer
 func (s *HTTPServer) Init() error {
 	var (
 		router    = gin.New()
-		handlers  = &handlers{s.processor}
+		handlers  = &handlers{processor: s.processor, cacheControl: cacheControl(s.config.Options.CacheMaxAge), streaming: s.config.Options.EnableStreaming}
 		endpoints = []endpoint{
 			{
 				pattern: "redirect",
@@ -139,6 +139,8 @@ func (s *HTTPServer) Init() error {
 			middleware.ParametersParser(),
 			middleware.KeyParser(),
 			middleware.Security(s.config.SecretKey),
+			// the format of the engine config is never negotiated
+			middleware.FormatNegotiator(s.config.Options.EnableFormatNegotiation && s.config.Engine.Format == ""),
 			middleware.URLParser(s.config.Options.MimetypeDetector),
 			middleware.OperationParser(),
 			middleware.RestrictSizes(s.config.Options.AllowedSizes),
@@ -152,6 +154,20 @@ func (s *HTTPServer) Init() error {
 		}
 	}
 
+	router.GET("/dominantcolor",
+		middleware.ParametersParser(),
+		middleware.KeyParser(),
+		middleware.Security(s.config.SecretKey),
+		middleware.URLParser(s.config.Options.MimetypeDetector),
+		failure.Handle(handlers.dominantColor))
+
+	router.GET("/metadata",
+		middleware.ParametersParser(),
+		middleware.KeyParser(),
+		middleware.Security(s.config.SecretKey),
+		middleware.URLParser(s.config.Options.MimetypeDetector),
+		failure.Handle(handlers.metadata))
+
 	if s.config.Options.EnableUpload {
 		router.POST("/upload",
 			restrictIPAddresses,
@@ -218,3 +234,13 @@ func (s *HTTPServer) Run() error {
 
 	return nil
 }
+
+// cacheControl returns the Cache-Control header of the displayed images,
+// they are revalidated unless a max age in seconds is configured.
+func cacheControl(maxAge int) string {
+	if maxAge <= 0 {
+		return "must-revalidate"
+	}
+
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
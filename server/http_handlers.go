@@ -14,12 +14,16 @@ import (
 
 	"github.com/thoas/picfit"
 	"github.com/thoas/picfit/constants"
+	"github.com/thoas/picfit/engine/backend"
 	"github.com/thoas/picfit/failure"
+	"github.com/thoas/picfit/image"
 	"github.com/thoas/picfit/payload"
 )
 
 type handlers struct {
-	processor *picfit.Processor
+	processor    *picfit.Processor
+	cacheControl string
+	streaming    bool
 }
 
 func (h handlers) stats(c *gin.Context) {
@@ -53,9 +57,24 @@ func (h handlers) healthcheck(startedAt time.Time) func(c *gin.Context) {
 
 // display displays and image using resizing parameters
 func (h handlers) display(c *gin.Context) error {
-	file, err := h.processor.ProcessContext(c,
-		picfit.WithAsync(true),
-		picfit.WithLoad(true))
+	var (
+		file    *image.ImageFile
+		encoder backend.Encoder
+		err     error
+	)
+
+	if h.streaming {
+		file, encoder, err = h.processor.StreamContext(c)
+	} else {
+		file, err = h.processor.ProcessContext(c,
+			picfit.WithAsync(true),
+			picfit.WithLoad(true))
+	}
+	if errors.Cause(err) == failure.ErrFileNotModified {
+		// the not modified responses keep the validators of the image
+		c.Header("ETag", c.GetString("etag"))
+		c.Header("Cache-Control", h.cacheControl)
+	}
 	if err != nil {
 		return err
 	}
@@ -64,13 +83,54 @@ func (h handlers) display(c *gin.Context) error {
 		c.Header(k, v)
 	}
 
-	c.Header("Cache-Control", "must-revalidate")
+	c.Header("Cache-Control", h.cacheControl)
+
+	if encoder == nil {
+		c.Data(http.StatusOK, file.ContentType(), file.Content())
+
+		return nil
+	}
+
+	c.Header("Content-Type", file.ContentType())
+	c.Status(http.StatusOK)
 
-	c.Data(http.StatusOK, file.ContentType(), file.Content())
+	if err := encoder(c.Writer); err != nil {
+		if !c.Writer.Written() {
+			return err
+		}
+
+		// the status has been sent with the beginning of the image, the
+		// connection is aborted so the truncated image isn't taken for a
+		// complete one
+		c.Error(err)
+		abortConnection(c)
+	}
 
 	return nil
 }
 
+// abortConnection closes the connection of the request without ending its
+// response. The connection is hijacked since the recovery middlewares would
+// recover http.ErrAbortHandler, it's only raised when the connection can't
+// be hijacked such as with HTTP/2.
+func abortConnection(c *gin.Context) {
+	c.Abort()
+
+	// gin asserts the hijacker without checking it
+	defer func() {
+		if r := recover(); r != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}()
+
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn.Close()
+}
+
 // upload uploads an image to the destination storage
 func (h handlers) upload(c *gin.Context) error {
 	multipartPayload := new(payload.Multipart)
@@ -140,6 +200,32 @@ func (h handlers) get(c *gin.Context) error {
 	return nil
 }
 
+// dominantColor displays the dominant color of an image as JSON
+func (h handlers) dominantColor(c *gin.Context) error {
+	color, err := h.processor.DominantColor(c)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dominant": color,
+	})
+
+	return nil
+}
+
+// metadata displays the dimensions, the format and the size of an image as JSON
+func (h handlers) metadata(c *gin.Context) error {
+	metadata, err := h.processor.Metadata(c)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, metadata)
+
+	return nil
+}
+
 // redirect redirects to the image using base url from storage
 func (h handlers) redirect(c *gin.Context) error {
 	file, err := h.processor.ProcessContext(c,